@@ -0,0 +1,110 @@
+// Command gbdisasm disassembles a Gameboy ROM into RGBDS-compatible
+// assembly.
+//
+// Usage:
+//
+//	gbdisasm [-linear] [-entry bank:addr,...] [-sym game.sym] [-o out.asm] game.gb
+//
+// By default only code reachable from the entry point and interrupt
+// vectors is disassembled; use -entry to add entry points that can't be
+// found automatically (e.g. the targets of jump tables), or -linear to
+// disassemble everything.
+//
+// If there's a symbol file next to the ROM (game.sym for game.gb), or one is
+// given with -sym, its labels are used instead of generated names.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/disasm"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+func main() {
+	linear := flag.Bool("linear", false, "disassemble every byte as code instead of following jumps from the entry points")
+	entries := flag.String("entry", "", "comma-separated list of extra entry points, as bank:addr in hex (e.g. 01:4000)")
+	symFile := flag.String("sym", "", "name labels using this symbol file (default: the ROM's name with a .sym extension, if it exists)")
+	out := flag.String("o", "", "write the disassembly to this file instead of stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] game.gb\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rom, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	opt := disasm.Options{Mode: disasm.Recursive}
+	if *linear {
+		opt.Mode = disasm.Linear
+	}
+	if *entries != "" {
+		for _, e := range strings.Split(*entries, ",") {
+			a, err := parseAddress(e)
+			if err != nil {
+				fail(err)
+			}
+			opt.Entries = append(opt.Entries, a)
+		}
+	}
+
+	if *symFile != "" {
+		opt.Symbols, err = symbols.LoadFile(*symFile)
+	} else {
+		opt.Symbols, err = symbols.ForROM(flag.Arg(0))
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	fmt.Fprintf(w, "; Disassembly of %s\n\n", filepath.Base(flag.Arg(0)))
+	if _, err := disasm.Disassemble(rom, opt).WriteTo(w); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "gbdisasm: %v\n", err)
+	os.Exit(1)
+}
+
+// parseAddress parses a bank-qualified address like "01:4000". A bare
+// address in $0000-$3FFF is taken to be in bank 0.
+func parseAddress(s string) (disasm.Address, error) {
+	s = strings.TrimSpace(s)
+	bankStr := "0"
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		bankStr, s = s[:i], s[i+1:]
+	}
+	bank, err := strconv.ParseUint(strings.TrimPrefix(bankStr, "$"), 16, 16)
+	if err != nil {
+		return disasm.Address{}, fmt.Errorf("bad bank in entry point %q", s)
+	}
+	addr, err := strconv.ParseUint(strings.TrimPrefix(s, "$"), 16, 16)
+	if err != nil {
+		return disasm.Address{}, fmt.Errorf("bad address in entry point %q", s)
+	}
+	return disasm.Address{Bank: int(bank), Addr: uint16(addr)}, nil
+}
@@ -0,0 +1,222 @@
+// Command gbheadless runs a game without a display, as fast as possible,
+// optionally playing a movie's input, and saves a screenshot of a chosen
+// frame or a video of the run, e.g. to attach to bug reports from automated
+// runs.
+//
+// Usage:
+//
+//	gbheadless [-frames N] [-play run.gbm] [-screenshot-at-frame N] [-o shot.png] [-video run.gif] game.gb
+//
+// Frames are numbered from 0, as in movies, and end when the PPU finishes
+// drawing one or, while the LCD is off, after a frame's worth of cycles
+// (see headless.Machine.Step). A screenshot is of what's on screen at the
+// end of the frame, which is white while the LCD is off. With
+// -screenshot-at-frame, the game runs up to that frame unless -frames asks
+// for more. Without a boot ROM (see -boot), the game starts in the state
+// the boot ROM leaves it in: the DMG's, or the Game Boy Color's for games
+// that support it, which then run in color. -model dmg runs them as on a
+// DMG instead, and -model cgb runs every game in Game Boy Color mode. A
+// movie is played back on the model it was recorded on, and needs -boot if
+// it was recorded starting with the boot ROM, as the emulator starts games
+// that run as on a DMG.
+//
+// With -video, the run is recorded as an animated GIF or PNG, or as
+// uncompressed YUV4MPEG2 (.y4m). "-video -" writes YUV4MPEG2 to the
+// standard output, and everything else that would be printed to the
+// standard error, so it can be piped to an encoder:
+//
+//	gbheadless -frames 600 -video - game.gb | ffmpeg -i - run.mp4
+//
+// -video-seconds keeps only the
+// end of the run, e.g. the last few seconds before a test failed.
+//
+// At the end, the number of frames run and the hashes of the last frame
+// and of the machine's state are printed, so that runs can be compared.
+//
+// The exit status is 1 if a movie desyncs or nothing had been drawn by the
+// screenshot frame, and 2 if an error occurred.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/mpingram/gameboy-emu/headless"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/movie"
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/savestate"
+	"github.com/mpingram/gameboy-emu/screenshot"
+	"github.com/mpingram/gameboy-emu/video"
+)
+
+func main() {
+	frames := flag.Int("frames", 0, "number of frames to run (default the length of the movie, or up to the screenshot)")
+	bootPath := flag.String("boot", "", "start by running this boot ROM")
+	playPath := flag.String("play", "", "play back the joypad input from a movie in this file, or from a BizHawk .bk2 movie")
+	shotFrame := flag.Int("screenshot-at-frame", -1, "save a screenshot of this frame")
+	shotPath := flag.String("o", "screenshot.png", "file to save the screenshot to")
+	scale := flag.Int("scale", 1, "scale the screenshot and video up by this integer factor")
+	videoPath := flag.String("video", "", "record the run to a video in this file: an animated .gif or .png, or uncompressed .y4m, or - for .y4m on the standard output")
+	videoSkip := flag.Int("video-skip", 0, "skip this many frames after each one recorded with -video, e.g. 1 for half the frame rate, which suits GIFs better")
+	videoSeconds := flag.Float64("video-seconds", 0, "keep only this many seconds at the end of the run in the video (default all of it)")
+	modelName := flag.String("model", "auto", "Game Boy to emulate: auto, dmg or cgb, as for the emulator's -model")
+	paletteName := flag.String("palette", "gray", "colors of the screenshot: a preset, four hex colors or a palette file, as for the emulator's -palette")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] game.gb\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *scale < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rom, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	opt := headless.Options{ROM: rom}
+	if opt.Model, err = mmu.ParseModel(*modelName); err != nil {
+		fail(err)
+	}
+	// Movies are played back on the Game Boy they were recorded on, started
+	// the same way.
+	var mv *movie.Movie
+	if *playPath != "" {
+		if mv, err = movie.ReadFile(*playPath); err != nil {
+			fail(err)
+		}
+		if opt.Model, err = mv.PlaybackModel(opt.Model); err != nil {
+			fail(err)
+		}
+		if mv.Model != mmu.ModelAuto && mv.Boot != (*bootPath != "") {
+			if mv.Boot {
+				fail(errors.New("the movie was recorded starting with the DMG's boot ROM, which has to be given with -boot"))
+			}
+			fail(errors.New("the movie was recorded without a boot ROM, so -boot can't be used"))
+		}
+	}
+	if *bootPath != "" {
+		if opt.BootROM, err = ioutil.ReadFile(*bootPath); err != nil {
+			fail(err)
+		}
+	}
+	pal, err := palette.Load(*paletteName)
+	if err != nil {
+		fail(err)
+	}
+	gb := headless.New(opt)
+
+	var player *movie.Player
+	if mv != nil {
+		if player, err = movie.NewPlayer(mv, rom); err != nil {
+			fail(err)
+		}
+		if mv.State != nil {
+			if err := gb.LoadState(mv.State); err != nil {
+				fail(fmt.Errorf("loading the movie's state: %v", err))
+			}
+		}
+		if *frames == 0 {
+			*frames = len(mv.Frames)
+		}
+	}
+	if *frames <= *shotFrame {
+		*frames = *shotFrame + 1
+	}
+	if *frames == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	shots := screenshot.NewSink()
+	gb.PPU.AddSink(shots)
+
+	// out is where the results are printed.
+	var out io.Writer = os.Stdout
+	var rec *video.Recorder
+	var videoOut *bufio.Writer
+	if *videoPath != "" {
+		opt := video.Options{Palette: pal, Scale: *scale, Skip: *videoSkip}
+		// The number of frames recorded in that many seconds, rounded up.
+		opt.Last = int(math.Ceil(*videoSeconds * video.FrameRate / float64(*videoSkip+1)))
+		if *videoPath == "-" {
+			opt.Format = video.Y4M
+			out = os.Stderr
+			videoOut = bufio.NewWriter(os.Stdout)
+		} else {
+			if opt.Format, err = video.FormatForPath(*videoPath); err != nil {
+				fail(err)
+			}
+			f, err := os.Create(*videoPath)
+			if err != nil {
+				fail(err)
+			}
+			defer f.Close()
+			videoOut = bufio.NewWriter(f)
+		}
+		if rec, err = video.New(videoOut, opt); err != nil {
+			fail(err)
+		}
+		gb.PPU.AddSink(rec)
+	}
+
+	status := 0
+	for i := 0; i < *frames; i++ {
+		if player != nil {
+			input, _ := player.Input()
+			gb.MMU.SetButtons(input)
+		}
+		gb.RunFrame()
+		if rec != nil {
+			rec.EndFrame()
+		}
+		if player != nil {
+			if err := player.EndFrame(func() uint64 { return savestate.Hash(gb.State()) }); err != nil {
+				fmt.Fprintf(os.Stderr, "gbheadless: %v\n", err)
+				status = 1
+			}
+		}
+		if i == *shotFrame {
+			if err := saveScreenshot(shots, *shotPath, pal, *scale); err != nil {
+				fmt.Fprintf(os.Stderr, "gbheadless: frame %d: %v\n", i, err)
+				status = 1
+			} else {
+				fmt.Fprintf(out, "Saved frame %d to %s\n", i, *shotPath)
+			}
+		}
+	}
+	if rec != nil {
+		err := rec.Close()
+		if err == nil {
+			err = videoOut.Flush()
+		}
+		if err != nil {
+			fail(fmt.Errorf("writing video: %v", err))
+		}
+		fmt.Fprintf(out, "Recorded %d frames to %s\n", rec.Frames(), *videoPath)
+	}
+	fmt.Fprintf(out, "Ran %d frames. Frame hash %016x, state hash %016x\n", *frames, gb.PPU.FrameHash(), savestate.Hash(gb.State()))
+	os.Exit(status)
+}
+
+// saveScreenshot saves the latest frame to a PNG file.
+func saveScreenshot(shots *screenshot.Sink, path string, pal *palette.Palette, scale int) error {
+	f, ok := shots.Latest()
+	if !ok {
+		return screenshot.ErrNoFrame
+	}
+	return screenshot.Save(path, f, pal, scale)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "gbheadless: %v\n", err)
+	os.Exit(2)
+}
@@ -0,0 +1,237 @@
+// Command gbtracediff finds the first point at which two CPU trace logs
+// diverge.
+//
+// Usage:
+//
+//	gbtracediff [-context N] [-sync=false] [-sym game.sym] left.log[.gz] right.log[.gz]
+//
+// Both logs are streamed, so they can be arbitrarily large. By default both
+// logs are synced at the first entry with PC=$0100, so that a trace which
+// includes the boot ROM can be compared with one that skips it.
+//
+// With -sym, each PC is shown with the label it's in. Traces don't record
+// which ROM bank is mapped, so addresses in $4000-$7FFF are looked up in
+// bank 1.
+//
+// The exit status is 0 if the traces match, 1 if they diverge and 2 if an
+// error occurred.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/symbols"
+	"github.com/mpingram/gameboy-emu/trace"
+)
+
+// entryPoint is the address execution jumps to when the boot ROM finishes.
+const entryPoint = 0x0100
+
+// syms holds the symbols loaded with -sym, if any.
+var syms *symbols.Table
+
+func main() {
+	context := flag.Int("context", 5, "number of lines of context to show around the divergence")
+	sync := flag.Bool("sync", true, "skip entries before the first PC=$0100 in both traces")
+	symFile := flag.String("sym", "", "show PCs with labels from this symbol file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] left.log right.log\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *symFile != "" {
+		var err error
+		if syms, err = symbols.LoadFile(*symFile); err != nil {
+			fail(err)
+		}
+	}
+
+	left, err := trace.Open(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	defer left.Close()
+	right, err := trace.Open(flag.Arg(1))
+	if err != nil {
+		fail(err)
+	}
+	defer right.Close()
+
+	d, err := diff(left, right, *sync, *context)
+	if err != nil {
+		fail(err)
+	}
+	if d == nil {
+		fmt.Println("Traces match.")
+		return
+	}
+	d.print(os.Stdout, flag.Arg(0), flag.Arg(1))
+	os.Exit(1)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "gbtracediff: %v\n", err)
+	os.Exit(2)
+}
+
+// line is an entry of a trace along with its position in the log.
+type line struct {
+	entry trace.Entry
+	num   int
+	ok    bool // false if the trace ended before this line
+}
+
+// divergence describes the first mismatch between two traces.
+type divergence struct {
+	index       int // number of entries compared before the divergence
+	left, right line
+	before      [][2]line // matching entries before the divergence, oldest first
+	afterLeft   []line
+	afterRight  []line
+}
+
+// diff compares left and right entry by entry, and returns the first
+// divergence, or nil if the traces are identical.
+func diff(left, right *trace.Reader, sync bool, context int) (*divergence, error) {
+	l, err := first(left, sync)
+	if err != nil {
+		return nil, fmt.Errorf("left trace: %v", err)
+	}
+	r, err := first(right, sync)
+	if err != nil {
+		return nil, fmt.Errorf("right trace: %v", err)
+	}
+	history := make([][2]line, 0, context)
+	for i := 0; ; i++ {
+		if i > 0 {
+			if l, err = next(left); err != nil {
+				return nil, fmt.Errorf("left trace: %v", err)
+			}
+			if r, err = next(right); err != nil {
+				return nil, fmt.Errorf("right trace: %v", err)
+			}
+		}
+		if !l.ok && !r.ok {
+			return nil, nil
+		}
+		if l.ok && r.ok && len(trace.Diff(l.entry, r.entry)) == 0 {
+			if context > 0 {
+				if len(history) == context {
+					history = append(history[:0], history[1:]...)
+				}
+				history = append(history, [2]line{l, r})
+			}
+			continue
+		}
+		d := &divergence{index: i, left: l, right: r, before: history}
+		for j := 0; j < context; j++ {
+			if l.ok {
+				if l, err = next(left); err != nil {
+					return nil, fmt.Errorf("left trace: %v", err)
+				}
+				if l.ok {
+					d.afterLeft = append(d.afterLeft, l)
+				}
+			}
+			if r.ok {
+				if r, err = next(right); err != nil {
+					return nil, fmt.Errorf("right trace: %v", err)
+				}
+				if r.ok {
+					d.afterRight = append(d.afterRight, r)
+				}
+			}
+		}
+		return d, nil
+	}
+}
+
+// first returns the first entry of r to compare. If sync is set, entries
+// before the first one at the entry point (i.e. the boot ROM) are skipped.
+func first(r *trace.Reader, sync bool) (line, error) {
+	for {
+		l, err := next(r)
+		if err != nil || !sync {
+			return l, err
+		}
+		if !l.ok {
+			return line{}, fmt.Errorf("never reaches PC=$%04X (try -sync=false)", entryPoint)
+		}
+		if l.entry.PC == entryPoint {
+			return l, nil
+		}
+	}
+}
+
+func next(r *trace.Reader) (line, error) {
+	e, err := r.Next()
+	if err == io.EOF {
+		return line{}, nil
+	} else if err != nil {
+		return line{}, err
+	}
+	return line{e, r.Line(), true}, nil
+}
+
+func (d *divergence) print(w io.Writer, leftName, rightName string) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", leftName, rightName)
+	switch {
+	case !d.left.ok:
+		fmt.Fprintf(w, "Left trace ends after %d matching entries.\n", d.index)
+	case !d.right.ok:
+		fmt.Fprintf(w, "Right trace ends after %d matching entries.\n", d.index)
+	default:
+		fmt.Fprintf(w, "Traces diverge after %d matching entries (left line %d, right line %d).\n",
+			d.index, d.left.num, d.right.num)
+		fmt.Fprintf(w, "Diverged: %s\n", strings.Join(trace.Diff(d.left.entry, d.right.entry), ", "))
+	}
+	fmt.Fprintln(w)
+
+	for _, pair := range d.before {
+		printLine(w, " ", pair[0])
+	}
+	if d.left.ok {
+		printLine(w, "-", d.left)
+	}
+	if d.right.ok {
+		printLine(w, "+", d.right)
+	}
+	for _, l := range d.afterLeft {
+		printLine(w, "-", l)
+	}
+	for _, r := range d.afterRight {
+		printLine(w, "+", r)
+	}
+}
+
+// printLine prints a trace entry alongside the disassembly of the
+// instruction at its PC.
+func printLine(w io.Writer, prefix string, l line) {
+	disasm := "??"
+	if instr, ok := l.entry.Instruction(); ok {
+		disasm = instr.String()
+	}
+	if syms == nil {
+		fmt.Fprintf(w, "%s %8d  $%04X  %-22s %s\n", prefix, l.num, l.entry.PC, disasm, l.entry)
+		return
+	}
+	fmt.Fprintf(w, "%s %8d  $%04X  %-24s %-22s %s\n", prefix, l.num, l.entry.PC, describe(l.entry.PC), disasm, l.entry)
+}
+
+// describe returns the label that pc is in, assuming that bank 1 is mapped.
+func describe(pc uint16) string {
+	bank := 0
+	if pc >= 0x4000 && pc < 0x8000 {
+		bank = 1
+	}
+	return syms.Describe(symbols.Address{Bank: bank, Addr: pc})
+}
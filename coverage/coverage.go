@@ -0,0 +1,200 @@
+// Package coverage records which bytes of a game's ROM have been executed or
+// read as data, and which bytes of RAM have been read or written, e.g. to
+// check which code paths a playthrough has exercised.
+//
+// A Map can be saved as a compact bitmap (see WriteBitmap), which can be
+// loaded again to add more runs to it, and which tells disassemblers which
+// bytes are code and which are data. It can also be written as a summary,
+// or drawn as a PNG image.
+package coverage
+
+import (
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// Kind is a set of ways a byte was accessed.
+type Kind uint8
+
+const (
+	// Opcode marks a byte executed as an instruction's opcode, including
+	// both bytes of CB-prefixed instructions.
+	Opcode Kind = 1 << iota
+	// Operand marks a byte executed as an instruction's immediate data.
+	Operand
+	// Read marks a byte read as data, by the CPU or by OAM DMA.
+	Read
+	// Written marks a byte of RAM that was written. Writes to ROM are
+	// commands to the cartridge's memory bank controller, and aren't
+	// recorded.
+	Written
+
+	// Code is the kinds of access made by executing a byte.
+	Code = Opcode | Operand
+)
+
+// numKinds is the number of kinds of access, i.e. of bitmaps per region
+// when a Map is saved.
+const numKinds = 4
+
+const (
+	// bankSize is the size of a ROM bank.
+	bankSize = 0x4000
+	// ramStart is the address of the first byte of memory that isn't ROM.
+	ramStart = 0x8000
+	ramSize  = 0x10000 - ramStart
+)
+
+// Map records how each byte of ROM and RAM has been accessed.
+type Map struct {
+	// ROM is indexed by offset in the ROM file, i.e. bank*$4000 plus the
+	// address within the bank.
+	ROM []Kind
+	// RAM is indexed by address, minus $8000. Banked work RAM is recorded
+	// by address, whichever bank was mapped.
+	RAM [ramSize]Kind
+}
+
+// NewMap returns an empty map of a ROM of romSize bytes.
+func NewMap(romSize int) *Map {
+	return &Map{ROM: make([]Kind, romSize)}
+}
+
+// Banks returns the number of ROM banks in the map.
+func (c *Map) Banks() int {
+	return (len(c.ROM) + bankSize - 1) / bankSize
+}
+
+// Merge adds the accesses recorded in other to c.
+func (c *Map) Merge(other *Map) {
+	c.grow(len(other.ROM))
+	for i, k := range other.ROM {
+		c.ROM[i] |= k
+	}
+	for i, k := range other.RAM {
+		c.RAM[i] |= k
+	}
+}
+
+// mark records an access to addr, in bank if it's banked ROM.
+func (c *Map) mark(bank int, addr uint16, k Kind) {
+	if addr >= ramStart {
+		c.RAM[addr-ramStart] |= k
+		return
+	}
+	if k == Written {
+		return
+	}
+	i := bank*bankSize + int(addr%bankSize)
+	c.grow(i + 1)
+	c.ROM[i] |= k
+}
+
+// grow makes room for a ROM of at least n bytes, rounded up to whole banks.
+func (c *Map) grow(n int) {
+	if n <= len(c.ROM) {
+		return
+	}
+	n = (n + bankSize - 1) / bankSize * bankSize
+	rom := make([]Kind, n)
+	copy(rom, c.ROM)
+	c.ROM = rom
+}
+
+// Recorder records the memory accesses made through an MMU in a Map.
+type Recorder struct {
+	m   *mmu.MMU
+	cov *Map
+
+	hook   mmu.HookID
+	paused bool
+
+	// fetchStart, opcodeEnd and fetchEnd are the address of the instruction
+	// being fetched, and the ends of its opcode and operands.
+	fetchStart          uint16
+	opcodeEnd, fetchEnd uint32
+}
+
+// New starts recording accesses made through m in cov. If cov is nil, a new
+// map is made, sized from the ROM header. The MMU must have a PC function
+// (see mmu.MMU.SetPCFunc), to tell instruction fetches from data reads.
+// Accesses by the PPU, and to the boot ROM, aren't recorded.
+func New(m *mmu.MMU, cov *Map) *Recorder {
+	if cov == nil {
+		cov = NewMap(romSize(m))
+	}
+	r := &Recorder{m: m, cov: cov}
+	r.hook = m.AddHook(0x0000, 0xFFFF, mmu.HookRead|mmu.HookWrite, r.accessed)
+	return r
+}
+
+// Map returns the map the recorder records in.
+func (r *Recorder) Map() *Map {
+	return r.cov
+}
+
+// Stop stops recording.
+func (r *Recorder) Stop() {
+	r.m.RemoveHook(r.hook)
+}
+
+// SetPaused pauses or resumes recording, e.g. while replaying history
+// that has been recorded already.
+func (r *Recorder) SetPaused(paused bool) {
+	r.paused = paused
+}
+
+func (r *Recorder) accessed(a mmu.Access) {
+	if r.paused || a.Component == mmu.PPU || a.Addr < 0x0100 && r.m.BootROMMapped() {
+		return
+	}
+	kind := Read
+	switch {
+	case a.Write:
+		kind = Written
+	case a.Component != mmu.CPU:
+	case a.Addr == a.PC:
+		// The CPU is fetching the next instruction; see how long it is.
+		instr := cpu.Decode(a.PC, peeker{r.m})
+		length := uint32(instr.Length())
+		if length == 0 {
+			// An illegal opcode.
+			length = 1
+		}
+		r.fetchStart = a.PC
+		r.fetchEnd = uint32(a.PC) + length
+		r.opcodeEnd = r.fetchEnd - uint32(len(instr.Data()))
+		kind = Opcode
+	case a.PC == r.fetchStart && a.Addr > r.fetchStart && uint32(a.Addr) < r.fetchEnd:
+		kind = Operand
+		if uint32(a.Addr) < r.opcodeEnd {
+			kind = Opcode
+		}
+	}
+	r.cov.mark(r.m.BankAt(a.Addr), a.Addr, kind)
+}
+
+// romSize returns the size of the game's ROM according to its header, or
+// the size of two banks if the header doesn't say.
+func romSize(m *mmu.MMU) int {
+	if code := m.Peek(addrROMSize); code <= 8 {
+		return 2 * bankSize << code
+	}
+	return 2 * bankSize
+}
+
+// addrROMSize is the address of the ROM size code in the cartridge header.
+const addrROMSize = 0x0148
+
+// peeker reads memory without running hooks.
+type peeker struct {
+	m *mmu.MMU
+}
+
+func (p peeker) Rb(addr uint16) byte {
+	return p.m.Peek(addr)
+}
+
+func (p peeker) Rw(addr uint16) uint16 {
+	return uint16(p.m.Peek(addr)) | uint16(p.m.Peek(addr+1))<<8
+}
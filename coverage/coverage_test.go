@@ -0,0 +1,129 @@
+package coverage
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// record runs a short program and returns what it covered:
+//
+//	$0100  ld a, [$4000]
+//	$0103  ld [$C000], a
+//	$0106  swap a
+//	$0108  push bc
+//	$0109  pop de
+func record(t *testing.T) *Map {
+	m := mmu.New(mmu.MMUOptions{})
+	copy(m.Mem[0x0100:], []byte{0xFA, 0x00, 0x40, 0xEA, 0x00, 0xC0, 0xCB, 0x37, 0xC5, 0xD1})
+	c := cpu.New(m.CPUInterface)
+	c.PC, c.SP = 0x0100, 0xFFFE
+	m.SetPCFunc(func() uint16 { return c.PC })
+	r := New(m, nil)
+	for i := 0; i < 5; i++ {
+		c.Step()
+	}
+	r.Stop()
+	c.Step()
+	return r.Map()
+}
+
+func TestRecorder(t *testing.T) {
+	cov := record(t)
+	tests := []struct {
+		name string
+		kind Kind
+		got  Kind
+	}{
+		{"opcode", Opcode, cov.ROM[0x0100]},
+		{"operand", Operand, cov.ROM[0x0101]},
+		{"CB prefix", Opcode, cov.ROM[0x0106]},
+		{"prefixed opcode", Opcode, cov.ROM[0x0107]},
+		{"data", Read, cov.ROM[0x4000]},
+		{"written", Written, cov.RAM[0xC000-ramStart]},
+		{"pushed and popped", Read | Written, cov.RAM[0xFFFC-ramStart]},
+		{"after stopping", 0, cov.ROM[0x010A]},
+		{"untouched", 0, cov.ROM[0x0200]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.kind {
+				t.Errorf("Expected %04b, got %04b", tt.kind, tt.got)
+			}
+		})
+	}
+}
+
+func TestMap_WriteBitmap(t *testing.T) {
+	cov := record(t)
+	var b bytes.Buffer
+	if err := cov.WriteBitmap(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := 9 + 4*len(cov.ROM)/8 + 4*ramSize/8; b.Len() != want {
+		t.Errorf("Expected %d bytes, got %d", want, b.Len())
+	}
+	got, err := ReadBitmap(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kindBytes(got.ROM), kindBytes(cov.ROM)) || got.RAM != cov.RAM {
+		t.Error("Map read back differs from the one written")
+	}
+
+	if _, err := ReadBitmap(strings.NewReader("GBCV\x02")); err == nil {
+		t.Error("Expected an error reading an unknown version")
+	}
+}
+
+func kindBytes(kinds []Kind) []byte {
+	b := make([]byte, len(kinds))
+	for i, k := range kinds {
+		b[i] = byte(k)
+	}
+	return b
+}
+
+func TestMap_WriteSummary(t *testing.T) {
+	var b bytes.Buffer
+	if err := record(t).WriteSummary(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"ROM: 2 banks, 32768 bytes\n",
+		// 10 bytes of code, then 1 byte of data in bank 1.
+		"$00           10   0.1%         0   0.0%     16374  99.9%\n",
+		"$01            0   0.0%         1   0.0%     16383 100.0%\n",
+		"work RAM       $C000-$DFFF         0   0.0%         1   0.0%         0   0.0%\n",
+		"high RAM       $FF80-$FFFE         2   1.6%         2   1.6%         0   0.0%\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected summary to contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestMap_WritePNG(t *testing.T) {
+	cov := record(t)
+	var b bytes.Buffer
+	if err := cov.WritePNG(&b); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two banks of ROM in a row, then RAM below.
+	if w, h := img.Bounds().Dx(), img.Bounds().Dy(); w != 2*tileSize+gap || h != 2*tileSize+gap {
+		t.Errorf("Expected a %dx%d image, got %dx%d", 2*tileSize+gap, 2*tileSize+gap, w, h)
+	}
+	got := color.RGBAModel.Convert(img.At(0x0100%tileSize, 0x0100/tileSize))
+	if got != colorOpcode {
+		t.Errorf("Expected $0100 to be drawn as %v, got %v", colorOpcode, got)
+	}
+}
@@ -0,0 +1,256 @@
+package coverage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// magic starts every coverage bitmap.
+const magic = "GBCV\x01"
+
+// maxROMSize is the largest ROM a bitmap can describe, 512 banks.
+const maxROMSize = 512 * bankSize
+
+// WriteBitmap writes the map as a set of bitmaps, one bit per byte of
+// memory for each kind of access:
+//
+//	offset  size       contents
+//	0       5          "GBCV" and the format version, 1
+//	5       4          the ROM size in bytes, n, little-endian
+//	9       4 × n/8    ROM bitmaps, for Opcode, Operand, Read and Written
+//	...     4 × $1000  RAM bitmaps for $8000-$FFFF, in the same order
+//
+// Bit i of a bitmap is bit i%8 (counting from the least significant bit)
+// of its byte i/8. The ROM's Written bitmap is always empty.
+func (c *Map) WriteBitmap(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(magic)
+	binary.Write(bw, binary.LittleEndian, uint32(len(c.ROM)))
+	writeBitmaps(bw, c.ROM)
+	writeBitmaps(bw, c.RAM[:])
+	return bw.Flush()
+}
+
+func writeBitmaps(w *bufio.Writer, kinds []Kind) {
+	for k := 0; k < numKinds; k++ {
+		kind := Kind(1) << uint(k)
+		for i := 0; i < len(kinds); i += 8 {
+			var b byte
+			for bit := 0; bit < 8 && i+bit < len(kinds); bit++ {
+				if kinds[i+bit]&kind != 0 {
+					b |= 1 << uint(bit)
+				}
+			}
+			w.WriteByte(b)
+		}
+	}
+}
+
+// ReadBitmap reads a map written by WriteBitmap.
+func ReadBitmap(r io.Reader) (*Map, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("reading coverage header: %v", err)
+	}
+	if string(header[:4]) != magic[:4] {
+		return nil, errors.New("not a coverage bitmap")
+	}
+	if header[4] != magic[4] {
+		return nil, fmt.Errorf("unsupported coverage bitmap version %d", header[4])
+	}
+	var size uint32
+	if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+		return nil, fmt.Errorf("reading coverage header: %v", err)
+	}
+	if size%8 != 0 || size > maxROMSize {
+		return nil, fmt.Errorf("bad ROM size %d in coverage bitmap", size)
+	}
+	c := NewMap(int(size))
+	if err := readBitmaps(br, c.ROM); err != nil {
+		return nil, err
+	}
+	if err := readBitmaps(br, c.RAM[:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func readBitmaps(r io.Reader, kinds []Kind) error {
+	bitmap := make([]byte, len(kinds)/8)
+	for k := 0; k < numKinds; k++ {
+		if _, err := io.ReadFull(r, bitmap); err != nil {
+			return fmt.Errorf("reading coverage bitmap: %v", err)
+		}
+		for i := range kinds {
+			if bitmap[i/8]&(1<<uint(i%8)) != 0 {
+				kinds[i] |= Kind(1) << uint(k)
+			}
+		}
+	}
+	return nil
+}
+
+// region is a part of RAM.
+type region struct {
+	name       string
+	start, end uint16 // inclusive
+}
+
+var regions = []region{
+	{"VRAM", 0x8000, 0x9FFF},
+	{"cartridge RAM", 0xA000, 0xBFFF},
+	{"work RAM", 0xC000, 0xDFFF},
+	{"OAM", 0xFE00, 0xFE9F},
+	{"I/O registers", 0xFF00, 0xFF7F},
+	{"high RAM", 0xFF80, 0xFFFE},
+}
+
+// WriteSummary writes how much of each ROM bank was executed or read as
+// data, and how much of each region of RAM was read, written or executed,
+// e.g.
+//
+//	ROM: 2 banks, 32768 bytes
+//	bank      code           data           untouched
+//	$00       3012  18.4%     512   3.1%    12860  78.5%
+//
+// ROM bytes that were both executed and read count as code.
+func (c *Map) WriteSummary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ROM: %d banks, %d bytes\n", c.Banks(), len(c.ROM))
+	fmt.Fprintf(bw, "%-6s %16s %16s %16s\n", "bank", "code", "data", "untouched")
+	var total [3]int
+	for bank := 0; bank < c.Banks(); bank++ {
+		var counts [3]int
+		end := (bank + 1) * bankSize
+		if end > len(c.ROM) {
+			end = len(c.ROM)
+		}
+		for _, k := range c.ROM[bank*bankSize : end] {
+			switch {
+			case k&Code != 0:
+				counts[0]++
+			case k&Read != 0:
+				counts[1]++
+			default:
+				counts[2]++
+			}
+		}
+		fmt.Fprintf(bw, "$%02X   ", bank)
+		writeCounts(bw, counts[:], end-bank*bankSize)
+		for i := range total {
+			total[i] += counts[i]
+		}
+	}
+	fmt.Fprintf(bw, "%-6s", "total")
+	writeCounts(bw, total[:], len(c.ROM))
+
+	fmt.Fprintf(bw, "\nRAM:\n%-26s %16s %16s %16s\n", "region", "read", "written", "executed")
+	for _, r := range regions {
+		var counts [3]int
+		for _, k := range c.RAM[r.start-ramStart : r.end-ramStart+1] {
+			if k&Read != 0 {
+				counts[0]++
+			}
+			if k&Written != 0 {
+				counts[1]++
+			}
+			if k&Code != 0 {
+				counts[2]++
+			}
+		}
+		fmt.Fprintf(bw, "%-14s $%04X-$%04X", r.name, r.start, r.end)
+		writeCounts(bw, counts[:], int(r.end-r.start)+1)
+	}
+	return bw.Flush()
+}
+
+// writeCounts writes a row of byte counts, with each as a percentage of
+// size.
+func writeCounts(w io.Writer, counts []int, size int) {
+	for _, n := range counts {
+		percent := 0.0
+		if size > 0 {
+			percent = 100 * float64(n) / float64(size)
+		}
+		fmt.Fprintf(w, " %9d %5.1f%%", n, percent)
+	}
+	fmt.Fprintln(w)
+}
+
+const (
+	// tileSize is the width and height in pixels of each 16KiB block of
+	// memory in the image, one pixel per byte.
+	tileSize = 128
+	// tilesPerRow is the most blocks drawn side by side.
+	tilesPerRow = 8
+	// gap is the space between blocks.
+	gap = 2
+)
+
+var (
+	colorUntouched = color.RGBA{0x20, 0x20, 0x20, 0xFF}
+	colorOpcode    = color.RGBA{0x40, 0xE0, 0x40, 0xFF}
+	colorOperand   = color.RGBA{0x20, 0x90, 0x20, 0xFF}
+	colorRead      = color.RGBA{0x40, 0x80, 0xFF, 0xFF}
+	colorWritten   = color.RGBA{0xE0, 0x40, 0x40, 0xFF}
+	colorReadWrite = color.RGBA{0xC0, 0x40, 0xC0, 0xFF}
+)
+
+// WritePNG draws the map as a PNG image, one pixel per byte. Each ROM bank
+// is a 128×128 square, in rows of up to 8 banks, followed by a row with RAM
+// from $8000-$BFFF and $C000-$FFFF. Opcodes are bright green, operands dark
+// green, data that was read blue, bytes that were written red (purple if
+// they were also read), and untouched bytes dark grey.
+func (c *Map) WritePNG(w io.Writer) error {
+	banks := c.Banks()
+	cols := banks
+	if cols > tilesPerRow {
+		cols = tilesPerRow
+	}
+	if cols < ramSize/bankSize {
+		cols = ramSize / bankSize
+	}
+	rows := (banks+cols-1)/cols + 1
+	img := image.NewRGBA(image.Rect(0, 0, cols*(tileSize+gap)-gap, rows*(tileSize+gap)-gap))
+	for bank := 0; bank < banks; bank++ {
+		end := (bank + 1) * bankSize
+		if end > len(c.ROM) {
+			end = len(c.ROM)
+		}
+		drawTile(img, bank%cols, bank/cols, c.ROM[bank*bankSize:end])
+	}
+	for i := 0; i < ramSize/bankSize; i++ {
+		drawTile(img, i, rows-1, c.RAM[i*bankSize:(i+1)*bankSize])
+	}
+	return png.Encode(w, img)
+}
+
+func drawTile(img *image.RGBA, col, row int, kinds []Kind) {
+	x0, y0 := col*(tileSize+gap), row*(tileSize+gap)
+	for i, k := range kinds {
+		img.SetRGBA(x0+i%tileSize, y0+i/tileSize, kindColor(k))
+	}
+}
+
+func kindColor(k Kind) color.RGBA {
+	switch {
+	case k&Opcode != 0:
+		return colorOpcode
+	case k&Operand != 0:
+		return colorOperand
+	case k&(Read|Written) == Read|Written:
+		return colorReadWrite
+	case k&Written != 0:
+		return colorWritten
+	case k&Read != 0:
+		return colorRead
+	}
+	return colorUntouched
+}
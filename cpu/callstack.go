@@ -0,0 +1,110 @@
+package cpu
+
+// Frame is a call that hasn't returned yet.
+type Frame struct {
+	// CallerPC is the address of the CALL or RST instruction.
+	CallerPC uint16
+	// Target is the address that was called.
+	Target uint16
+	// Bank is the bank that was mapped at Target when it was called, if
+	// the CPU was given a way to find out (see TrackCalls).
+	Bank int
+	// SP is the stack pointer on entry, i.e. the address of the return
+	// address.
+	SP uint16
+}
+
+// callStack is a shadow of the call stack kept in memory, recording how
+// each return address got there.
+//
+// Games don't always return from what they call: a routine may pop its own
+// return address to read the data after the CALL, or jump elsewhere after
+// moving SP, and a RET can be used as a computed jump by pushing an address
+// first. Rather than matching calls with returns, frames are kept while
+// their return address is still on the stack, i.e. while SP is at or below
+// the frame's SP.
+type callStack struct {
+	frames []Frame // outermost first
+	bank   func(addr uint16) int
+}
+
+// TrackCalls starts keeping a shadow call stack, which CallStack returns.
+// bank, if not nil, returns the bank mapped at an address, to record in
+// each Frame. The stack starts out empty, so calls made before tracking
+// started are never known.
+func (c *CPU) TrackCalls(bank func(addr uint16) int) {
+	c.calls = &callStack{bank: bank}
+}
+
+// CallStack returns the calls that haven't returned yet, innermost first.
+// It returns nil unless TrackCalls has been called.
+func (c *CPU) CallStack() []Frame {
+	if c.calls == nil {
+		return nil
+	}
+	return c.AppendCallStack(make([]Frame, 0, c.CallDepth()))
+}
+
+// AppendCallStack appends the frames CallStack would return to frames, and
+// returns the extended slice. It doesn't allocate if frames has room, so
+// it's suitable for calling after every instruction.
+func (c *CPU) AppendCallStack(frames []Frame) []Frame {
+	if c.calls == nil {
+		return frames
+	}
+	c.calls.unwind(c.SP)
+	for i := len(c.calls.frames) - 1; i >= 0; i-- {
+		frames = append(frames, c.calls.frames[i])
+	}
+	return frames
+}
+
+// CallDepth returns the number of calls that haven't returned yet, as
+// len(c.CallStack()) would, but without allocating.
+func (c *CPU) CallDepth() int {
+	if c.calls == nil {
+		return 0
+	}
+	c.calls.unwind(c.SP)
+	return len(c.calls.frames)
+}
+
+// enterCall records a call to target, after its return address has been
+// pushed.
+// TODO Interrupt dispatch isn't emulated, so interrupt handlers don't get
+// frames.
+func (c *CPU) enterCall(target uint16) {
+	if c.calls == nil {
+		return
+	}
+	// Frames whose return address is being overwritten are gone.
+	c.calls.unwind(c.SP + 1)
+	f := Frame{CallerPC: c.PC, Target: target, SP: c.SP}
+	if c.calls.bank != nil {
+		f.Bank = c.calls.bank(target)
+	}
+	c.calls.frames = append(c.calls.frames, f)
+}
+
+// leaveCall records a return, before the return address is popped. A RET
+// that doesn't pop a tracked return address is a jump, and leaves the
+// stack alone.
+func (c *CPU) leaveCall() {
+	if c.calls == nil {
+		return
+	}
+	c.calls.unwind(c.SP)
+	if n := len(c.calls.frames); n > 0 && c.calls.frames[n-1].SP == c.SP {
+		c.calls.frames = c.calls.frames[:n-1]
+	}
+}
+
+// unwind removes frames whose return address has been popped, i.e. is
+// below sp.
+func (s *callStack) unwind(sp uint16) {
+	n := len(s.frames)
+	for n > 0 && s.frames[n-1].SP < sp {
+		n--
+	}
+	s.frames = s.frames[:n]
+}
@@ -0,0 +1,87 @@
+package cpu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCPU_CallStack(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  map[uint16][]byte
+		steps int
+		want  []Frame
+	}{
+		{
+			"call",
+			map[uint16][]byte{0x0100: {0xCD, 0x10, 0x01}},
+			1,
+			[]Frame{{CallerPC: 0x0100, Target: 0x0110, SP: 0xFFFC}},
+		},
+		{
+			"call and return",
+			map[uint16][]byte{0x0100: {0xCD, 0x10, 0x01}, 0x0110: {0x00, 0xC9}},
+			3,
+			[]Frame{},
+		},
+		{
+			"nested calls, with banks",
+			map[uint16][]byte{0x0100: {0xCD, 0x00, 0x40}, 0x4000: {0xCF}},
+			2,
+			[]Frame{
+				{CallerPC: 0x4000, Target: 0x0008, SP: 0xFFFA},
+				{CallerPC: 0x0100, Target: 0x4000, Bank: 1, SP: 0xFFFC},
+			},
+		},
+		{
+			// rst $28; pop hl; jp hl
+			"jump table pops the return address",
+			map[uint16][]byte{0x0100: {0xEF}, 0x0028: {0xE1, 0xE9}},
+			2,
+			[]Frame{},
+		},
+		{
+			// call Sub; Sub: ld hl, $0120; push hl; ret
+			"ret used as a jump",
+			map[uint16][]byte{0x0100: {0xCD, 0x10, 0x01}, 0x0110: {0x21, 0x20, 0x01, 0xE5, 0xC9}},
+			4,
+			[]Frame{{CallerPC: 0x0100, Target: 0x0110, SP: 0xFFFC}},
+		},
+		{
+			// call Sub; Sub: ld sp, $FFFE
+			"stack pointer reset",
+			map[uint16][]byte{0x0100: {0xCD, 0x10, 0x01}, 0x0110: {0x31, 0xFE, 0xFF}},
+			2,
+			[]Frame{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, m := testSetup()
+			for addr, code := range tt.code {
+				copy(m.Mem[addr:], code)
+			}
+			c.PC, c.SP = 0x0100, 0xFFFE
+			c.TrackCalls(m.BankAt)
+			for i := 0; i < tt.steps; i++ {
+				c.Step()
+			}
+			if got := c.CallStack(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CallStack() = %+v, want %+v", got, tt.want)
+			}
+			if c.CallDepth() != len(tt.want) {
+				t.Errorf("CallDepth() = %d, want %d", c.CallDepth(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestCPU_CallStack_untracked(t *testing.T) {
+	c, m := testSetup()
+	copy(m.Mem[0x0100:], []byte{0xCD, 0x10, 0x01})
+	c.PC, c.SP = 0x0100, 0xFFFE
+	c.Step()
+	if got := c.CallStack(); got != nil {
+		t.Errorf("CallStack() = %+v, want nil without TrackCalls", got)
+	}
+}
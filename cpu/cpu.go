@@ -20,6 +20,14 @@ type MemoryWriter interface {
 	Ww(addr uint16, bb uint16)
 }
 
+// SpeedSwitcher is implemented by memory that can switch the CPU's speed,
+// as the Game Boy Color's can when STOP is executed.
+type SpeedSwitcher interface {
+	// SwitchSpeed switches speed if the game has asked to, and reports
+	// whether it did.
+	SwitchSpeed() bool
+}
+
 // New initializes and returns an instance of CPU.
 func New(memoryInterface MemoryReadWriter) *CPU {
 	cpu := &CPU{mem: memoryInterface}
@@ -47,6 +55,8 @@ type CPU struct {
 	setIME bool // set IME next instruction (used for Ei() command)
 
 	breakpoint uint16
+
+	calls *callStack // nil unless TrackCalls has been called
 }
 
 func (c *CPU) SetBreakpoint(pc uint16) {
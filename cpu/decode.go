@@ -51,6 +51,30 @@ func (i *Instruction) String() string {
 	}
 }
 
+// Mnemonic returns the instruction's mnemonic, with placeholders for any
+// immediate data, e.g. "LD A, d8" or "JP NZ, a16".
+func (i *Instruction) Mnemonic() string {
+	return i.opc.mnemonic
+}
+
+// Length returns the length of the instruction in bytes, including the
+// 0xCB prefix of prefixed instructions.
+func (i *Instruction) Length() uint16 {
+	return i.opc.length
+}
+
+// Data returns the instruction's immediate data (the bytes following the
+// opcode), if any.
+func (i *Instruction) Data() []byte {
+	return i.data
+}
+
+// Valid returns false if the instruction was decoded from one of the
+// unused opcodes (e.g. 0xD3), which lock up the real CPU.
+func (i *Instruction) Valid() bool {
+	return i.opc.mnemonic != ""
+}
+
 func Decode(addr uint16, mem MemoryReader) Instruction {
 
 	// FIXME: EDGE CASE: 'HALT' opcode may be 1 or 2 bytes long. Officially,
@@ -19,9 +19,8 @@ func (c *CPU) Execute(i Instruction) {
 		case INC_BC:
 			c.Inc_rr(RegBC)
 
-		case LD_vala16_SP: // FIXME inverted arguements??
-			// FIXME not sure about this one
-			c.Ld_SP_d16(d16(i.data))
+		case LD_vala16_SP:
+			c.Ld_valA16_SP(a16(i.data))
 
 		case STOP_0:
 			c.Halt()
@@ -28,6 +28,12 @@ func (c *CPU) Ld_SP_d16(d16 uint16) {
 	c.SP = d16
 }
 
+// Ld_valA16_SP stores SP at address a16. Like all words in memory,
+// SP is stored little-endian: the low byte at a16 and the high byte at a16+1.
+func (c *CPU) Ld_valA16_SP(a16 uint16) {
+	c.mem.Ww(a16, c.SP)
+}
+
 // Ld_SP_HL loads HL into the SP(stack pointer) register.
 func (c *CPU) Ld_SP_HL() {
 	c.SP = c.getHL()
@@ -37,9 +43,16 @@ func (c *CPU) Ld_SP_HL() {
 // (I.e., it increments SP, then loads rr into the two bytes at address of SP)
 // rr can be BC, DE, HL, AF.
 func (c *CPU) Push_rr(rr Reg16) {
-	c.SP -= 2 // stack grows downwards
 	get, _ := c.getReg16(rr)
-	c.mem.Ww(c.SP, get())
+	c.push(get())
+}
+
+// push pushes a word onto the stack. The SM83 writes the high byte first,
+// at SP-1, and then the low byte, at SP-2.
+func (c *CPU) push(w uint16) {
+	c.SP -= 2 // stack grows downwards
+	c.mem.Wb(c.SP+1, byte(w>>8))
+	c.mem.Wb(c.SP, byte(w))
 }
 
 // Pop_rr pops a value off the stack and places it in 16bit register rr.
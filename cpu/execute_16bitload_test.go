@@ -84,6 +84,34 @@ func TestCPU_Ld_SP_HL(t *testing.T) {
 	}
 }
 
+func TestCPU_Ld_valA16_SP(t *testing.T) {
+	type args struct {
+		a16 uint16
+	}
+	tests := []struct {
+		name string
+		regs Registers
+		args args
+	}{
+		{"($C000) <- SP, SP=0xBEEF", Registers{SP: 0xBEEF}, args{a16: 0xC000}},
+		{"($FFFE) <- SP, SP=0x0102", Registers{SP: 0x0102}, args{a16: 0xFFFE}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, m := testSetup()
+			c.Registers = tt.regs
+
+			c.Ld_valA16_SP(tt.args.a16)
+
+			// Expect SP to be stored little-endian at a16
+			lo, hi := m.Mem[tt.args.a16], m.Mem[tt.args.a16+1]
+			if lo != byte(tt.regs.SP) || hi != byte(tt.regs.SP>>8) {
+				t.Errorf("Expected ($%04x) to be %02x %02x, got %02x %02x", tt.args.a16, byte(tt.regs.SP), byte(tt.regs.SP>>8), lo, hi)
+			}
+		})
+	}
+}
+
 func TestCPU_Push_rr(t *testing.T) {
 	type args struct {
 		rr Reg16
@@ -351,7 +351,7 @@ func TestCPU_Ld_A_FF00_plus_a8(t *testing.T) {
 		args          args
 		valFF00plusA8 byte
 	}{
-		{"A <-($FF00+a8), a8=0x00", Registers{}, args{a8: 0x00}, 0x12},
+		{"A <-($FF00+a8), a8=0x90", Registers{}, args{a8: 0x90}, 0x12},
 		{"A <-($FF00+a8), a8=0x80", Registers{}, args{a8: 0x80}, 0x00},
 		{"A <-($FF00+a8), a8=0xFF", Registers{}, args{a8: 0xFF}, 0xF0},
 	}
@@ -383,7 +383,7 @@ func TestCPU_Ld_FF00_plus_a8_A(t *testing.T) {
 		regs Registers
 		args args
 	}{
-		{"($FF00+a8) <- A, a8=0x00, A=0x12", Registers{A: 0x12}, args{0x00}},
+		{"($FF00+a8) <- A, a8=0x90, A=0x12", Registers{A: 0x12}, args{0x90}},
 		{"($FF00+a8) <- A, a8=0x80, A=0x00", Registers{A: 0x00}, args{0x80}},
 		{"($FF00+a8) <- A, a8=0xFF, A=0xF0", Registers{A: 0xF0}, args{0xFF}},
 	}
@@ -411,7 +411,7 @@ func TestCPU_Ld_A_FF00_plus_C(t *testing.T) {
 		regs         Registers
 		valFF00plusC byte
 	}{
-		{"A <-($FF00+C), C=0x00", Registers{C: 0x00}, 0x12},
+		{"A <-($FF00+C), C=0x90", Registers{C: 0x90}, 0x12},
 		{"A <-($FF00+C), C=0x80", Registers{C: 0x80}, 0x00},
 		{"A <-($FF00+C), C=0xFF", Registers{C: 0xFF}, 0xF0},
 	}
@@ -439,7 +439,7 @@ func TestCPU_Ld_FF00_plus_C_A(t *testing.T) {
 		name string
 		regs Registers
 	}{
-		{"($FF00+C) <- A, C=0x00, A=0x12", Registers{A: 0x12, C: 0x00}},
+		{"($FF00+C) <- A, C=0x90, A=0x12", Registers{A: 0x12, C: 0x90}},
 		{"($FF00+C) <- A, C=0x80, A=0x00", Registers{A: 0x00, C: 0x80}},
 		{"($FF00+C) <- A, C=0xFF, A=0xF0", Registers{A: 0xF0, C: 0xFF}},
 	}
@@ -40,8 +40,12 @@ func (c *CPU) Halt() {
 	c.halted = true
 }
 
-// Stop enters low-power standby mode.
+// Stop enters low-power standby mode, unless the memory switches the CPU's
+// speed instead (see SpeedSwitcher).
 func (c *CPU) Stop() {
+	if s, ok := c.mem.(SpeedSwitcher); ok && s.SwitchSpeed() {
+		return
+	}
 	c.stopped = true
 }
 
@@ -2,6 +2,8 @@ package cpu
 
 import (
 	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
 )
 
 func TestCcf(t *testing.T) {
@@ -119,6 +121,35 @@ func TestHalt(t *testing.T) {
 	// FIXME figure out how to test halt bug / interrupts. Maybe I should test interrupts separately
 }
 
+func TestStop(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  mmu.MMUOptions
+		key1 byte
+		// wantStopped is whether the CPU stops, and wantDouble whether it
+		// runs at double speed after.
+		wantStopped, wantDouble bool
+	}{
+		{"DMG", mmu.MMUOptions{}, 1, true, false},
+		{"CGB", mmu.MMUOptions{Model: mmu.ModelCGB}, 0, true, false},
+		{"CGB speed switch", mmu.MMUOptions{Model: mmu.ModelCGB}, 1, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mmu.New(tt.opt)
+			c := New(m.CPUInterface)
+			c.mem.Wb(mmu.AddrKEY1, tt.key1)
+			c.Stop()
+			if c.stopped != tt.wantStopped {
+				t.Errorf("stopped = %v, want %v", c.stopped, tt.wantStopped)
+			}
+			if m.DoubleSpeed() != tt.wantDouble {
+				t.Errorf("DoubleSpeed() = %v, want %v", m.DoubleSpeed(), tt.wantDouble)
+			}
+		})
+	}
+}
+
 func TestDi(t *testing.T) {
 
 	tests := []struct {
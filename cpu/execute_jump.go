@@ -96,11 +96,11 @@ func (c *CPU) JrC(r8 int8) {
 
 // Call calls a subroutine at address a16 (push PC onto stack, jump to a16)
 func (c *CPU) Call(a16 uint16) {
-	c.SP -= 2 // stack grows downward in memory
 	// write the location of the NEXT instruction in
 	// memory -- ie, write c.PC + instr.length.
 	// increment PC to next instruction and push it onto the stack
-	c.mem.Ww(c.SP, c.PC+3)
+	c.push(c.PC + 3)
+	c.enterCall(a16)
 	c.PC = a16
 }
 
@@ -134,6 +134,7 @@ func (c *CPU) CallC(a16 uint16) {
 
 // Ret returns from a subroutine. (Pop stack and jump to that address)
 func (c *CPU) Ret() {
+	c.leaveCall()
 	// jump to address at top of stack
 	b := c.mem.Rw(c.SP)
 	c.PC = b
@@ -183,9 +184,9 @@ func (c *CPU) Rst(n RstTarget) {
 	case 0x00, 0x08, 0x10, 0x18, 0x20, 0x28, 0x30, 0x38:
 		// Manually call to address -- c.Call() might
 		// restrict access to this rea of memory.
-		c.SP -= 2 // stack grows downward
 		// increment PC to next instruction and push it onto the stack
-		c.mem.Ww(c.SP, c.PC+1) // RST instructions are 1 byte long, as RST $00, RST $20 etc are hard-coded
+		c.push(c.PC + 1) // RST instructions are 1 byte long, as RST $00, RST $20 etc are hard-coded
+		c.enterCall(uint16(n))
 		c.PC = uint16(n)
 
 	default:
@@ -0,0 +1,253 @@
+package cpu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// This file runs "SingleStepTests"-style JSON test vectors
+// (https://github.com/SingleStepTests/sm83) through Decode and Execute.
+//
+// Each file holds the vectors for one opcode (e.g. "3e.json", "cb 11.json").
+// Each vector gives the initial CPU registers and the contents of every
+// memory address the instruction touches, the expected final state, and the
+// bus activity of every machine cycle the instruction takes.
+//
+// The SM83 overlaps the fetch of the next opcode with the last cycle of the
+// current instruction, and the vectors are written from that point of view:
+// the opcode being tested is at PC-1 and has already been fetched, and the
+// final PC is one past the next opcode. Our CPU fetches at PC, so we run
+// from PC-1 and compare against the final PC minus one. Likewise, the bus
+// activity we expect is the fetch of the opcode followed by every cycle but
+// the last.
+//
+// Every access to the bus takes a cycle of its own, and the other cycles
+// leave the bus idle, so comparing the CPU's accesses in order with the
+// cycles that aren't idle, and the number of cycles, checks the bus
+// activity cycle by cycle, without the CPU having to run a cycle at a time.
+//
+// The full suite is large, so it isn't checked in. To run it, download the
+// suite and point SM83_TESTS_DIR at its v1 directory:
+//
+// 	SM83_TESTS_DIR=~/sm83/v1 go test ./cpu -run TestSingleStep
+//
+// Otherwise, the vectors in testdata/sm83 are run. They're written by hand
+// in the suite's format, from the instruction timings in Pan Docs, rather
+// than taken from the suite.
+
+const singleStepDirEnv = "SM83_TESTS_DIR"
+
+type singleStepTest struct {
+	Name    string            `json:"name"`
+	Initial singleStepState   `json:"initial"`
+	Final   singleStepState   `json:"final"`
+	Cycles  []json.RawMessage `json:"cycles"`
+}
+
+type singleStepState struct {
+	PC  uint16      `json:"pc"`
+	SP  uint16      `json:"sp"`
+	A   byte        `json:"a"`
+	B   byte        `json:"b"`
+	C   byte        `json:"c"`
+	D   byte        `json:"d"`
+	E   byte        `json:"e"`
+	F   byte        `json:"f"`
+	H   byte        `json:"h"`
+	L   byte        `json:"l"`
+	IME byte        `json:"ime"`
+	RAM [][2]uint16 `json:"ram"`
+}
+
+func (s singleStepState) registers() Registers {
+	return Registers{A: s.A, B: s.B, C: s.C, D: s.D, E: s.E, F: s.F, H: s.H, L: s.L, SP: s.SP, PC: s.PC}
+}
+
+// busAccess is a single byte read from or written to the bus.
+type busAccess struct {
+	addr  uint16
+	val   byte
+	write bool
+}
+
+func (a busAccess) String() string {
+	if a.write {
+		return fmt.Sprintf("w$%04X=$%02X", a.addr, a.val)
+	}
+	return fmt.Sprintf("r$%04X=$%02X", a.addr, a.val)
+}
+
+// testBus is a mock 64KiB bus that records every access made through it.
+type testBus struct {
+	mem      [0x10000]byte
+	accesses []busAccess
+}
+
+func (b *testBus) Rb(addr uint16) byte {
+	v := b.mem[addr]
+	b.accesses = append(b.accesses, busAccess{addr, v, false})
+	return v
+}
+
+func (b *testBus) Rw(addr uint16) uint16 {
+	lo := b.Rb(addr)
+	return uint16(b.Rb(addr+1))<<8 | uint16(lo)
+}
+
+func (b *testBus) Wb(addr uint16, v byte) {
+	b.mem[addr] = v
+	b.accesses = append(b.accesses, busAccess{addr, v, true})
+}
+
+func (b *testBus) Ww(addr uint16, w uint16) {
+	b.Wb(addr, byte(w))
+	b.Wb(addr+1, byte(w>>8))
+}
+
+// expectedAccesses returns the bus accesses we expect the CPU to make: the
+// fetch of the opcode at the initial PC-1, then those listed in a vector's
+// cycles but the last, which fetches the next opcode. Each cycle is either
+// null (the bus is idle) or [addr, value, activity], where activity is a
+// string like "r-m" (read) or "-wm" (write).
+func (tt singleStepTest) expectedAccesses(opcode byte) ([]busAccess, error) {
+	accesses := []busAccess{{tt.Initial.PC - 1, opcode, false}}
+	for i, raw := range tt.Cycles {
+		var cycle []interface{}
+		if err := json.Unmarshal(raw, &cycle); err != nil {
+			return nil, err
+		}
+		if cycle == nil {
+			continue
+		}
+		if len(cycle) != 3 {
+			return nil, fmt.Errorf("bad cycle %s", raw)
+		}
+		addr, aok := cycle[0].(float64)
+		val, vok := cycle[1].(float64)
+		activity, sok := cycle[2].(string)
+		if !aok || !vok || !sok {
+			return nil, fmt.Errorf("bad cycle %s", raw)
+		}
+		a := busAccess{uint16(addr), byte(val), strings.Contains(activity, "w")}
+		if i == len(tt.Cycles)-1 {
+			if a.write || a.addr != tt.Final.PC-1 {
+				return nil, fmt.Errorf("last cycle %s isn't the fetch of the next opcode", raw)
+			}
+			continue
+		}
+		accesses = append(accesses, a)
+	}
+	return accesses, nil
+}
+
+// run executes one vector on c and returns a description of every way in
+// which the final state differs from the expected state.
+func (tt singleStepTest) run(c *CPU) (problems []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			problems = append(problems, fmt.Sprintf("panic: %v", r))
+		}
+	}()
+
+	bus := &testBus{}
+	for _, kv := range tt.Initial.RAM {
+		bus.mem[kv[0]] = byte(kv[1])
+	}
+	opcode := bus.mem[tt.Initial.PC-1]
+	c.mem = bus
+	c.Registers = tt.Initial.registers()
+	c.PC-- // see the comment at the top of this file
+	c.ime = tt.Initial.IME != 0
+	c.setIME = false
+	c.halted = false
+	c.stopped = false
+
+	_, cycles := c.Step()
+
+	want := tt.Final.registers()
+	want.PC--
+	if c.Registers != want {
+		problems = append(problems, fmt.Sprintf("registers: got %s, want %s", formatRegisters(c.Registers), formatRegisters(want)))
+	}
+	if wantIME := tt.Final.IME != 0; c.ime != wantIME {
+		problems = append(problems, fmt.Sprintf("IME: got %v, want %v", c.ime, wantIME))
+	}
+	for _, kv := range tt.Final.RAM {
+		if got := bus.mem[kv[0]]; got != byte(kv[1]) {
+			problems = append(problems, fmt.Sprintf("($%04X): got $%02X, want $%02X", kv[0], got, kv[1]))
+		}
+	}
+	if wantCycles := len(tt.Cycles) * 4; cycles != wantCycles {
+		problems = append(problems, fmt.Sprintf("cycles: got %d, want %d", cycles, wantCycles))
+	}
+	wantAccesses, err := tt.expectedAccesses(opcode)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if got, want := formatAccesses(bus.accesses), formatAccesses(wantAccesses); got != want {
+		problems = append(problems, fmt.Sprintf("bus activity: got [%s], want [%s]", got, want))
+	}
+	return problems
+}
+
+func formatAccesses(accesses []busAccess) string {
+	parts := make([]string, len(accesses))
+	for i, a := range accesses {
+		parts[i] = a.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatRegisters(r Registers) string {
+	return fmt.Sprintf("{A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X}",
+		r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, r.PC)
+}
+
+func TestSingleStep(t *testing.T) {
+	dir := os.Getenv(singleStepDirEnv)
+	if dir == "" {
+		dir = filepath.Join("testdata", "sm83")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skipf("No test vectors found in %s", dir)
+	}
+	// One CPU is reused for every vector: New starts a clock ticker, and
+	// we don't want one of those per vector.
+	c := New(&testBus{})
+	for _, file := range files {
+		opcode := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(opcode, func(t *testing.T) {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tests []singleStepTest
+			if err := json.Unmarshal(data, &tests); err != nil {
+				t.Fatalf("Couldn't parse %s: %v", file, err)
+			}
+			failed := 0
+			var firstFailure string
+			for _, tt := range tests {
+				problems := tt.run(c)
+				if len(problems) == 0 {
+					continue
+				}
+				failed++
+				if firstFailure == "" {
+					firstFailure = fmt.Sprintf("%q:\n\t%s", tt.Name, strings.Join(problems, "\n\t"))
+				}
+			}
+			if failed > 0 {
+				t.Errorf("%d/%d vectors failed. First failure: %s", failed, len(tests), firstFailure)
+			}
+		})
+	}
+}
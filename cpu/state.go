@@ -0,0 +1,38 @@
+package cpu
+
+// State is everything about the CPU that affects how it executes, for
+// saving and restoring, e.g. to rewind.
+type State struct {
+	Registers
+	Halted, Stopped bool
+	IME, SetIME     bool
+	// Calls is the shadow call stack, innermost first, if calls are being
+	// tracked (see TrackCalls).
+	Calls []Frame
+}
+
+// State returns the CPU's state.
+func (c *CPU) State() State {
+	return State{
+		Registers: c.Registers,
+		Halted:    c.halted,
+		Stopped:   c.stopped,
+		IME:       c.ime,
+		SetIME:    c.setIME,
+		Calls:     c.CallStack(),
+	}
+}
+
+// SetState restores a state returned by State. The call stack is only
+// restored if calls are being tracked.
+func (c *CPU) SetState(s State) {
+	c.Registers = s.Registers
+	c.halted, c.stopped = s.Halted, s.Stopped
+	c.ime, c.setIME = s.IME, s.SetIME
+	if c.calls != nil {
+		c.calls.frames = c.calls.frames[:0]
+		for i := len(s.Calls) - 1; i >= 0; i-- {
+			c.calls.frames = append(c.calls.frames, s.Calls[i])
+		}
+	}
+}
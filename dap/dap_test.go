@@ -0,0 +1,294 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/debugger"
+	"github.com/mpingram/gameboy-emu/internal/debugtest"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+const testSource = `SECTION "Main", ROM0[$100]
+Main:
+	ld a, 0
+.loop
+	inc a       ; count up
+	call Sub
+	jr .loop
+
+Sub:
+	ld [wCounter], a
+	ld b, [hl]
+	ret
+	db 1, 2, 3
+	nop
+`
+
+const testSym = `
+00:0100 Main
+00:0102 Main.loop
+00:0110 Sub
+00:c000 wCounter
+`
+
+// newTestServer returns a server for a CPU about to run testSource, and the
+// path of the source file.
+func newTestServer(t *testing.T) (*Server, string) {
+	dir, err := ioutil.TempDir("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "main.asm")
+	if err := ioutil.WriteFile(path, []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syms, err := symbols.Load(strings.NewReader(testSym))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := debugtest.New(map[uint16][]byte{
+		0x0100: {0x3E, 0x00, 0x3C, 0xCD, 0x10, 0x01, 0x18, 0xFA},
+		0x0110: {0xEA, 0x00, 0xC0, 0x46, 0xC9, 0x01, 0x02, 0x03, 0x00},
+	})
+	target.CPU.H, target.CPU.L = 0xC0, 0x10
+	lines, err := BuildLineMap(target.MMU.Mem[:0x8000], syms, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(debugger.New(target, syms), lines), path
+}
+
+func TestLineMap(t *testing.T) {
+	s, path := newTestServer(t)
+	tests := []struct {
+		line     int
+		wantAddr uint16
+		wantLine int
+		wantOK   bool
+	}{
+		{1, 0x0100, 3, true},
+		{3, 0x0100, 3, true},
+		{4, 0x0102, 5, true},
+		{6, 0x0103, 6, true},
+		{7, 0x0106, 7, true},
+		{8, 0x0110, 10, true},
+		{12, 0x0114, 12, true},
+		// After data, we don't know where the code is.
+		{13, 0, 0, false},
+		{14, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.line), func(t *testing.T) {
+			a, line, ok := s.lines.Address(path, tt.line)
+			if ok != tt.wantOK || a.Addr != tt.wantAddr || line != tt.wantLine {
+				t.Errorf("Address(%d) = $%04X, %d, %t, want $%04X, %d, %t", tt.line, a.Addr, line, ok, tt.wantAddr, tt.wantLine, tt.wantOK)
+			}
+		})
+	}
+	if l, ok := s.lines.Line(symbols.Address{Addr: 0x0113}); !ok || l != (Line{path, 11}) {
+		t.Errorf("Line($0113) = %+v, %t, want line 11", l, ok)
+	}
+}
+
+func TestSplitLabel(t *testing.T) {
+	tests := []struct {
+		line       string
+		name, rest string
+		ok         bool
+	}{
+		{"Main:", "Main", "", true},
+		{"Main:: ld a, b", "Main", " ld a, b", true},
+		{".loop", ".loop", "", true},
+		{"\t.loop: inc a", ".loop", " inc a", true},
+		{"\tld a, b", "", "", false},
+		{"\t.loop", "", "", false},
+		{"FOO EQU 3", "", "", false},
+	}
+	for _, tt := range tests {
+		name, rest, ok := splitLabel(tt.line)
+		if name != tt.name || rest != tt.rest || ok != tt.ok {
+			t.Errorf("splitLabel(%q) = %q, %q, %t, want %q, %q, %t", tt.line, name, rest, ok, tt.name, tt.rest, tt.ok)
+		}
+	}
+}
+
+// testClient is the editor's end of a session.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *textproto.Reader
+	seq    int
+	events []map[string]interface{}
+}
+
+func (c *testClient) read() map[string]interface{} {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("Bad message %q: %v", body, err)
+	}
+	return msg
+}
+
+// request sends a request and returns the body of the response, failing the
+// test if it's an error. Events that arrive first are kept for event.
+func (c *testClient) request(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.seq++
+	b, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if _, err := c.conn.Write([]byte("Content-Length: " + strconv.Itoa(len(b)) + "\r\n\r\n" + string(b))); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.read()
+		if msg["type"] == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg["request_seq"] != float64(c.seq) || msg["success"] != true {
+			c.t.Fatalf("%s: got %v", command, msg)
+		}
+		body, _ := msg["body"].(map[string]interface{})
+		return body
+	}
+}
+
+// event returns the body of the next event, which must have the given name.
+func (c *testClient) event(name string) map[string]interface{} {
+	c.t.Helper()
+	var msg map[string]interface{}
+	if len(c.events) > 0 {
+		msg, c.events = c.events[0], c.events[1:]
+	} else {
+		msg = c.read()
+	}
+	if msg["event"] != name {
+		c.t.Fatalf("Got %v, want a %s event", msg, name)
+	}
+	body, _ := msg["body"].(map[string]interface{})
+	return body
+}
+
+func TestServer_Serve(t *testing.T) {
+	s, path := newTestServer(t)
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan bool)
+	go func() {
+		terminate, _ := s.Serve(serverConn)
+		done <- terminate
+	}()
+	c := &testClient{t: t, conn: clientConn, r: textproto.NewReader(bufio.NewReader(clientConn))}
+
+	if caps := c.request("initialize", map[string]string{"adapterID": "gameboy"}); caps["supportsConditionalBreakpoints"] != true {
+		t.Errorf("initialize: got capabilities %v", caps)
+	}
+	c.event("initialized")
+	c.request("launch", nil)
+	bps := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]interface{}{{"line": 9, "condition": "a == 2"}, {"line": 14}},
+	})["breakpoints"].([]interface{})
+	if bp := bps[0].(map[string]interface{}); bp["verified"] != true || bp["line"] != float64(10) {
+		t.Errorf("Breakpoint on line 9: got %v, want it moved to line 10", bp)
+	}
+	if bp := bps[1].(map[string]interface{}); bp["verified"] != false {
+		t.Errorf("Breakpoint on line 14: got %v, want it unverified", bp)
+	}
+	c.request("configurationDone", nil)
+	if stop := c.event("stopped"); stop["reason"] != "breakpoint" {
+		t.Errorf("Stopped with %v, want a breakpoint", stop)
+	}
+
+	frames := c.request("stackTrace", map[string]int{"threadId": threadID})["stackFrames"].([]interface{})
+	var got []string
+	for _, f := range frames {
+		f := f.(map[string]interface{})
+		got = append(got, f["name"].(string)+":"+strconv.Itoa(int(f["line"].(float64))))
+	}
+	if want := "Sub:10 Main.loop+1:6"; strings.Join(got, " ") != want {
+		t.Errorf("Stack trace = %q, want %q", strings.Join(got, " "), want)
+	}
+
+	vars := c.request("variables", map[string]int{"variablesReference": registersRef})["variables"].([]interface{})
+	if a := vars[0].(map[string]interface{}); a["name"] != "A" || a["value"] != "$02 (2)" {
+		t.Errorf("First register = %v, want A = $02 (2)", a)
+	}
+	c.request("setVariable", map[string]interface{}{"variablesReference": registersRef, "name": "A", "value": "$10"})
+	if r := c.request("evaluate", map[string]string{"expression": "a + [wCounter]"}); r["result"] != "17 ($11)" {
+		t.Errorf("evaluate: got %v, want 17 ($11)", r)
+	}
+	if r := c.request("readMemory", map[string]interface{}{"memoryReference": "0xC000", "count": 2}); r["data"] != "AQA=" {
+		t.Errorf("readMemory: got %v, want [1 0]", r)
+	}
+
+	c.request("stepIn", map[string]int{"threadId": threadID})
+	if stop := c.event("stopped"); stop["reason"] != "step" {
+		t.Errorf("Stopped with %v, want a step", stop)
+	}
+	if r := c.request("evaluate", map[string]string{"expression": "[wCounter]"}); r["result"] != "16 ($10)" {
+		t.Errorf("After stepping, [wCounter] = %v, want 16", r["result"])
+	}
+
+	c.request("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": path}, "breakpoints": []interface{}{}})
+	c.request("continue", map[string]int{"threadId": threadID})
+	c.request("pause", map[string]int{"threadId": threadID})
+	if stop := c.event("stopped"); stop["reason"] != "pause" {
+		t.Errorf("Stopped with %v, want a pause", stop)
+	}
+
+	c.request("disconnect", map[string]bool{"terminateDebuggee": true})
+	if terminate := <-done; !terminate {
+		t.Errorf("Serve() returned false, want true to terminate")
+	}
+}
+
+func TestServer_Serve_connectionLost(t *testing.T) {
+	s, path := newTestServer(t)
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := s.Serve(serverConn)
+		done <- err
+	}()
+	c := &testClient{t: t, conn: clientConn, r: textproto.NewReader(bufio.NewReader(clientConn))}
+	c.request("initialize", map[string]string{"adapterID": "gameboy"})
+	c.event("initialized")
+	c.request("launch", nil)
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]interface{}{{"line": 10}},
+	})
+	c.request("configurationDone", nil)
+	c.event("stopped")
+
+	// The editor goes away without disconnecting, and the game is left to
+	// run without its breakpoints.
+	clientConn.Close()
+	if err := <-done; err == nil {
+		t.Error("Serve() returned no error when the connection was lost")
+	}
+	if bps := s.d.Breakpoints(); len(bps) != 0 {
+		t.Errorf("%d breakpoints left after the connection was lost, want 0", len(bps))
+	}
+}
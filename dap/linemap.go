@@ -0,0 +1,231 @@
+package dap
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// sourceExtensions are the extensions of RGBDS source files.
+var sourceExtensions = map[string]bool{".asm": true, ".s": true, ".inc": true, ".z80": true, ".sm83": true}
+
+// Line is a line of a source file.
+type Line struct {
+	File string // absolute path
+	Line int    // 1-based
+}
+
+// LineMap maps lines of RGBDS source to the addresses of the instructions
+// they assembled to, and back.
+//
+// RGBDS doesn't record line numbers, so the map is rebuilt from the labels in
+// the .sym file: each instruction line after a label is matched with the
+// next instruction in the ROM, checking that the mnemonics agree. Mapping
+// stops at anything whose size isn't known (data, macros, SECTION) until the
+// next label, so those lines can't have breakpoints. A nil *LineMap maps
+// nothing.
+type LineMap struct {
+	lines map[string][]mappedLine // by file, in order of line
+	addrs map[symbols.Address]Line
+}
+
+type mappedLine struct {
+	line int
+	addr symbols.Address
+}
+
+// BuildLineMap reads the source files in dirs and their subdirectories, and
+// maps their lines to addresses in rom using syms.
+func BuildLineMap(rom []byte, syms *symbols.Table, dirs []string) (*LineMap, error) {
+	lm := &LineMap{lines: make(map[string][]mappedLine), addrs: make(map[symbols.Address]Line)}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !sourceExtensions[strings.ToLower(filepath.Ext(path))] {
+				return err
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return lm.addFile(abs, f, rom, syms)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lm, nil
+}
+
+// addFile maps the lines of one source file.
+func (lm *LineMap) addFile(path string, src io.Reader, rom []byte, syms *symbols.Table) error {
+	var (
+		scope string // the current global label, for local labels
+		addr  symbols.Address
+		known bool // whether addr is the address of the next instruction
+	)
+	scanner := bufio.NewScanner(src)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		if name, rest, ok := splitLabel(line); ok {
+			if strings.HasPrefix(name, ".") {
+				name = scope + name
+			} else if i := strings.IndexByte(name, '.'); i >= 0 {
+				scope = name[:i]
+			} else {
+				scope = name
+			}
+			addr, known = syms.Lookup(name)
+			line = rest
+		}
+		fields := strings.Fields(strings.Replace(line, ",", " ", -1))
+		if len(fields) == 0 {
+			continue
+		}
+		if !known || noCode(fields) {
+			continue
+		}
+		instr := cpu.Decode(addr.Addr, romReader{rom, addr.Bank})
+		if !instr.Valid() || !sameMnemonic(fields[0], instr.Mnemonic()) {
+			// Data, a directive or a macro: we can't know how big it is.
+			known = false
+			continue
+		}
+		lm.lines[path] = append(lm.lines[path], mappedLine{n, addr})
+		if _, ok := lm.addrs[addr]; !ok {
+			lm.addrs[addr] = Line{path, n}
+		}
+		addr.Addr += instr.Length()
+	}
+	return scanner.Err()
+}
+
+// noCode returns true if a line is a directive that doesn't emit anything,
+// like a constant definition.
+func noCode(fields []string) bool {
+	switch strings.ToUpper(fields[0]) {
+	case "DEF", "REDEF", "EXPORT", "GLOBAL", "PURGE", "ASSERT", "STATIC_ASSERT", "PRINT", "PRINTLN", "WARN", "OPT", "PUSHO", "POPO":
+		return true
+	}
+	if len(fields) > 1 {
+		switch strings.ToUpper(fields[1]) {
+		case "EQU", "EQUS", "=", "SET":
+			return true
+		}
+	}
+	return false
+}
+
+// splitLabel splits a label definition from the start of a line. Global
+// labels start in the first column and end with one or two colons; local
+// labels start with a dot, and the colon is optional.
+func splitLabel(line string) (name, rest string, ok bool) {
+	trimmed := strings.TrimLeft(line, " \t")
+	if trimmed == "" || !(isLabelStart(trimmed[0]) || trimmed[0] == '.') {
+		return "", "", false
+	}
+	end := 1
+	for end < len(trimmed) && isLabelChar(trimmed[end]) {
+		end++
+	}
+	name, rest = trimmed[:end], trimmed[end:]
+	switch {
+	case strings.HasPrefix(rest, "::"):
+		return name, rest[2:], true
+	case strings.HasPrefix(rest, ":"):
+		return name, rest[1:], true
+	case name[0] == '.' && name != "." && len(trimmed) == len(line):
+		return name, rest, true
+	}
+	return "", "", false
+}
+
+func isLabelStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isLabelChar(c byte) bool {
+	return isLabelStart(c) || c >= '0' && c <= '9' || c == '.' || c == '@' || c == '#' || c == '$'
+}
+
+// sameMnemonic returns true if op, as written in source, could have
+// assembled to an instruction with the given mnemonic, e.g. "ldi" to
+// "LD (HL+), A".
+func sameMnemonic(op, mnemonic string) bool {
+	op = strings.ToUpper(op)
+	decoded := strings.Fields(mnemonic)[0]
+	switch op {
+	case "LDI", "LDD", "LDH":
+		op = "LD"
+	}
+	if decoded == "LDH" {
+		decoded = "LD"
+	}
+	return op == decoded
+}
+
+// romReader reads a bank of a ROM as the CPU would see it with that bank
+// mapped. Addresses outside the ROM read as 0xFF.
+type romReader struct {
+	rom  []byte
+	bank int
+}
+
+func (r romReader) Rb(addr uint16) byte {
+	off := int(addr)
+	if addr >= 0x4000 {
+		off = r.bank*0x4000 + int(addr-0x4000)
+	}
+	if addr >= 0x8000 || off >= len(r.rom) {
+		return 0xFF
+	}
+	return r.rom[off]
+}
+
+func (r romReader) Rw(addr uint16) uint16 {
+	return uint16(r.Rb(addr+1))<<8 | uint16(r.Rb(addr))
+}
+
+// Address returns the address of the first instruction at or after line in
+// file, and the line it's on. ok is false if there's no code there.
+func (lm *LineMap) Address(file string, line int) (a symbols.Address, actual int, ok bool) {
+	if lm == nil {
+		return symbols.Address{}, 0, false
+	}
+	lines := lm.lines[file]
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].line >= line })
+	if i == len(lines) {
+		return symbols.Address{}, 0, false
+	}
+	return lines[i].addr, lines[i].line, true
+}
+
+// Line returns the source line an instruction came from.
+func (lm *LineMap) Line(a symbols.Address) (Line, bool) {
+	if lm == nil {
+		return Line{}, false
+	}
+	l, ok := lm.addrs[a]
+	return l, ok
+}
+
+// Len returns the number of lines mapped.
+func (lm *LineMap) Len() int {
+	if lm == nil {
+		return 0
+	}
+	return len(lm.addrs)
+}
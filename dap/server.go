@@ -0,0 +1,716 @@
+// Package dap implements a Debug Adapter Protocol server, so that editors
+// like VS Code can debug games running in the emulator. It supports
+// breakpoints on lines of RGBDS source (see LineMap) and on addresses,
+// conditional breakpoints, stepping over, into and out of calls, pausing,
+// registers as variables, expressions, reading and writing memory, and the
+// CPU's shadow call stack.
+//
+// The protocol is described at
+// https://microsoft.github.io/debug-adapter-protocol/specification.
+package dap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/debugger"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// threadID is the ID of the only thread, the CPU.
+const threadID = 1
+
+// Variable references for the scopes of each stack frame. The registers are
+// the same whichever frame is selected.
+const (
+	registersRef = iota + 1
+	flagsRef
+	ioRegistersRef
+)
+
+// ioRegisters are the hardware registers shown in the "I/O" scope.
+var ioRegisters = []struct {
+	name string
+	addr uint16
+}{
+	{"P1", 0xFF00}, {"DIV", 0xFF04}, {"TIMA", 0xFF05}, {"TMA", 0xFF06}, {"TAC", 0xFF07},
+	{"IF", 0xFF0F}, {"LCDC", 0xFF40}, {"STAT", 0xFF41}, {"SCY", 0xFF42}, {"SCX", 0xFF43},
+	{"LY", 0xFF44}, {"LYC", 0xFF45}, {"BGP", 0xFF47}, {"OBP0", 0xFF48}, {"OBP1", 0xFF49},
+	{"WY", 0xFF4A}, {"WX", 0xFF4B}, {"IE", 0xFFFF},
+}
+
+var errRunning = errors.New("the game is running")
+
+// Server debugs a game for one client at a time.
+type Server struct {
+	d     *debugger.Debugger
+	lines *LineMap
+	conn  *conn
+
+	// sourceBreakpoints are the IDs of the debugger breakpoints set on each
+	// source file, which are replaced all at once by setBreakpoints.
+	sourceBreakpoints map[string][]int
+	// instructionBreakpoints are the IDs of breakpoints set on addresses.
+	instructionBreakpoints []int
+	stopOnEntry            bool
+	// resumeWith is run in the background after the response to a request
+	// to continue or step is sent, so that the stopped event comes after
+	// the response.
+	resumeWith func() debugger.Stop
+	// paused is where a pause request stopped the game, to be reported
+	// after the response.
+	paused *debugger.Stop
+
+	mu sync.Mutex
+	// running is true while the debugger runs the game in the background.
+	running bool
+	// pausing receives where the game stopped when a request interrupts it
+	// in order to look at it.
+	pausing chan debugger.Stop
+}
+
+// New returns a server for d. lines may be nil, in which case breakpoints
+// can only be set on addresses.
+func New(d *debugger.Debugger, lines *LineMap) *Server {
+	return &Server{d: d, lines: lines, sourceBreakpoints: make(map[string][]int)}
+}
+
+// Serve handles a session on rw until the client disconnects. The game
+// doesn't run until the client has finished configuring breakpoints, and is
+// left paused with the client's breakpoints removed. It returns true if the
+// client asked for the game to be terminated.
+func (s *Server) Serve(rw io.ReadWriter) (terminate bool, err error) {
+	s.conn = newConn(rw)
+	for {
+		req, err := s.conn.readRequest()
+		if err != nil {
+			s.pause()
+			s.clearBreakpoints()
+			return false, err
+		}
+		if req.Command == "disconnect" {
+			var args struct {
+				TerminateDebuggee bool `json:"terminateDebuggee"`
+			}
+			json.Unmarshal(req.Arguments, &args)
+			s.pause()
+			s.clearBreakpoints()
+			return args.TerminateDebuggee, s.conn.respond(req, nil)
+		}
+		body, err := s.handle(req)
+		if err != nil {
+			err = s.conn.respondError(req, err)
+		} else {
+			err = s.conn.respond(req, body)
+		}
+		if err != nil {
+			return false, err
+		}
+		if s.resumeWith != nil {
+			s.resume(s.resumeWith)
+			s.resumeWith = nil
+		}
+		if s.paused != nil {
+			s.stopped(*s.paused)
+			s.paused = nil
+		}
+		switch {
+		case req.Command == "initialize":
+			err = s.conn.event("initialized", nil)
+		case req.Command == "configurationDone" && s.stopOnEntry:
+			err = s.conn.event("stopped", map[string]interface{}{"reason": "entry", "threadId": threadID, "allThreadsStopped": true})
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+func (s *Server) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest":  true,
+			"supportsConditionalBreakpoints":    true,
+			"supportsHitConditionalBreakpoints": true,
+			"supportsEvaluateForHovers":         true,
+			"supportsSetVariable":               true,
+			"supportsReadMemoryRequest":         true,
+			"supportsWriteMemoryRequest":        true,
+			"supportsInstructionBreakpoints":    true,
+		}, nil
+	case "launch", "attach":
+		// The emulator has already loaded the game.
+		var args struct {
+			StopOnEntry bool `json:"stopOnEntry"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		s.stopOnEntry = args.StopOnEntry
+		return nil, nil
+	case "configurationDone":
+		if !s.stopOnEntry {
+			s.resumeWith = s.d.Continue
+		}
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []map[string]interface{}{{"id": threadID, "name": "CPU"}}}, nil
+	case "pause":
+		if stop, wasRunning := s.pause(); wasRunning {
+			s.paused = &stop
+		}
+		return nil, nil
+	case "continue":
+		if err := s.resumeIfStopped(s.d.Continue); err != nil {
+			return nil, err
+		}
+		return map[string]bool{"allThreadsContinued": true}, nil
+	case "next":
+		return nil, s.resumeIfStopped(s.d.StepOver)
+	case "stepIn":
+		return nil, s.resumeIfStopped(func() debugger.Stop { return s.d.Step(1) })
+	case "stepOut":
+		return nil, s.resumeIfStopped(s.d.StepOut)
+	}
+
+	// Everything else looks at or changes the game, so it has to be
+	// stopped.
+	var body interface{}
+	var err error
+	s.whilePaused(func() {
+		body, err = s.handlePaused(req)
+	})
+	return body, err
+}
+
+func (s *Server) handlePaused(req *request) (interface{}, error) {
+	switch req.Command {
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		return s.setInstructionBreakpoints(req.Arguments)
+	case "stackTrace":
+		return s.stackTrace(), nil
+	case "scopes":
+		return map[string]interface{}{"scopes": []map[string]interface{}{
+			{"name": "Registers", "presentationHint": "registers", "variablesReference": registersRef, "expensive": false},
+			{"name": "Flags", "variablesReference": flagsRef, "expensive": false},
+			{"name": "I/O", "variablesReference": ioRegistersRef, "expensive": false},
+		}}, nil
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variables": s.variables(args.VariablesReference)}, nil
+	case "setVariable":
+		return s.setVariable(req.Arguments)
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		x, err := debugger.ParseExpr(args.Expression, s.d.Symbols())
+		if err != nil {
+			return nil, err
+		}
+		v := s.d.Eval(x)
+		return map[string]interface{}{
+			"result":             fmt.Sprintf("%d ($%X)", v, v),
+			"variablesReference": 0,
+			"memoryReference":    memoryReference(uint16(v)),
+		}, nil
+	case "readMemory":
+		return s.readMemory(req.Arguments)
+	case "writeMemory":
+		return s.writeMemory(req.Arguments)
+	}
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// resume runs fn in the background, and sends a stopped event when it
+// returns.
+func (s *Server) resume(fn func() debugger.Stop) {
+	s.mu.Lock()
+	s.running = true
+	s.mu.Unlock()
+	go func() {
+		stop := fn()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running = false
+		if s.pausing != nil {
+			s.pausing <- stop
+			return
+		}
+		s.stopped(stop)
+	}()
+}
+
+// resumeIfStopped arranges for fn to run once the response to the current
+// request is sent, unless the game is already running.
+func (s *Server) resumeIfStopped(fn func() debugger.Stop) error {
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	if running {
+		return errRunning
+	}
+	s.resumeWith = fn
+	return nil
+}
+
+// pause stops the game if it's running, and returns where it stopped.
+func (s *Server) pause() (stop debugger.Stop, wasRunning bool) {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return debugger.Stop{}, false
+	}
+	pausing := make(chan debugger.Stop, 1)
+	s.pausing = pausing
+	s.mu.Unlock()
+
+	// The interrupt is lost if it comes before the debugger starts
+	// running, so keep sending it until the game stops.
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		s.d.Interrupt()
+		select {
+		case stop = <-pausing:
+			s.mu.Lock()
+			s.pausing = nil
+			s.mu.Unlock()
+			return stop, true
+		case <-tick.C:
+		}
+	}
+}
+
+// whilePaused runs fn with the game stopped. If the game was running, it
+// carries on afterwards, unless it stopped for some other reason in the
+// meantime.
+func (s *Server) whilePaused(fn func()) {
+	stop, wasRunning := s.pause()
+	fn()
+	if !wasRunning {
+		return
+	}
+	if stop.Reason == debugger.StopInterrupt {
+		s.resume(s.d.Continue)
+		return
+	}
+	s.mu.Lock()
+	s.stopped(stop)
+	s.mu.Unlock()
+}
+
+// stopped tells the client why the game stopped.
+func (s *Server) stopped(stop debugger.Stop) {
+	body := map[string]interface{}{"threadId": threadID, "allThreadsStopped": true}
+	switch stop.Reason {
+	case debugger.StopStep:
+		body["reason"] = "step"
+	case debugger.StopBreakpoint:
+		body["reason"] = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.Breakpoint.ID}
+	case debugger.StopWatchpoint:
+		body["reason"] = "data breakpoint"
+		body["description"] = fmt.Sprintf("Watchpoint %d: %s", stop.Watchpoint.ID, s.d.Describe(stop.Access.Addr))
+	case debugger.StopInterrupt:
+		body["reason"] = "pause"
+	}
+	s.conn.event("stopped", body)
+}
+
+type sourceBreakpoint struct {
+	Line         int    `json:"line"`
+	Condition    string `json:"condition"`
+	HitCondition string `json:"hitCondition"`
+}
+
+// setBreakpoints replaces the breakpoints in a source file.
+func (s *Server) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range s.sourceBreakpoints[path] {
+		s.d.Delete(id)
+	}
+	s.sourceBreakpoints[path] = nil
+
+	results := make([]map[string]interface{}, 0, len(args.Breakpoints))
+	for _, b := range args.Breakpoints {
+		a, line, ok := s.lines.Address(path, b.Line)
+		if !ok {
+			results = append(results, map[string]interface{}{"verified": false, "line": b.Line, "message": "No code found at or after this line"})
+			continue
+		}
+		cond, err := s.condition(b.Condition, b.HitCondition)
+		if err != nil {
+			results = append(results, map[string]interface{}{"verified": false, "line": b.Line, "message": err.Error()})
+			continue
+		}
+		bp := s.d.AddBreakpoint(a, false, cond)
+		s.sourceBreakpoints[path] = append(s.sourceBreakpoints[path], bp.ID)
+		results = append(results, map[string]interface{}{"id": bp.ID, "verified": true, "line": line, "instructionReference": memoryReference(a.Addr)})
+	}
+	return map[string]interface{}{"breakpoints": results}, nil
+}
+
+// setInstructionBreakpoints replaces the breakpoints set on addresses, e.g.
+// from a disassembly view.
+func (s *Server) setInstructionBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+			Condition            string `json:"condition"`
+			HitCondition         string `json:"hitCondition"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	for _, id := range s.instructionBreakpoints {
+		s.d.Delete(id)
+	}
+	s.instructionBreakpoints = nil
+
+	results := make([]map[string]interface{}, 0, len(args.Breakpoints))
+	for _, b := range args.Breakpoints {
+		addr, err := parseMemoryReference(b.InstructionReference, b.Offset)
+		if err != nil {
+			results = append(results, map[string]interface{}{"verified": false, "message": err.Error()})
+			continue
+		}
+		cond, err := s.condition(b.Condition, b.HitCondition)
+		if err != nil {
+			results = append(results, map[string]interface{}{"verified": false, "message": err.Error()})
+			continue
+		}
+		bp := s.d.AddBreakpoint(s.d.Address(addr), true, cond)
+		s.instructionBreakpoints = append(s.instructionBreakpoints, bp.ID)
+		results = append(results, map[string]interface{}{"id": bp.ID, "verified": true, "instructionReference": memoryReference(addr)})
+	}
+	return map[string]interface{}{"breakpoints": results}, nil
+}
+
+// condition combines a breakpoint's condition and hit condition into one
+// expression. A hit condition is a number, meaning stop once the breakpoint
+// has been reached that many times, or a comparison like "== 5" or "% 10".
+func (s *Server) condition(cond, hitCond string) (*debugger.Expr, error) {
+	var parts []string
+	if cond = strings.TrimSpace(cond); cond != "" {
+		parts = append(parts, "("+cond+")")
+	}
+	if hitCond = strings.TrimSpace(hitCond); hitCond != "" {
+		switch {
+		case strings.HasPrefix(hitCond, "%"):
+			parts = append(parts, "(hits "+hitCond+" == 0)")
+		case strings.ContainsAny(hitCond[:1], "<>=!"):
+			parts = append(parts, "(hits "+hitCond+")")
+		default:
+			parts = append(parts, "(hits >= "+hitCond+")")
+		}
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	return debugger.ParseExpr(strings.Join(parts, " && "), s.d.Symbols())
+}
+
+func (s *Server) clearBreakpoints() {
+	for path, ids := range s.sourceBreakpoints {
+		for _, id := range ids {
+			s.d.Delete(id)
+		}
+		delete(s.sourceBreakpoints, path)
+	}
+	for _, id := range s.instructionBreakpoints {
+		s.d.Delete(id)
+	}
+	s.instructionBreakpoints = nil
+}
+
+// stackTrace returns the current instruction, and the call sites of the
+// calls that haven't returned yet.
+func (s *Server) stackTrace() interface{} {
+	pc := s.d.Target().Registers().PC
+	addrs := []symbols.Address{s.d.Address(pc)}
+	calls := s.d.CallStack()
+	for i, f := range calls {
+		a := s.d.Address(f.CallerPC)
+		// The caller was in the function called by the next frame out,
+		// so it's in the bank that was mapped then.
+		if i+1 < len(calls) && isSwitchable(f.CallerPC) && isSwitchable(calls[i+1].Target) {
+			a.Bank = calls[i+1].Bank
+		}
+		addrs = append(addrs, a)
+	}
+	frames := make([]map[string]interface{}, len(addrs))
+	for i, a := range addrs {
+		pc := a.Addr
+		name := s.d.Symbols().Describe(a)
+		if name == "" {
+			name = fmt.Sprintf("$%04X", pc)
+		}
+		frame := map[string]interface{}{
+			"id":                          i + 1,
+			"name":                        name,
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": memoryReference(pc),
+		}
+		if l, ok := s.lines.Line(a); ok {
+			frame["source"] = map[string]string{"name": filepath.Base(l.File), "path": l.File}
+			frame["line"] = l.Line
+			frame["column"] = 1
+		}
+		frames[i] = frame
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// isSwitchable returns true if addr is in the switchable ROM bank.
+func isSwitchable(addr uint16) bool {
+	return addr >= 0x4000 && addr < 0x8000
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+func (s *Server) variables(ref int) []variable {
+	r := s.d.Target().Registers()
+	switch ref {
+	case registersRef:
+		vars := []variable{
+			{Name: "A", Value: hex8(r.A)},
+			{Name: "F", Value: hex8(r.F)},
+			{Name: "B", Value: hex8(r.B)},
+			{Name: "C", Value: hex8(r.C)},
+			{Name: "D", Value: hex8(r.D)},
+			{Name: "E", Value: hex8(r.E)},
+			{Name: "H", Value: hex8(r.H)},
+			{Name: "L", Value: hex8(r.L)},
+		}
+		for _, p := range []struct {
+			name string
+			v    uint16
+		}{
+			{"AF", uint16(r.A)<<8 | uint16(r.F)},
+			{"BC", uint16(r.B)<<8 | uint16(r.C)},
+			{"DE", uint16(r.D)<<8 | uint16(r.E)},
+			{"HL", uint16(r.H)<<8 | uint16(r.L)},
+			{"SP", r.SP},
+			{"PC", r.PC},
+		} {
+			vars = append(vars, variable{Name: p.name, Value: s.pointer(p.v), MemoryReference: memoryReference(p.v)})
+		}
+		return vars
+	case flagsRef:
+		var vars []variable
+		for i, name := range []string{"Z", "N", "H", "C"} {
+			vars = append(vars, variable{Name: name, Value: strconv.Itoa(int(r.F>>uint(7-i)) & 1)})
+		}
+		return vars
+	case ioRegistersRef:
+		var vars []variable
+		for _, reg := range ioRegisters {
+			vars = append(vars, variable{Name: reg.name, Value: hex8(s.d.Target().ReadMemory(reg.addr)), MemoryReference: memoryReference(reg.addr)})
+		}
+		return vars
+	}
+	return []variable{}
+}
+
+// pointer formats a 16-bit register, with the label it points to if any.
+func (s *Server) pointer(v uint16) string {
+	if desc := s.d.Symbols().Describe(s.d.Address(v)); desc != "" {
+		return fmt.Sprintf("$%04X <%s>", v, desc)
+	}
+	return fmt.Sprintf("$%04X", v)
+}
+
+// setVariable sets a register or an I/O register to the value of an
+// expression.
+func (s *Server) setVariable(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	x, err := debugger.ParseExpr(args.Value, s.d.Symbols())
+	if err != nil {
+		return nil, err
+	}
+	v := s.d.Eval(x)
+	t := s.d.Target()
+	r := t.Registers()
+	switch args.VariablesReference {
+	case registersRef:
+		if !setRegister(&r, args.Name, v) {
+			return nil, fmt.Errorf("no register %q", args.Name)
+		}
+		t.SetRegisters(r)
+	case flagsRef:
+		bit := strings.Index("ZNHC", args.Name)
+		if bit < 0 || len(args.Name) != 1 {
+			return nil, fmt.Errorf("no flag %q", args.Name)
+		}
+		mask := uint8(0x80) >> uint(bit)
+		r.F &^= mask
+		if v != 0 {
+			r.F |= mask
+		}
+		t.SetRegisters(r)
+		v &= 1
+	case ioRegistersRef:
+		found := false
+		for _, reg := range ioRegisters {
+			if reg.name == args.Name {
+				t.WriteMemory(reg.addr, byte(v))
+				v, found = int(t.ReadMemory(reg.addr)), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no I/O register %q", args.Name)
+		}
+	default:
+		return nil, fmt.Errorf("can't set %q", args.Name)
+	}
+	for _, variable := range s.variables(args.VariablesReference) {
+		if variable.Name == args.Name {
+			return map[string]string{"value": variable.Value}, nil
+		}
+	}
+	return map[string]string{"value": strconv.Itoa(v)}, nil
+}
+
+func setRegister(r *cpu.Registers, name string, v int) bool {
+	regs8 := map[string]*uint8{"A": &r.A, "F": &r.F, "B": &r.B, "C": &r.C, "D": &r.D, "E": &r.E, "H": &r.H, "L": &r.L}
+	if p, ok := regs8[name]; ok {
+		*p = uint8(v)
+		return true
+	}
+	pairs := map[string][2]*uint8{"AF": {&r.A, &r.F}, "BC": {&r.B, &r.C}, "DE": {&r.D, &r.E}, "HL": {&r.H, &r.L}}
+	if p, ok := pairs[name]; ok {
+		*p[0], *p[1] = uint8(v>>8), uint8(v)
+		return true
+	}
+	switch name {
+	case "SP":
+		r.SP = uint16(v)
+	case "PC":
+		r.PC = uint16(v)
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *Server) readMemory(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	addr, err := parseMemoryReference(args.MemoryReference, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	n := args.Count
+	if int(addr)+n > 0x10000 {
+		n = 0x10000 - int(addr)
+	}
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = s.d.Target().ReadMemory(addr + uint16(i))
+	}
+	return map[string]interface{}{
+		"address":         memoryReference(addr),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": args.Count - n,
+	}, nil
+}
+
+func (s *Server) writeMemory(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Data            string `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	addr, err := parseMemoryReference(args.MemoryReference, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return nil, err
+	}
+	if int(addr)+len(data) > 0x10000 {
+		data = data[:0x10000-int(addr)]
+	}
+	for i, b := range data {
+		s.d.Target().WriteMemory(addr+uint16(i), b)
+	}
+	return map[string]int{"bytesWritten": len(data)}, nil
+}
+
+func memoryReference(addr uint16) string {
+	return fmt.Sprintf("0x%04X", addr)
+}
+
+// parseMemoryReference parses a memory reference, as returned by
+// memoryReference or typed by the user, plus an offset.
+func parseMemoryReference(ref string, offset int) (uint16, error) {
+	a, err := symbols.ParseAddress(ref)
+	if err != nil {
+		return 0, err
+	}
+	addr := int(a.Addr) + offset
+	if addr < 0 || addr > 0xFFFF {
+		return 0, fmt.Errorf("address %s%+d is out of range", ref, offset)
+	}
+	return uint16(addr), nil
+}
+
+func hex8(v uint8) string {
+	return fmt.Sprintf("$%02X (%d)", v, v)
+}
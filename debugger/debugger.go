@@ -0,0 +1,354 @@
+// Package debugger implements an interactive debugger for the emulator,
+// with any number of (optionally conditional) breakpoints, memory
+// watchpoints, stepping over and out of calls, and, for targets that can
+// rewind, going back to the last write to watched memory.
+//
+// The Debugger type controls execution, and REPL provides a command line
+// interface to it; type "help" at the prompt for a list of commands.
+package debugger
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// Target is the emulator being debugged.
+type Target interface {
+	Registers() cpu.Registers
+	SetRegisters(cpu.Registers)
+	// ReadMemory and WriteMemory access memory on behalf of the debugger,
+	// as mmu.MMU's Peek and Poke do. They must not run memory hooks for the
+	// access itself, and watchpoints ignore the hooks run by any transfer
+	// a write to a register starts.
+	ReadMemory(addr uint16) byte
+	WriteMemory(addr uint16, b byte)
+	// AddHook and RemoveHook add and remove hooks on memory accesses, as
+	// mmu.MMU's methods of the same names do.
+	AddHook(start, end uint16, kinds mmu.AccessKind, fn mmu.Hook) mmu.HookID
+	RemoveHook(id mmu.HookID)
+	// Step executes one instruction, and advances the rest of the system
+	// by the same amount of time.
+	Step()
+	// BankAt returns the number of the bank mapped at addr.
+	BankAt(addr uint16) int
+	// CallStack returns the calls that haven't returned yet, innermost
+	// first, as cpu.CPU's method of the same name does.
+	CallStack() []cpu.Frame
+}
+
+// interruptCheckInterval is the number of instructions executed between
+// checks for an interrupt from the user while running.
+const interruptCheckInterval = 4096
+
+// Breakpoint stops execution before the instruction at an address.
+type Breakpoint struct {
+	ID   int
+	Addr symbols.Address
+	// AnyBank is true if the breakpoint stops at Addr.Addr whichever
+	// bank is mapped.
+	AnyBank bool
+	// Cond, if set, is evaluated each time the breakpoint is reached, and
+	// execution only stops if it's nonzero.
+	Cond *Expr
+	// Hits is the number of times the breakpoint has been reached,
+	// whether or not its condition was true.
+	Hits int
+}
+
+// StopReason is why execution stopped.
+type StopReason int
+
+const (
+	// StopStep means the requested step, step over or step out finished.
+	StopStep StopReason = iota
+	StopBreakpoint
+	StopWatchpoint
+	// StopInterrupt means the user interrupted execution.
+	StopInterrupt
+	// StopHistoryStart means ReverseContinue went back as far as the
+	// target's history goes.
+	StopHistoryStart
+)
+
+// Stop describes why and where execution stopped.
+type Stop struct {
+	Reason     StopReason
+	Breakpoint *Breakpoint // for StopBreakpoint
+	Watchpoint *Watchpoint // for StopWatchpoint
+	// Access is the access that triggered a StopWatchpoint.
+	Access Access
+}
+
+// Debugger controls execution of a Target.
+type Debugger struct {
+	target Target
+	syms   *symbols.Table
+
+	breakpoints []*Breakpoint
+	watchpoints []*Watchpoint
+	nextID      int
+
+	// returned is true if the last instruction executed was a taken
+	// return, for stepping out of calls made before the call stack was
+	// tracked.
+	returned bool
+
+	// hit is the first watchpoint triggered during the current step, and
+	// written the first watchpoint written to, which may be a later access
+	// than the hit, e.g. the write of an INC (HL) after its read.
+	hit           *Watchpoint
+	access        Access
+	written       *Watchpoint
+	writtenAccess Access
+	// stepping is true while the target executes an instruction, so that
+	// accesses made by e.g. the GDB stub aren't mistaken for the game's.
+	stepping bool
+	// fetchStart and fetchLen are the bytes of the instruction being
+	// executed, whose reads don't trigger watchpoints.
+	fetchStart uint16
+	fetchLen   uint16
+
+	// interrupted is set to 1 by Interrupt, possibly from another
+	// goroutine.
+	interrupted int32
+}
+
+// New returns a debugger for t. syms may be nil.
+func New(t Target, syms *symbols.Table) *Debugger {
+	return &Debugger{target: t, syms: syms, nextID: 1}
+}
+
+// Symbols returns the debugger's symbol table, which may be nil.
+func (d *Debugger) Symbols() *symbols.Table {
+	return d.syms
+}
+
+// Target returns the target being debugged.
+func (d *Debugger) Target() Target {
+	return d.target
+}
+
+// Address returns the bank-qualified form of addr, using the bank that's
+// currently mapped there.
+func (d *Debugger) Address(addr uint16) symbols.Address {
+	return symbols.Address{Bank: d.target.BankAt(addr), Addr: addr}
+}
+
+// Describe formats addr with its bank and label, e.g. "$00:0153 <Main+3>".
+func (d *Debugger) Describe(addr uint16) string {
+	return d.syms.Format(d.Address(addr))
+}
+
+// ParseLocation parses a label name or an address. Addresses can be
+// bank-qualified ("01:4000"); if not, anyBank is true.
+func (d *Debugger) ParseLocation(loc string) (a symbols.Address, anyBank bool, err error) {
+	if a, ok := d.syms.Lookup(loc); ok {
+		return a, false, nil
+	}
+	if strings.Contains(loc, ":") {
+		a, err := symbols.ParseAddress(loc)
+		return a, false, err
+	}
+	n, ok := parseNumber(loc)
+	if !ok || n > 0xFFFF {
+		return symbols.Address{}, false, fmt.Errorf("%q is not an address or a known label", loc)
+	}
+	return d.Address(uint16(n)), true, nil
+}
+
+// AddBreakpoint adds a breakpoint. If anyBank is set, it stops at a.Addr
+// whichever bank is mapped. cond may be nil.
+func (d *Debugger) AddBreakpoint(a symbols.Address, anyBank bool, cond *Expr) *Breakpoint {
+	bp := &Breakpoint{ID: d.nextID, Addr: a, AnyBank: anyBank, Cond: cond}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp
+}
+
+// AddWatchpoint adds a watchpoint on the memory from start to end
+// inclusive.
+func (d *Debugger) AddWatchpoint(start, end uint16, kind WatchKind) *Watchpoint {
+	w := &Watchpoint{ID: d.nextID, Start: start, End: end, Kind: kind}
+	d.nextID++
+	d.watchpoints = append(d.watchpoints, w)
+	d.hookWatchpoint(w)
+	return w
+}
+
+// Delete deletes the breakpoint or watchpoint with the given ID. It returns
+// false if there isn't one.
+func (d *Debugger) Delete(id int) bool {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	for i, w := range d.watchpoints {
+		if w.ID == id {
+			d.unhookWatchpoint(w)
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the breakpoints, in the order they were added.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return d.breakpoints
+}
+
+// Watchpoints returns the watchpoints, in the order they were added.
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	return d.watchpoints
+}
+
+// CallDepth returns the number of calls that haven't returned yet.
+func (d *Debugger) CallDepth() int {
+	return len(d.target.CallStack())
+}
+
+// CallStack returns the calls that haven't returned yet, innermost first.
+func (d *Debugger) CallStack() []cpu.Frame {
+	return d.target.CallStack()
+}
+
+// Eval evaluates an expression against the current state of the target.
+func (d *Debugger) Eval(x *Expr) int {
+	return x.eval(&env{regs: d.target.Registers(), mem: d.target.ReadMemory})
+}
+
+// Interrupt stops execution as soon as possible. It may be called from any
+// goroutine, e.g. a signal handler.
+func (d *Debugger) Interrupt() {
+	atomic.StoreInt32(&d.interrupted, 1)
+}
+
+// Step executes n instructions, stopping early at breakpoints and
+// watchpoints.
+func (d *Debugger) Step(n int) Stop {
+	steps := 0
+	return d.run(func() bool {
+		steps++
+		return steps >= n
+	})
+}
+
+// StepOver executes one instruction, but if it's a call, runs until it
+// returns.
+func (d *Debugger) StepOver() Stop {
+	depth := d.CallDepth()
+	return d.run(func() bool { return d.CallDepth() <= depth })
+}
+
+// StepOut runs until the current function returns. If the call wasn't
+// tracked, it runs until the next return.
+func (d *Debugger) StepOut() Stop {
+	depth := d.CallDepth()
+	if depth == 0 {
+		return d.run(func() bool { return d.returned })
+	}
+	return d.run(func() bool { return d.CallDepth() < depth })
+}
+
+// Continue runs until a breakpoint or watchpoint is hit, or the user
+// interrupts execution.
+func (d *Debugger) Continue() Stop {
+	return d.run(func() bool { return false })
+}
+
+// run executes instructions until done returns true after one, or something
+// else stops execution. Breakpoints and execute watchpoints are checked
+// before each instruction except the first, so that execution can continue
+// from a breakpoint.
+func (d *Debugger) run(done func() bool) Stop {
+	atomic.StoreInt32(&d.interrupted, 0)
+	for i := 0; ; i++ {
+		pc := d.target.Registers().PC
+		if i > 0 {
+			if bp := d.breakpointAt(pc); bp != nil {
+				return Stop{Reason: StopBreakpoint, Breakpoint: bp}
+			}
+			if w := d.executeWatchpointAt(pc); w != nil {
+				return Stop{Reason: StopWatchpoint, Watchpoint: w, Access: Access{Addr: pc, Kind: WatchExecute}}
+			}
+			if i%interruptCheckInterval == 0 && atomic.LoadInt32(&d.interrupted) != 0 {
+				return Stop{Reason: StopInterrupt}
+			}
+		}
+		d.step()
+		if d.hit != nil {
+			d.hit.Hits++
+			return Stop{Reason: StopWatchpoint, Watchpoint: d.hit, Access: d.access}
+		}
+		if done() {
+			return Stop{Reason: StopStep}
+		}
+	}
+}
+
+// breakpointAt returns the breakpoint that stops at pc, if any. Every
+// breakpoint at pc counts a hit, even if another one stops first.
+func (d *Debugger) breakpointAt(pc uint16) *Breakpoint {
+	var stop *Breakpoint
+	var bank int
+	bankKnown := false
+	for _, bp := range d.breakpoints {
+		if bp.Addr.Addr != pc {
+			continue
+		}
+		if !bp.AnyBank {
+			if !bankKnown {
+				bank, bankKnown = d.target.BankAt(pc), true
+			}
+			if bp.Addr.Bank != bank {
+				continue
+			}
+		}
+		bp.Hits++
+		if bp.Cond != nil && bp.Cond.eval(&env{regs: d.target.Registers(), mem: d.target.ReadMemory, hits: bp.Hits}) == 0 {
+			continue
+		}
+		if stop == nil {
+			stop = bp
+		}
+	}
+	return stop
+}
+
+// step executes one instruction, noting whether it returned, and recording
+// the first watchpoint it triggers in d.hit and the first it writes to in
+// d.written.
+func (d *Debugger) step() {
+	pc := d.target.Registers().PC
+	instr := cpu.Decode(pc, targetReader{d.target})
+	d.fetchStart, d.fetchLen = pc, instr.Length()
+	d.hit, d.written = nil, nil
+	d.stepping = true
+	d.target.Step()
+	d.stepping = false
+
+	// Returns that are taken leave the PC somewhere other than the next
+	// instruction.
+	op := strings.Fields(instr.Mnemonic())
+	d.returned = len(op) > 0 && (op[0] == "RET" || op[0] == "RETI") && d.target.Registers().PC != pc+instr.Length()
+}
+
+// targetReader lets cpu.Decode read the target's memory.
+type targetReader struct {
+	t Target
+}
+
+func (r targetReader) Rb(addr uint16) byte {
+	return r.t.ReadMemory(addr)
+}
+
+func (r targetReader) Rw(addr uint16) uint16 {
+	return uint16(r.t.ReadMemory(addr+1))<<8 | uint16(r.t.ReadMemory(addr))
+}
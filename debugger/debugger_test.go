@@ -0,0 +1,328 @@
+package debugger
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/internal/debugtest"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/rewind"
+	"github.com/mpingram/gameboy-emu/savestate"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+const testSym = `
+00:0100 Main
+00:0102 Main.loop
+00:0110 Sub
+00:c000 wCounter
+`
+
+// newTestDebugger returns a debugger for a CPU about to run:
+//
+//	Main:      ld a, 0
+//	.loop:     inc a
+//	           call Sub
+//	           jr .loop
+//	Sub:       ld [wCounter], a
+//	           ld b, [hl]          ; HL = $C010
+//	           ret
+func newTestDebugger(t *testing.T) (*Debugger, *debugtest.Target) {
+	syms, err := symbols.Load(strings.NewReader(testSym))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := debugtest.New(map[uint16][]byte{
+		0x0100: {0x3E, 0x00, 0x3C, 0xCD, 0x10, 0x01, 0x18, 0xFA},
+		0x0110: {0xEA, 0x00, 0xC0, 0x46, 0xC9},
+	})
+	target.CPU.H, target.CPU.L = 0xC0, 0x10
+	return New(target, syms), target
+}
+
+func TestDebugger_conditionalBreakpoint(t *testing.T) {
+	d, target := newTestDebugger(t)
+	a, anyBank, err := d.ParseLocation("Main.loop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cond, err := ParseExpr("a == 3", d.Symbols())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := d.AddBreakpoint(a, anyBank, cond)
+
+	stop := d.Continue()
+	if stop.Reason != StopBreakpoint || stop.Breakpoint != bp {
+		t.Fatalf("Continue() = %+v, want a stop at breakpoint %d", stop, bp.ID)
+	}
+	if target.CPU.PC != 0x0102 || target.CPU.A != 3 {
+		t.Errorf("Stopped at PC=$%04X with A=%d, want PC=$0102 with A=3", target.CPU.PC, target.CPU.A)
+	}
+	// The loop is reached with A = 0, 1, 2 and 3.
+	if bp.Hits != 4 {
+		t.Errorf("Breakpoint hit %d times, want 4", bp.Hits)
+	}
+}
+
+func TestDebugger_bankedBreakpoint(t *testing.T) {
+	d, _ := newTestDebugger(t)
+	d.AddBreakpoint(symbols.Address{Bank: 1, Addr: 0x0102}, false, nil)
+	d.AddBreakpoint(symbols.Address{Bank: 0, Addr: 0x0110}, false, nil)
+	stop := d.Continue()
+	if stop.Reason != StopBreakpoint || stop.Breakpoint.Addr.Addr != 0x0110 {
+		t.Errorf("Continue() = %+v, want a stop at $0110: the breakpoint in bank 1 should never be hit", stop)
+	}
+}
+
+func TestDebugger_watchpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end uint16
+		kind       WatchKind
+		wantPC     uint16
+		wantAccess Access
+	}{
+		{"write", 0xC000, 0xC000, WatchWrite, 0x0113, Access{Addr: 0xC000, Kind: WatchWrite, Value: 1, PC: 0x0110}},
+		{"read", 0xC008, 0xC01F, WatchRead, 0x0114, Access{Addr: 0xC010, Kind: WatchRead, PC: 0x0113}},
+		{"read ignores opcode fetches", 0x0110, 0x0112, WatchRead | WatchWrite, 0, Access{}},
+		{"execute", 0x0110, 0x0114, WatchExecute, 0x0110, Access{Addr: 0x0110, Kind: WatchExecute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, target := newTestDebugger(t)
+			w := d.AddWatchpoint(tt.start, tt.end, tt.kind)
+			// The program loops forever, so stop it after a while.
+			stop := d.Step(1000)
+			if tt.wantPC == 0 {
+				if stop.Reason != StopStep {
+					t.Errorf("Step() = %+v, want no watchpoint hit", stop)
+				}
+				return
+			}
+			if stop.Reason != StopWatchpoint || stop.Watchpoint != w {
+				t.Fatalf("Step() = %+v, want a stop at watchpoint %d", stop, w.ID)
+			}
+			if target.CPU.PC != tt.wantPC {
+				t.Errorf("Stopped at PC=$%04X, want $%04X", target.CPU.PC, tt.wantPC)
+			}
+			if stop.Access != tt.wantAccess {
+				t.Errorf("Access = %+v, want %+v", stop.Access, tt.wantAccess)
+			}
+		})
+	}
+}
+
+func TestDebugger_stepOverAndOut(t *testing.T) {
+	d, target := newTestDebugger(t)
+	d.Step(2) // ld a, 0; inc a
+	if target.CPU.PC != 0x0103 {
+		t.Fatalf("PC = $%04X, want $0103", target.CPU.PC)
+	}
+
+	// Stepping over the call runs the whole subroutine.
+	if stop := d.StepOver(); stop.Reason != StopStep {
+		t.Fatalf("StepOver() = %+v", stop)
+	}
+	if target.CPU.PC != 0x0106 || target.MMU.Peek(0xC000) != 1 {
+		t.Errorf("After stepping over call, PC = $%04X, [wCounter] = %d, want $0106, 1", target.CPU.PC, target.MMU.Peek(0xC000))
+	}
+	if d.CallDepth() != 0 {
+		t.Errorf("CallDepth() = %d, want 0", d.CallDepth())
+	}
+
+	// Step into the next call, then out of it.
+	d.Step(3) // jr .loop; inc a; call Sub
+	if target.CPU.PC != 0x0110 || d.CallDepth() != 1 {
+		t.Fatalf("PC = $%04X, CallDepth() = %d, want $0110, 1", target.CPU.PC, d.CallDepth())
+	}
+	if got, want := d.CallStack(), []cpu.Frame{{CallerPC: 0x0103, Target: 0x0110, SP: 0xFFFC}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CallStack() = %+v, want %+v", got, want)
+	}
+	if stop := d.StepOut(); stop.Reason != StopStep {
+		t.Fatalf("StepOut() = %+v", stop)
+	}
+	if target.CPU.PC != 0x0106 || d.CallDepth() != 0 {
+		t.Errorf("After stepping out, PC = $%04X, CallDepth() = %d, want $0106, 0", target.CPU.PC, d.CallDepth())
+	}
+}
+
+func TestDebugger_Interrupt(t *testing.T) {
+	d, _ := newTestDebugger(t)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.Interrupt()
+	}()
+	if stop := d.Continue(); stop.Reason != StopInterrupt {
+		t.Errorf("Continue() = %+v, want an interrupt", stop)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands string
+		want     []string
+	}{
+		{
+			"break and continue",
+			"break Sub\ncontinue\ninfo\n",
+			[]string{
+				"Breakpoint 1 at $00:0110 <Sub>",
+				"Breakpoint 1, hit 1 times",
+				"=> * $00:0110  EA 00 C0",
+				"1  break  $00:0110 <Sub>, hit 1 times",
+			},
+		},
+		{
+			"conditional breakpoint",
+			"break $102 if [wCounter] == 2\ncontinue\nprint a\n",
+			[]string{"Breakpoint 1 at $0102 (any bank) if [wCounter] == 2", "2 ($2)"},
+		},
+		{
+			"watch",
+			"watch wCounter\nc\n",
+			[]string{"Watchpoint 1 (w) on $00:C000 <wCounter>", "Watchpoint 1: write of $01 to $00:C000 <wCounter> by CPU at $00:0110 <Sub>"},
+		},
+		{
+			"step, and repeat with an empty line",
+			"step\n\nregs\n",
+			[]string{"PC=0103", "PC is at $00:0103 <Main.loop+1>, call depth 0"},
+		},
+		{
+			"backtrace",
+			"break Sub\ncontinue\nbt\n",
+			[]string{"#0  $00:0110 <Sub>\n#1  $00:0103 <Main.loop+1>  called $00:0110 <Sub> with SP=FFFC"},
+		},
+		{
+			"history",
+			"step 2\nx wCounter 4\n!1\nhistory\n",
+			[]string{"$C000  00 00 00 00", "   1  step 2\n   2  x wCounter 4"},
+		},
+		{
+			"list",
+			"list Main 3\n",
+			[]string{"Main:\n=>   $00:0100  3E 00     LD A, d8: 0x00", ".loop:", "CALL a16: $0110"},
+		},
+		{
+			"oam",
+			"oam\n",
+			[]string{" #   Y   X  screen", "39  00  00  off        00    00 ---0 b0p0  -           -"},
+		},
+		{
+			"hide and show",
+			"hide bg 3 16\nshow 3\nhide\nhide 40\nshow\n",
+			[]string{"Hidden: bg, sprite 3, sprite 16\n", "> Hidden: bg, sprite 16\n> Hidden: bg, sprite 16\n", `"40" is not bg, window, sprites or a sprite from 0 to 39`, "No layers are hidden"},
+		},
+		{
+			"errors",
+			"break nowhere\nfrobnicate\nx\nsave state.bin\ndump-vram dumps no-such-palette\n",
+			[]string{`"nowhere" is not an address or a known label`, `Unknown command "frobnicate"`, "usage: x <expr> [n]", "The target can't save its state", "Failed to load palette"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newTestDebugger(t)
+			var out bytes.Buffer
+			NewREPL(d, strings.NewReader(tt.commands), &out).Run()
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Expected output to contain %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+// rewindTarget is a debugtest.Target that keeps a history to rewind through.
+type rewindTarget struct {
+	*debugtest.Target
+	buf *rewind.Buffer
+}
+
+func (t *rewindTarget) Step()                          { t.buf.Step() }
+func (t *rewindTarget) Rewind(frames int) (int, error) { return t.buf.Rewind(frames) }
+func (t *rewindTarget) Instructions() uint64           { return t.buf.Instructions() }
+func (t *rewindTarget) Checkpoints() []uint64          { return t.buf.Checkpoints() }
+func (t *rewindTarget) Seek(instructions uint64) error { return t.buf.Seek(instructions) }
+
+// rewindMachine is what a rewindTarget's history is kept of, with a "frame"
+// each time round the loop.
+type rewindMachine struct {
+	t *debugtest.Target
+}
+
+func (m rewindMachine) machine() savestate.Machine {
+	return savestate.Machine{CPU: m.t.CPU, MMU: m.t.MMU, PPU: m.t.PPU}
+}
+func (m rewindMachine) SaveState(dst []byte) []byte  { return savestate.Append(dst, m.machine()) }
+func (m rewindMachine) LoadState(state []byte) error { return savestate.Load(m.machine(), state) }
+func (m rewindMachine) Step() bool {
+	m.t.CPU.Step()
+	return m.t.CPU.PC == 0x0102
+}
+func (m rewindMachine) Input() mmu.Buttons           { return m.t.MMU.Buttons() }
+func (m rewindMachine) Replay(next mmu.Buttons) bool { return m.Step() }
+
+func TestDebugger_ReverseContinue(t *testing.T) {
+	_, target := newTestDebugger(t)
+	rt := &rewindTarget{Target: target}
+	rt.buf = rewind.New(rewindMachine{target}, rewind.Options{Interval: 3})
+	d := New(rt, nil)
+	d.Step(200)
+	d.AddWatchpoint(0xC000, 0xC000, WatchWrite)
+
+	last := target.MMU.Peek(0xC000)
+	for i := 0; i < 2; i++ {
+		a := last - byte(i)
+		stop, err := d.ReverseContinue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stop.Reason != StopWatchpoint || stop.Access.Value != a {
+			t.Fatalf("ReverseContinue() = %+v, want the write of %d", stop, a)
+		}
+		// Stopped before the write.
+		if target.CPU.PC != 0x0110 || target.CPU.A != a || target.MMU.Peek(0xC000) != a-1 {
+			t.Errorf("Stopped at PC=$%04X with A=%d, [wCounter]=%d; want PC=$0110, A=%d, [wCounter]=%d",
+				target.CPU.PC, target.CPU.A, target.MMU.Peek(0xC000), a, a-1)
+		}
+	}
+
+	if back, err := d.Rewind(1000); err != nil || back == 0 || rt.Instructions() != 0 {
+		t.Errorf("Rewind(1000) = %d, %v, and went back to instruction %d; want the start", back, err, rt.Instructions())
+	}
+	if stop, err := d.ReverseContinue(); err != nil || stop.Reason != StopHistoryStart {
+		t.Errorf("ReverseContinue() at the start = %+v, %v, want StopHistoryStart", stop, err)
+	}
+}
+
+func TestDebugger_ReverseContinue_readModifyWrite(t *testing.T) {
+	// .loop: inc [hl]  ; HL = $C000
+	//        jr .loop
+	target := debugtest.New(map[uint16][]byte{0x0100: {0x00, 0x00, 0x34, 0x18, 0xFD}})
+	target.CPU.H, target.CPU.L = 0xC0, 0x00
+	rt := &rewindTarget{Target: target}
+	rt.buf = rewind.New(rewindMachine{target}, rewind.Options{Interval: 3})
+	d := New(rt, nil)
+	d.Step(50)
+	// INC reads the watched byte before writing it, so the read is the
+	// step's first hit.
+	d.AddWatchpoint(0xC000, 0xC000, WatchRead|WatchWrite)
+
+	last := target.MMU.Peek(0xC000)
+	stop, err := d.ReverseContinue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != StopWatchpoint || stop.Access.Kind != WatchWrite || stop.Access.Value != last {
+		t.Fatalf("ReverseContinue() = %+v, want the write of %d", stop, last)
+	}
+	if target.CPU.PC != 0x0102 || target.MMU.Peek(0xC000) != last-1 {
+		t.Errorf("Stopped at PC=$%04X with [$C000]=%d; want PC=$0102, [$C000]=%d", target.CPU.PC, target.MMU.Peek(0xC000), last-1)
+	}
+}
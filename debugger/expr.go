@@ -0,0 +1,345 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// Expr is an expression over the CPU's registers and memory, used for
+// breakpoint conditions and the print command. For example:
+//
+//	a == $3F && [hl] != 0
+//	[wCounter] >= 10 || hits > 100
+//
+// Operands are:
+//   - numbers: 42, $2A, 0x2A or %101010
+//   - registers: a, f, b, c, d, e, h, l, af, bc, de, hl, sp and pc
+//   - flags, which are 0 or 1: zf, nf, hf and cf
+//   - hits: the number of times the breakpoint being checked has been hit
+//   - label names from the symbol file, which evaluate to their address
+//   - [expr]: the byte of memory at expr
+//
+// The operators are those of C, with the same precedence: unary ! - ~,
+// then * + - & ^ | == != < <= > >= && ||.
+type Expr struct {
+	src  string
+	root node
+}
+
+// env is what an expression is evaluated against.
+type env struct {
+	regs cpu.Registers
+	mem  func(addr uint16) byte
+	hits int
+}
+
+type node interface {
+	eval(e *env) int
+}
+
+// ParseExpr parses an expression. Label names are looked up in syms, which
+// may be nil.
+func ParseExpr(src string, syms *symbols.Table) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, syms: syms}
+	root, err := p.parse(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return &Expr{src, root}, nil
+}
+
+func (x *Expr) String() string {
+	return x.src
+}
+
+func (x *Expr) eval(e *env) int {
+	return x.root.eval(e)
+}
+
+func tokenize(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case isIdentChar(c) || c == '$' || c == '%':
+			j := i + 1
+			for j < len(src) && isIdentChar(src[j]) {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		default:
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, two)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("!~-+*&|^<>()[]", rune(c)) {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '@' || c == '#'
+}
+
+// binaryOps gives the precedence of each binary operator. Higher binds
+// more tightly.
+var binaryOps = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"+": 8, "-": 8,
+	"*": 9,
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+	syms   *symbols.Table
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// parse parses an expression whose binary operators all have a precedence
+// greater than minPrec.
+func (p *parser) parse(minPrec int) (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		prec, ok := binaryOps[op]
+		if !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parse(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op, left, right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	switch t := p.next(); t {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "!", "-", "~":
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{t, operand}, nil
+	case "(", "[":
+		inner, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		closing := ")"
+		if t == "[" {
+			closing = "]"
+		}
+		if p.next() != closing {
+			return nil, fmt.Errorf("missing %q", closing)
+		}
+		if t == "[" {
+			return memoryNode{inner}, nil
+		}
+		return inner, nil
+	default:
+		return p.operand(t)
+	}
+}
+
+func (p *parser) operand(t string) (node, error) {
+	if n, ok := parseNumber(t); ok {
+		return constNode(n), nil
+	}
+	if _, ok := registers[strings.ToLower(t)]; ok {
+		return registerNode(strings.ToLower(t)), nil
+	}
+	if t == "hits" {
+		return hitsNode{}, nil
+	}
+	if a, ok := p.syms.Lookup(t); ok {
+		return constNode(int(a.Addr)), nil
+	}
+	return nil, fmt.Errorf("unknown register or label %q", t)
+}
+
+// parseNumber parses a number in decimal, or in hex or binary with the
+// prefixes used by RGBDS ($, %) or Go (0x, 0b).
+func parseNumber(t string) (int, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(t, "$"):
+		t, base = t[1:], 16
+	case strings.HasPrefix(t, "0x"), strings.HasPrefix(t, "0X"):
+		t, base = t[2:], 16
+	case strings.HasPrefix(t, "%"):
+		t, base = t[1:], 2
+	case strings.HasPrefix(t, "0b"):
+		t, base = t[2:], 2
+	}
+	n, err := strconv.ParseUint(t, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// registers are the registers and flags that can appear in expressions.
+var registers = map[string]func(r cpu.Registers) int{
+	"a":  func(r cpu.Registers) int { return int(r.A) },
+	"f":  func(r cpu.Registers) int { return int(r.F) },
+	"b":  func(r cpu.Registers) int { return int(r.B) },
+	"c":  func(r cpu.Registers) int { return int(r.C) },
+	"d":  func(r cpu.Registers) int { return int(r.D) },
+	"e":  func(r cpu.Registers) int { return int(r.E) },
+	"h":  func(r cpu.Registers) int { return int(r.H) },
+	"l":  func(r cpu.Registers) int { return int(r.L) },
+	"af": func(r cpu.Registers) int { return int(r.A)<<8 | int(r.F) },
+	"bc": func(r cpu.Registers) int { return int(r.B)<<8 | int(r.C) },
+	"de": func(r cpu.Registers) int { return int(r.D)<<8 | int(r.E) },
+	"hl": func(r cpu.Registers) int { return int(r.H)<<8 | int(r.L) },
+	"sp": func(r cpu.Registers) int { return int(r.SP) },
+	"pc": func(r cpu.Registers) int { return int(r.PC) },
+	"zf": func(r cpu.Registers) int { return int(r.F>>7) & 1 },
+	"nf": func(r cpu.Registers) int { return int(r.F>>6) & 1 },
+	"hf": func(r cpu.Registers) int { return int(r.F>>5) & 1 },
+	"cf": func(r cpu.Registers) int { return int(r.F>>4) & 1 },
+}
+
+type constNode int
+
+func (n constNode) eval(e *env) int {
+	return int(n)
+}
+
+type registerNode string
+
+func (n registerNode) eval(e *env) int {
+	return registers[string(n)](e.regs)
+}
+
+type hitsNode struct{}
+
+func (hitsNode) eval(e *env) int {
+	return e.hits
+}
+
+type memoryNode struct {
+	addr node
+}
+
+func (n memoryNode) eval(e *env) int {
+	return int(e.mem(uint16(n.addr.eval(e))))
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n unaryNode) eval(e *env) int {
+	v := n.operand.eval(e)
+	switch n.op {
+	case "!":
+		return boolToInt(v == 0)
+	case "-":
+		return -v
+	default:
+		return ^v
+	}
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n binaryNode) eval(e *env) int {
+	l := n.left.eval(e)
+	// && and || short-circuit, so that e.g. a memory read on the right
+	// is only made when it matters.
+	switch n.op {
+	case "&&":
+		return boolToInt(l != 0 && n.right.eval(e) != 0)
+	case "||":
+		return boolToInt(l != 0 || n.right.eval(e) != 0)
+	}
+	r := n.right.eval(e)
+	switch n.op {
+	case "|":
+		return l | r
+	case "^":
+		return l ^ r
+	case "&":
+		return l & r
+	case "==":
+		return boolToInt(l == r)
+	case "!=":
+		return boolToInt(l != r)
+	case "<":
+		return boolToInt(l < r)
+	case "<=":
+		return boolToInt(l <= r)
+	case ">":
+		return boolToInt(l > r)
+	case ">=":
+		return boolToInt(l >= r)
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	default:
+		return l * r
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package debugger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+func TestParseExpr(t *testing.T) {
+	syms, err := symbols.Load(strings.NewReader("00:c000 wCounter\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := map[uint16]byte{0xC000: 7, 0xC010: 0x55}
+	e := &env{
+		regs: cpu.Registers{A: 0x3F, F: 0x90, H: 0xC0, L: 0x10, SP: 0xFFFE, PC: 0x0150},
+		mem:  func(addr uint16) byte { return mem[addr] },
+		hits: 3,
+	}
+
+	tests := []struct {
+		src     string
+		want    int
+		wantErr bool
+	}{
+		{"42", 42, false},
+		{"$2A", 42, false},
+		{"0x2a", 42, false},
+		{"%101010", 42, false},
+		{"a", 0x3F, false},
+		{"A", 0x3F, false},
+		{"hl", 0xC010, false},
+		{"zf", 1, false},
+		{"nf", 0, false},
+		{"cf", 1, false},
+		{"[hl]", 0x55, false},
+		{"[wCounter]", 7, false},
+		{"[wCounter + $10]", 0x55, false},
+		{"a == $3F && [hl] != 0", 1, false},
+		{"a == 1 || hits > 2", 1, false},
+		{"1 + 2 * 3", 7, false},
+		{"(1 + 2) * 3", 9, false},
+		{"(f & $80) == $80", 1, false},
+		{"f & $80 == $80", 0, false}, // == binds more tightly than &, as in C
+		{"!0", 1, false},
+		{"-1 + 2", 1, false},
+		{"~0 & $FF", 0xFF, false},
+		{"pc >= $150 && pc < $160", 1, false},
+		{"", 0, true},
+		{"a ==", 0, true},
+		{"(a", 0, true},
+		{"[hl", 0, true},
+		{"notALabel", 0, true},
+		{"a ? 1", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			x, err := ParseExpr(tt.src, syms)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExpr(%q) error = %v, wantErr %v", tt.src, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := x.eval(e); got != tt.want {
+				t.Errorf("%q = %d, want %d", tt.src, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package debugger
+
+import (
+	"github.com/mpingram/gameboy-emu/ppu"
+	"github.com/mpingram/gameboy-emu/vramview"
+)
+
+// SpriteSelector is implemented by targets that can say which scanlines
+// the PPU drew each sprite on, for the oam command.
+type SpriteSelector interface {
+	// LastSpriteSelection returns the sprites selected on each scanline
+	// of the last frame, as ppu.PPU's method of the same name does.
+	LastSpriteSelection() ppu.SpriteSelection
+}
+
+// LayerHider is implemented by targets whose PPU can hide layers of the
+// picture, for the hide and show commands.
+type LayerHider interface {
+	// DebugOptions and SetDebugOptions get and set the layers hidden, as
+	// ppu.PPU's methods of the same names do.
+	DebugOptions() ppu.DebugOptions
+	SetDebugOptions(o ppu.DebugOptions)
+}
+
+// ColorTarget is implemented by targets that can run in Game Boy Color
+// mode, for the dump-vram and oam commands to read both banks of VRAM and
+// the color palettes, which ReadMemory doesn't reach.
+type ColorTarget interface {
+	// ColorMemory returns the target's memory, such as an mmu.MMU.
+	ColorMemory() vramview.ColorMemory
+}
+
+// colorMemory returns the target's Game Boy Color memory, or nil if it
+// has none.
+func (d *Debugger) colorMemory() vramview.ColorMemory {
+	if c, ok := d.target.(ColorTarget); ok {
+		return c.ColorMemory()
+	}
+	return nil
+}
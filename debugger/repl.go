@@ -0,0 +1,548 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/oamview"
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+	"github.com/mpingram/gameboy-emu/vramview"
+)
+
+const replHelp = `Execution:
+  c, continue              run until a breakpoint or watchpoint, or Ctrl-C
+  s, step [n]              execute n instructions (default 1)
+  n, next                  step over calls
+  o, out                   run until the current function returns
+  rc, reverse-continue     go back to the last write to memory watched for
+                           writes, stopping before the instruction that made it
+  rewind [n]               go back n video frames (default 1)
+Breakpoints and watchpoints:
+  b, break <loc> [if <expr>]
+                           stop before executing <loc>, which is a label, an
+                           address, or a bank-qualified address like 01:4000
+  w, watch <start>[..<end>] [r|w|x]
+                           stop on reads, writes (the default) or executes
+                           of memory from <start> to <end>
+  d, delete <id>...        delete breakpoints or watchpoints
+  i, info                  list breakpoints and watchpoints
+Inspecting:
+  r, regs                  show the registers
+  bt, backtrace            show the calls that haven't returned yet
+  p, print [expr]          evaluate an expression, or show the registers
+  x <expr> [n]             show n bytes (default 64) of memory at <expr>
+  l, list [expr] [n]       disassemble n instructions (default 10) at <expr>
+                           (default the PC)
+  m, memdump [file]        dump memory to a file (default dumps/memdump.bin)
+  dump-vram [dir] [palette]
+                           draw the tiles in VRAM to tiles.png and the tile
+                           maps to map-9800.png and map-9C00.png in <dir>
+                           (default dumps), in a preset palette or one from
+                           a file
+  oam [file] [palette]     show the sprites in OAM, and the scanlines they
+                           were drawn on and dropped from in the last frame,
+                           and draw them to <file> if given
+  hide [layer]...          hide layers of the picture, which are bg, window,
+                           sprites, or a sprite's OAM index, without changing
+                           what the game sees; with no layers, show which
+                           are hidden
+  show [layer]...          show hidden layers again, or all of them
+States:
+  save <file>              save the state of the machine to a file
+  load <file>              restore a state saved with save
+Other:
+  history                  list previous commands; !n runs command n again,
+                           and !! runs the last command again
+  q, quit                  exit
+An empty line repeats the last command.
+Expressions can use numbers ($2A, 0x2A, 42), registers, flags (zf, nf, hf,
+cf), labels, memory ([hl], [wCounter]) and C operators. In breakpoint
+conditions, hits is the number of times the breakpoint has been reached.
+`
+
+// Defaults for commands that take an optional count.
+const (
+	defaultDumpLen   = 64
+	defaultListLen   = 10
+	defaultDumpFile  = "dumps/memdump.bin"
+	defaultDumpDir   = "dumps"
+	memorySize       = 0x10000
+	maxHistoryLength = 1000
+)
+
+// REPL is a command line interface to a Debugger.
+type REPL struct {
+	d       *Debugger
+	in      *bufio.Scanner
+	out     io.Writer
+	history []string
+}
+
+// NewREPL returns a REPL that reads commands from in and writes output to
+// out.
+func NewREPL(d *Debugger, in io.Reader, out io.Writer) *REPL {
+	return &REPL{d: d, in: bufio.NewScanner(in), out: out}
+}
+
+// Run reads and executes commands until the user quits or the input ends.
+func (r *REPL) Run() {
+	for {
+		fmt.Fprint(r.out, "> ")
+		if !r.in.Scan() {
+			return
+		}
+		if quit := r.Exec(r.in.Text()); quit {
+			return
+		}
+	}
+}
+
+// Exec executes a command line, and returns true if it was a command to
+// quit.
+func (r *REPL) Exec(line string) (quit bool) {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "":
+		// Repeat the last command, or step if there isn't one.
+		line = "step"
+		if len(r.history) > 0 {
+			line = r.history[len(r.history)-1]
+		}
+	case line == "!!":
+		if len(r.history) == 0 {
+			fmt.Fprintln(r.out, "No previous command")
+			return false
+		}
+		line = r.history[len(r.history)-1]
+		fmt.Fprintln(r.out, line)
+	case strings.HasPrefix(line, "!"):
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(r.history) {
+			fmt.Fprintf(r.out, "No command %s in history\n", line[1:])
+			return false
+		}
+		line = r.history[n-1]
+		fmt.Fprintln(r.out, line)
+	}
+	r.addHistory(line)
+
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "h", "help":
+		fmt.Fprint(r.out, replHelp)
+	case "c", "continue":
+		fmt.Fprintln(r.out, "Running; press Ctrl-C to stop")
+		r.printStop(r.d.Continue())
+	case "s", "step":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				fmt.Fprintf(r.out, "Bad step count %q\n", args[0])
+				break
+			}
+		}
+		r.printStop(r.d.Step(n))
+	case "n", "next":
+		r.printStop(r.d.StepOver())
+	case "o", "out", "finish":
+		r.printStop(r.d.StepOut())
+	case "rc", "reverse-continue":
+		s, err := r.d.ReverseContinue()
+		if err != nil {
+			fmt.Fprintf(r.out, "Can't reverse: %v\n", err)
+			break
+		}
+		r.printStop(s)
+	case "rewind":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				fmt.Fprintf(r.out, "Bad frame count %q\n", args[0])
+				break
+			}
+		}
+		back, err := r.d.Rewind(n)
+		if err != nil {
+			fmt.Fprintf(r.out, "Can't rewind: %v\n", err)
+			break
+		}
+		fmt.Fprintf(r.out, "Went back %d frames\n", back)
+		r.printStop(Stop{Reason: StopStep})
+	case "b", "break":
+		r.breakCmd(line)
+	case "w", "watch":
+		r.watchCmd(args)
+	case "d", "delete":
+		if len(args) == 0 {
+			fmt.Fprintln(r.out, "usage: delete <id>...")
+		}
+		for _, arg := range args {
+			id, err := strconv.Atoi(arg)
+			if err != nil || !r.d.Delete(id) {
+				fmt.Fprintf(r.out, "No breakpoint or watchpoint %s\n", arg)
+			}
+		}
+	case "i", "info":
+		r.info()
+	case "r", "regs":
+		r.d.writeRegisters(r.out)
+	case "bt", "backtrace":
+		r.d.writeBacktrace(r.out)
+	case "p", "print":
+		if len(args) == 0 {
+			r.d.writeRegisters(r.out)
+			break
+		}
+		x, err := ParseExpr(strings.Join(args, " "), r.d.syms)
+		if err != nil {
+			fmt.Fprintf(r.out, "Bad expression: %v\n", err)
+			break
+		}
+		v := r.d.Eval(x)
+		fmt.Fprintf(r.out, "%d ($%X)\n", v, v)
+	case "x":
+		if len(args) == 0 {
+			fmt.Fprintln(r.out, "usage: x <expr> [n]")
+			break
+		}
+		addr, n, ok := r.addressAndCount(args, defaultDumpLen)
+		if ok {
+			r.d.writeHexDump(r.out, addr, n)
+		}
+	case "l", "list":
+		if len(args) == 0 {
+			r.d.writeDisassembly(r.out, r.d.target.Registers().PC, defaultListLen)
+			break
+		}
+		addr, n, ok := r.addressAndCount(args, defaultListLen)
+		if ok {
+			r.d.writeDisassembly(r.out, addr, n)
+		}
+	case "m", "memdump":
+		file := defaultDumpFile
+		if len(args) > 0 {
+			file = args[0]
+		}
+		mem := make([]byte, memorySize)
+		for i := range mem {
+			mem[i] = r.d.target.ReadMemory(uint16(i))
+		}
+		if err := ioutil.WriteFile(file, mem, 0644); err != nil {
+			fmt.Fprintf(r.out, "Failed to dump memory: %v\n", err)
+			break
+		}
+		fmt.Fprintf(r.out, "Dumped memory to %s\n", file)
+	case "dump-vram":
+		r.dumpVRAM(args)
+	case "oam":
+		r.oam(args)
+	case "hide", "show":
+		r.hideLayers(cmd == "hide", args)
+	case "save", "load":
+		if len(args) != 1 {
+			fmt.Fprintf(r.out, "usage: %s <file>\n", cmd)
+			break
+		}
+		s, ok := r.d.target.(StateSaver)
+		if !ok {
+			fmt.Fprintln(r.out, "The target can't save its state")
+			break
+		}
+		if cmd == "save" {
+			if err := ioutil.WriteFile(args[0], s.SaveState(nil), 0644); err != nil {
+				fmt.Fprintf(r.out, "Failed to save state: %v\n", err)
+				break
+			}
+			fmt.Fprintf(r.out, "Saved state to %s\n", args[0])
+			break
+		}
+		state, err := ioutil.ReadFile(args[0])
+		if err == nil {
+			err = s.LoadState(state)
+		}
+		if err != nil {
+			fmt.Fprintf(r.out, "Failed to load state: %v\n", err)
+			break
+		}
+		r.printStop(Stop{Reason: StopStep})
+	case "history":
+		for i, h := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, h)
+		}
+	case "q", "quit":
+		return true
+	default:
+		fmt.Fprintf(r.out, "Unknown command %q; type help for a list of commands\n", cmd)
+	}
+	return false
+}
+
+// dumpVRAM handles "dump-vram [dir] [palette]".
+func (r *REPL) dumpVRAM(args []string) {
+	dir, pal := defaultDumpDir, palette.Default
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	if len(args) > 1 {
+		var err error
+		if pal, err = palette.Load(args[1]); err != nil {
+			fmt.Fprintf(r.out, "Failed to load palette: %v\n", err)
+			return
+		}
+	}
+	if err := vramview.Read(r.d.target.ReadMemory, r.d.colorMemory()).WriteFiles(dir, pal.BG); err != nil {
+		fmt.Fprintf(r.out, "Failed to dump VRAM: %v\n", err)
+		return
+	}
+	fmt.Fprintf(r.out, "Dumped VRAM to tiles.png, map-9800.png and map-9C00.png in %s\n", dir)
+}
+
+// oam handles "oam [file] [palette]".
+func (r *REPL) oam(args []string) {
+	var sel ppu.SpriteSelection
+	if s, ok := r.d.target.(SpriteSelector); ok {
+		sel = s.LastSpriteSelection()
+	}
+	o := oamview.Read(r.d.target.ReadMemory, sel, r.d.colorMemory())
+	o.Write(r.out)
+	if len(args) == 0 {
+		return
+	}
+	pal := palette.Default
+	if len(args) > 1 {
+		var err error
+		if pal, err = palette.Load(args[1]); err != nil {
+			fmt.Fprintf(r.out, "Failed to load palette: %v\n", err)
+			return
+		}
+	}
+	if err := writePNG(args[0], o.Sheet(pal)); err != nil {
+		fmt.Fprintf(r.out, "Failed to draw sprites: %v\n", err)
+		return
+	}
+	fmt.Fprintf(r.out, "Drew sprites to %s\n", args[0])
+}
+
+// hideLayers handles "hide [layer]..." and "show [layer]...".
+func (r *REPL) hideLayers(hide bool, args []string) {
+	h, ok := r.d.target.(LayerHider)
+	if !ok {
+		fmt.Fprintln(r.out, "The target can't hide layers")
+		return
+	}
+	o := h.DebugOptions()
+	if !hide && len(args) == 0 {
+		o = ppu.DebugOptions{}
+	}
+	for _, arg := range args {
+		switch arg {
+		case "bg":
+			o.HideBG = hide
+		case "window":
+			o.HideWindow = hide
+		case "sprites":
+			o.HideSprites = hide
+		default:
+			i, err := strconv.ParseUint(arg, 10, 8)
+			if err != nil || i >= oamview.Sprites {
+				fmt.Fprintf(r.out, "%q is not bg, window, sprites or a sprite from 0 to %d\n", arg, oamview.Sprites-1)
+				return
+			}
+			if hide {
+				o.HiddenSprites |= 1 << i
+			} else {
+				o.HiddenSprites &^= 1 << i
+			}
+		}
+	}
+	h.SetDebugOptions(o)
+
+	var hidden []string
+	for _, l := range []struct {
+		name   string
+		hidden bool
+	}{{"bg", o.HideBG}, {"window", o.HideWindow}, {"sprites", o.HideSprites}} {
+		if l.hidden {
+			hidden = append(hidden, l.name)
+		}
+	}
+	for i := 0; i < oamview.Sprites; i++ {
+		if o.HiddenSprites&(1<<uint(i)) != 0 {
+			hidden = append(hidden, fmt.Sprintf("sprite %d", i))
+		}
+	}
+	if len(hidden) == 0 {
+		fmt.Fprintln(r.out, "No layers are hidden")
+		return
+	}
+	fmt.Fprintf(r.out, "Hidden: %s\n", strings.Join(hidden, ", "))
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *REPL) addHistory(line string) {
+	if len(r.history) > 0 && r.history[len(r.history)-1] == line {
+		return
+	}
+	if len(r.history) == maxHistoryLength {
+		r.history = append(r.history[:0], r.history[1:]...)
+	}
+	r.history = append(r.history, line)
+}
+
+// addressAndCount parses the arguments of x and list: an address expression,
+// optionally followed by a count.
+func (r *REPL) addressAndCount(args []string, defaultCount int) (uint16, int, bool) {
+	n := defaultCount
+	if len(args) > 1 {
+		if c, err := strconv.Atoi(args[len(args)-1]); err == nil && c > 0 {
+			n = c
+			args = args[:len(args)-1]
+		}
+	}
+	x, err := ParseExpr(strings.Join(args, " "), r.d.syms)
+	if err != nil {
+		fmt.Fprintf(r.out, "Bad expression: %v\n", err)
+		return 0, 0, false
+	}
+	return uint16(r.d.Eval(x)), n, true
+}
+
+// breakCmd handles "break <loc> [if <expr>]".
+func (r *REPL) breakCmd(line string) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		fmt.Fprintln(r.out, "usage: break <loc> [if <expr>]")
+		return
+	}
+	a, anyBank, err := r.d.ParseLocation(fields[1])
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	var cond *Expr
+	if len(fields) > 2 {
+		if fields[2] != "if" || len(fields) == 3 {
+			fmt.Fprintln(r.out, "usage: break <loc> [if <expr>]")
+			return
+		}
+		if cond, err = ParseExpr(strings.Join(fields[3:], " "), r.d.syms); err != nil {
+			fmt.Fprintf(r.out, "Bad condition: %v\n", err)
+			return
+		}
+	}
+	bp := r.d.AddBreakpoint(a, anyBank, cond)
+	fmt.Fprintf(r.out, "Breakpoint %d at %s\n", bp.ID, r.describeBreakpoint(bp))
+}
+
+// watchCmd handles "watch <start>[..<end>] [kind]".
+func (r *REPL) watchCmd(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(r.out, "usage: watch <start>[..<end>] [r|w|x]")
+		return
+	}
+	kind := WatchWrite
+	if len(args) == 2 {
+		var err error
+		if kind, err = ParseWatchKind(args[1]); err != nil {
+			fmt.Fprintln(r.out, err)
+			return
+		}
+	}
+	bounds := strings.SplitN(args[0], "..", 2)
+	var addrs []uint16
+	for _, b := range bounds {
+		x, err := ParseExpr(b, r.d.syms)
+		if err != nil {
+			fmt.Fprintf(r.out, "Bad address: %v\n", err)
+			return
+		}
+		addrs = append(addrs, uint16(r.d.Eval(x)))
+	}
+	start, end := addrs[0], addrs[len(addrs)-1]
+	if end < start {
+		fmt.Fprintln(r.out, "The end of the range is before the start")
+		return
+	}
+	w := r.d.AddWatchpoint(start, end, kind)
+	fmt.Fprintf(r.out, "Watchpoint %d (%s) on %s\n", w.ID, w.Kind, r.describeRange(w))
+}
+
+func (r *REPL) info() {
+	if len(r.d.breakpoints) == 0 && len(r.d.watchpoints) == 0 {
+		fmt.Fprintln(r.out, "No breakpoints or watchpoints")
+		return
+	}
+	for _, bp := range r.d.breakpoints {
+		fmt.Fprintf(r.out, "%3d  break  %s, hit %d times\n", bp.ID, r.describeBreakpoint(bp), bp.Hits)
+	}
+	for _, w := range r.d.watchpoints {
+		fmt.Fprintf(r.out, "%3d  watch  %s (%s), hit %d times\n", w.ID, r.describeRange(w), w.Kind, w.Hits)
+	}
+}
+
+func (r *REPL) describeBreakpoint(bp *Breakpoint) string {
+	desc := r.d.syms.Format(bp.Addr)
+	if bp.AnyBank {
+		desc = fmt.Sprintf("$%04X (any bank)", bp.Addr.Addr)
+	}
+	if bp.Cond != nil {
+		desc += " if " + bp.Cond.String()
+	}
+	return desc
+}
+
+func (r *REPL) describeRange(w *Watchpoint) string {
+	if w.Start == w.End {
+		return r.d.Describe(w.Start)
+	}
+	return fmt.Sprintf("%s..%s", r.d.Describe(w.Start), r.d.Describe(w.End))
+}
+
+// printStop reports why execution stopped, and shows the next instruction.
+func (r *REPL) printStop(s Stop) {
+	switch s.Reason {
+	case StopBreakpoint:
+		fmt.Fprintf(r.out, "Breakpoint %d, hit %d times\n", s.Breakpoint.ID, s.Breakpoint.Hits)
+	case StopWatchpoint:
+		a := s.Access
+		switch a.Kind {
+		case WatchRead:
+			fmt.Fprintf(r.out, "Watchpoint %d: read of $%02X from %s by %s at %s\n", s.Watchpoint.ID, a.Value, r.d.Describe(a.Addr), a.Component, r.d.Describe(a.PC))
+		case WatchWrite:
+			fmt.Fprintf(r.out, "Watchpoint %d: write of $%02X to %s by %s at %s\n", s.Watchpoint.ID, a.Value, r.d.Describe(a.Addr), a.Component, r.d.Describe(a.PC))
+		default:
+			fmt.Fprintf(r.out, "Watchpoint %d: execute of %s\n", s.Watchpoint.ID, r.d.Describe(a.Addr))
+		}
+	case StopInterrupt:
+		fmt.Fprintln(r.out, "Interrupted")
+	case StopHistoryStart:
+		fmt.Fprintln(r.out, "Reached the start of the recorded history")
+	}
+	pc := r.d.target.Registers().PC
+	if desc := r.d.syms.Describe(r.d.Address(pc)); desc != "" {
+		fmt.Fprintf(r.out, "%s:\n", desc)
+	}
+	fmt.Fprintln(r.out, r.d.disassemblyLine(pc, true))
+}
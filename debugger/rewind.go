@@ -0,0 +1,80 @@
+package debugger
+
+import "errors"
+
+// Rewinder is implemented by targets that can go back in time, e.g. by
+// keeping a rewind.Buffer.
+type Rewinder interface {
+	// Rewind goes back the given number of video frames, or as far as it
+	// can, and returns the number of frames it went back.
+	Rewind(frames int) (int, error)
+	// Instructions returns the number of instructions executed so far.
+	Instructions() uint64
+	// Checkpoints returns the points, in instructions executed, that can
+	// be gone back to without replaying, oldest first.
+	Checkpoints() []uint64
+	// Seek goes back or forward to the point at which the given number of
+	// instructions had been executed.
+	Seek(instructions uint64) error
+}
+
+var errNoRewind = errors.New("the target can't rewind")
+
+func (d *Debugger) rewinder() (Rewinder, error) {
+	r, ok := d.target.(Rewinder)
+	if !ok || len(r.Checkpoints()) == 0 {
+		return nil, errNoRewind
+	}
+	return r, nil
+}
+
+// Rewind goes back the given number of video frames, or as far as the
+// target's history goes, and returns the number of frames it went back.
+func (d *Debugger) Rewind(frames int) (int, error) {
+	r, err := d.rewinder()
+	if err != nil {
+		return 0, err
+	}
+	return r.Rewind(frames)
+}
+
+// ReverseContinue goes back to the last instruction that wrote to memory
+// watched for writes, stopping before it executes, so that stepping
+// forward makes the write again. If no watched memory was written within
+// the target's history, it goes back to the start of the history.
+//
+// The target's history is searched a checkpoint at a time, newest first,
+// by going back to each checkpoint and replaying up to the next one.
+func (d *Debugger) ReverseContinue() (Stop, error) {
+	r, err := d.rewinder()
+	if err != nil {
+		return Stop{}, err
+	}
+	points := r.Checkpoints()
+	end := r.Instructions()
+	for i := len(points) - 1; i >= 0; i-- {
+		start := points[i]
+		if start >= end {
+			continue
+		}
+		if err := r.Seek(start); err != nil {
+			return Stop{}, err
+		}
+		found := false
+		var at uint64
+		var stop Stop
+		for r.Instructions() < end {
+			n := r.Instructions()
+			d.step()
+			if d.written != nil {
+				found, at = true, n
+				stop = Stop{Reason: StopWatchpoint, Watchpoint: d.written, Access: d.writtenAccess}
+			}
+		}
+		if found {
+			return stop, r.Seek(at)
+		}
+		end = start
+	}
+	return Stop{Reason: StopHistoryStart}, nil
+}
@@ -0,0 +1,11 @@
+package debugger
+
+// StateSaver is implemented by targets whose state can be saved and
+// restored, e.g. with the savestate package.
+type StateSaver interface {
+	// SaveState appends the target's state to dst, and returns the
+	// extended slice.
+	SaveState(dst []byte) []byte
+	// LoadState restores a state saved by SaveState.
+	LoadState(state []byte) error
+}
@@ -0,0 +1,115 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// bytesPerRow is the number of bytes in each row of a hex dump.
+const bytesPerRow = 16
+
+// writeRegisters writes the registers, along with the flags and where the
+// PC is, e.g.
+//
+//	AF=01B0 BC=0013 DE=00D8 HL=014D SP=FFFE PC=0150  flags Z-HC
+//	PC is at $00:0150 <Main>, call depth 0
+func (d *Debugger) writeRegisters(w io.Writer) {
+	r := d.target.Registers()
+	flags := []byte("ZNHC")
+	for i := range flags {
+		if r.F&(0x80>>uint(i)) == 0 {
+			flags[i] = '-'
+		}
+	}
+	fmt.Fprintf(w, "AF=%02X%02X BC=%02X%02X DE=%02X%02X HL=%02X%02X SP=%04X PC=%04X  flags %s\n",
+		r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, r.PC, flags)
+	fmt.Fprintf(w, "PC is at %s, call depth %d\n", d.Describe(r.PC), d.CallDepth())
+}
+
+// writeBacktrace writes the call stack, innermost first, e.g.
+//
+//	#0  $00:0113 <Sub+3>
+//	#1  $00:0103 <Main.loop+1>  called $00:0110 <Sub> with SP=FFFC
+func (d *Debugger) writeBacktrace(w io.Writer) {
+	fmt.Fprintf(w, "#0  %s\n", d.Describe(d.target.Registers().PC))
+	for i, f := range d.CallStack() {
+		target := d.syms.Format(symbols.Address{Bank: f.Bank, Addr: f.Target})
+		fmt.Fprintf(w, "#%d  %s  called %s with SP=%04X\n", i+1, d.Describe(f.CallerPC), target, f.SP)
+	}
+}
+
+// writeHexDump writes n bytes of memory from addr as rows of hex and ASCII.
+func (d *Debugger) writeHexDump(w io.Writer, addr uint16, n int) {
+	for row := 0; row < n; row += bytesPerRow {
+		rowAddr := addr + uint16(row)
+		var hex, ascii strings.Builder
+		for i := 0; i < bytesPerRow; i++ {
+			if row+i >= n {
+				hex.WriteString("   ")
+				continue
+			}
+			b := d.target.ReadMemory(rowAddr + uint16(i))
+			fmt.Fprintf(&hex, "%02X ", b)
+			if b >= 0x20 && b < 0x7F {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(w, "$%04X  %s |%s|\n", rowAddr, hex.String(), ascii.String())
+	}
+}
+
+// writeDisassembly writes n instructions starting at addr, marking the one
+// at the PC with "=>" and those with breakpoints with "*". Labels from the
+// symbol file are shown above the instructions they name.
+func (d *Debugger) writeDisassembly(w io.Writer, addr uint16, n int) {
+	pc := d.target.Registers().PC
+	for i := 0; i < n; i++ {
+		a := d.Address(addr)
+		if name, ok := d.syms.Name(a); ok {
+			fmt.Fprintf(w, "%s:\n", name)
+		}
+		fmt.Fprintln(w, d.disassemblyLine(addr, addr == pc))
+		instr := cpu.Decode(addr, targetReader{d.target})
+		length := instr.Length()
+		if length == 0 {
+			// An illegal opcode.
+			length = 1
+		}
+		addr += length
+	}
+}
+
+// disassemblyLine formats the instruction at addr, e.g.
+//
+//	=> * $00:0150  3E 01     LD A, d8: 0x01
+func (d *Debugger) disassemblyLine(addr uint16, current bool) string {
+	instr := cpu.Decode(addr, targetReader{d.target})
+	marker := "  "
+	if current {
+		marker = "=>"
+	}
+	bp := " "
+	for _, b := range d.breakpoints {
+		if b.Addr.Addr == addr && (b.AnyBank || b.Addr.Bank == d.target.BankAt(addr)) {
+			bp = "*"
+			break
+		}
+	}
+	length := int(instr.Length())
+	text := instr.String()
+	if !instr.Valid() {
+		length = 1
+		text = "(illegal opcode)"
+	}
+	bytes := make([]string, length)
+	for i := range bytes {
+		bytes[i] = fmt.Sprintf("%02X", d.target.ReadMemory(addr+uint16(i)))
+	}
+	return fmt.Sprintf("%s %s %s  %-9s %s", marker, bp, d.Address(addr), strings.Join(bytes, " "), text)
+}
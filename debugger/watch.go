@@ -0,0 +1,143 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// WatchKind is a set of kinds of memory access.
+type WatchKind int
+
+const (
+	WatchRead WatchKind = 1 << iota
+	WatchWrite
+	WatchExecute
+)
+
+func (k WatchKind) String() string {
+	var b strings.Builder
+	for _, kind := range []struct {
+		k WatchKind
+		c byte
+	}{{WatchRead, 'r'}, {WatchWrite, 'w'}, {WatchExecute, 'x'}} {
+		if k&kind.k != 0 {
+			b.WriteByte(kind.c)
+		}
+	}
+	return b.String()
+}
+
+// ParseWatchKind parses a combination of the letters r, w and x.
+func ParseWatchKind(s string) (WatchKind, error) {
+	var k WatchKind
+	for _, c := range s {
+		switch c {
+		case 'r':
+			k |= WatchRead
+		case 'w':
+			k |= WatchWrite
+		case 'x':
+			k |= WatchExecute
+		default:
+			return 0, fmt.Errorf("bad watch kind %q: expected a combination of r, w and x", s)
+		}
+	}
+	if k == 0 {
+		return 0, fmt.Errorf("empty watch kind")
+	}
+	return k, nil
+}
+
+// Watchpoint stops execution when a range of memory is accessed. Reads and
+// writes stop after the instruction that made them; executes stop before
+// the instruction.
+type Watchpoint struct {
+	ID         int
+	Start, End uint16 // inclusive
+	Kind       WatchKind
+	Hits       int
+
+	hook   mmu.HookID
+	hooked bool
+}
+
+func (w *Watchpoint) contains(addr uint16) bool {
+	return addr >= w.Start && addr <= w.End
+}
+
+// Access is a single memory access.
+type Access struct {
+	Addr  uint16
+	Kind  WatchKind
+	Value byte // the value read or written
+	// Component is the part of the Gameboy that made the access: the CPU,
+	// or DMA started by the CPU.
+	Component mmu.Component
+	// PC is the address of the instruction that made the access.
+	PC uint16
+}
+
+// executeWatchpointAt returns the execute watchpoint covering pc, if any.
+func (d *Debugger) executeWatchpointAt(pc uint16) *Watchpoint {
+	for _, w := range d.watchpoints {
+		if w.Kind&WatchExecute != 0 && w.contains(pc) {
+			w.Hits++
+			return w
+		}
+	}
+	return nil
+}
+
+// hookWatchpoint adds an MMU hook for w's reads and writes, if it watches
+// for them.
+func (d *Debugger) hookWatchpoint(w *Watchpoint) {
+	var kinds mmu.AccessKind
+	if w.Kind&WatchRead != 0 {
+		kinds |= mmu.HookRead
+	}
+	if w.Kind&WatchWrite != 0 {
+		kinds |= mmu.HookWrite
+	}
+	if kinds == 0 {
+		return
+	}
+	w.hook = d.target.AddHook(w.Start, w.End, kinds, func(a mmu.Access) {
+		d.accessed(w, a)
+	})
+	w.hooked = true
+}
+
+func (d *Debugger) unhookWatchpoint(w *Watchpoint) {
+	if w.hooked {
+		d.target.RemoveHook(w.hook)
+		w.hooked = false
+	}
+}
+
+// accessed records the first access during a step that triggers a
+// watchpoint, and the first write. Reads of the instruction being executed
+// are opcode fetches, not data reads, so they're ignored, as are the PPU's
+// accesses.
+func (d *Debugger) accessed(w *Watchpoint, a mmu.Access) {
+	if !d.stepping || a.Component == mmu.PPU {
+		return
+	}
+	if a.Write && d.written == nil {
+		d.written = w
+		d.writtenAccess = Access{Addr: a.Addr, Kind: WatchWrite, Value: a.Value, Component: a.Component, PC: d.fetchStart}
+	}
+	if d.hit != nil {
+		return
+	}
+	kind := WatchRead
+	if a.Write {
+		kind = WatchWrite
+	}
+	if kind == WatchRead && a.Component == mmu.CPU && a.Addr >= d.fetchStart && uint32(a.Addr) < uint32(d.fetchStart)+uint32(d.fetchLen) {
+		return
+	}
+	d.hit = w
+	d.access = Access{Addr: a.Addr, Kind: kind, Value: a.Value, Component: a.Component, PC: d.fetchStart}
+}
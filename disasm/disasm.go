@@ -0,0 +1,469 @@
+// Package disasm disassembles Gameboy ROMs into RGBDS-compatible assembly.
+//
+// ROMs are disassembled bank by bank. Bank 0 is always mapped at
+// $0000-$3FFF, and every other bank is mapped at $4000-$7FFF. In Recursive
+// mode, only code reachable from the entry point, the interrupt vectors and
+// any extra entry points is disassembled, and everything else is emitted as
+// data. In Linear mode, every byte is disassembled as code unless it isn't a
+// valid instruction.
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// BankSize is the size of a ROM bank.
+const BankSize = 0x4000
+
+// Mode selects how code is told apart from data.
+type Mode int
+
+const (
+	// Recursive follows jumps and calls from the entry points.
+	Recursive Mode = iota
+	// Linear disassembles every byte of every bank in order.
+	Linear
+)
+
+// Address is a bank-qualified ROM address.
+type Address struct {
+	Bank int
+	Addr uint16
+}
+
+func (a Address) String() string {
+	return fmt.Sprintf("$%02X:%04X", a.Bank, a.Addr)
+}
+
+// Options configures a disassembly.
+type Options struct {
+	Mode Mode
+	// Entries are extra addresses to start disassembling from in Recursive
+	// mode, e.g. the targets of jump tables that can't be followed.
+	Entries []Address
+	// Symbols, if set, names labels and memory addresses. Symbol names
+	// are used in place of generated label names.
+	Symbols *symbols.Table
+}
+
+// vectors are the addresses the CPU jumps to on its own: the entry point
+// at the end of the boot ROM, and the interrupt handlers.
+var vectors = []struct {
+	addr uint16
+	name string
+}{
+	{0x0040, "VBlankInterrupt"},
+	{0x0048, "LCDCInterrupt"},
+	{0x0050, "TimerInterrupt"},
+	{0x0058, "SerialInterrupt"},
+	{0x0060, "JoypadInterrupt"},
+	{0x0100, "Entry"},
+}
+
+// padding is the byte unused parts of ROMs are usually filled with. It
+// decodes as RST $38.
+const padding = 0xFF
+
+// header is the range of the cartridge header after the entry point,
+// which is never code.
+const (
+	headerStart = 0x0104
+	headerEnd   = 0x0150
+)
+
+// byteKind records what a byte of the ROM was found to be.
+type byteKind uint8
+
+const (
+	data    byteKind = iota // not (yet) known to be code
+	opcode                  // the first byte of an instruction
+	operand                 // any other byte of an instruction
+)
+
+type labelKind int
+
+const (
+	jumpLabel labelKind = iota
+	callLabel
+	rstLabel
+	namedLabel
+)
+
+type label struct {
+	name string
+	kind labelKind
+	refs []Address
+	// fromSymbols is true if the label was named by the symbol file.
+	fromSymbols bool
+}
+
+// Disassembly is a disassembled ROM.
+type Disassembly struct {
+	rom    []byte
+	banks  int
+	kinds  []byteKind
+	labels map[int]*label
+	// lengths holds the length of the instruction starting at each opcode.
+	lengths map[int]uint16
+	symbols *symbols.Table
+}
+
+// Disassemble disassembles rom.
+func Disassemble(rom []byte, opt Options) *Disassembly {
+	banks := (len(rom) + BankSize - 1) / BankSize
+	if banks < 2 {
+		// Even the smallest ROMs fill both $0000-$3FFF and $4000-$7FFF.
+		banks = 2
+	}
+	d := &Disassembly{
+		rom:     rom,
+		banks:   banks,
+		kinds:   make([]byteKind, len(rom)),
+		labels:  make(map[int]*label),
+		lengths: make(map[int]uint16),
+		symbols: opt.Symbols,
+	}
+	for _, v := range vectors {
+		if off := d.offset(Address{0, v.addr}); off >= 0 {
+			d.labels[off] = &label{name: v.name, kind: namedLabel}
+		}
+	}
+	for _, s := range opt.Symbols.Symbols() {
+		off := d.offset(Address{s.Bank, s.Addr})
+		if off < 0 {
+			continue
+		}
+		// Only the first symbol at an address is used.
+		if l, ok := d.labels[off]; !ok || !l.fromSymbols {
+			d.labels[off] = &label{name: s.Name, kind: namedLabel, fromSymbols: true}
+		}
+	}
+
+	switch opt.Mode {
+	case Linear:
+		for bank := 0; bank < banks; bank++ {
+			d.linear(bank)
+		}
+	default:
+		work := make([]Address, 0, len(vectors)+len(opt.Entries))
+		for _, v := range vectors {
+			// Unused vectors are usually left as $FF padding.
+			if off := d.offset(Address{0, v.addr}); off >= 0 && d.rom[off] != padding {
+				work = append(work, Address{0, v.addr})
+			}
+		}
+		work = append(work, opt.Entries...)
+		for len(work) > 0 {
+			a := work[len(work)-1]
+			work = work[:len(work)-1]
+			work = d.follow(a, work)
+		}
+	}
+	return d
+}
+
+// Banks returns the number of banks in the ROM.
+func (d *Disassembly) Banks() int {
+	return d.banks
+}
+
+// IsCode returns true if the byte at a was disassembled as part of an
+// instruction.
+func (d *Disassembly) IsCode(a Address) bool {
+	off := d.offset(a)
+	return off >= 0 && d.kinds[off] != data
+}
+
+// Label returns the name of the label at a, if there is one.
+func (d *Disassembly) Label(a Address) (string, bool) {
+	off := d.offset(a)
+	if off < 0 {
+		return "", false
+	}
+	l, ok := d.labels[off]
+	if !ok || d.kinds[off] == operand {
+		return "", false
+	}
+	return l.name, true
+}
+
+// offset returns the offset into the ROM of a, or -1 if a isn't in the ROM.
+func (d *Disassembly) offset(a Address) int {
+	var off int
+	switch {
+	case a.Addr < BankSize:
+		if a.Bank != 0 {
+			return -1
+		}
+		off = int(a.Addr)
+	case a.Addr < 2*BankSize:
+		if a.Bank == 0 {
+			return -1
+		}
+		off = a.Bank*BankSize + int(a.Addr) - BankSize
+	default:
+		return -1
+	}
+	if off >= len(d.rom) {
+		return -1
+	}
+	return off
+}
+
+// address returns the bank-qualified address of a ROM offset.
+func address(off int) Address {
+	bank := off / BankSize
+	if bank == 0 {
+		return Address{0, uint16(off)}
+	}
+	return Address{bank, uint16(BankSize + off%BankSize)}
+}
+
+// bankReader presents one bank of the ROM as the CPU would see it, so that
+// instructions can be decoded with cpu.Decode.
+type bankReader struct {
+	d    *Disassembly
+	bank int
+}
+
+func (r bankReader) Rb(addr uint16) byte {
+	bank := r.bank
+	if addr < BankSize {
+		bank = 0
+	} else if bank == 0 {
+		bank = 1
+	}
+	if off := r.d.offset(Address{bank, addr}); off >= 0 {
+		return r.d.rom[off]
+	}
+	return 0xFF
+}
+
+func (r bankReader) Rw(addr uint16) uint16 {
+	return uint16(r.Rb(addr+1))<<8 | uint16(r.Rb(addr))
+}
+
+// decode decodes the instruction at a. It returns false if the bytes at a
+// aren't a valid instruction, or if the instruction would run off the end
+// of the bank or overlap another instruction.
+func (d *Disassembly) decode(a Address) (cpu.Instruction, uint16, bool) {
+	instr := cpu.Decode(a.Addr, bankReader{d, a.Bank})
+	if !instr.Valid() {
+		return instr, 0, false
+	}
+	length := instr.Length()
+	if strings.HasPrefix(instr.Mnemonic(), "STOP") {
+		// STOP is followed by a padding byte, which RGBDS always emits as
+		// $00. Any other padding byte can only be represented as data.
+		if next := d.offset(Address{a.Bank, a.Addr + 1}); next < 0 || d.rom[next] != 0x00 {
+			return instr, 0, false
+		}
+		length = 2
+	}
+	start := d.offset(a)
+	end := d.offset(Address{a.Bank, a.Addr + length - 1})
+	if start < 0 || end < 0 || end-start != int(length)-1 {
+		return instr, 0, false
+	}
+	for off := start; off <= end; off++ {
+		if d.kinds[off] != data {
+			return instr, 0, false
+		}
+	}
+	return instr, length, true
+}
+
+// mark records that the instruction at a is code.
+func (d *Disassembly) mark(a Address, length uint16) {
+	off := d.offset(a)
+	d.kinds[off] = opcode
+	for i := 1; i < int(length); i++ {
+		d.kinds[off+i] = operand
+	}
+	d.lengths[off] = length
+}
+
+// linear disassembles a whole bank in order.
+func (d *Disassembly) linear(bank int) {
+	start := uint16(BankSize)
+	if bank == 0 {
+		start = 0
+	}
+	for addr := int(start); addr < int(start)+BankSize; {
+		a := Address{bank, uint16(addr)}
+		if d.offset(a) < 0 {
+			return
+		}
+		if bank == 0 && addr >= headerStart && addr < headerEnd {
+			addr = headerEnd
+			continue
+		}
+		instr, length, ok := d.decode(a)
+		if !ok {
+			addr++
+			continue
+		}
+		d.mark(a, length)
+		for _, t := range d.targets(a, instr) {
+			d.addLabel(t.addr, t.kind, a)
+		}
+		addr += int(length)
+	}
+}
+
+// follow disassembles code starting at a until it reaches an unconditional
+// jump or return, and returns work with any newly found jump and call
+// targets appended.
+func (d *Disassembly) follow(a Address, work []Address) []Address {
+	for {
+		off := d.offset(a)
+		if off < 0 || d.kinds[off] != data {
+			// Either off the end of the ROM, or already disassembled.
+			return work
+		}
+		if a.Bank == 0 && a.Addr >= headerStart && a.Addr < headerEnd {
+			return work
+		}
+		instr, length, ok := d.decode(a)
+		if !ok {
+			return work
+		}
+		d.mark(a, length)
+		for _, t := range d.targets(a, instr) {
+			if d.addLabel(t.addr, t.kind, a) {
+				work = append(work, t.addr)
+			}
+		}
+		if endsBlock(instr.Mnemonic()) || d.isPadding(a) {
+			return work
+		}
+		a.Addr += length
+	}
+}
+
+// isPadding returns true if a is the start of a run of padding bytes.
+// Padding decodes as a string of RST $38 instructions, which we don't want
+// to follow.
+func (d *Disassembly) isPadding(a Address) bool {
+	off := d.offset(a)
+	return off+1 < len(d.rom) && d.rom[off] == padding && d.rom[off+1] == padding
+}
+
+type target struct {
+	addr Address
+	kind labelKind
+}
+
+// targets returns the ROM addresses that the instruction at a can transfer
+// control to, other than the next instruction.
+func (d *Disassembly) targets(a Address, instr cpu.Instruction) []target {
+	op, operands := split(instr.Mnemonic())
+	data := instr.Data()
+	var addr uint16
+	var kind labelKind
+	switch {
+	case (op == "JP" || op == "CALL") && operands[len(operands)-1] == "a16":
+		addr = uint16(data[1])<<8 | uint16(data[0])
+		kind = jumpLabel
+		if op == "CALL" {
+			kind = callLabel
+		}
+	case op == "JR":
+		addr = relativeTarget(a.Addr, data[0])
+		kind = jumpLabel
+	case op == "RST":
+		addr = rstTarget(operands[0])
+		kind = rstLabel
+	default:
+		return nil
+	}
+	t, ok := d.resolve(a, addr)
+	if !ok {
+		return nil
+	}
+	return []target{{t, kind}}
+}
+
+// resolve works out which bank a jump from a to addr ends up in. It returns
+// false if addr isn't in ROM, or if it's in the switchable bank and we can't
+// tell which bank is mapped there.
+func (d *Disassembly) resolve(from Address, addr uint16) (Address, bool) {
+	var to Address
+	switch {
+	case addr < BankSize:
+		to = Address{0, addr}
+	case addr < 2*BankSize && from.Bank != 0:
+		to = Address{from.Bank, addr}
+	case addr < 2*BankSize && d.banks == 2:
+		// Without a memory bank controller, bank 1 is always mapped.
+		to = Address{1, addr}
+	default:
+		return Address{}, false
+	}
+	return to, d.offset(to) >= 0
+}
+
+// addLabel records a reference from `from` to a. It returns true if this is
+// the first reference to a.
+func (d *Disassembly) addLabel(a Address, kind labelKind, from Address) bool {
+	off := d.offset(a)
+	l, ok := d.labels[off]
+	if !ok {
+		l = &label{kind: kind}
+		d.labels[off] = l
+	}
+	first := len(l.refs) == 0
+	l.refs = append(l.refs, from)
+	if l.kind < kind {
+		l.kind = kind
+	}
+	switch l.kind {
+	case jumpLabel:
+		l.name = fmt.Sprintf("Jump_%03X_%04X", a.Bank, a.Addr)
+	case callLabel:
+		l.name = fmt.Sprintf("Call_%03X_%04X", a.Bank, a.Addr)
+	case rstLabel:
+		l.name = fmt.Sprintf("RST_%02X", a.Addr)
+	}
+	return first
+}
+
+// endsBlock returns true if execution never continues to the instruction
+// after mnemonic.
+func endsBlock(mnemonic string) bool {
+	op, operands := split(mnemonic)
+	switch op {
+	case "JP", "JR":
+		return len(operands) == 1
+	case "RET":
+		return len(operands) == 0
+	case "RETI":
+		return true
+	}
+	return false
+}
+
+// split splits a mnemonic like "LD A, (HL+)" into "LD" and ["A", "(HL+)"].
+func split(mnemonic string) (string, []string) {
+	parts := strings.SplitN(mnemonic, " ", 2)
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return parts[0], strings.Split(parts[1], ", ")
+}
+
+func relativeTarget(pc uint16, r8 byte) uint16 {
+	// JR is two bytes long, and the offset is relative to the next instruction.
+	return pc + 2 + uint16(int8(r8))
+}
+
+// rstTarget parses RST operands like "38H".
+func rstTarget(operand string) uint16 {
+	var n uint16
+	fmt.Sscanf(operand, "%xH", &n)
+	return n
+}
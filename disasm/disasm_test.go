@@ -0,0 +1,180 @@
+package disasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// testROM returns a 32KiB ROM filled with $FF, with code copied in at the
+// given addresses.
+func testROM(code map[uint16][]byte) []byte {
+	rom := make([]byte, 2*BankSize)
+	for i := range rom {
+		rom[i] = 0xFF
+	}
+	for addr, b := range code {
+		copy(rom[addr:], b)
+	}
+	return rom
+}
+
+func TestDisassembly_format(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes []byte
+		want  string
+	}{
+		{"ldh store", []byte{0xE0, 0x44}, "ldh [$FF44], a"},
+		{"ld (C)", []byte{0xE2}, "ld [$FF00+c], a"},
+		{"ld hl, sp-2", []byte{0xF8, 0xFE}, "ld hl, sp - 2"},
+		{"ld hl, sp+3", []byte{0xF8, 0x03}, "ld hl, sp + 3"},
+		{"add sp, -2", []byte{0xE8, 0xFE}, "add sp, -2"},
+		{"ld a, (hl+)", []byte{0x2A}, "ld a, [hl+]"},
+		{"ld (a16), sp", []byte{0x08, 0x00, 0xC1}, "ld [$C100], sp"},
+		{"ld de, d16", []byte{0x11, 0x34, 0x12}, "ld de, $1234"},
+		{"bit 7, (hl)", []byte{0xCB, 0x7E}, "bit 7, [hl]"},
+		{"jp hl", []byte{0xE9}, "jp hl"},
+		{"jr to numeric target", []byte{0x20, 0x10}, "jr nz, $0012"},
+		{"stop", []byte{0x10, 0x00}, "stop"},
+		{"rst", []byte{0xEF}, "rst $28"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Disassemble(testROM(map[uint16][]byte{0x0000: tt.bytes}), Options{})
+			a := Address{0, 0x0000}
+			instr, _, ok := d.decode(a)
+			if !ok {
+				t.Fatalf("Couldn't decode % x", tt.bytes)
+			}
+			if got := d.format(a, instr); got != tt.want {
+				t.Errorf("Got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisassemble_recursive(t *testing.T) {
+	rom := testROM(map[uint16][]byte{
+		0x0040: {0xD9},                   // reti
+		0x0048: {0xD9},                   // reti
+		0x0050: {0xD9},                   // reti
+		0x0058: {0xD9},                   // reti
+		0x0060: {0xD9},                   // reti
+		0x0100: {0x00, 0xC3, 0x50, 0x01}, // nop; jp $0150
+		0x0150: {
+			0x3E, 0x01, // ld a, $01
+			0xCD, 0x60, 0x01, // call $0160
+			0x18, 0xFE, // jr $0155
+		},
+		0x0160: {0xAF, 0xC9, 0x12, 0x34}, // xor a; ret; data
+	})
+	d := Disassemble(rom, Options{Mode: Recursive})
+
+	tests := []struct {
+		name string
+		addr uint16
+		code bool
+	}{
+		{"entry point", 0x0100, true},
+		{"jump target", 0x0150, true},
+		{"call target", 0x0160, true},
+		{"data after ret", 0x0162, false},
+		{"cartridge header", 0x0134, false},
+		{"unreached padding", 0x0200, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.IsCode(Address{0, tt.addr}); got != tt.code {
+				t.Errorf("IsCode($%04X) = %v, want %v", tt.addr, got, tt.code)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if _, err := d.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`SECTION "ROM Bank $000", ROM0[$0000]`,
+		"Entry:",
+		"jp Jump_000_0150",
+		"call Call_000_0160",
+		"Jump_000_0155: ; referenced from $00:0155",
+		"jr Jump_000_0155",
+		"Call_000_0160: ; referenced from $00:0152",
+		"db $12, $34",
+		`SECTION "ROM Bank $001", ROMX[$4000], BANK[$1]`,
+		"ds 16384, $FF",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDisassemble_linear(t *testing.T) {
+	rom := testROM(map[uint16][]byte{
+		0x0200: {0x3E, 0x01, 0xD3, 0xC9}, // ld a, $01; (invalid); ret
+	})
+	d := Disassemble(rom, Options{Mode: Linear})
+	tests := []struct {
+		name string
+		addr uint16
+		code bool
+	}{
+		{"unreachable code", 0x0200, true},
+		{"invalid opcode", 0x0202, false},
+		{"code after invalid opcode", 0x0203, true},
+		{"cartridge header", 0x0134, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.IsCode(Address{0, tt.addr}); got != tt.code {
+				t.Errorf("IsCode($%04X) = %v, want %v", tt.addr, got, tt.code)
+			}
+		})
+	}
+}
+
+func TestDisassemble_symbols(t *testing.T) {
+	rom := testROM(map[uint16][]byte{
+		0x0100: {0x00, 0xC3, 0x50, 0x01}, // nop; jp $0150
+		0x0150: {
+			0xEA, 0x00, 0xC0, // ld [$C000], a
+			0xE0, 0x44, // ldh [$FF44], a
+			0xCD, 0x60, 0x01, // call $0160
+			0x18, 0xFE, // jr $0158
+		},
+		0x0160: {0xC9}, // ret
+	})
+	syms, err := symbols.Load(strings.NewReader(`
+00:0150 Main
+00:0158 Main.loop
+00:0160 DoNothing
+00:c000 wCounter
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Disassemble(rom, Options{Mode: Recursive, Symbols: syms})
+
+	var out bytes.Buffer
+	if _, err := d.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"jp Main",
+		"Main.loop: ; referenced from $00:0158",
+		"jr Main.loop",
+		"call DoNothing",
+		"ld [wCounter], a",
+		"ldh [$FF44], a",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, out.String())
+		}
+	}
+}
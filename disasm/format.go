@@ -0,0 +1,246 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// maxRefs is the maximum number of references listed in a label's comment.
+const maxRefs = 4
+
+// minFillRun is the shortest run of identical data bytes emitted with `ds`
+// rather than `db`.
+const minFillRun = 16
+
+// WriteTo writes the disassembly to w as RGBDS assembly.
+func (d *Disassembly) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for bank := 0; bank < d.banks; bank++ {
+		d.writeBank(cw, bank)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// countingWriter remembers the first error so that writeBank doesn't have
+// to check every write.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+func (d *Disassembly) writeBank(w *countingWriter, bank int) {
+	start := bank * BankSize
+	end := start + BankSize
+	if end > len(d.rom) {
+		end = len(d.rom)
+	}
+	if start >= end {
+		return
+	}
+	if bank == 0 {
+		w.printf("SECTION \"ROM Bank $000\", ROM0[$0000]\n")
+	} else {
+		w.printf("\nSECTION \"ROM Bank $%03X\", ROMX[$4000], BANK[$%X]\n", bank, bank)
+	}
+
+	for off := start; off < end; {
+		a := address(off)
+		if l, ok := d.labels[off]; ok {
+			w.printf("\n%s:%s\n", l.name, refsComment(l))
+		}
+		if bank == 0 && a.Addr == headerStart && d.kinds[off] == data {
+			w.printf("; Cartridge header\n")
+		}
+		if d.kinds[off] == opcode {
+			length := int(d.lengths[off])
+			instr := cpu.Decode(a.Addr, bankReader{d, a.Bank})
+			bytes := make([]string, length)
+			for i := range bytes {
+				bytes[i] = fmt.Sprintf("%02X", d.rom[off+i])
+			}
+			w.printf("    %-28s ; %s %s\n", d.format(a, instr), a, strings.Join(bytes, " "))
+			off += length
+			continue
+		}
+		off = d.writeData(w, off, end)
+	}
+}
+
+// writeData writes the data starting at off, up to the next instruction or
+// label, and returns the offset after it.
+func (d *Disassembly) writeData(w *countingWriter, off, end int) int {
+	// Find the end of this run of data.
+	runEnd := off + 1
+	for runEnd < end && d.kinds[runEnd] == data && !d.breaksData(runEnd) {
+		runEnd++
+	}
+	for off < runEnd {
+		// Long runs of the same byte are usually padding.
+		fill := off + 1
+		for fill < runEnd && d.rom[fill] == d.rom[off] {
+			fill++
+		}
+		if fill-off >= minFillRun {
+			w.printf("    %-28s ; %s\n", fmt.Sprintf("ds %d, $%02X", fill-off, d.rom[off]), address(off))
+			off = fill
+			continue
+		}
+		lineEnd := off + 8
+		if lineEnd > runEnd {
+			lineEnd = runEnd
+		}
+		// Don't swallow the start of a run of padding.
+		for i := off + 1; i < lineEnd; i++ {
+			if d.fillLength(i, runEnd) >= minFillRun {
+				lineEnd = i
+				break
+			}
+		}
+		bytes := make([]string, 0, 8)
+		for i := off; i < lineEnd; i++ {
+			bytes = append(bytes, fmt.Sprintf("$%02X", d.rom[i]))
+		}
+		w.printf("    %-28s ; %s\n", "db "+strings.Join(bytes, ", "), address(off))
+		off = lineEnd
+	}
+	return off
+}
+
+// breaksData returns true if a run of data must end before off, because
+// there's a label there or the cartridge header starts there.
+func (d *Disassembly) breaksData(off int) bool {
+	_, ok := d.labels[off]
+	return ok || off == headerStart
+}
+
+func (d *Disassembly) fillLength(off, end int) int {
+	n := 1
+	for off+n < end && d.rom[off+n] == d.rom[off] {
+		n++
+	}
+	return n
+}
+
+func refsComment(l *label) string {
+	if len(l.refs) == 0 {
+		return ""
+	}
+	refs := make([]string, 0, maxRefs)
+	for i, r := range l.refs {
+		if i == maxRefs {
+			break
+		}
+		refs = append(refs, r.String())
+	}
+	more := ""
+	if len(l.refs) > maxRefs {
+		more = fmt.Sprintf(" (+%d more)", len(l.refs)-maxRefs)
+	}
+	return fmt.Sprintf(" ; referenced from %s%s", strings.Join(refs, ", "), more)
+}
+
+// format formats the instruction at a in RGBDS syntax, replacing jump and
+// call targets with labels where possible.
+func (d *Disassembly) format(a Address, instr cpu.Instruction) string {
+	op, operands := split(instr.Mnemonic())
+	data := instr.Data()
+	op = strings.ToLower(op)
+
+	switch op {
+	case "stop":
+		return "stop"
+	case "rst":
+		return fmt.Sprintf("rst $%02X", rstTarget(operands[0]))
+	}
+
+	formatted := make([]string, len(operands))
+	for i, operand := range operands {
+		formatted[i] = d.formatOperand(a, op, operand, data)
+	}
+	if len(formatted) == 0 {
+		return op
+	}
+	return op + " " + strings.Join(formatted, ", ")
+}
+
+func (d *Disassembly) formatOperand(a Address, op, operand string, data []byte) string {
+	switch operand {
+	case "d8":
+		return fmt.Sprintf("$%02X", data[0])
+	case "d16":
+		return fmt.Sprintf("$%04X", uint16(data[1])<<8|uint16(data[0]))
+	case "a16":
+		return d.addressOperand(a, uint16(data[1])<<8|uint16(data[0]))
+	case "(a16)":
+		return "[" + d.memoryOperand(uint16(data[1])<<8|uint16(data[0])) + "]"
+	case "(a8)":
+		return "[" + d.memoryOperand(0xFF00|uint16(data[0])) + "]"
+	case "(C)":
+		return "[$FF00+c]"
+	case "r8":
+		if op == "jr" {
+			return d.addressOperand(a, relativeTarget(a.Addr, data[0]))
+		}
+		// ADD SP, r8
+		return fmt.Sprintf("%d", int8(data[0]))
+	case "SP+r8":
+		if r8 := int8(data[0]); r8 < 0 {
+			return fmt.Sprintf("sp - %d", -int(r8))
+		}
+		return fmt.Sprintf("sp + %d", data[0])
+	}
+	if op == "jp" && operand == "(HL)" {
+		return "hl"
+	}
+	operand = strings.ToLower(operand)
+	if strings.HasPrefix(operand, "(") {
+		operand = "[" + strings.Trim(operand, "()") + "]"
+	}
+	return operand
+}
+
+// addressOperand formats a jump or call target as a label if the target has
+// one, or as a number otherwise.
+func (d *Disassembly) addressOperand(from Address, addr uint16) string {
+	if to, ok := d.resolve(from, addr); ok {
+		if name, ok := d.Label(to); ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+// memoryOperand formats the address of a load or store as a symbol name if
+// the symbol file has one for it, or as a number otherwise.
+func (d *Disassembly) memoryOperand(addr uint16) string {
+	bank := 0
+	// On the DMG, WRAM bank 1 is always mapped at $D000-$DFFF, and
+	// linkers put symbols there in bank 1.
+	if addr >= 0xD000 && addr < 0xE000 {
+		bank = 1
+	}
+	if addr >= 2*BankSize {
+		if name, ok := d.symbols.Name(symbols.Address{Bank: bank, Addr: addr}); ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
@@ -4,10 +4,15 @@ import (
 	"fmt"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/palette"
 	"github.com/mpingram/gameboy-emu/ppu"
+	"github.com/mpingram/gameboy-emu/screenshot"
 )
 
 const scale = 4
@@ -21,8 +26,23 @@ var (
 	vao           uint32
 	vertices      []float32
 	eboIndices    []uint32
+	pal           = palette.Default
+	layers        *ppu.PPU
 )
 
+// SetPalette sets the colors the screen is drawn in. It must be called
+// before ConnectVideo or Render.
+func SetPalette(p *palette.Palette) {
+	pal = p
+}
+
+// SetLayerToggles makes F1, F2 and F3 hide and show the background, window
+// and sprites p draws (see ppu.DebugOptions). It must be called before
+// ConnectVideo.
+func SetLayerToggles(p *ppu.PPU) {
+	layers = p
+}
+
 func openGLFWWindow() {
 	// ensure that this runs on main thread
 	runtime.LockOSThread()
@@ -232,10 +252,10 @@ func openGLFWWindow() {
 	// =====================================
 }
 
-func render(screen []ppu.Pixel) {
+func render(frame *ppu.Frame) {
 	// Render the screen passed in.
 	// convert screen to RGB pixels
-	pixels := formatPixelsForOpenGL(screen)
+	pixels := formatPixelsForOpenGL(frame)
 	gl.ClearColor(0.9, 0.9, 0.7, 1.0) // gross pale yellow
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 	gl.UseProgram(shaderProgram)
@@ -262,11 +282,11 @@ func render(screen []ppu.Pixel) {
 	window.SwapBuffers()
 }
 
-func Render(screen []ppu.Pixel) {
+func Render(frame *ppu.Frame) {
 	runtime.LockOSThread()
 	openGLFWWindow()
 	defer glfw.Terminate()
-	render(screen)
+	render(frame)
 	for {
 		window.SwapBuffers()
 		glfw.PollEvents()
@@ -277,32 +297,136 @@ func Render(screen []ppu.Pixel) {
 	}
 }
 
-func ConnectVideo(screens <-chan []ppu.Pixel) {
+// ConnectVideo opens the window and renders the sink's frames as they come
+// in, until Esc is pressed or the window is closed. It must be called from
+// the main goroutine, and the game must run on another.
+func ConnectVideo(frames *ppu.ChannelSink) {
 	runtime.LockOSThread()
 	openGLFWWindow()
 	defer glfw.Terminate()
-	// Main loop: render screens as they come in.
-	// Does not return -- any calling code must
-	// use a separate goroutine to do work.
+	// last is the frame on screen, for screenshots. It's held on to until
+	// the next one comes in.
+	var last *ppu.Frame
 	for {
 		select {
-		case screen := <-screens:
-			render(screen)
-			glfw.PollEvents()
-			// Break out of loop on esc keypress
-			if k := window.GetKey(glfw.KeyEscape); k == glfw.Press {
-				return
+		case frame := <-frames.C:
+			render(frame)
+			if last != nil {
+				frames.Release(last)
 			}
+			last = frame
 		default:
-			glfw.PollEvents()
-			// Break out of loop on esc keypress
-			if k := window.GetKey(glfw.KeyEscape); k == glfw.Press {
-				return
-			}
+		}
+		glfw.PollEvents()
+		pollButtons()
+		pollScreenshot(last)
+		pollLayerToggles()
+		// Break out of loop on esc keypress
+		if window.GetKey(glfw.KeyEscape) == glfw.Press || window.ShouldClose() {
+			return
+		}
+	}
+}
+
+// keyBindings are the keys for each joypad button.
+var keyBindings = []struct {
+	key    glfw.Key
+	button mmu.Buttons
+}{
+	{glfw.KeyUp, mmu.ButtonUp},
+	{glfw.KeyDown, mmu.ButtonDown},
+	{glfw.KeyLeft, mmu.ButtonLeft},
+	{glfw.KeyRight, mmu.ButtonRight},
+	{glfw.KeyX, mmu.ButtonA},
+	{glfw.KeyZ, mmu.ButtonB},
+	{glfw.KeyEnter, mmu.ButtonStart},
+	{glfw.KeyBackspace, mmu.ButtonSelect},
+}
+
+// buttons is the joypad buttons whose keys are held down, updated by
+// pollButtons and read with atomic operations.
+var buttons uint32
+
+func pollButtons() {
+	var b mmu.Buttons
+	for _, kb := range keyBindings {
+		if window.GetKey(kb.key) == glfw.Press {
+			b |= kb.button
+		}
+	}
+	atomic.StoreUint32(&buttons, uint32(b))
+}
+
+// screenshotKeyDown is set while the screenshot key is held down, so that
+// holding it takes only one screenshot.
+var screenshotKeyDown bool
+
+// pollScreenshot saves the frame on screen as a PNG in the current
+// directory when F12 is pressed: at 1× scale, or at the window's scale with
+// Shift.
+func pollScreenshot(frame *ppu.Frame) {
+	down := window.GetKey(glfw.KeyF12) == glfw.Press
+	pressed := down && !screenshotKeyDown
+	screenshotKeyDown = down
+	if !pressed || frame == nil {
+		return
+	}
+	n := 1
+	if window.GetKey(glfw.KeyLeftShift) == glfw.Press || window.GetKey(glfw.KeyRightShift) == glfw.Press {
+		n = scale
+	}
+	path := time.Now().Format("screenshot-20060102-150405.png")
+	if err := screenshot.Save(path, frame, pal, n); err != nil {
+		fmt.Printf("ERR: Failed to save screenshot: %v\n", err)
+		return
+	}
+	fmt.Printf("Saved screenshot to %s\n", path)
+}
+
+// layerToggles are the keys that hide and show each layer.
+var layerToggles = []struct {
+	key  glfw.Key
+	name string
+	hide func(o *ppu.DebugOptions) *bool
+	// down is set while the key is held down, so that holding it toggles
+	// the layer once.
+	down bool
+}{
+	{key: glfw.KeyF1, name: "Background", hide: func(o *ppu.DebugOptions) *bool { return &o.HideBG }},
+	{key: glfw.KeyF2, name: "Window", hide: func(o *ppu.DebugOptions) *bool { return &o.HideWindow }},
+	{key: glfw.KeyF3, name: "Sprites", hide: func(o *ppu.DebugOptions) *bool { return &o.HideSprites }},
+}
+
+func pollLayerToggles() {
+	if layers == nil {
+		return
+	}
+	for i := range layerToggles {
+		t := &layerToggles[i]
+		down := window.GetKey(t.key) == glfw.Press
+		pressed := down && !t.down
+		t.down = down
+		if !pressed {
+			continue
+		}
+		o := layers.DebugOptions()
+		hide := t.hide(&o)
+		*hide = !*hide
+		layers.SetDebugOptions(o)
+		if *hide {
+			fmt.Printf("%s hidden\n", t.name)
+		} else {
+			fmt.Printf("%s shown\n", t.name)
 		}
 	}
 }
 
+// Buttons returns the joypad buttons whose keys are held down in the
+// window. It may be called from any goroutine.
+func Buttons() mmu.Buttons {
+	return mmu.Buttons(atomic.LoadUint32(&buttons))
+}
+
 func checkGLErr() {
 	// 'handle' errors
 	for err := gl.GetError(); err != gl.NO_ERROR; err = gl.GetError() {
@@ -335,11 +459,12 @@ func compileShader(sourceBytes []byte, shaderType uint32) (uint32, error) {
 }
 
 // formatPixelsForOpenGL converts the screen data from the gameboy, which are single-byte
-// color identifiers, into RGB pixels that can be fed to openGL. As part of this, it
-// switches the coordinate system from top-left (Gameboy) to bottom-left (OpenGL)
-func formatPixelsForOpenGL(pixels []ppu.Pixel) []byte {
+// color identifiers, into RGB pixels in the current palette that can be fed to openGL.
+// As part of this, it switches the coordinate system from top-left (Gameboy) to
+// bottom-left (OpenGL)
+func formatPixelsForOpenGL(frame *ppu.Frame) []byte {
 	openGLPixels := make([]byte, width*height*3)
-	for i, px := range pixels {
+	for i := range frame.Pixels {
 		// convert top-left screen coordinates to bottom-left screen coordinates
 		// GB:
 		//              x = i%w
@@ -357,27 +482,10 @@ func formatPixelsForOpenGL(pixels []ppu.Pixel) []byte {
 		//              x = i%w
 		tgt := ((height-1)-(i/width))*width + i%width
 		tgt *= 3 // each pixel is represented by 3 bytes(R,G,B)
-		// tgt := i * 3
-		switch px {
-		case ppu.White:
-			openGLPixels[tgt] = 255
-			openGLPixels[tgt+1] = 255
-			openGLPixels[tgt+2] = 255
-		case ppu.LightGray:
-			openGLPixels[tgt] = 150
-			openGLPixels[tgt+1] = 150
-			openGLPixels[tgt+2] = 150
-		case ppu.DarkGray:
-			openGLPixels[tgt] = 75
-			openGLPixels[tgt+1] = 75
-			openGLPixels[tgt+2] = 75
-		case ppu.Black:
-			openGLPixels[tgt] = 0
-			openGLPixels[tgt+1] = 0
-			openGLPixels[tgt+2] = 0
-		default:
-			panic(fmt.Sprintf("toRGB: Got bad color: %v", px))
-		}
+		c := pal.PixelColor(frame, i)
+		openGLPixels[tgt] = c.R
+		openGLPixels[tgt+1] = c.G
+		openGLPixels[tgt+2] = c.B
 	}
 	return openGLPixels
 }
@@ -0,0 +1,407 @@
+// Package gdbstub implements a GDB Remote Serial Protocol server, so that
+// GDB (e.g. gdb-multiarch) and IDEs that speak the protocol can debug games
+// running in the emulator.
+//
+// The stub supports reading and writing registers and memory, software
+// breakpoints, read/write/access watchpoints, single-stepping, continuing
+// and interrupting with Ctrl-C. Registers are described to GDB with a
+// target description using the Z80 register names, which the SM83 shares:
+//
+//	(gdb) set architecture gbz80
+//	(gdb) target remote localhost:2345
+//
+// GDB only knows about 16-bit addresses, so bank-qualified addresses are
+// handled by monitor commands; see "monitor help".
+package gdbstub
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/debugger"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// Signals reported to GDB in stop replies.
+const (
+	sigint  = 2
+	sigtrap = 5
+)
+
+// Stub is a GDB stub that controls a debugger's target through the
+// debugger, so that breakpoints and watchpoints work the same way as they
+// do in its REPL.
+type Stub struct {
+	dbg    *debugger.Debugger
+	target debugger.Target
+	syms   *symbols.Table
+
+	// breakpoints are set by GDB, and stop at their address whichever bank
+	// is mapped. bankedBreakpoints are set with "monitor break", and only
+	// stop when the right bank is mapped.
+	breakpoints       map[uint16]*debugger.Breakpoint
+	bankedBreakpoints map[symbols.Address]*debugger.Breakpoint
+	watchpoints       map[watchpoint]*debugger.Watchpoint
+}
+
+// New returns a stub that debugs d's target.
+func New(d *debugger.Debugger) *Stub {
+	return &Stub{
+		dbg:               d,
+		target:            d.Target(),
+		syms:              d.Symbols(),
+		breakpoints:       make(map[uint16]*debugger.Breakpoint),
+		bankedBreakpoints: make(map[symbols.Address]*debugger.Breakpoint),
+		watchpoints:       make(map[watchpoint]*debugger.Watchpoint),
+	}
+}
+
+// Listen listens for GDB on addr, which is either a TCP address like
+// "localhost:2345" or a Unix socket path prefixed with "unix:".
+func Listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		return net.Listen("unix", strings.TrimPrefix(addr, "unix:"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// resumeMode is what the stub does after handling a packet.
+type resumeMode int
+
+const (
+	stayStopped resumeMode = iota
+	resumeStep
+	resumeContinue
+	endSession
+)
+
+// Serve handles a debugging session on rw, and returns when GDB detaches
+// or disconnects. The target is stopped for the whole session, except while
+// GDB has it stepping or continuing. The breakpoints and watchpoints GDB
+// set are deleted when the session ends.
+func (s *Stub) Serve(rw io.ReadWriter) error {
+	defer s.deleteAll()
+	pc := newPacketConn(rw)
+	packets := make(chan string)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			p, err := pc.readPacket()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case packets <- p:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		var p string
+		select {
+		case p = <-packets:
+		case err := <-errs:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if p == interrupt {
+			// We're already stopped.
+			continue
+		}
+		reply, mode := s.handle(p)
+		switch mode {
+		case endSession:
+			if reply != "" {
+				return pc.writePacket(reply)
+			}
+			return nil
+		case resumeStep, resumeContinue:
+			var err error
+			reply, err = s.resume(mode == resumeStep, packets, errs)
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := pc.writePacket(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a packet from GDB, and returns the reply. An empty reply
+// tells GDB that the packet isn't supported.
+func (s *Stub) handle(p string) (string, resumeMode) {
+	if p == "" {
+		return "", stayStopped
+	}
+	args := p[1:]
+	switch p[0] {
+	case '?':
+		return fmt.Sprintf("S%02x", sigtrap), stayStopped
+	case 'g':
+		return encodeRegisters(s.target.Registers()), stayStopped
+	case 'G':
+		r, err := decodeRegisters(args, s.target.Registers())
+		if err != nil {
+			return "E01", stayStopped
+		}
+		s.target.SetRegisters(r)
+		return "OK", stayStopped
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || int(n) >= numRegisters {
+			return "E01", stayStopped
+		}
+		return encodeRegisters(s.target.Registers())[n*4 : n*4+4], stayStopped
+	case 'P':
+		return s.writeRegister(args), stayStopped
+	case 'm':
+		return s.readMemory(args), stayStopped
+	case 'M':
+		return s.writeMemory(args), stayStopped
+	case 'Z', 'z':
+		return s.setBreakpoint(p[0] == 'Z', args), stayStopped
+	case 's':
+		if args != "" {
+			return "E01", stayStopped
+		}
+		return "", resumeStep
+	case 'c':
+		if args != "" {
+			return "E01", stayStopped
+		}
+		return "", resumeContinue
+	case 'H':
+		// There's only one thread.
+		return "OK", stayStopped
+	case 'D':
+		return "OK", endSession
+	case 'k':
+		return "", endSession
+	case 'q':
+		return s.query(args), stayStopped
+	case 'Q':
+		if p == "QStartNoAckMode" {
+			return "OK", stayStopped
+		}
+	}
+	return "", stayStopped
+}
+
+func (s *Stub) query(q string) string {
+	switch {
+	case strings.HasPrefix(q, "Supported"):
+		return "PacketSize=1000;qXfer:features:read+;QStartNoAckMode+"
+	case q == "Attached":
+		return "1"
+	case q == "C":
+		return "QC1"
+	case q == "fThreadInfo":
+		return "m1"
+	case q == "sThreadInfo":
+		return "l"
+	case strings.HasPrefix(q, "Xfer:features:read:target.xml:"):
+		return readXfer(targetXML, strings.TrimPrefix(q, "Xfer:features:read:target.xml:"))
+	case strings.HasPrefix(q, "Rcmd,"):
+		cmd, err := hex.DecodeString(strings.TrimPrefix(q, "Rcmd,"))
+		if err != nil {
+			return "E01"
+		}
+		out := s.monitor(string(cmd))
+		if out == "" {
+			return "OK"
+		}
+		return hex.EncodeToString([]byte(out))
+	}
+	return ""
+}
+
+// readXfer returns the part of doc asked for by a qXfer packet, whose
+// arguments are offset,length in hex.
+func readXfer(doc, args string) string {
+	parts := strings.Split(args, ",")
+	if len(parts) != 2 {
+		return "E01"
+	}
+	off, err1 := strconv.ParseUint(parts[0], 16, 32)
+	n, err2 := strconv.ParseUint(parts[1], 16, 32)
+	if err1 != nil || err2 != nil {
+		return "E01"
+	}
+	if off >= uint64(len(doc)) {
+		return "l"
+	}
+	if off+n >= uint64(len(doc)) {
+		return "l" + doc[off:]
+	}
+	return "m" + doc[off:off+n]
+}
+
+// resume steps or continues the target until it stops, and returns the
+// stop reply. It returns early if GDB sends a Ctrl-C or disconnects.
+func (s *Stub) resume(step bool, packets <-chan string, errs <-chan error) (string, error) {
+	if step {
+		return stopReply(s.dbg.Step(1)), nil
+	}
+	running := make(chan struct{})
+	watched := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case p := <-packets:
+				// The only packet GDB sends while the target is running
+				// is an interrupt.
+				if p == interrupt {
+					s.dbg.Interrupt()
+				}
+			case err := <-errs:
+				s.dbg.Interrupt()
+				watched <- err
+				return
+			case <-running:
+				watched <- nil
+				return
+			}
+		}
+	}()
+	stop := s.dbg.Continue()
+	close(running)
+	if err := <-watched; err != nil {
+		return "", err
+	}
+	return stopReply(stop), nil
+}
+
+// stopReply returns the reply telling GDB why the target stopped.
+func stopReply(stop debugger.Stop) string {
+	switch stop.Reason {
+	case debugger.StopInterrupt:
+		return fmt.Sprintf("S%02x", sigint)
+	case debugger.StopWatchpoint:
+		return fmt.Sprintf("T%02x%s:%x;", sigtrap, stopReason(stop.Watchpoint.Kind), stop.Access.Addr)
+	}
+	return fmt.Sprintf("S%02x", sigtrap)
+}
+
+// address returns the bank-qualified address of addr.
+func (s *Stub) address(addr uint16) symbols.Address {
+	return s.dbg.Address(addr)
+}
+
+// parseAddrLen parses the "addr,length" arguments of memory packets.
+func parseAddrLen(args string) (addr uint16, n int, rest string, ok bool) {
+	comma := strings.IndexByte(args, ',')
+	if comma < 0 {
+		return 0, 0, "", false
+	}
+	end := strings.IndexAny(args, ":;")
+	if end < 0 {
+		end = len(args)
+	} else {
+		rest = args[end+1:]
+	}
+	a, err1 := strconv.ParseUint(args[:comma], 16, 16)
+	l, err2 := strconv.ParseUint(args[comma+1:end], 16, 32)
+	if err1 != nil || err2 != nil || a+l > 0x10000 {
+		return 0, 0, "", false
+	}
+	return uint16(a), int(l), rest, true
+}
+
+func (s *Stub) readMemory(args string) string {
+	addr, n, _, ok := parseAddrLen(args)
+	if !ok {
+		return "E01"
+	}
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%02x", s.target.ReadMemory(addr+uint16(i)))
+	}
+	return b.String()
+}
+
+func (s *Stub) writeMemory(args string) string {
+	addr, n, data, ok := parseAddrLen(args)
+	if !ok {
+		return "E01"
+	}
+	bytes, err := hex.DecodeString(data)
+	if err != nil || len(bytes) != n {
+		return "E01"
+	}
+	for i, b := range bytes {
+		s.target.WriteMemory(addr+uint16(i), b)
+	}
+	return "OK"
+}
+
+// setBreakpoint handles Z (insert) and z (remove) packets, whose arguments
+// are type,addr,kind. For watchpoints, kind is the number of bytes watched.
+func (s *Stub) setBreakpoint(insert bool, args string) string {
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 {
+		return "E01"
+	}
+	typ, err1 := strconv.ParseUint(parts[0], 16, 8)
+	addr, err2 := strconv.ParseUint(parts[1], 16, 16)
+	kind, err3 := strconv.ParseUint(parts[2], 16, 16)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "E01"
+	}
+	switch typ {
+	case 0, 1:
+		// Software and hardware breakpoints are the same to us.
+		s.setAnyBankBreakpoint(insert, uint16(addr))
+	case 2, 3, 4:
+		if insert {
+			s.addWatchpoint(watchKind(typ), uint16(addr), uint16(kind))
+		} else {
+			s.removeWatchpoint(watchKind(typ), uint16(addr), uint16(kind))
+		}
+	default:
+		return ""
+	}
+	return "OK"
+}
+
+// setAnyBankBreakpoint inserts or removes a breakpoint at addr that stops
+// whichever bank is mapped.
+func (s *Stub) setAnyBankBreakpoint(insert bool, addr uint16) {
+	bp, ok := s.breakpoints[addr]
+	switch {
+	case insert && !ok:
+		s.breakpoints[addr] = s.dbg.AddBreakpoint(s.address(addr), true, nil)
+	case !insert && ok:
+		s.dbg.Delete(bp.ID)
+		delete(s.breakpoints, addr)
+	}
+}
+
+// deleteAll deletes the breakpoints and watchpoints GDB set.
+func (s *Stub) deleteAll() {
+	for addr, bp := range s.breakpoints {
+		s.dbg.Delete(bp.ID)
+		delete(s.breakpoints, addr)
+	}
+	for a, bp := range s.bankedBreakpoints {
+		s.dbg.Delete(bp.ID)
+		delete(s.bankedBreakpoints, a)
+	}
+	for k, w := range s.watchpoints {
+		s.dbg.Delete(w.ID)
+		delete(s.watchpoints, k)
+	}
+}
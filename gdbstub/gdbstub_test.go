@@ -0,0 +1,231 @@
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/debugger"
+	"github.com/mpingram/gameboy-emu/internal/debugtest"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// newTestStub returns a stub for a CPU about to run:
+//
+//	$0100: ld a, $42
+//	$0102: ld [$C000], a
+//	$0105: nop
+//	$0106: jr $0105
+func newTestStub(t *testing.T) (*Stub, *debugtest.Target) {
+	syms, err := symbols.Load(strings.NewReader("00:0100 Main\n00:0105 Main.loop\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := debugtest.New(map[uint16][]byte{0x0100: {0x3E, 0x42, 0xEA, 0x00, 0xC0, 0x00, 0x18, 0xFD}})
+	return New(debugger.New(target, syms)), target
+}
+
+func TestStub_handle(t *testing.T) {
+	s, target := newTestStub(t)
+	target.CPU.A, target.CPU.F = 0x01, 0xB0
+	target.CPU.SP = 0xFFFE
+
+	tests := []struct {
+		packet string
+		want   string
+	}{
+		{"?", "S05"},
+		{"g", "b001" + "0000" + "0000" + "0000" + "feff" + "0001"},
+		{"p5", "0001"},
+		{"p6", "E01"},
+		{"m100,3", "3e42ea"},
+		{"m10000,1", "E01"},
+		{"Mc000,2:abcd", "OK"},
+		{"mc000,2", "abcd"},
+		{"P1=3412", "OK"},
+		{"p1", "3412"},
+		{"Z0,105,1", "OK"},
+		{"z0,105,1", "OK"},
+		{"Z9,105,1", ""},
+		{"qAttached", "1"},
+		{"vMustReplyEmpty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.packet, func(t *testing.T) {
+			if got, _ := s.handle(tt.packet); got != tt.want {
+				t.Errorf("handle(%q) = %q, want %q", tt.packet, got, tt.want)
+			}
+		})
+	}
+	if target.CPU.B != 0x12 || target.CPU.C != 0x34 {
+		t.Errorf("After P1=3412, BC = %02X%02X, want 1234", target.CPU.B, target.CPU.C)
+	}
+}
+
+func TestReadXfer(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"0,4", "mabcd"},
+		{"4,4", "lef"},
+		{"6,4", "l"},
+		{"nonsense", "E01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			if got := readXfer("abcdef", tt.args); got != tt.want {
+				t.Errorf("readXfer(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	for _, s := range []string{"plain", "a#b$c}d*e"} {
+		if got := unescape(escape(s)); got != s {
+			t.Errorf("unescape(escape(%q)) = %q", s, got)
+		}
+	}
+}
+
+// testClient is the GDB end of a connection.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// send sends a packet, and returns the reply.
+func (c *testClient) send(data string) string {
+	c.t.Helper()
+	c.sendNoReply(data)
+	return c.reply()
+}
+
+// sendNoReply sends a packet and waits for it to be acknowledged.
+func (c *testClient) sendNoReply(data string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", data, checksum(data)); err != nil {
+		c.t.Fatal(err)
+	}
+	if b, err := c.r.ReadByte(); err != nil || b != '+' {
+		c.t.Fatalf("Expected an acknowledgement, got %q, %v", b, err)
+	}
+}
+
+func (c *testClient) reply() string {
+	c.t.Helper()
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if b == '$' {
+			break
+		}
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	sum := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, sum); err != nil {
+		c.t.Fatal(err)
+	}
+	data = strings.TrimSuffix(data, "#")
+	if fmt.Sprintf("%02x", checksum(data)) != string(sum) {
+		c.t.Fatalf("Bad checksum on reply %q", data)
+	}
+	// The stub may already have hung up after a reply to D, so errors
+	// acknowledging the reply are ignored.
+	c.conn.Write([]byte("+"))
+	return unescape(data)
+}
+
+func TestStub_Serve(t *testing.T) {
+	s, target := newTestStub(t)
+	stubConn, gdbConn := net.Pipe()
+	defer gdbConn.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(stubConn)
+		stubConn.Close()
+	}()
+	c := &testClient{t, gdbConn, bufio.NewReader(gdbConn)}
+
+	if got := c.send("qSupported:swbreak+"); !strings.Contains(got, "qXfer:features:read+") {
+		t.Errorf("qSupported reply %q doesn't offer target.xml", got)
+	}
+	if got := c.send("qXfer:features:read:target.xml:0,1000"); !strings.Contains(got, `<reg name="pc"`) {
+		t.Errorf("target.xml doesn't describe PC: %q", got)
+	}
+
+	// The write watchpoint stops right after the store.
+	c.send("Z2,c000,1")
+	if got, want := c.send("c"), "T05watch:c000;"; got != want {
+		t.Errorf("After continuing to watchpoint, got %q, want %q", got, want)
+	}
+	if target.CPU.PC != 0x0105 {
+		t.Errorf("Stopped at PC=$%04X, want $0105", target.CPU.PC)
+	}
+	c.send("z2,c000,1")
+
+	if got, want := c.send("s"), "S05"; got != want {
+		t.Errorf("After step, got %q, want %q", got, want)
+	}
+
+	// The program loops forever, so only a Ctrl-C stops it.
+	c.sendNoReply("c")
+	if _, err := gdbConn.Write([]byte(interrupt)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.reply(), "S02"; got != want {
+		t.Errorf("After interrupting, got %q, want %q", got, want)
+	}
+
+	out, err := hex.DecodeString(c.send("qRcmd," + hex.EncodeToString([]byte("where"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); !strings.HasPrefix(got, "PC is at $00:010") {
+		t.Errorf("monitor where = %q, want the PC in the loop", got)
+	}
+
+	// Breakpoints are the debugger's, so they're gone once GDB detaches,
+	// even if GDB didn't remove them.
+	c.send("Z0,105,1")
+	if got := len(s.dbg.Breakpoints()); got != 1 {
+		t.Errorf("The debugger has %d breakpoints while GDB has one set", got)
+	}
+	if got := c.send("D"); got != "OK" {
+		t.Errorf("Detach reply = %q, want OK", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+	if got := len(s.dbg.Breakpoints()); got != 0 {
+		t.Errorf("The debugger has %d breakpoints after GDB detached, want none", got)
+	}
+}
+
+func TestStub_monitorBreak(t *testing.T) {
+	s, target := newTestStub(t)
+	if got, want := s.monitor("break Main.loop"), "Breakpoint at $00:0105 <Main.loop>\n"; got != want {
+		t.Errorf("monitor break = %q, want %q", got, want)
+	}
+	got, err := s.resume(false, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "S05" || target.CPU.PC != 0x0105 {
+		t.Errorf("resume() = %q at PC=$%04X, want S05 at $0105", got, target.CPU.PC)
+	}
+	if got, want := s.monitor("delete 00:0105"), "Deleted breakpoint at $00:0105 <Main.loop>\n"; got != want {
+		t.Errorf("monitor delete = %q, want %q", got, want)
+	}
+}
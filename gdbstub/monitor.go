@@ -0,0 +1,100 @@
+package gdbstub
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+const monitorHelp = `Commands:
+  where                 show the bank-qualified PC, and the label it's in
+  bank [addr]           show the banks currently mapped, or the bank-qualified form of addr
+  break <bank:addr|label>
+                        stop at addr only when its bank is mapped
+  delete <bank:addr|label>
+                        delete a breakpoint set with "break"
+  breakpoints           list breakpoints set with "break"
+`
+
+// monitor runs a "monitor" command from GDB, and returns its output.
+func (s *Stub) monitor(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return monitorHelp
+	}
+	switch fields[0] {
+	case "help":
+		return monitorHelp
+	case "where":
+		return fmt.Sprintf("PC is at %s\n", s.syms.Format(s.address(s.target.Registers().PC)))
+	case "bank":
+		if len(fields) == 1 {
+			return fmt.Sprintf("ROM bank %d is mapped at $4000-$7FFF\nWRAM bank %d is mapped at $D000-$DFFF\n",
+				s.target.BankAt(0x4000), s.target.BankAt(0xD000))
+		}
+		a, err := symbols.ParseAddress(fields[1])
+		if err != nil {
+			return fmt.Sprintf("%v\n", err)
+		}
+		return s.syms.Format(s.address(a.Addr)) + "\n"
+	case "break", "delete":
+		if len(fields) != 2 {
+			return fmt.Sprintf("usage: %s <bank:addr|label>\n", fields[0])
+		}
+		a, err := s.parseLocation(fields[1])
+		if err != nil {
+			return fmt.Sprintf("%v\n", err)
+		}
+		bp, ok := s.bankedBreakpoints[a]
+		if fields[0] == "delete" {
+			if !ok {
+				return fmt.Sprintf("No breakpoint at %s\n", s.syms.Format(a))
+			}
+			s.dbg.Delete(bp.ID)
+			delete(s.bankedBreakpoints, a)
+			return fmt.Sprintf("Deleted breakpoint at %s\n", s.syms.Format(a))
+		}
+		if !ok {
+			s.bankedBreakpoints[a] = s.dbg.AddBreakpoint(a, false, nil)
+		}
+		return fmt.Sprintf("Breakpoint at %s\n", s.syms.Format(a))
+	case "breakpoints":
+		if len(s.bankedBreakpoints) == 0 {
+			return "No breakpoints set with \"monitor break\"\n"
+		}
+		addrs := make([]symbols.Address, 0, len(s.bankedBreakpoints))
+		for a := range s.bankedBreakpoints {
+			addrs = append(addrs, a)
+		}
+		sort.Slice(addrs, func(i, j int) bool {
+			if addrs[i].Bank != addrs[j].Bank {
+				return addrs[i].Bank < addrs[j].Bank
+			}
+			return addrs[i].Addr < addrs[j].Addr
+		})
+		var b strings.Builder
+		for _, a := range addrs {
+			fmt.Fprintln(&b, s.syms.Format(a))
+		}
+		return b.String()
+	}
+	return fmt.Sprintf("Unknown command %q\n%s", fields[0], monitorHelp)
+}
+
+// parseLocation parses a label name or a bank-qualified address. If the
+// bank is left out, the bank currently mapped at the address is used.
+func (s *Stub) parseLocation(loc string) (symbols.Address, error) {
+	if a, ok := s.syms.Lookup(loc); ok {
+		return a, nil
+	}
+	a, err := symbols.ParseAddress(loc)
+	if err != nil {
+		return symbols.Address{}, fmt.Errorf("%q is not an address or a known label", loc)
+	}
+	if !strings.Contains(loc, ":") {
+		a = s.address(a.Addr)
+	}
+	return a, nil
+}
@@ -0,0 +1,126 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// interrupt is sent by GDB outside of a packet when the user presses
+// Ctrl-C. readPacket returns it as a packet of its own.
+const interrupt = "\x03"
+
+// packetConn reads and writes RSP packets, which look like $data#checksum.
+type packetConn struct {
+	r *bufio.Reader
+	w io.Writer
+	// noAck is set once GDB asks for QStartNoAckMode, after which neither
+	// side acknowledges packets with + or -. It's only used by the
+	// goroutine reading packets.
+	noAck bool
+}
+
+func newPacketConn(rw io.ReadWriter) *packetConn {
+	return &packetConn{r: bufio.NewReader(rw), w: rw}
+}
+
+// readPacket returns the data of the next packet, or interrupt if GDB sent
+// a Ctrl-C. Acknowledgements from GDB are skipped: we never need to resend
+// a packet over a reliable connection.
+func (pc *packetConn) readPacket() (string, error) {
+	for {
+		b, err := pc.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '+', '-':
+			continue
+		case interrupt[0]:
+			return interrupt, nil
+		case '$':
+		default:
+			// Junk between packets.
+			continue
+		}
+		data, err := pc.r.ReadString('#')
+		if err != nil {
+			return "", err
+		}
+		data = strings.TrimSuffix(data, "#")
+		var sum [2]byte
+		if _, err := io.ReadFull(pc.r, sum[:]); err != nil {
+			return "", err
+		}
+		if fmt.Sprintf("%02x", checksum(data)) != strings.ToLower(string(sum[:])) {
+			if !pc.noAck {
+				if _, err := pc.w.Write([]byte("-")); err != nil {
+					return "", err
+				}
+			}
+			continue
+		}
+		if !pc.noAck {
+			if _, err := pc.w.Write([]byte("+")); err != nil {
+				return "", err
+			}
+		}
+		// GDB doesn't send anything else until it has our reply, so it's
+		// safe to stop acknowledging packets now.
+		if data == "QStartNoAckMode" {
+			pc.noAck = true
+		}
+		return unescape(data), nil
+	}
+}
+
+// writePacket sends data as a packet.
+func (pc *packetConn) writePacket(data string) error {
+	data = escape(data)
+	_, err := fmt.Fprintf(pc.w, "$%s#%02x", data, checksum(data))
+	return err
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// escape escapes the characters that can't appear literally in a packet,
+// which are sent as } followed by the character XORed with 0x20.
+func escape(data string) string {
+	if !strings.ContainsAny(data, "#$}*") {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '#', '$', '}', '*':
+			b.WriteByte('}')
+			b.WriteByte(c ^ 0x20)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func unescape(data string) string {
+	if !strings.Contains(data, "}") {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			b.WriteByte(data[i] ^ 0x20)
+			continue
+		}
+		b.WriteByte(data[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package gdbstub
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+)
+
+// The registers are sent to GDB as 16-bit register pairs, little-endian,
+// in the same order as GDB's Z80 target: AF, BC, DE, HL, SP, PC.
+const numRegisters = 6
+
+// targetXML describes the registers to GDB.
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <architecture>gbz80</architecture>
+  <feature name="org.gnu.gdb.z80.cpu">
+    <reg name="af" bitsize="16" type="int"/>
+    <reg name="bc" bitsize="16" type="int"/>
+    <reg name="de" bitsize="16" type="data_ptr"/>
+    <reg name="hl" bitsize="16" type="data_ptr"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+func registerPairs(r cpu.Registers) [numRegisters]uint16 {
+	return [numRegisters]uint16{
+		uint16(r.A)<<8 | uint16(r.F),
+		uint16(r.B)<<8 | uint16(r.C),
+		uint16(r.D)<<8 | uint16(r.E),
+		uint16(r.H)<<8 | uint16(r.L),
+		r.SP,
+		r.PC,
+	}
+}
+
+func setRegisterPair(r *cpu.Registers, n int, v uint16) {
+	hi, lo := byte(v>>8), byte(v)
+	switch n {
+	case 0:
+		r.A, r.F = hi, lo
+	case 1:
+		r.B, r.C = hi, lo
+	case 2:
+		r.D, r.E = hi, lo
+	case 3:
+		r.H, r.L = hi, lo
+	case 4:
+		r.SP = v
+	case 5:
+		r.PC = v
+	}
+}
+
+func encodeRegisters(r cpu.Registers) string {
+	var b strings.Builder
+	for _, v := range registerPairs(r) {
+		fmt.Fprintf(&b, "%02x%02x", byte(v), byte(v>>8))
+	}
+	return b.String()
+}
+
+// decodeRegisters decodes the registers in a G packet. GDB may send fewer
+// registers than we have, in which case the rest are left as they are in r.
+func decodeRegisters(data string, r cpu.Registers) (cpu.Registers, error) {
+	bytes, err := hex.DecodeString(data)
+	if err != nil {
+		return r, err
+	}
+	for n := 0; n < numRegisters && 2*n+1 < len(bytes); n++ {
+		setRegisterPair(&r, n, uint16(bytes[2*n+1])<<8|uint16(bytes[2*n]))
+	}
+	return r, nil
+}
+
+// writeRegister handles a P packet, whose arguments are n=value.
+func (s *Stub) writeRegister(args string) string {
+	parts := strings.Split(args, "=")
+	if len(parts) != 2 {
+		return "E01"
+	}
+	n, err := strconv.ParseUint(parts[0], 16, 8)
+	if err != nil || int(n) >= numRegisters {
+		return "E01"
+	}
+	bytes, err := hex.DecodeString(parts[1])
+	if err != nil || len(bytes) != 2 {
+		return "E01"
+	}
+	r := s.target.Registers()
+	setRegisterPair(&r, int(n), uint16(bytes[1])<<8|uint16(bytes[0]))
+	s.target.SetRegisters(r)
+	return "OK"
+}
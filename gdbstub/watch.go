@@ -0,0 +1,74 @@
+package gdbstub
+
+import "github.com/mpingram/gameboy-emu/debugger"
+
+// watchKind is the kind of memory access a watchpoint stops on. The values
+// match the types of GDB's Z2, Z3 and Z4 packets.
+type watchKind int
+
+const (
+	watchWrite  watchKind = 2
+	watchRead   watchKind = 3
+	watchAccess watchKind = 4
+)
+
+// debuggerKind returns the kinds of memory access the watchpoint stops on.
+func (k watchKind) debuggerKind() debugger.WatchKind {
+	switch k {
+	case watchWrite:
+		return debugger.WatchWrite
+	case watchRead:
+		return debugger.WatchRead
+	default:
+		return debugger.WatchRead | debugger.WatchWrite
+	}
+}
+
+// stopReason returns the name GDB uses for a watchpoint of this kind in
+// stop replies.
+func stopReason(k debugger.WatchKind) string {
+	switch k {
+	case debugger.WatchRead:
+		return "rwatch"
+	case debugger.WatchWrite:
+		return "watch"
+	default:
+		return "awatch"
+	}
+}
+
+// watchpoint is a watchpoint as GDB set it.
+type watchpoint struct {
+	kind  watchKind
+	start uint16
+	len   uint16
+}
+
+// addWatchpoint adds a watchpoint to the debugger, unless GDB already set
+// the same one.
+func (s *Stub) addWatchpoint(kind watchKind, start, n uint16) {
+	if n == 0 {
+		n = 1
+	}
+	key := watchpoint{kind, start, n}
+	if _, ok := s.watchpoints[key]; ok {
+		return
+	}
+	end := start + n - 1
+	if end < start {
+		end = 0xFFFF
+	}
+	s.watchpoints[key] = s.dbg.AddWatchpoint(start, end, kind.debuggerKind())
+}
+
+// removeWatchpoint removes the watchpoint added with the same arguments.
+func (s *Stub) removeWatchpoint(kind watchKind, start, n uint16) {
+	if n == 0 {
+		n = 1
+	}
+	key := watchpoint{kind, start, n}
+	if w, ok := s.watchpoints[key]; ok {
+		s.dbg.Delete(w.ID)
+		delete(s.watchpoints, key)
+	}
+}
@@ -0,0 +1,45 @@
+package headless
+
+import (
+	"fmt"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/savestate"
+)
+
+// Hashes are the hashes of a frame, and of the machine's state at the end
+// of it.
+type Hashes struct {
+	Frame, State uint64
+}
+
+// Run runs a game from power-on for a frame per input, holding down the
+// buttons in input[i] during frame i, and returns the hashes of every
+// frame.
+func Run(opt Options, input []mmu.Buttons) []Hashes {
+	gb := New(opt)
+	hashes := make([]Hashes, len(input))
+	for i, buttons := range input {
+		gb.MMU.SetButtons(buttons)
+		gb.RunFrame()
+		hashes[i] = Hashes{Frame: gb.PPU.FrameHash(), State: savestate.Hash(gb.State())}
+	}
+	return hashes
+}
+
+// Diff returns an error describing the first frame at which two runs'
+// hashes differ, or nil if they're the same.
+func Diff(a, b []Hashes) error {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i].Frame != b[i].Frame:
+			return fmt.Errorf("frame %d differs: its hash is %016x, then %016x", i, a[i].Frame, b[i].Frame)
+		case a[i].State != b[i].State:
+			return fmt.Errorf("the state differs at the end of frame %d: its hash is %016x, then %016x", i, a[i].State, b[i].State)
+		}
+	}
+	if len(a) != len(b) {
+		return fmt.Errorf("one run has %d frames and the other %d", len(a), len(b))
+	}
+	return nil
+}
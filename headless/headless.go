@@ -0,0 +1,156 @@
+// Package headless runs games without a display, a debugger or anything
+// else that depends on the time of day, as fast as possible, so that the
+// same game with the same input always runs the same way. It's for tests
+// and tools, e.g. to check that the emulator is deterministic:
+//
+//	if err := headless.Diff(headless.Run(opt, input), headless.Run(opt, input)); err != nil {
+//		...
+//	}
+//
+// The headlesstest package does that in a test.
+package headless
+
+import (
+	"bytes"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/ppu"
+	"github.com/mpingram/gameboy-emu/savestate"
+)
+
+// Options configures a Machine.
+type Options struct {
+	// ROM is the game.
+	ROM []byte
+	// BootROM is the boot ROM to start with. If it's nil, the machine
+	// starts in the state the boot ROM leaves it in, with the game about to
+	// run from $0100: the DMG's, or the Game Boy Color's for games that
+	// support it (see mmu.MMU.CGB).
+	BootROM []byte
+	// Model chooses whether the game runs in Game Boy Color mode.
+	Model mmu.Model
+}
+
+// Machine is a whole Gameboy.
+type Machine struct {
+	CPU *cpu.CPU
+	MMU *mmu.MMU
+	PPU *ppu.PPU
+
+	// cycles is the number of cycles since the last frame ended.
+	cycles int
+}
+
+// New returns a machine at power-on.
+func New(opt Options) *Machine {
+	mopt := mmu.MMUOptions{GameRom: bytes.NewReader(opt.ROM), Model: opt.Model}
+	if opt.BootROM != nil {
+		mopt.BootRom = bytes.NewReader(opt.BootROM)
+	}
+	m := mmu.New(mopt)
+	gb := &Machine{CPU: cpu.New(m.CPUInterface), MMU: m}
+	m.SetPCFunc(func() uint16 { return gb.CPU.PC })
+	if opt.BootROM == nil {
+		SkipBoot(gb.CPU, m)
+	}
+	// The PPU starts with the LCD on if the boot ROM has been skipped.
+	gb.PPU = ppu.New(m.PPUInterface)
+	return gb
+}
+
+// SkipBoot sets the registers the way the boot ROM leaves them, the DMG's
+// or, in Game Boy Color mode, the CGB's. Games check A to tell which they're
+// running on. It's for machines with no boot ROM, and must be called before
+// the PPU is made, so that it starts with the LCD on.
+// See https://gbdev.io/pandocs/Power_Up_Sequence.html.
+func SkipBoot(c *cpu.CPU, m *mmu.MMU) {
+	c.Registers = cpu.Registers{
+		A: 0x01, F: 0xB0, B: 0x00, C: 0x13, D: 0x00, E: 0xD8, H: 0x01, L: 0x4D,
+		SP: 0xFFFE, PC: 0x0100,
+	}
+	if m.CGB() {
+		c.Registers = cpu.Registers{
+			A: 0x11, F: 0x80, B: 0x00, C: 0x00, D: 0xFF, E: 0x56, H: 0x00, L: 0x0D,
+			SP: 0xFFFE, PC: 0x0100,
+		}
+	}
+	for _, r := range []struct {
+		addr uint16
+		b    byte
+	}{
+		{mmu.AddrLCDC, 0x91},
+		{mmu.AddrBGP, 0xFC},
+		{mmu.AddrOBP0, 0xFF},
+		{mmu.AddrOBP1, 0xFF},
+	} {
+		m.Poke(r.addr, r.b)
+	}
+}
+
+// frameCycles is the length of a frame: 154 scanlines of 456 cycles.
+const frameCycles = 154 * 456
+
+// RunPPU runs the PPU for as long as the CPU took to run for cycles, which
+// is half as long at double speed, and then for as long as HDMA or a speed
+// switch stopped the CPU for, including any HDMA that running the PPU
+// caused. It returns the number of dots the PPU ran for.
+// TODO There's no timer yet, and OAM DMA is instant, so only the PPU's
+// speed relative to the CPU changes at double speed.
+func RunPPU(m *mmu.MMU, p *ppu.PPU, cycles int) int {
+	if m.DoubleSpeed() {
+		cycles /= 2
+	}
+	total := 0
+	for dots := cycles; dots > 0; dots = m.TakeStall() {
+		p.RunFor(dots)
+		total += dots
+	}
+	return total
+}
+
+// Step executes one instruction, and runs the PPU for as long as it took.
+// It returns true if that finished a frame (see RunFor).
+func (gb *Machine) Step() bool {
+	_, cycles := gb.CPU.Step()
+	return gb.RunFor(cycles)
+}
+
+// RunFor runs the PPU for the cycles an instruction took, for machines
+// that execute their instructions some other way, e.g. with a profiler. It
+// returns true if that finished a frame: when the PPU finishes drawing
+// one, or, while the LCD is off, after each frame's worth of cycles.
+func (gb *Machine) RunFor(cycles int) bool {
+	frames := gb.PPU.Frames()
+	gb.cycles += RunPPU(gb.MMU, gb.PPU, cycles)
+	switch {
+	case gb.PPU.Frames() != frames:
+		gb.cycles = 0
+	case gb.cycles >= frameCycles:
+		gb.cycles -= frameCycles
+	default:
+		return false
+	}
+	return true
+}
+
+// RunFrame runs until the end of a frame (see Step).
+func (gb *Machine) RunFrame() {
+	for !gb.Step() {
+	}
+}
+
+// LoadState restores a state saved from State. The next frame ends a whole
+// frame later, or when the PPU finishes drawing one.
+func (gb *Machine) LoadState(state []byte) error {
+	if err := savestate.Load(gb.State(), state); err != nil {
+		return err
+	}
+	gb.cycles = 0
+	return nil
+}
+
+// State returns the parts of the machine that states are saved from.
+func (gb *Machine) State() savestate.Machine {
+	return savestate.Machine{CPU: gb.CPU, MMU: gb.MMU, PPU: gb.PPU}
+}
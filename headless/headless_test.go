@@ -0,0 +1,130 @@
+package headless
+
+import (
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+// testROM returns a ROM that copies the joypad register to VRAM over and
+// over, so that what it draws depends on the input.
+func testROM() []byte {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{0xC3, 0x50, 0x01}) // JP $0150
+	copy(rom[0x150:], []byte{
+		0x3E, 0x10, // LD A,$10 (select the buttons)
+		0xE0, 0x00, // LDH ($00),A
+		0x21, 0x00, 0x80, // start: LD HL,$8000
+		0xF0, 0x00, // loop: LDH A,($00)
+		0x22,       // LD (HL+),A
+		0xCB, 0x6C, // BIT 5,H (until HL reaches $A000)
+		0x28, 0xF9, // JR Z,loop
+		0x18, 0xF4, // JR start
+	})
+	return rom
+}
+
+func testInput(frames int, held mmu.Buttons) []mmu.Buttons {
+	input := make([]mmu.Buttons, frames)
+	for i := range input {
+		if i%3 == 0 {
+			input[i] = held
+		}
+	}
+	return input
+}
+
+func TestDiff(t *testing.T) {
+	opt := Options{ROM: testROM()}
+	a := Run(opt, testInput(20, mmu.ButtonA))
+	tests := []struct {
+		name    string
+		b       []Hashes
+		wantErr bool
+	}{
+		{"same input", Run(opt, testInput(20, mmu.ButtonA)), false},
+		{"different input", Run(opt, testInput(20, mmu.ButtonB)), true},
+		{"fewer frames", Run(opt, testInput(10, mmu.ButtonA)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Diff(a, tt.b); (err != nil) != tt.wantErr {
+				t.Errorf("Diff() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew_CGB(t *testing.T) {
+	tests := []struct {
+		name  string
+		flag  byte
+		model mmu.Model
+		wantA byte
+	}{
+		{"DMG game", 0x00, mmu.ModelAuto, 0x01},
+		{"CGB game", 0x80, mmu.ModelAuto, 0x11},
+		{"CGB game on a DMG", 0x80, mmu.ModelDMG, 0x01},
+		{"DMG game on a CGB", 0x00, mmu.ModelCGB, 0x11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rom := testROM()
+			rom[0x0143] = tt.flag
+			gb := New(Options{ROM: rom, Model: tt.model})
+			if gb.CPU.A != tt.wantA {
+				t.Errorf("A = $%02X, want $%02X", gb.CPU.A, tt.wantA)
+			}
+			var cgb bool
+			gb.PPU.AddSink(ppu.FrameSinkFunc(func(f *ppu.Frame) { cgb = f.CGB }))
+			gb.RunFrame()
+			if want := tt.wantA == 0x11; cgb != want {
+				t.Errorf("Frame's CGB is %v, want %v", cgb, want)
+			}
+		})
+	}
+}
+
+func TestRunPPU(t *testing.T) {
+	tests := []struct {
+		name        string
+		doubleSpeed bool
+		// switching is whether the instruction run switched to double
+		// speed, rather than the machine being at double speed already.
+		switching bool
+		// hdma is whether a two-block general-purpose HDMA runs first.
+		hdma     bool
+		cycles   int
+		wantDots int
+	}{
+		{"normal speed", false, false, false, 8, 8},
+		{"double speed", true, false, false, 8, 4},
+		{"switching to double speed", true, true, false, 8, 4 + 8200},
+		{"general-purpose HDMA", false, false, true, 8, 8 + 2*32},
+		{"general-purpose HDMA at double speed", true, false, true, 8, 4 + 2*32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gb := New(Options{ROM: testROM(), Model: mmu.ModelCGB})
+			if tt.doubleSpeed {
+				gb.MMU.Poke(mmu.AddrKEY1, 1)
+				gb.MMU.SwitchSpeed()
+				if !tt.switching {
+					gb.MMU.TakeStall()
+				}
+			}
+			if tt.hdma {
+				gb.MMU.Poke(mmu.AddrHDMA5, 0x01)
+			}
+			cycles := gb.PPU.State().Cycles
+			if got := RunPPU(gb.MMU, gb.PPU, tt.cycles); got != tt.wantDots {
+				t.Errorf("RunPPU() = %d, want %d", got, tt.wantDots)
+			}
+			// The PPU's count of dots starts again on each line.
+			if got, want := (gb.PPU.State().Cycles-cycles+456)%456, tt.wantDots%456; got != want {
+				t.Errorf("PPU ran for %d dots into the line, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+// Package headlesstest checks games run by the headless package in tests:
+//
+//	func TestDeterminism(t *testing.T) {
+//		headlesstest.CheckDeterminism(t, headless.Options{ROM: rom}, input)
+//	}
+package headlesstest
+
+import (
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/headless"
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// CheckDeterminism runs a game twice from power-on with the same input (see
+// headless.Run), and fails the test if the runs differ at all.
+func CheckDeterminism(t testing.TB, opt headless.Options, input []mmu.Buttons) {
+	t.Helper()
+	if err := headless.Diff(headless.Run(opt, input), headless.Run(opt, input)); err != nil {
+		t.Fatalf("The game didn't run the same way twice: %v", err)
+	}
+}
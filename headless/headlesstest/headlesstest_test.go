@@ -0,0 +1,19 @@
+package headlesstest
+
+import (
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/headless"
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+func TestCheckDeterminism(t *testing.T) {
+	// A game that reads the joypad over and over.
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], []byte{
+		0xF0, 0x00, // LDH A,($00)
+		0x18, 0xFC, // JR -4
+	})
+	input := []mmu.Buttons{0, mmu.ButtonA, mmu.ButtonA | mmu.ButtonStart, 0}
+	CheckDeterminism(t, headless.Options{ROM: rom}, input)
+}
@@ -0,0 +1,48 @@
+// Package debugtest provides a machine for the tests of the packages that
+// debug one: the debugger, the GDB stub and the Debug Adapter Protocol
+// server.
+package debugtest
+
+import (
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/ppu"
+	"github.com/mpingram/gameboy-emu/vramview"
+)
+
+// Target runs a real CPU on a real MMU with no cartridge. It has a PPU for
+// saving states and setting debug options, which isn't run.
+type Target struct {
+	CPU *cpu.CPU
+	MMU *mmu.MMU
+	PPU *ppu.PPU
+}
+
+// New returns a target with the code at each address in code, about to
+// run from $0100 with SP at $FFFE, and tracking calls.
+func New(code map[uint16][]byte) *Target {
+	m := mmu.New(mmu.MMUOptions{})
+	for addr, b := range code {
+		copy(m.Mem[addr:], b)
+	}
+	t := &Target{CPU: cpu.New(m.CPUInterface), MMU: m, PPU: ppu.New(m.PPUInterface)}
+	t.CPU.TrackCalls(m.BankAt)
+	m.SetPCFunc(func() uint16 { return t.CPU.PC })
+	t.CPU.PC, t.CPU.SP = 0x0100, 0xFFFE
+	return t
+}
+
+func (t *Target) Registers() cpu.Registers        { return t.CPU.Registers }
+func (t *Target) SetRegisters(r cpu.Registers)    { t.CPU.Registers = r }
+func (t *Target) ReadMemory(addr uint16) byte     { return t.MMU.Peek(addr) }
+func (t *Target) WriteMemory(addr uint16, b byte) { t.MMU.Poke(addr, b) }
+func (t *Target) AddHook(start, end uint16, kinds mmu.AccessKind, fn mmu.Hook) mmu.HookID {
+	return t.MMU.AddHook(start, end, kinds, fn)
+}
+func (t *Target) RemoveHook(id mmu.HookID)           { t.MMU.RemoveHook(id) }
+func (t *Target) Step()                              { t.CPU.Step() }
+func (t *Target) BankAt(addr uint16) int             { return t.MMU.BankAt(addr) }
+func (t *Target) CallStack() []cpu.Frame             { return t.CPU.CallStack() }
+func (t *Target) ColorMemory() vramview.ColorMemory  { return t.MMU }
+func (t *Target) DebugOptions() ppu.DebugOptions     { return t.PPU.DebugOptions() }
+func (t *Target) SetDebugOptions(o ppu.DebugOptions) { t.PPU.SetDebugOptions(o) }
@@ -2,121 +2,727 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/mpingram/gameboy-emu/coverage"
 	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/dap"
+	"github.com/mpingram/gameboy-emu/debugger"
 	frontend "github.com/mpingram/gameboy-emu/frontend/opengl"
+	"github.com/mpingram/gameboy-emu/gdbstub"
+	"github.com/mpingram/gameboy-emu/headless"
 	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/movie"
+	"github.com/mpingram/gameboy-emu/palette"
 	"github.com/mpingram/gameboy-emu/ppu"
+	"github.com/mpingram/gameboy-emu/profiler"
+	"github.com/mpingram/gameboy-emu/rewind"
+	"github.com/mpingram/gameboy-emu/savestate"
+	"github.com/mpingram/gameboy-emu/symbols"
+	"github.com/mpingram/gameboy-emu/video"
+	"github.com/mpingram/gameboy-emu/vramview"
 )
 
 func main() {
+	gdbAddr := flag.String("gdb", "", "wait for GDB to connect on this address (host:port, or unix:/path/to/socket) instead of starting the REPL")
+	dapAddr := flag.String("dap", "", "wait for an editor to connect with the Debug Adapter Protocol on this address (host:port, or stdio) instead of starting the REPL")
+	profile := flag.String("profile", "", "profile the game, and on exit write a report to this file, and folded stacks for flame graphs to the same file with .folded appended")
+	coveragePath := flag.String("coverage", "", "record which parts of the ROM and RAM the game uses, adding to the coverage bitmap in this file if it exists, and on exit write the bitmap, a summary to the same file with .txt appended, and a map to the same file with .png appended")
+	rewindMiB := flag.Int("rewind", 16, "keep up to this many MiB of history for rewinding in the debugger, or 0 to keep none; there's no rewinding while recording or playing a movie")
+	statePath := flag.String("state", "", "start from a state saved with the debugger's save command")
+	recordPath := flag.String("record", "", "record the joypad input to a movie in this file, written on exit")
+	playPath := flag.String("play", "", "play back the joypad input from a movie in this file, or from a BizHawk .bk2 movie")
+	paletteName := flag.String("palette", "gray", "colors to draw the screen in: a preset (gray, dmg, pocket or light), four hex colors from lightest to darkest (e.g. \"e0f8d0 88c070 346856 081820\"), or a palette file (see the palette package)")
+	videoPath := flag.String("video", "", "record the screen to a video in this file, written on exit: an animated .gif or .png, or uncompressed .y4m for an encoder such as ffmpeg")
+	videoSkip := flag.Int("video-skip", 0, "skip this many frames after each one recorded with -video, e.g. 1 for half the frame rate, which suits GIFs better")
+	modelName := flag.String("model", "auto", "Game Boy to emulate: auto runs games that support the Game Boy Color in color and others as on a DMG, dmg runs every game as on a DMG, and cgb every game in Game Boy Color mode")
+	srcDir := flag.String("src", "", "directory of the game's RGBDS source, for -dap (default the ROM's directory)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] game.gb [breakpoint]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// Debug Adapter Protocol messages go to stdout, so everything else has
+	// to go somewhere else.
+	dapOut := os.Stdout
+	if *dapAddr == "stdio" {
+		os.Stdout = os.Stderr
+	}
 
-	bootRomFileLocation := "./roms/boot/DMG_ROM.gb"
-	bootRom, err := os.Open(bootRomFileLocation)
+	pal, err := palette.Load(*paletteName)
 	if err != nil {
-		panic(err)
+		fmt.Printf("ERR: Failed to load palette: %v\n", err)
+		return
 	}
-	gameRomFileLocation := os.Args[1]
-	gameRom, err := os.Open(gameRomFileLocation)
+	frontend.SetPalette(pal)
+
+	gameRomFileLocation := flag.Arg(0)
+	gameRom, err := ioutil.ReadFile(gameRomFileLocation)
 	if err != nil {
 		panic(err)
 	}
+	model, err := mmu.ParseModel(*modelName)
+	if err != nil {
+		fmt.Printf("ERR: %v\n", err)
+		return
+	}
+	// Movies are played back on the Game Boy they were recorded on, started
+	// the same way.
+	var mv *movie.Movie
+	if *playPath != "" {
+		if mv, err = movie.ReadFile(*playPath); err != nil {
+			fmt.Printf("ERR: Failed to read movie: %v\n", err)
+			return
+		}
+		if model, err = mv.PlaybackModel(model); err != nil {
+			fmt.Printf("ERR: %v\n", err)
+			return
+		}
+	}
+	opt := headless.Options{ROM: gameRom, Model: model}
+	// Games booted with the DMG's boot ROM run as they would on a DMG, so
+	// games that run in Game Boy Color mode skip it.
+	boot := !model.CGB(gameRom)
+	if mv != nil && mv.Model != mmu.ModelAuto {
+		boot = mv.Boot
+	}
+	if boot {
+		bootRomFileLocation := "./roms/boot/DMG_ROM.gb"
+		if opt.BootROM, err = ioutil.ReadFile(bootRomFileLocation); err != nil {
+			panic(err)
+		}
+	}
 
-	var breakpointEnabled bool
-	var breakpoint int64
-	if len(os.Args) > 2 {
-		breakpointInput := os.Args[2]
-		breakpoint, err = strconv.ParseInt(breakpointInput, 0, 0)
+	gb := &machine{Machine: headless.New(opt)}
+	m, p := gb.MMU, gb.PPU
+	frontend.SetLayerToggles(p)
+	// The display only needs to keep up, so it drops frames it's too slow
+	// for.
+	display := ppu.NewChannelSink(1, false)
+	p.AddSink(display)
+
+	// If there's a symbol file next to the ROM (e.g. game.sym for game.gb),
+	// addresses are shown with the names of their labels, and breakpoints
+	// can be set by label.
+	syms, err := symbols.ForROM(gameRomFileLocation)
+	if err != nil {
+		fmt.Printf("ERR: Failed to load symbols: %v\n", err)
+		return
+	}
+	if syms != nil {
+		fmt.Printf("Loaded %d symbols\n", syms.Len())
+	}
+
+	gb.CPU.TrackCalls(m.BankAt)
+	if *profile != "" {
+		gb.prof = profiler.New(gb.CPU, syms, m.BankAt)
+		gb.profilePath = *profile
+	}
+	if *coveragePath != "" {
+		cov, err := loadCoverage(*coveragePath)
 		if err != nil {
-			if breakpointInput != "" {
-				fmt.Printf("ERR: Failed to parse breakpoint: %v\n", breakpointInput)
-				return
-			}
-			breakpointEnabled = false
-		} else {
-			breakpointEnabled = true
+			fmt.Printf("ERR: Failed to load coverage: %v\n", err)
+			return
+		}
+		gb.cov = coverage.New(m, cov)
+		gb.coveragePath = *coveragePath
+	}
+	if err := gb.startMovie(gameRom, boot, *statePath, *recordPath, mv); err != nil {
+		fmt.Printf("ERR: %v\n", err)
+		return
+	}
+	if *videoPath != "" {
+		if err := gb.startVideo(*videoPath, video.Options{Palette: pal, Skip: *videoSkip}); err != nil {
+			fmt.Printf("ERR: Failed to start recording video: %v\n", err)
+			return
+		}
+	}
+	if *rewindMiB > 0 && gb.rec == nil && gb.player == nil {
+		gb.rw = rewind.New(history{gb}, rewind.Options{MaxBytes: *rewindMiB << 20})
+	}
+	// Closing the window or being asked to terminate exits the same way as
+	// quitting the debugger, writing everything that's written on exit.
+	terms := make(chan os.Signal, 1)
+	signal.Notify(terms, syscall.SIGTERM)
+	go func() {
+		<-terms
+		gb.quit()
+	}()
+	dbg := debugger.New(gb, syms)
+	if *gdbAddr != "" {
+		stub := gdbstub.New(dbg)
+		go serveGDB(stub, dbg, gb, *gdbAddr)
+		frontend.ConnectVideo(display)
+		gb.quit()
+		return
+	}
+	if *dapAddr != "" {
+		dir := *srcDir
+		if dir == "" {
+			dir = filepath.Dir(gameRomFileLocation)
 		}
+		go serveDAP(dbg, gb, gameRomFileLocation, dir, *dapAddr, dapOut)
+		frontend.ConnectVideo(display)
+		gb.quit()
+		return
 	}
 
-	m := mmu.New(mmu.MMUOptions{BootRom: bootRom, GameRom: gameRom})
-	if err != nil {
-		panic(err)
+	if flag.NArg() > 1 && flag.Arg(1) != "" {
+		a, anyBank, err := dbg.ParseLocation(flag.Arg(1))
+		if err != nil {
+			fmt.Printf("ERR: Failed to parse breakpoint: %v\n", err)
+			return
+		}
+		dbg.AddBreakpoint(a, anyBank, nil)
 	}
-	p := ppu.New(m.PPUInterface)
-	c := cpu.New(m.CPUInterface)
 
-	cpuClock := time.NewTicker(time.Nanosecond)
-	defer cpuClock.Stop()
-	paused := false
 	// cpu goroutine
+	go runREPL(dbg, gb)
+
+	frontend.ConnectVideo(display)
+	gb.quit()
+}
+
+// runREPL runs the game under the debugger's REPL until the user quits or
+// its input ends, and then exits.
+func runREPL(dbg *debugger.Debugger, gb *machine) {
+	// Ctrl-C stops the game and drops into the debugger, rather than
+	// exiting.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
 	go func() {
-		var instr cpu.Instruction
-		for {
-			<-cpuClock.C
-			if paused {
-				fmt.Print("> ")
-				command := waitForInput()
-				if command == "p\n" || command == "print\n" {
-					fmt.Println(printCPUState(c))
-				} else if command == "m\n" || command == "memdump\n" {
-					memdump, err := os.Create("dumps/memdump.bin")
-					defer memdump.Close()
-					if err != nil {
-						panic(err)
-					}
-					// dump memory to file
-					m.Dump(memdump)
-				} else if command == "c\n" || command == "continue\n" {
-					paused = false
-				} else if command == "q\n" || command == "quit\n" {
-					break
-				} else {
-					pc := c.PC
-					instr, _ = c.Step()
-					fmt.Printf("($%04x)\t%s\n", pc, instr.String())
-					fmt.Printf("c.PC is now: %04x\n", c.PC)
-				}
-			} else {
-				pc := c.PC
-				instr, cycles := c.Step()
-				p.RunFor(cycles)
-				if breakpointEnabled && int64(pc) == breakpoint {
-					paused = true
-					fmt.Println("HALTED after executing")
-					fmt.Printf("($%04x)\t%s\n", pc, instr.String())
-				}
-			}
+		for range interrupts {
+			dbg.Interrupt()
 		}
 	}()
+	repl := debugger.NewREPL(dbg, os.Stdin, os.Stdout)
+	repl.Exec("continue")
+	repl.Run()
+	gb.quit()
+}
+
+// machine is the whole Gameboy, as seen by the debugger and the GDB stub.
+type machine struct {
+	// Machine decides where frames end, the same way as for gbheadless, so
+	// that movies recorded with one play back the same with the other.
+	*headless.Machine
+
+	// mu is held while the machine executes an instruction, so that quit
+	// can stop it from any goroutine.
+	mu sync.Mutex
+
+	// prof, if not nil, profiles the CPU, and is written to profilePath on
+	// exit.
+	prof        *profiler.Profiler
+	profilePath string
+
+	// rw, if not nil, keeps a history of the machine to rewind through.
+	rw *rewind.Buffer
+	// rec, if not nil, records a movie, which is written to recordPath on
+	// exit. player, if not nil, plays one.
+	rec        *movie.Recorder
+	recordPath string
+	player     *movie.Player
+	// state is reused for hashing the state.
+	state []byte
+
+	// cov, if not nil, records coverage, which is written to coveragePath
+	// on exit.
+	cov          *coverage.Recorder
+	coveragePath string
+
+	// vid, if not nil, records video to videoFile, which is finished on
+	// exit.
+	vid       *video.Recorder
+	videoFile *os.File
+	videoBuf  *bufio.Writer
+}
+
+func (gb *machine) Registers() cpu.Registers {
+	return gb.CPU.Registers
+}
+
+func (gb *machine) SetRegisters(r cpu.Registers) {
+	gb.CPU.Registers = r
+}
+
+func (gb *machine) ReadMemory(addr uint16) byte {
+	return gb.MMU.Peek(addr)
+}
+
+func (gb *machine) WriteMemory(addr uint16, b byte) {
+	gb.MMU.Poke(addr, b)
+}
+
+func (gb *machine) AddHook(start, end uint16, kinds mmu.AccessKind, fn mmu.Hook) mmu.HookID {
+	return gb.MMU.AddHook(start, end, kinds, fn)
+}
+
+func (gb *machine) RemoveHook(id mmu.HookID) {
+	gb.MMU.RemoveHook(id)
+}
+
+func (gb *machine) Step() {
+	if gb.rw != nil {
+		gb.rw.Step()
+		return
+	}
+	gb.step()
+}
+
+// step executes one instruction, and returns true if it finished a frame.
+func (gb *machine) step() bool {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	var cycles int
+	if gb.prof != nil {
+		_, cycles = gb.prof.Step()
+	} else {
+		_, cycles = gb.CPU.Step()
+	}
+	frameDone := gb.RunFor(cycles)
+	if frameDone {
+		gb.endFrame()
+	}
+	return frameDone
+}
+
+// replay executes one instruction of history that's being replayed by the
+// rewind buffer. Frames aren't presented, and nothing is profiled,
+// recorded or covered, since that happened the first time. If the
+// instruction finishes a frame, next is the input for the next one.
+func (gb *machine) replay(next mmu.Buttons) bool {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	gb.PPU.MuteSinks(true)
+	defer gb.PPU.MuteSinks(false)
+	if gb.cov != nil {
+		gb.cov.SetPaused(true)
+		defer gb.cov.SetPaused(false)
+	}
+	frameDone := gb.Machine.Step()
+	if frameDone {
+		gb.MMU.SetButtons(next)
+	}
+	return frameDone
+}
+
+// endFrame records or plays back the input for the frame that just ended,
+// and sets the input for the next one. It also records the frame in the
+// video, if there is one.
+func (gb *machine) endFrame() {
+	if gb.prof != nil {
+		gb.prof.EndFrame()
+	}
+	if gb.vid != nil {
+		gb.vid.EndFrame()
+	}
+	if gb.rec != nil {
+		gb.rec.EndFrame(gb.MMU.Buttons(), gb.stateHash)
+	}
+	next := frontend.Buttons()
+	if gb.player != nil {
+		if err := gb.player.EndFrame(gb.stateHash); err != nil {
+			fmt.Printf("ERR: %v\n", err)
+		}
+		if input, ok := gb.player.Input(); ok {
+			next = input
+		} else {
+			fmt.Printf("Movie finished after %d frames\n", gb.player.Frame())
+			gb.player = nil
+		}
+	}
+	gb.MMU.SetButtons(next)
+}
+
+func (gb *machine) stateHash() uint64 {
+	gb.state = gb.saveState(gb.state[:0])
+	return savestate.HashState(gb.state)
+}
+
+// startMovie loads the state the game starts from, if there is one, and
+// starts recording a movie, or playing back mv if it isn't nil. boot is
+// whether the game was started with the boot ROM.
+func (gb *machine) startMovie(rom []byte, boot bool, statePath, recordPath string, mv *movie.Movie) error {
+	var state []byte
+	if statePath != "" {
+		var err error
+		if state, err = ioutil.ReadFile(statePath); err != nil {
+			return fmt.Errorf("failed to read state: %v", err)
+		}
+	}
+	if mv != nil {
+		if state != nil {
+			return errors.New("-state can't be used with -play, since movies record where they start")
+		}
+		var err error
+		if gb.player, err = movie.NewPlayer(mv, rom); err != nil {
+			return err
+		}
+		fmt.Printf("Playing a movie of %d frames recorded with %s\n", len(mv.Frames), mv.Emulator)
+		state = mv.State
+		input, _ := gb.player.Input()
+		gb.MMU.SetButtons(input)
+	}
+	if state != nil {
+		if err := gb.loadState(state); err != nil {
+			return fmt.Errorf("failed to load state: %v", err)
+		}
+	}
+	if recordPath != "" {
+		gb.rec = movie.NewRecorder(rom, state, gb.MMU.CGB(), boot, version())
+		gb.recordPath = recordPath
+	}
+	return nil
+}
+
+// version returns the emulator's version, to record in movies.
+func version() string {
+	v := "(unknown version)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		v = info.Main.Version
+	}
+	return "gameboy-emu " + v
+}
+
+// errNoRewind is returned when asked to rewind with -rewind 0.
+var errNoRewind = errors.New("no history is being kept (see -rewind)")
+
+func (gb *machine) Rewind(frames int) (int, error) {
+	if gb.rw == nil {
+		return 0, errNoRewind
+	}
+	return gb.rw.Rewind(frames)
+}
+
+func (gb *machine) Instructions() uint64 {
+	if gb.rw == nil {
+		return 0
+	}
+	return gb.rw.Instructions()
+}
+
+func (gb *machine) Checkpoints() []uint64 {
+	if gb.rw == nil {
+		return nil
+	}
+	return gb.rw.Checkpoints()
+}
+
+func (gb *machine) Seek(instructions uint64) error {
+	if gb.rw == nil {
+		return errNoRewind
+	}
+	return gb.rw.Seek(instructions)
+}
+
+func (gb *machine) LastSpriteSelection() ppu.SpriteSelection {
+	return gb.PPU.LastSpriteSelection()
+}
+
+func (gb *machine) ColorMemory() vramview.ColorMemory {
+	return gb.MMU
+}
+
+func (gb *machine) DebugOptions() ppu.DebugOptions {
+	return gb.PPU.DebugOptions()
+}
+
+func (gb *machine) SetDebugOptions(o ppu.DebugOptions) {
+	gb.PPU.SetDebugOptions(o)
+}
+
+func (gb *machine) SaveState(dst []byte) []byte {
+	return gb.saveState(dst)
+}
+
+// LoadState restores a saved state, starting the rewind history afresh,
+// since the machine didn't get there by running.
+func (gb *machine) LoadState(state []byte) error {
+	if err := gb.loadState(state); err != nil {
+		return err
+	}
+	if gb.rw != nil {
+		gb.rw = rewind.New(history{gb}, gb.rw.Options())
+	}
+	return nil
+}
+
+func (gb *machine) saveState(dst []byte) []byte {
+	return savestate.Append(dst, gb.State())
+}
+
+func (gb *machine) loadState(state []byte) error {
+	return gb.Machine.LoadState(state)
+}
+
+// history is the machine as a rewind.Buffer sees it.
+type history struct {
+	gb *machine
+}
+
+func (h history) SaveState(dst []byte) []byte {
+	return h.gb.saveState(dst)
+}
+
+func (h history) LoadState(state []byte) error {
+	return h.gb.loadState(state)
+}
 
-	frontend.ConnectVideo(p.VideoOut)
+func (h history) Step() bool {
+	return h.gb.step()
+}
 
+func (h history) Input() mmu.Buttons {
+	return h.gb.MMU.Buttons()
 }
 
-func waitForInput() string {
-	// block until the user types anything
-	reader := bufio.NewReader(os.Stdin)
-	text, err := reader.ReadString('\n')
+func (h history) Replay(next mmu.Buttons) bool {
+	return h.gb.replay(next)
+}
+
+// quit stops the machine, writes the movie, video, coverage and profile,
+// if there are any, and exits. It may be called from any goroutine, but not
+// while the machine is executing an instruction on the same one.
+func (gb *machine) quit() {
+	// The lock is never released, so the machine stays stopped until the
+	// process exits.
+	gb.mu.Lock()
+	if gb.rec != nil {
+		if err := writeMovie(gb.rec.Movie, gb.recordPath); err != nil {
+			fmt.Printf("ERR: Failed to write movie: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote a movie of %d frames to %s\n", len(gb.rec.Movie.Frames), gb.recordPath)
+	}
+	if gb.vid != nil {
+		if err := gb.finishVideo(); err != nil {
+			fmt.Printf("ERR: Failed to write video: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote a video of %d frames to %s\n", gb.vid.Frames(), gb.videoFile.Name())
+	}
+	if gb.cov != nil {
+		if err := writeCoverage(gb.cov.Map(), gb.coveragePath); err != nil {
+			fmt.Printf("ERR: Failed to write coverage: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote coverage to %s, %s.txt and %s.png\n", gb.coveragePath, gb.coveragePath, gb.coveragePath)
+	}
+	if gb.prof != nil {
+		if err := writeProfile(gb.prof, gb.profilePath); err != nil {
+			fmt.Printf("ERR: Failed to write profile: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote profile to %s and %s.folded\n", gb.profilePath, gb.profilePath)
+	}
+	os.Exit(0)
+}
+
+// startVideo starts recording the screen to a video file, in the format
+// its extension gives.
+func (gb *machine) startVideo(path string, opt video.Options) error {
+	var err error
+	if opt.Format, err = video.FormatForPath(path); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	gb.videoBuf = bufio.NewWriter(f)
+	if gb.vid, err = video.New(gb.videoBuf, opt); err != nil {
+		f.Close()
+		return err
+	}
+	gb.videoFile = f
+	gb.PPU.AddSink(gb.vid)
+	return nil
+}
+
+// finishVideo writes the rest of the video and closes its file.
+func (gb *machine) finishVideo() error {
+	err := gb.vid.Close()
+	if err == nil {
+		err = gb.videoBuf.Flush()
+	}
+	if cerr := gb.videoFile.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeMovie(mv *movie.Movie, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := mv.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadCoverage reads the coverage bitmap at path, or returns nil if there
+// isn't one.
+func loadCoverage(path string) (*coverage.Map, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return coverage.ReadBitmap(f)
+}
+
+func writeCoverage(cov *coverage.Map, path string) error {
+	writers := []struct {
+		path  string
+		write func(io.Writer) error
+	}{
+		{path, cov.WriteBitmap},
+		{path + ".txt", cov.WriteSummary},
+		{path + ".png", cov.WritePNG},
+	}
+	for _, w := range writers {
+		f, err := os.Create(w.path)
+		if err != nil {
+			return err
+		}
+		if err := w.write(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeProfile(prof *profiler.Profiler, path string) error {
+	report, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+	if err := prof.WriteReport(report, 30); err != nil {
+		return err
+	}
+	folded, err := os.Create(path + ".folded")
+	if err != nil {
+		return err
+	}
+	defer folded.Close()
+	if err := prof.WriteFolded(folded); err != nil {
+		return err
+	}
+	if err := report.Close(); err != nil {
+		return err
+	}
+	return folded.Close()
+}
+
+func (gb *machine) BankAt(addr uint16) int {
+	return gb.MMU.BankAt(addr)
+}
+
+func (gb *machine) CallStack() []cpu.Frame {
+	return gb.CPU.CallStack()
+}
+
+// serveGDB waits for GDB to connect, and lets it control the emulator until
+// it detaches. After that, the game runs under the REPL (see runREPL).
+func serveGDB(stub *gdbstub.Stub, dbg *debugger.Debugger, gb *machine, addr string) {
+	l, err := gdbstub.Listen(addr)
+	if err != nil {
+		fmt.Printf("ERR: Failed to listen for GDB: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Waiting for GDB to connect on %s\n", l.Addr())
+	conn, err := l.Accept()
+	l.Close()
+	if err != nil {
+		fmt.Printf("ERR: Failed to accept GDB connection: %v\n", err)
+		os.Exit(1)
+	}
+	if err := stub.Serve(conn); err != nil {
+		fmt.Printf("ERR: GDB session ended: %v\n", err)
+	}
+	conn.Close()
+	runREPL(dbg, gb)
+}
+
+// serveDAP waits for an editor to connect, and lets it control the emulator
+// until it disconnects. After that, the game runs under the REPL (see
+// runREPL), unless the editor asked for it to be stopped, or the session was
+// on stdin and stdout, which the REPL can't use once the editor has closed
+// them.
+func serveDAP(dbg *debugger.Debugger, gb *machine, romPath, srcDir, addr string, stdout *os.File) {
+	rom, err := ioutil.ReadFile(romPath)
+	if err != nil {
+		fmt.Printf("ERR: Failed to read ROM: %v\n", err)
+		os.Exit(1)
+	}
+	lines, err := dap.BuildLineMap(rom, dbg.Symbols(), []string{srcDir})
+	if err != nil {
+		fmt.Printf("ERR: Failed to read source: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Mapped %d lines of source in %s\n", lines.Len(), srcDir)
+	server := dap.New(dbg, lines)
+
+	var conn io.ReadWriteCloser
+	if addr == "stdio" {
+		conn = stdio{os.Stdin, stdout}
+	} else {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			fmt.Printf("ERR: Failed to listen for the debug adapter protocol: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Waiting for an editor to connect on %s\n", l.Addr())
+		conn, err = l.Accept()
+		l.Close()
+		if err != nil {
+			fmt.Printf("ERR: Failed to accept connection: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	terminate, err := server.Serve(conn)
+	if err != nil && err != io.EOF {
+		fmt.Printf("ERR: Debug session ended: %v\n", err)
+	}
+	conn.Close()
+	if terminate || addr == "stdio" {
+		gb.quit()
 	}
-	return text
+	runREPL(dbg, gb)
 }
 
-func printCPUState(c *cpu.CPU) string {
-	return fmt.Sprintf(`
-	===== CPU =====
-	PC: %0x \t SP: %0x
-	A: %0x \t F: %0x
-	B: %0x \t C: %0x
-	D: %0x \t E: %0x
-	H: %0x \t L: %0x
-	F: %08b
-	`, c.PC, c.SP, c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L, c.F)
+// stdio is stdin and stdout as a connection.
+type stdio struct {
+	io.Reader
+	io.WriteCloser
 }
 
 func placeholderScreen() []byte {
@@ -0,0 +1,248 @@
+package mmu
+
+import "fmt"
+
+// The Game Boy Color has a second bank of VRAM, which the CPU sees at
+// $8000-$9FFF when bit 0 of VBK is set, and RAM for eight background and
+// eight sprite palettes of four colors each. The CPU reaches palette RAM a
+// byte at a time through BCPD and OCPD, at the index in the low six bits of
+// BCPS and OCPS, which advances after each write if bit 7 is set.
+//
+// It also has seven banks of work RAM at $D000-$DFFF, chosen by SVBK (0
+// chooses bank 1), a double speed mode, switched to by setting bit 0 of
+// KEY1 and executing STOP, and HDMA (see hdma.go).
+// See https://gbdev.io/pandocs/CGB_Registers.html.
+
+const (
+	// cartCGBFlag is the address of the cartridge header's CGB flag, which
+	// has bit 7 set if the game supports the Game Boy Color.
+	cartCGBFlag = 0x0143
+
+	vramSize = 0x2000
+	// paletteRAMSize is the size of each of the background and sprite
+	// palette RAMs: eight palettes of four two-byte colors.
+	paletteRAMSize = 64
+
+	// Bits of BCPS and OCPS.
+	paletteIndex         = 0b0011_1111
+	paletteAutoIncrement = 1 << 7
+
+	wramBanks    = 8
+	wramBankSize = 0x1000
+	svbkBank     = 0b111
+
+	// Bits of KEY1.
+	key1DoubleSpeed = 1 << 7
+	key1Switch      = 1 << 0
+)
+
+// Model is the Game Boy an MMU is, which decides whether games run in Game
+// Boy Color mode.
+type Model int
+
+const (
+	// ModelAuto runs games that support the Game Boy Color in CGB mode,
+	// unless they're started with a boot ROM, since only the DMG's is
+	// supported, and other games as a DMG would.
+	ModelAuto Model = iota
+	ModelDMG
+	// ModelCGB runs every game in CGB mode. Games that don't support the
+	// CGB don't set its palettes, so they're drawn all white.
+	ModelCGB
+)
+
+var modelNames = []string{"auto", "dmg", "cgb"}
+
+func (m Model) String() string {
+	if m < 0 || int(m) >= len(modelNames) {
+		return fmt.Sprintf("Model(%d)", int(m))
+	}
+	return modelNames[m]
+}
+
+// ParseModel returns the model with a name: auto, dmg or cgb.
+func ParseModel(name string) (Model, error) {
+	for i, n := range modelNames {
+		if n == name {
+			return Model(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown model %q: want auto, dmg or cgb", name)
+}
+
+// CGB reports whether a game runs in Game Boy Color mode on the model, if
+// it's started without a boot ROM.
+func (m Model) CGB(rom []byte) bool {
+	return m == ModelCGB || m == ModelAuto && SupportsCGB(rom)
+}
+
+// SupportsCGB reports whether a game ROM's header says it supports the
+// Game Boy Color.
+func SupportsCGB(rom []byte) bool {
+	return len(rom) > cartCGBFlag && rom[cartCGBFlag]&0x80 != 0
+}
+
+// CGB reports whether the game runs in Game Boy Color mode (see Model).
+func (m *MMU) CGB() bool {
+	return m.cgb
+}
+
+// PeekVRAM reads a byte from the given bank of VRAM, 0 or 1, whichever bank
+// the CPU has selected, without running any hooks. It's meant for
+// debuggers. Addresses outside VRAM, $8000-$9FFF, read as $FF.
+func (m *MMU) PeekVRAM(bank byte, addr uint16) byte {
+	if addr < AddrVRAM || addr >= AddrCartRAM {
+		return 0xFF
+	}
+	if bank == 0 {
+		return m.Mem[addr]
+	}
+	return m.vram1[addr-AddrVRAM]
+}
+
+// PeekPalette reads byte i of the background palette RAM, or the sprite
+// palette RAM if obj is set, without running any hooks.
+func (m *MMU) PeekPalette(obj bool, i byte) byte {
+	if obj {
+		return m.objPalettes[i%paletteRAMSize]
+	}
+	return m.bgPalettes[i%paletteRAMSize]
+}
+
+// DoubleSpeed reports whether the CPU runs at double speed, which it only
+// can in Game Boy Color mode.
+func (m *MMU) DoubleSpeed() bool {
+	return m.cgb && m.Mem[AddrKEY1]&key1DoubleSpeed != 0
+}
+
+// speedSwitchDots is how long switching speed stops the CPU for: 2050
+// M-cycles, or 8200 dots.
+// See https://gbdev.io/pandocs/CGB_Registers.html#ff4d--key1-cgb-mode-only-prepare-speed-switch.
+const speedSwitchDots = 2050 * 4
+
+// SwitchSpeed switches between normal and double speed if the game has
+// asked to by setting bit 0 of KEY1, and reports whether it did. The CPU
+// calls it when it executes STOP, which doesn't stop it if the speed
+// switches, but the switch itself stops it for a while, as HDMA does (see
+// TakeStall).
+func (m *MMU) SwitchSpeed() bool {
+	if !m.cgb || m.Mem[AddrKEY1]&key1Switch == 0 {
+		return false
+	}
+	m.Mem[AddrKEY1] = ^m.Mem[AddrKEY1] & key1DoubleSpeed
+	m.stall += speedSwitchDots
+	return true
+}
+
+// startCGB switches to Game Boy Color mode, with the background palettes
+// white, as the CGB's boot ROM leaves them.
+func (m *MMU) startCGB() {
+	m.cgb = true
+	m.Mem[AddrHDMA5] = hdmaIdle
+	// Colors are little-endian, and white is $7FFF.
+	for i := 0; i < paletteRAMSize; i += 2 {
+		m.bgPalettes[i], m.bgPalettes[i+1] = 0xFF, 0x7F
+	}
+}
+
+// cgbMapped reports whether the CPU accesses addr differently in Game Boy
+// Color mode.
+func cgbMapped(addr uint16) bool {
+	switch addr {
+	case AddrVBK, AddrBCPS, AddrBCPD, AddrOCPS, AddrOCPD, AddrKEY1, AddrSVBK:
+		return true
+	}
+	return addr >= AddrVRAM && addr < AddrCartRAM ||
+		addr >= AddrWorkRAMSwitchableBank && addr < AddrEchoRAM ||
+		addr >= AddrHDMA1 && addr <= AddrHDMA5
+}
+
+// wramBank returns the bank of work RAM at $D000-$DFFF.
+func (m *MMU) wramBank() int {
+	if b := int(m.Mem[AddrSVBK] & svbkBank); b > 1 {
+		return b
+	}
+	return 1
+}
+
+// wram returns the byte of work RAM at addr in $D000-$DFFF. Bank 1 is in
+// Mem, and the others in wram.
+func (m *MMU) wram(addr uint16) *byte {
+	if b := m.wramBank(); b > 1 {
+		return &m.wramBanks[b-2][addr-AddrWorkRAMSwitchableBank]
+	}
+	return &m.Mem[addr]
+}
+
+func (m *MMU) rbCGB(addr uint16) byte {
+	switch addr {
+	case AddrVBK:
+		return m.Mem[addr] | 0xFE
+	case AddrBCPS, AddrOCPS:
+		return m.Mem[addr] | 0x40
+	case AddrBCPD:
+		return m.bgPalettes[m.Mem[AddrBCPS]&paletteIndex]
+	case AddrOCPD:
+		return m.objPalettes[m.Mem[AddrOCPS]&paletteIndex]
+	case AddrKEY1:
+		return m.Mem[addr] | 0x7E
+	case AddrSVBK:
+		return m.Mem[addr] | 0xF8
+	case AddrHDMA5:
+		return m.Mem[addr]
+	}
+	switch {
+	case addr >= AddrHDMA1 && addr < AddrHDMA5:
+		// The source and destination can't be read back.
+		return 0xFF
+	case addr >= AddrWorkRAMSwitchableBank && addr < AddrEchoRAM:
+		return *m.wram(addr)
+	}
+	if m.Mem[AddrVBK]&1 != 0 {
+		return m.vram1[addr-AddrVRAM]
+	}
+	return m.Mem[addr]
+}
+
+func (m *MMU) wbCGB(addr uint16, b byte) {
+	switch addr {
+	case AddrVBK:
+		m.Mem[addr] = b & 1
+	case AddrBCPS, AddrOCPS:
+		m.Mem[addr] = b &^ 0x40
+	case AddrBCPD:
+		m.writePalette(&m.bgPalettes, AddrBCPS, b)
+	case AddrOCPD:
+		m.writePalette(&m.objPalettes, AddrOCPS, b)
+	case AddrKEY1:
+		m.Mem[addr] = m.Mem[addr]&key1DoubleSpeed | b&key1Switch
+	case AddrSVBK:
+		m.Mem[addr] = b & svbkBank
+	case AddrHDMA1, AddrHDMA2, AddrHDMA3, AddrHDMA4:
+		m.Mem[addr] = b
+	case AddrHDMA5:
+		m.startHDMA(b)
+	default:
+		if addr >= AddrWorkRAMSwitchableBank && addr < AddrEchoRAM {
+			*m.wram(addr) = b
+			return
+		}
+		if m.Mem[AddrVBK]&1 != 0 {
+			m.vram1[addr-AddrVRAM] = b
+		} else {
+			m.Mem[addr] = b
+		}
+	}
+}
+
+// writePalette writes a byte of palette RAM at the index in the
+// specification register spec (BCPS or OCPS), and advances the index if
+// auto-increment is on.
+// TODO Palette RAM can't be accessed while the PPU is drawing a scanline.
+func (m *MMU) writePalette(ram *[paletteRAMSize]byte, spec uint16, b byte) {
+	s := m.Mem[spec]
+	ram[s&paletteIndex] = b
+	if s&paletteAutoIncrement != 0 {
+		m.Mem[spec] = s&^paletteIndex | (s+1)&paletteIndex
+	}
+}
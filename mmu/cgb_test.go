@@ -0,0 +1,199 @@
+package mmu
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newCGB returns an MMU in Game Boy Color mode.
+func newCGB() *MMU {
+	rom := make([]byte, 0x8000)
+	rom[cartCGBFlag] = 0x80
+	return New(MMUOptions{GameRom: bytes.NewReader(rom)})
+}
+
+func TestMMU_CGB(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    byte
+		bootROM bool
+		model   Model
+		want    bool
+	}{
+		{"DMG game", 0x00, false, ModelAuto, false},
+		{"CGB game", 0x80, false, ModelAuto, true},
+		{"CGB-only game", 0xC0, false, ModelAuto, true},
+		{"booted with the DMG boot ROM", 0x80, true, ModelAuto, false},
+		{"CGB game on a DMG", 0x80, false, ModelDMG, false},
+		{"DMG game on a CGB", 0x00, false, ModelCGB, true},
+		{"booted with the DMG boot ROM on a CGB", 0x00, true, ModelCGB, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rom := make([]byte, 0x8000)
+			rom[cartCGBFlag] = tt.flag
+			opt := MMUOptions{GameRom: bytes.NewReader(rom), Model: tt.model}
+			if tt.bootROM {
+				opt.BootRom = bytes.NewReader(make([]byte, 0x100))
+			}
+			if got := New(opt).CGB(); got != tt.want {
+				t.Errorf("CGB() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseModel(t *testing.T) {
+	for _, m := range []Model{ModelAuto, ModelDMG, ModelCGB} {
+		if got, err := ParseModel(m.String()); got != m || err != nil {
+			t.Errorf("ParseModel(%q) = %v, %v, want %v", m.String(), got, err, m)
+		}
+	}
+	if _, err := ParseModel("gba"); err == nil {
+		t.Error("ParseModel(\"gba\") succeeded")
+	}
+}
+
+func TestMMU_WRAMBanks(t *testing.T) {
+	tests := []struct {
+		name string
+		svbk byte
+		// want is the value read back, and bank the one written to.
+		want byte
+		bank int
+	}{
+		{"bank 0 is bank 1", 0, 0xF8, 1},
+		{"bank 1", 1, 0xF9, 1},
+		{"bank 7", 7, 0xFF, 7},
+		{"high bits ignored", 0xFA, 0xFA, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newCGB()
+			cpu := m.CPUInterface
+			// Fill each bank with its number.
+			for b := byte(1); b < wramBanks; b++ {
+				cpu.Wb(AddrSVBK, b)
+				cpu.Wb(0xDFFF, b)
+			}
+			cpu.Wb(AddrSVBK, tt.svbk)
+			if got := cpu.Rb(AddrSVBK); got != tt.want {
+				t.Errorf("SVBK = $%02X, want $%02X", got, tt.want)
+			}
+			if got := cpu.Rb(0xDFFF); got != byte(tt.bank) {
+				t.Errorf("Read from bank %d, want %d", got, tt.bank)
+			}
+			if got := m.BankAt(0xD000); got != tt.bank {
+				t.Errorf("BankAt($D000) = %d, want %d", got, tt.bank)
+			}
+			// Bank 0 is fixed.
+			if got := m.BankAt(0xC000); got != 0 {
+				t.Errorf("BankAt($C000) = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestMMU_SwitchSpeed(t *testing.T) {
+	m := newCGB()
+	cpu := m.CPUInterface
+	if m.SwitchSpeed() {
+		t.Error("Switched speed without KEY1 bit 0 set")
+	}
+	cpu.Wb(AddrKEY1, 0xFF)
+	if got := cpu.Rb(AddrKEY1); got != 0x7F {
+		t.Errorf("KEY1 = $%02X, want $7F", got)
+	}
+	for _, double := range []bool{true, false} {
+		cpu.Wb(AddrKEY1, 1)
+		if !m.SwitchSpeed() {
+			t.Fatal("Didn't switch speed")
+		}
+		if m.DoubleSpeed() != double {
+			t.Errorf("DoubleSpeed() = %v, want %v", m.DoubleSpeed(), double)
+		}
+		if got := m.TakeStall(); got != 8200 {
+			t.Errorf("TakeStall() = %d after switching speed, want 8200", got)
+		}
+		// Switching clears bit 0.
+		if got, want := cpu.Rb(AddrKEY1), byte(0x7E)|boolBit7(double); got != want {
+			t.Errorf("KEY1 = $%02X, want $%02X", got, want)
+		}
+	}
+}
+
+func boolBit7(b bool) byte {
+	if b {
+		return 1 << 7
+	}
+	return 0
+}
+
+func TestMMU_VRAMBanks(t *testing.T) {
+	m := newCGB()
+	cpu := m.CPUInterface
+	cpu.Wb(0x9800, 1)
+	cpu.Wb(AddrVBK, 0xFF)
+	if got := cpu.Rb(AddrVBK); got != 0xFF {
+		t.Errorf("VBK = $%02X, want $FF", got)
+	}
+	if got := cpu.Rb(0x9800); got != 0 {
+		t.Errorf("Bank 1 was written through bank 0: read $%02X", got)
+	}
+	cpu.Wb(0x9800, 2)
+	cpu.Wb(AddrVBK, 0)
+	if got := cpu.Rb(AddrVBK); got != 0xFE {
+		t.Errorf("VBK = $%02X, want $FE", got)
+	}
+	if got := cpu.Rb(0x9800); got != 1 {
+		t.Errorf("Bank 0 reads $%02X, want $01", got)
+	}
+	// The PPU sees both banks, whichever the CPU has selected.
+	if b0, b1 := m.PPUInterface.RbVRAM(0, 0x9800), m.PPUInterface.RbVRAM(1, 0x9800); b0 != 1 || b1 != 2 {
+		t.Errorf("PPU reads $%02X and $%02X, want $01 and $02", b0, b1)
+	}
+	if b0, b1 := m.PeekVRAM(0, 0x9800), m.PeekVRAM(1, 0x9800); b0 != 1 || b1 != 2 {
+		t.Errorf("PeekVRAM reads $%02X and $%02X, want $01 and $02", b0, b1)
+	}
+	for _, addr := range []uint16{0x0000, 0x7FFF, 0xA000, 0xFFFF} {
+		if b := m.PeekVRAM(1, addr); b != 0xFF {
+			t.Errorf("PeekVRAM(1, $%04X) = $%02X outside VRAM, want $FF", addr, b)
+		}
+	}
+}
+
+func TestMMU_paletteRAM(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec, data uint16
+		obj        bool
+	}{
+		{"background", AddrBCPS, AddrBCPD, false},
+		{"sprites", AddrOCPS, AddrOCPD, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newCGB()
+			cpu := m.CPUInterface
+			// Auto-increment wraps around from the last byte.
+			cpu.Wb(tt.spec, paletteAutoIncrement|0x3E)
+			for _, b := range []byte{0x1F, 0x00, 0xE0} {
+				cpu.Wb(tt.data, b)
+			}
+			if got := cpu.Rb(tt.spec); got != paletteAutoIncrement|0x40|0x01 {
+				t.Errorf("Spec register = $%02X, want $C1", got)
+			}
+			for i, want := range map[byte]byte{0x3E: 0x1F, 0x3F: 0x00, 0x00: 0xE0} {
+				if got := m.PPUInterface.RbPalette(tt.obj, i); got != want {
+					t.Errorf("Palette RAM byte $%02X = $%02X, want $%02X", i, got, want)
+				}
+			}
+			// Without auto-increment, the index stays put.
+			cpu.Wb(tt.spec, 0x3E)
+			cpu.Wb(tt.data, 0x55)
+			if got := cpu.Rb(tt.data); got != 0x55 {
+				t.Errorf("Read back $%02X, want $55", got)
+			}
+		})
+	}
+}
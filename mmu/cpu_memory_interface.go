@@ -5,17 +5,37 @@ type cpuMemoryInterface struct {
 }
 
 func (cmi *cpuMemoryInterface) Rb(addr uint16) byte {
-	return cmi.mmu.rb(addr)
+	m := cmi.mmu
+	b := m.rb(addr)
+	if m.hooked(addr, HookRead) {
+		m.runHooks(CPU, addr, b, HookRead)
+	}
+	return b
 }
 
 func (cmi *cpuMemoryInterface) Wb(addr uint16, b byte) {
-	cmi.mmu.wb(addr, b)
+	m := cmi.mmu
+	m.wb(addr, b)
+	if m.hooked(addr, HookWrite) {
+		m.runHooks(CPU, addr, b, HookWrite)
+	}
 }
 
+// Rw reads a word, low byte first (see MMU.rw).
 func (cmi *cpuMemoryInterface) Rw(addr uint16) uint16 {
-	return cmi.mmu.rw(addr)
+	lo := cmi.Rb(addr)
+	hi := cmi.Rb(addr + 1)
+	return uint16(hi)<<8 | uint16(lo)
 }
 
+// Ww writes a word, low byte first.
 func (cmi *cpuMemoryInterface) Ww(addr uint16, w uint16) {
-	cmi.mmu.ww(addr, w)
+	cmi.Wb(addr, byte(w))
+	cmi.Wb(addr+1, byte(w>>8))
+}
+
+// SwitchSpeed switches the CPU's speed when it executes STOP, if the game
+// has asked to (see MMU.SwitchSpeed).
+func (cmi *cpuMemoryInterface) SwitchSpeed() bool {
+	return cmi.mmu.SwitchSpeed()
 }
@@ -0,0 +1,89 @@
+package mmu
+
+// HDMA copies data to VRAM on the Game Boy Color, in blocks of 16 bytes,
+// from the source in HDMA1 and HDMA2 to the destination in HDMA3 and HDMA4,
+// ignoring their low four bits. Writing HDMA5 starts a transfer of
+// (HDMA5&$7F)+1 blocks: a general-purpose transfer if bit 7 is clear, which
+// copies every block at once, or an HBlank transfer if it's set, which
+// copies a block each time the PPU enters HBlank. The CPU is stopped while
+// blocks are copied. While an HBlank transfer is running, bit 7 of HDMA5
+// reads as 0 and the rest as the number of blocks left minus one. Writing
+// bit 7 clear stops it, after which bit 7 reads as 1 and the rest still as
+// the number of blocks left minus one. Once it's done, HDMA5 reads as $FF.
+// See https://gbdev.io/pandocs/CGB_Registers.html#lcd-vram-dma-transfers.
+
+const (
+	hdmaBlock = 0x10
+	// hdmaBlockDots is how long the CPU is stopped for each block, in dots,
+	// whatever its speed.
+	hdmaBlockDots = 32
+	// Bits of HDMA5.
+	hdmaHBlank = 1 << 7
+	hdmaBlocks = 0b0111_1111
+	hdmaIdle   = 0xFF
+)
+
+// hdmaActive reports whether an HBlank transfer is running.
+func (m *MMU) hdmaActive() bool {
+	return m.Mem[AddrHDMA5]&hdmaHBlank == 0
+}
+
+// startHDMA starts, or stops, a transfer when b is written to HDMA5.
+func (m *MMU) startHDMA(b byte) {
+	switch {
+	case b&hdmaHBlank != 0:
+		m.Mem[AddrHDMA5] = b & hdmaBlocks
+	case m.hdmaActive():
+		m.Mem[AddrHDMA5] |= hdmaHBlank
+	default:
+		for i := 0; i <= int(b&hdmaBlocks); i++ {
+			m.copyHDMABlock()
+		}
+		m.Mem[AddrHDMA5] = hdmaIdle
+	}
+}
+
+// hblank copies the next block of an HBlank transfer, if one is running
+// (see ppuMemoryInterface.HBlank).
+func (m *MMU) hblank() {
+	if !m.cgb || !m.hdmaActive() {
+		return
+	}
+	m.copyHDMABlock()
+	if m.Mem[AddrHDMA5] == 0 {
+		m.Mem[AddrHDMA5] = hdmaIdle
+	} else {
+		m.Mem[AddrHDMA5]--
+	}
+}
+
+// copyHDMABlock copies a block from the source to the destination, which it
+// then moves on to the next block, and stops the CPU while it does.
+func (m *MMU) copyHDMABlock() {
+	src := uint16(m.Mem[AddrHDMA1])<<8 | uint16(m.Mem[AddrHDMA2]&0xF0)
+	dst := uint16(m.Mem[AddrHDMA3]&0x1F)<<8 | uint16(m.Mem[AddrHDMA4]&0xF0)
+	for i := uint16(0); i < hdmaBlock; i++ {
+		from, to := src+i, AddrVRAM+(dst+i)&0x1FFF
+		b := m.rb(from)
+		if m.hooked(from, HookRead) {
+			m.runHooks(DMA, from, b, HookRead)
+		}
+		m.wbCGB(to, b)
+		if m.hooked(to, HookWrite) {
+			m.runHooks(DMA, to, b, HookWrite)
+		}
+	}
+	src, dst = src+hdmaBlock, dst+hdmaBlock
+	m.Mem[AddrHDMA1], m.Mem[AddrHDMA2] = byte(src>>8), byte(src)
+	m.Mem[AddrHDMA3], m.Mem[AddrHDMA4] = byte(dst>>8), byte(dst)
+	m.stall += hdmaBlockDots
+}
+
+// TakeStall returns how many dots the CPU has been stopped for by HDMA or
+// switching speed since it was last called. The PPU should run for that long before the
+// CPU runs again.
+func (m *MMU) TakeStall() int {
+	n := m.stall
+	m.stall = 0
+	return n
+}
@@ -0,0 +1,117 @@
+package mmu
+
+import "testing"
+
+// hdmaSetup returns an MMU in Game Boy Color mode with 64 bytes counting up
+// from 1 at $C000, and HDMA set to copy them to $9000 in VRAM bank 1, with
+// the low bits of the addresses set to show they're ignored.
+func hdmaSetup() *MMU {
+	m := newCGB()
+	cpu := m.CPUInterface
+	for i := uint16(0); i < 0x40; i++ {
+		cpu.Wb(AddrWorkRAMBank0+i, byte(i+1))
+	}
+	cpu.Wb(AddrVBK, 1)
+	for addr, b := range map[uint16]byte{AddrHDMA1: 0xC0, AddrHDMA2: 0x0F, AddrHDMA3: 0xF0, AddrHDMA4: 0x0F} {
+		cpu.Wb(addr, b)
+	}
+	return m
+}
+
+// copied returns the number of bytes copied to $9000 in VRAM bank 1.
+func copied(m *MMU) int {
+	n := 0
+	for n < 0x40 && m.PPUInterface.RbVRAM(1, 0x9000+uint16(n)) == byte(n+1) {
+		n++
+	}
+	return n
+}
+
+func TestMMU_GeneralPurposeHDMA(t *testing.T) {
+	m := hdmaSetup()
+	m.CPUInterface.Wb(AddrHDMA5, 0x02)
+	if n := copied(m); n != 0x30 {
+		t.Errorf("Copied %d bytes, want 48", n)
+	}
+	if got := m.CPUInterface.Rb(AddrHDMA5); got != 0xFF {
+		t.Errorf("HDMA5 = $%02X, want $FF", got)
+	}
+	// The stall is part of the state, in case it's saved before the stall
+	// is taken.
+	s := m.State()
+	m.TakeStall()
+	m.SetState(s)
+	if got := m.TakeStall(); got != 3*32 {
+		t.Errorf("TakeStall() = %d, want 96", got)
+	}
+	if got := m.TakeStall(); got != 0 {
+		t.Errorf("TakeStall() = %d after taking the stall, want 0", got)
+	}
+	// The source and destination moved on.
+	m.CPUInterface.Wb(AddrHDMA5, 0x00)
+	if n := copied(m); n != 0x40 {
+		t.Errorf("Copied %d bytes after a second transfer, want 64", n)
+	}
+}
+
+func TestMMU_Poke_HDMA(t *testing.T) {
+	// A debugger writing HDMA5 starts a transfer, as the CPU does.
+	m := hdmaSetup()
+	m.Poke(AddrHDMA5, 0x00)
+	if n := copied(m); n != 0x10 {
+		t.Errorf("Copied %d bytes, want 16", n)
+	}
+	if got := m.TakeStall(); got != 32 {
+		t.Errorf("TakeStall() = %d, want 32", got)
+	}
+}
+
+func TestMMU_HBlankHDMA(t *testing.T) {
+	tests := []struct {
+		name string
+		// cancelAfter is the number of HBlanks after which the transfer is
+		// stopped, or -1 if it isn't.
+		cancelAfter int
+		// want is the number of blocks copied after four HBlanks.
+		want int
+	}{
+		{"running", -1, 3},
+		{"stopped", 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := hdmaSetup()
+			cpu, ppu := m.CPUInterface, m.PPUInterface
+			cpu.Wb(AddrHDMA5, hdmaHBlank|0x02)
+			if got := cpu.Rb(AddrHDMA5); got != 0x02 {
+				t.Errorf("HDMA5 = $%02X at the start, want $02", got)
+			}
+			stall := 0
+			for i := 0; i < 4; i++ {
+				if i == tt.cancelAfter {
+					cpu.Wb(AddrHDMA5, 0)
+				}
+				// The PPU writing STAT doesn't copy a block; only its
+				// telling the MMU it entered HBlank does.
+				ppu.Wb(AddrLCDStat, 3)
+				ppu.Wb(AddrLCDStat, 0)
+				ppu.HBlank()
+				stall += m.TakeStall()
+			}
+			if n := copied(m); n != tt.want*hdmaBlock {
+				t.Errorf("Copied %d bytes, want %d", n, tt.want*hdmaBlock)
+			}
+			if stall != tt.want*hdmaBlockDots {
+				t.Errorf("Stalled for %d dots, want %d", stall, tt.want*hdmaBlockDots)
+			}
+			// Stopping a transfer leaves the number of blocks left.
+			want := byte(hdmaIdle)
+			if tt.cancelAfter >= 0 {
+				want = hdmaHBlank | 0x01
+			}
+			if got := cpu.Rb(AddrHDMA5); got != want {
+				t.Errorf("HDMA5 = $%02X at the end, want $%02X", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package mmu
+
+// Component is a part of the Gameboy that accesses memory.
+type Component int
+
+const (
+	CPU Component = iota
+	PPU
+	DMA
+)
+
+func (c Component) String() string {
+	switch c {
+	case CPU:
+		return "CPU"
+	case PPU:
+		return "PPU"
+	case DMA:
+		return "DMA"
+	}
+	return "unknown"
+}
+
+// AccessKind is a set of kinds of memory access.
+type AccessKind uint8
+
+const (
+	HookRead AccessKind = 1 << iota
+	HookWrite
+)
+
+// Access is a single byte read or written. Word accesses are reported as
+// two byte accesses, low byte first.
+type Access struct {
+	Addr      uint16
+	Value     byte // the value read or written
+	Write     bool
+	Component Component
+	// PC is the CPU's program counter at the time of the access, if a
+	// PC function has been set with SetPCFunc.
+	PC uint16
+}
+
+// Hook is called on memory accesses.
+type Hook func(Access)
+
+// HookID identifies a hook so that it can be removed.
+type HookID int
+
+type hook struct {
+	id         HookID
+	start, end uint16
+	kinds      AccessKind
+	fn         Hook
+}
+
+// AddHook calls fn after every access of the given kinds to memory from
+// start to end inclusive, until the hook is removed with RemoveHook.
+// Hooks are called in the order they were added. They must not access
+// memory through the MMU's interfaces, but may use Peek and Poke.
+func (m *MMU) AddHook(start, end uint16, kinds AccessKind, fn Hook) HookID {
+	m.nextHookID++
+	id := m.nextHookID
+	// Hooks are copied rather than modified in place, so that a hook can
+	// add or remove hooks while the hooks are being run.
+	hooks := make([]hook, len(m.hooks), len(m.hooks)+1)
+	copy(hooks, m.hooks)
+	m.hooks = append(hooks, hook{id, start, end, kinds, fn})
+	m.indexHooks()
+	return id
+}
+
+// RemoveHook removes a hook added with AddHook.
+func (m *MMU) RemoveHook(id HookID) {
+	hooks := make([]hook, 0, len(m.hooks))
+	for _, h := range m.hooks {
+		if h.id != id {
+			hooks = append(hooks, h)
+		}
+	}
+	m.hooks = hooks
+	m.indexHooks()
+}
+
+// SetPCFunc sets the function used to find the CPU's program counter for
+// Access.PC.
+func (m *MMU) SetPCFunc(pc func() uint16) {
+	m.pc = pc
+}
+
+// indexHooks rebuilds hookKinds, which records which kinds of access to
+// each address have hooks. It's nil when there are no hooks, so that
+// checking for hooks costs a single comparison.
+func (m *MMU) indexHooks() {
+	if len(m.hooks) == 0 {
+		m.hookKinds = nil
+		return
+	}
+	m.hookKinds = new([0x10000]AccessKind)
+	for _, h := range m.hooks {
+		for addr := uint32(h.start); addr <= uint32(h.end); addr++ {
+			m.hookKinds[addr] |= h.kinds
+		}
+	}
+}
+
+// hooked returns true if there are hooks on accesses of this kind to addr.
+func (m *MMU) hooked(addr uint16, kind AccessKind) bool {
+	return m.hookKinds != nil && m.hookKinds[addr]&kind != 0
+}
+
+func (m *MMU) runHooks(c Component, addr uint16, value byte, kind AccessKind) {
+	a := Access{Addr: addr, Value: value, Write: kind == HookWrite, Component: c}
+	if m.pc != nil {
+		a.PC = m.pc()
+	}
+	for _, h := range m.hooks {
+		if h.kinds&kind != 0 && addr >= h.start && addr <= h.end {
+			h.fn(a)
+		}
+	}
+}
+
+// Peek reads a byte as the CPU sees it, without running any hooks. It's
+// meant for debuggers.
+func (m *MMU) Peek(addr uint16) byte {
+	return m.rb(addr)
+}
+
+// Poke writes a byte as the CPU would, without running any hooks for the
+// write itself. It's meant for debuggers. Writes to registers have the same
+// side effects as the CPU's: in particular, writing DMA or, in Game Boy
+// Color mode, HDMA5 starts a transfer, which runs the hooks for the memory
+// it copies, and a general-purpose HDMA stalls the CPU (see TakeStall).
+func (m *MMU) Poke(addr uint16, b byte) {
+	m.wb(addr, b)
+}
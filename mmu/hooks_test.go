@@ -0,0 +1,107 @@
+package mmu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMMU_hooks(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end uint16
+		kinds      AccessKind
+		access     func(m *MMU)
+		want       []Access
+	}{
+		{
+			"CPU write",
+			0xC000, 0xC000, HookWrite,
+			func(m *MMU) { m.CPUInterface.Wb(0xC000, 0x42) },
+			[]Access{{Addr: 0xC000, Value: 0x42, Write: true, Component: CPU, PC: 0x0150}},
+		},
+		{
+			"CPU read outside range",
+			0xC000, 0xC0FF, HookRead | HookWrite,
+			func(m *MMU) { m.CPUInterface.Rb(0xC100) },
+			nil,
+		},
+		{
+			"read hook ignores writes",
+			0xC000, 0xC0FF, HookRead,
+			func(m *MMU) { m.CPUInterface.Wb(0xC010, 1) },
+			nil,
+		},
+		{
+			"CPU word read is two byte reads",
+			0xC000, 0xC0FF, HookRead,
+			func(m *MMU) { m.CPUInterface.Rw(0xC0FF) },
+			[]Access{{Addr: 0xC0FF, Value: 0xFF, Component: CPU, PC: 0x0150}},
+		},
+		{
+			"PPU read",
+			0x8000, 0x9FFF, HookRead,
+			func(m *MMU) { m.PPUInterface.Rb(0x8010) },
+			[]Access{{Addr: 0x8010, Value: 0x10, Component: PPU, PC: 0x0150}},
+		},
+		{
+			"DMA to OAM",
+			0xFE9F, 0xFE9F, HookWrite,
+			func(m *MMU) { m.CPUInterface.Wb(AddrDMA, 0xC0) },
+			[]Access{{Addr: 0xFE9F, Value: 0x9F, Write: true, Component: DMA, PC: 0x0150}},
+		},
+		{
+			"Peek and Poke don't run hooks",
+			0x0000, 0xFFFF, HookRead | HookWrite,
+			func(m *MMU) {
+				m.Poke(0xC000, m.Peek(0xC001))
+			},
+			nil,
+		},
+		{
+			"Poke starts DMA as the CPU does",
+			0xFE00, 0xFE00, HookWrite,
+			func(m *MMU) { m.Poke(AddrDMA, 0xC0) },
+			[]Access{{Addr: 0xFE00, Value: 0x00, Write: true, Component: DMA, PC: 0x0150}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(MMUOptions{})
+			for i := 0; i < 0x100; i++ {
+				m.Mem[0x8000+i] = byte(i)
+				m.Mem[0xC000+i] = byte(i)
+			}
+			m.SetPCFunc(func() uint16 { return 0x0150 })
+			var got []Access
+			m.AddHook(tt.start, tt.end, tt.kinds, func(a Access) {
+				got = append(got, a)
+			})
+			tt.access(m)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Hook got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMMU_RemoveHook(t *testing.T) {
+	m := New(MMUOptions{})
+	var calls int
+	id := m.AddHook(0xC000, 0xC000, HookWrite, func(Access) { calls++ })
+	// A hook that removes itself shouldn't stop the other hooks running.
+	var self HookID
+	self = m.AddHook(0xC000, 0xC000, HookWrite, func(Access) {
+		calls++
+		m.RemoveHook(self)
+	})
+	m.CPUInterface.Wb(0xC000, 1)
+	m.CPUInterface.Wb(0xC000, 2)
+	m.RemoveHook(id)
+	m.CPUInterface.Wb(0xC000, 3)
+	if calls != 3 {
+		t.Errorf("Hooks called %d times, want 3", calls)
+	}
+	if m.hookKinds != nil {
+		t.Errorf("hookKinds should be nil with no hooks")
+	}
+}
@@ -0,0 +1,64 @@
+package mmu
+
+// Buttons is a set of joypad buttons.
+type Buttons uint8
+
+const (
+	ButtonRight Buttons = 1 << iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
+)
+
+// buttonNames are the names of the buttons, in the order of their bits.
+var buttonNames = []string{"Right", "Left", "Up", "Down", "A", "B", "Select", "Start"}
+
+func (b Buttons) String() string {
+	s := ""
+	for i, name := range buttonNames {
+		if b&(1<<uint(i)) != 0 {
+			if s != "" {
+				s += "+"
+			}
+			s += name
+		}
+	}
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// p1Select is the bits of the P1 register that the game writes to choose
+// which buttons to read: bit 4 low selects the direction buttons, and bit
+// 5 low the action buttons.
+const p1Select = 0x30
+
+// SetButtons sets the buttons that are held down.
+// TODO Pressing a button should request the joypad interrupt.
+func (m *MMU) SetButtons(b Buttons) {
+	m.buttons = b
+}
+
+// Buttons returns the buttons that are held down.
+func (m *MMU) Buttons() Buttons {
+	return m.buttons
+}
+
+// readP1 returns the value of the P1 register: the selected group of
+// buttons in the low 4 bits, with held buttons as 0 bits.
+func (m *MMU) readP1() byte {
+	sel := m.Mem[AddrP1] & p1Select
+	held := byte(0)
+	if sel&0x10 == 0 {
+		held |= byte(m.buttons) & 0x0F
+	}
+	if sel&0x20 == 0 {
+		held |= byte(m.buttons>>4) & 0x0F
+	}
+	return 0xC0 | sel | (0x0F &^ held)
+}
@@ -0,0 +1,29 @@
+package mmu
+
+import "testing"
+
+func TestMMU_readP1(t *testing.T) {
+	tests := []struct {
+		name    string
+		buttons Buttons
+		sel     byte
+		want    byte
+	}{
+		{"nothing held", 0, 0x10, 0xDF},
+		{"directions", ButtonUp | ButtonA, 0x20, 0xEB},
+		{"actions", ButtonUp | ButtonA | ButtonStart, 0x10, 0xD6},
+		{"both groups", ButtonRight | ButtonB, 0x00, 0xCC},
+		{"neither group", ButtonRight | ButtonB, 0x30, 0xFF},
+		{"only select bits are written", ButtonDown, 0xE7, 0xE7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(MMUOptions{})
+			m.SetButtons(tt.buttons)
+			m.CPUInterface.Wb(AddrP1, tt.sel)
+			if got := m.CPUInterface.Rb(AddrP1); got != tt.want {
+				t.Errorf("P1 = $%02X, want $%02X", got, tt.want)
+			}
+		})
+	}
+}
@@ -23,6 +23,7 @@ const (
 	AddrOamRAM                = 0xFE00
 	AddrIORegs                = 0xFF00
 
+	AddrP1      = 0xFF00
 	AddrLCDC    = 0xFF40
 	AddrLCDStat = 0xFF41
 	AddrSCY     = 0xFF42
@@ -36,6 +37,20 @@ const (
 	AddrWY      = 0xFF4A
 	AddrWX      = 0xFF4B
 
+	// Game Boy Color registers (see cgb.go and hdma.go).
+	AddrKEY1  = 0xFF4D
+	AddrVBK   = 0xFF4F
+	AddrHDMA1 = 0xFF51
+	AddrHDMA2 = 0xFF52
+	AddrHDMA3 = 0xFF53
+	AddrHDMA4 = 0xFF54
+	AddrHDMA5 = 0xFF55
+	AddrBCPS  = 0xFF68
+	AddrBCPD  = 0xFF69
+	AddrOCPS  = 0xFF6A
+	AddrOCPD  = 0xFF6B
+	AddrSVBK  = 0xFF70
+
 	AddrHighRAM            = 0xFF80
 	AddrInterruptEnableReg = 0xFFFF
 )
@@ -47,11 +62,28 @@ type MMU struct {
 	gameRom      []byte
 	bootRom      []byte
 	mapBootRom   bool
+	buttons      Buttons // see joypad.go
+
+	// See cgb.go and hdma.go.
+	cgb         bool
+	vram1       [vramSize]byte
+	wramBanks   [wramBanks - 2][wramBankSize]byte
+	bgPalettes  [paletteRAMSize]byte
+	objPalettes [paletteRAMSize]byte
+	stall       int
+
+	// See hooks.go.
+	hooks      []hook
+	hookKinds  *[0x10000]AccessKind
+	nextHookID HookID
+	pc         func() uint16
 }
 
 type MMUOptions struct {
 	GameRom io.Reader
 	BootRom io.Reader
+	// Model chooses whether the game runs in Game Boy Color mode.
+	Model Model
 }
 
 func New(opt MMUOptions) *MMU {
@@ -84,6 +116,11 @@ func New(opt MMUOptions) *MMU {
 			m.Mem[i] = m.gameRom[i]
 		}
 	}
+	// Only the DMG's boot ROM is supported, and games booted with it run
+	// as they would on a DMG, unless the model is forced.
+	if opt.Model.CGB(m.gameRom) && (opt.BootRom == nil || opt.Model == ModelCGB) {
+		m.startCGB()
+	}
 	return m
 }
 
@@ -96,6 +133,10 @@ func (m *MMU) Dump(out io.Writer) {
 
 func (m *MMU) rb(addr uint16) byte {
 	switch {
+	case addr == AddrP1:
+		return m.readP1()
+	case m.cgb && cgbMapped(addr):
+		return m.rbCGB(addr)
 	case addr < 0x0100:
 		if m.mapBootRom {
 			return m.bootRom[addr]
@@ -108,25 +149,124 @@ func (m *MMU) rb(addr uint16) byte {
 
 func (m *MMU) wb(addr uint16, b byte) {
 	// TODO Handle memory mapped registers
+	if m.cgb && cgbMapped(addr) {
+		m.wbCGB(addr, b)
+		return
+	}
 	switch addr {
 	case 0xff50: // writing 0x1 to $ff50 unmaps the boot ROM from memory.
 		if b == 0x1 {
 			m.mapBootRom = false
 		}
+	case AddrP1:
+		m.Mem[addr] = b & p1Select
+	case AddrLCDStat:
+		// The mode and the LY=LYC flag are set by the PPU.
+		m.Mem[addr] = b&^statReadOnly | m.Mem[addr]&statReadOnly
+	case AddrLY:
+		// LY is set by the PPU.
+	case AddrDMA:
+		m.Mem[addr] = b
+		m.dma(b)
 	default:
 		m.Mem[addr] = b
 	}
 }
 
+// statReadOnly are the bits of STAT that can't be written.
+const statReadOnly = 0b0000_0111
+
+// oamSize is the size of OAM, the sprite attribute table at $FE00.
+const oamSize = 0xA0
+
+// dma copies a page of memory to OAM, starting from the address src<<8.
+// TODO The real transfer takes 160 machine cycles, during which the CPU can
+// only access HRAM; this copies everything at once.
+func (m *MMU) dma(src byte) {
+	from := uint16(src) << 8
+	for i := uint16(0); i < oamSize; i++ {
+		b := m.rb(from + i)
+		if m.hooked(from+i, HookRead) {
+			m.runHooks(DMA, from+i, b, HookRead)
+		}
+		m.Mem[AddrOamRAM+i] = b
+		if m.hooked(AddrOamRAM+i, HookWrite) {
+			m.runHooks(DMA, AddrOamRAM+i, b, HookWrite)
+		}
+	}
+}
+
+// rw reads a word. The Gameboy is little-endian, so the low byte
+// of the word is at addr and the high byte is at addr+1.
 func (m *MMU) rw(addr uint16) uint16 {
-	hi := m.Mem[addr]
-	lo := m.Mem[addr+1]
+	lo := m.rb(addr)
+	hi := m.rb(addr + 1)
 	return uint16(hi)<<8 | uint16(lo)
 }
 
+// ww writes a word, low byte first (see rw).
 func (m *MMU) ww(addr uint16, w uint16) {
 	hi := byte(w >> 8)
 	lo := byte(w)
-	m.Mem[addr] = hi
-	m.Mem[addr+1] = lo
+	m.wb(addr, lo)
+	m.wb(addr+1, hi)
+}
+
+// BankAt returns the number of the bank mapped at addr, for addresses in
+// banked regions: ROM at $4000-$7FFF and work RAM at $D000-$DFFF. It returns
+// 0 for addresses outside of banked regions. There's no memory bank
+// controller yet, so ROM is always bank 1, as is work RAM except in Game Boy
+// Color mode.
+func (m *MMU) BankAt(addr uint16) int {
+	switch {
+	case addr >= AddrCartRomSwitchableBank && addr < AddrVRAM:
+		return 1
+	case addr >= AddrWorkRAMSwitchableBank && addr < AddrEchoRAM:
+		if m.cgb {
+			return m.wramBank()
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+// BootROMMapped returns true if the boot ROM is mapped over the start of the
+// game ROM, i.e. reads from $0000-$00FF come from the boot ROM.
+func (m *MMU) BootROMMapped() bool {
+	return m.mapBootRom
+}
+
+// State is the contents of memory, for saving and restoring, e.g. to rewind.
+// The game ROM isn't included, since it never changes.
+type State struct {
+	Mem           [0x10000]byte
+	BootROMMapped bool
+	Buttons       Buttons
+	// VRAM1 is the Game Boy Color's second bank of VRAM, WRAMBanks its
+	// banks 2-7 of work RAM (bank 1 is in Mem), and BGPalettes and
+	// OBJPalettes its color palette RAM. They're zero on the DMG.
+	VRAM1                   [vramSize]byte
+	WRAMBanks               [wramBanks - 2][wramBankSize]byte
+	BGPalettes, OBJPalettes [paletteRAMSize]byte
+	// Stall is the number of dots the CPU has been stopped for by HDMA
+	// that haven't been taken yet (see TakeStall).
+	Stall int
+}
+
+// State returns the contents of memory.
+func (m *MMU) State() *State {
+	s := &State{BootROMMapped: m.mapBootRom, Buttons: m.buttons, Stall: m.stall}
+	copy(s.Mem[:], m.Mem)
+	s.VRAM1, s.WRAMBanks, s.BGPalettes, s.OBJPalettes = m.vram1, m.wramBanks, m.bgPalettes, m.objPalettes
+	return s
+}
+
+// SetState restores memory from a State, without running any hooks.
+func (m *MMU) SetState(s *State) {
+	copy(m.Mem, s.Mem[:])
+	m.mapBootRom = s.BootROMMapped
+	m.buttons = s.Buttons
+	m.stall = s.Stall
+	m.vram1, m.wramBanks, m.bgPalettes, m.objPalettes = s.VRAM1, s.WRAMBanks, s.BGPalettes, s.OBJPalettes
 }
@@ -0,0 +1,26 @@
+package mmu
+
+import "testing"
+
+func TestMMU_readOnlyRegisters(t *testing.T) {
+	tests := []struct {
+		name       string
+		addr       uint16
+		ppu, write byte
+		want       byte
+	}{
+		{"STAT mode and LY=LYC flag", AddrLCDStat, 0b0000_0111, 0b0111_1000, 0b0111_1111},
+		{"STAT interrupt enables", AddrLCDStat, 0b0000_0010, 0b0000_0000, 0b0000_0010},
+		{"LY", AddrLY, 100, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(MMUOptions{})
+			m.PPUInterface.Wb(tt.addr, tt.ppu)
+			m.CPUInterface.Wb(tt.addr, tt.write)
+			if got := m.CPUInterface.Rb(tt.addr); got != tt.want {
+				t.Errorf("Read $%02X, want $%02X", got, tt.want)
+			}
+		})
+	}
+}
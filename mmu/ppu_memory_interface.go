@@ -6,24 +6,61 @@ type ppuMemoryInterface struct {
 
 func (pmi *ppuMemoryInterface) Rb(addr uint16) byte {
 	m := pmi.mmu
-	return m.Mem[addr]
+	b := m.Mem[addr]
+	if m.hooked(addr, HookRead) {
+		m.runHooks(PPU, addr, b, HookRead)
+	}
+	return b
 }
 func (pmi *ppuMemoryInterface) Wb(addr uint16, b byte) {
 	m := pmi.mmu
 	m.Mem[addr] = b
+	if m.hooked(addr, HookWrite) {
+		m.runHooks(PPU, addr, b, HookWrite)
+	}
 }
 
-func (pmi *ppuMemoryInterface) Rw(addr uint16) uint16 {
+// CGB reports whether the game runs in Game Boy Color mode (see cgb.go).
+func (pmi *ppuMemoryInterface) CGB() bool {
+	return pmi.mmu.cgb
+}
+
+// RbVRAM reads a byte from the given bank of VRAM, 0 or 1, whichever bank
+// the CPU has selected (see MMU.PeekVRAM).
+func (pmi *ppuMemoryInterface) RbVRAM(bank byte, addr uint16) byte {
+	if bank == 0 {
+		return pmi.Rb(addr)
+	}
 	m := pmi.mmu
-	hi := m.Mem[addr]
-	lo := m.Mem[addr+1]
+	b := m.PeekVRAM(1, addr)
+	if m.hooked(addr, HookRead) {
+		m.runHooks(PPU, addr, b, HookRead)
+	}
+	return b
+}
+
+// RbPalette reads byte i of the background palette RAM, or the sprite
+// palette RAM if obj is set.
+func (pmi *ppuMemoryInterface) RbPalette(obj bool, i byte) byte {
+	return pmi.mmu.PeekPalette(obj, i)
+}
+
+// HBlank copies a block of HDMA, if an HBlank transfer is running (see
+// hdma.go). The PPU calls it as it enters HBlank on a visible line.
+func (pmi *ppuMemoryInterface) HBlank() {
+	pmi.mmu.hblank()
+}
+
+// Rw reads a word. The Gameboy is little-endian, so the low byte
+// of the word is at addr and the high byte is at addr+1.
+func (pmi *ppuMemoryInterface) Rw(addr uint16) uint16 {
+	lo := pmi.Rb(addr)
+	hi := pmi.Rb(addr + 1)
 	return uint16(hi)<<8 | uint16(lo)
 }
 
+// Ww writes a word, low byte first (see Rw).
 func (pmi *ppuMemoryInterface) Ww(addr uint16, w uint16) {
-	m := pmi.mmu
-	hi := byte(w >> 8)
-	lo := byte(w)
-	m.Mem[addr] = hi
-	m.Mem[addr+1] = lo
+	pmi.Wb(addr, byte(w))
+	pmi.Wb(addr+1, byte(w>>8))
 }
@@ -0,0 +1,147 @@
+package movie
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// bk2Buttons are the names BizHawk gives the Game Boy's buttons in its
+// input logs.
+var bk2Buttons = map[string]mmu.Buttons{
+	"Up": mmu.ButtonUp, "Down": mmu.ButtonDown, "Left": mmu.ButtonLeft, "Right": mmu.ButtonRight,
+	"Start": mmu.ButtonStart, "Select": mmu.ButtonSelect, "B": mmu.ButtonB, "A": mmu.ButtonA,
+}
+
+// ReadBK2 imports a Game Boy movie recorded by BizHawk. A .bk2 file is a
+// zip archive whose Header.txt has the ROM's hash, and whose Input Log.txt
+// has a line of input per frame:
+//
+//	[Input]
+//	LogKey:#Up|Down|Left|Right|Start|Select|B|A|Power|
+//	|.........|
+//	|U......A.|
+//	[/Input]
+//
+// Buttons that the Game Boy doesn't have, such as Power, are ignored.
+// Imported movies have no state hashes, so desyncs can't be detected, and
+// movies that start from a BizHawk savestate can't be imported.
+func ReadBK2(r io.ReaderAt, size int64) (*Movie, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("reading bk2: %v", err)
+	}
+	m := &Movie{Emulator: "BizHawk"}
+	var gotHeader, gotInput bool
+	for _, f := range z.File {
+		switch f.Name {
+		case "Header.txt":
+			err = readBK2File(f, func(r io.Reader) error { return readBK2Header(r, m) })
+			gotHeader = true
+		case "Input Log.txt":
+			err = readBK2File(f, func(r io.Reader) error { return readBK2Input(r, m) })
+			gotInput = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading bk2 %s: %v", f.Name, err)
+		}
+	}
+	if !gotHeader || !gotInput {
+		return nil, errors.New("reading bk2: missing Header.txt or Input Log.txt")
+	}
+	return m, nil
+}
+
+func readBK2File(f *zip.File, read func(io.Reader) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return read(rc)
+}
+
+func readBK2Header(r io.Reader, m *Movie) error {
+	s := bufio.NewScanner(r)
+	gotSHA1 := false
+	for s.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(s.Text()), " ", 2)
+		if len(fields) < 2 {
+			continue
+		}
+		key, value := fields[0], strings.TrimSpace(fields[1])
+		switch key {
+		case "SHA1":
+			b, err := hex.DecodeString(value)
+			if err != nil || len(b) != len(m.ROMSHA1) {
+				return fmt.Errorf("bad SHA1 %q", value)
+			}
+			copy(m.ROMSHA1[:], b)
+			gotSHA1 = true
+		case "emuVersion":
+			m.Emulator = "BizHawk " + strings.TrimPrefix(value, "Version ")
+		case "StartsFromSavestate", "StartsFromSaveRam":
+			if strings.EqualFold(value, "true") {
+				return errors.New("movies that start from a BizHawk savestate or save RAM aren't supported")
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if !gotSHA1 {
+		return errors.New("no SHA1")
+	}
+	return nil
+}
+
+func readBK2Input(r io.Reader, m *Movie) error {
+	// groups are the buttons in each |-separated group of a line, from the
+	// LogKey.
+	var groups [][]mmu.Buttons
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, "LogKey:"):
+			groups = nil
+			for _, group := range strings.Split(strings.TrimPrefix(line, "LogKey:"), "#")[1:] {
+				var buttons []mmu.Buttons
+				for _, name := range strings.Split(group, "|") {
+					if name != "" {
+						// Unknown buttons are 0, so they're ignored.
+						buttons = append(buttons, bk2Buttons[name])
+					}
+				}
+				groups = append(groups, buttons)
+			}
+		case strings.HasPrefix(line, "|"):
+			if groups == nil {
+				return errors.New("input before the LogKey")
+			}
+			var f Frame
+			fields := strings.Split(strings.Trim(line, "|"), "|")
+			if len(fields) != len(groups) {
+				return fmt.Errorf("bad input %q for the LogKey", line)
+			}
+			for i, field := range fields {
+				if len(field) != len(groups[i]) {
+					return fmt.Errorf("bad input %q for the LogKey", line)
+				}
+				for j := range field {
+					if field[j] != '.' {
+						f.Input |= groups[i][j]
+					}
+				}
+			}
+			m.Frames = append(m.Frames, f)
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,353 @@
+// Package movie records and plays back the joypad input of a game, frame by
+// frame, so that a run of the game can be reproduced exactly, e.g. to report
+// a bug.
+//
+// A movie records where the game started (at power-on, or from a saved
+// state), the SHA-1 hash of the ROM, the emulator that recorded it, and the
+// Game Boy it emulated, as well as the input, and a hash of the machine's
+// state every so often, so that playback can check it hasn't desynced.
+// Movies are text files:
+//
+//	gameboy-emu movie 1
+//	rom-sha1 8e4cd2bd4e4c8a0f9e5d1f0e2b8d6e9a4f2c0b1d
+//	emulator gameboy-emu (devel)
+//	model dmg
+//	boot true
+//	hash-interval 60
+//	input
+//	........
+//	.......A
+//	U.......  b3e4c1a29f0d6e57
+//
+// Each line after "input" is a frame, with a letter for each button held,
+// in the order Up, Down, Left, Right, Start, Select, B, A, and dots for the
+// rest, followed by the state hash on every hash-interval'th frame. "model"
+// is dmg or cgb, whether the game ran in Game Boy Color mode, and "boot" is
+// whether it started by running the DMG's boot ROM. A movie that starts
+// from a saved state has a "state" line with the state in base64 before
+// "input".
+//
+// BizHawk's movies can be imported with ReadBK2.
+package movie
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+const header = "gameboy-emu movie 1"
+
+// DefaultHashInterval is the number of frames between state hashes in
+// recorded movies: one a second.
+const DefaultHashInterval = 60
+
+// Movie is a recording of a game's input.
+type Movie struct {
+	ROMSHA1  [sha1.Size]byte
+	Emulator string
+	// Model is the Game Boy the movie was recorded on, ModelDMG or
+	// ModelCGB, and Boot is set if the game started by running the DMG's
+	// boot ROM. Movies that don't record them, such as imported ones, have
+	// ModelAuto.
+	Model mmu.Model
+	Boot  bool
+	// State is the saved state the movie starts from (see the savestate
+	// package), or nil if it starts at power-on.
+	State []byte
+	// HashInterval is the number of frames between state hashes, or 0 if
+	// the movie has none.
+	HashInterval int
+	Frames       []Frame
+}
+
+// Frame is the input for one video frame.
+type Frame struct {
+	Input mmu.Buttons
+	// Hash is the hash of the machine's state at the end of the frame, if
+	// HasHash is set.
+	Hash    uint64
+	HasHash bool
+}
+
+// buttonLetters are the letters for each button in a frame's input, in
+// the order they appear.
+var buttonLetters = []struct {
+	b      mmu.Buttons
+	letter byte
+}{
+	{mmu.ButtonUp, 'U'}, {mmu.ButtonDown, 'D'}, {mmu.ButtonLeft, 'L'}, {mmu.ButtonRight, 'R'},
+	{mmu.ButtonStart, 'S'}, {mmu.ButtonSelect, 's'}, {mmu.ButtonB, 'B'}, {mmu.ButtonA, 'A'},
+}
+
+// Write writes the movie.
+func (m *Movie) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, header)
+	fmt.Fprintf(bw, "rom-sha1 %x\n", m.ROMSHA1)
+	fmt.Fprintf(bw, "emulator %s\n", m.Emulator)
+	if m.Model != mmu.ModelAuto {
+		fmt.Fprintf(bw, "model %s\n", m.Model)
+		fmt.Fprintf(bw, "boot %t\n", m.Boot)
+	}
+	fmt.Fprintf(bw, "hash-interval %d\n", m.HashInterval)
+	if m.State != nil {
+		fmt.Fprintf(bw, "state %s\n", base64.StdEncoding.EncodeToString(m.State))
+	}
+	fmt.Fprintln(bw, "input")
+	line := make([]byte, len(buttonLetters))
+	for _, f := range m.Frames {
+		for i, b := range buttonLetters {
+			line[i] = '.'
+			if f.Input&b.b != 0 {
+				line[i] = b.letter
+			}
+		}
+		bw.Write(line)
+		if f.HasHash {
+			fmt.Fprintf(bw, "  %016x", f.Hash)
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// Read reads a movie written by Write.
+func Read(r io.Reader) (*Movie, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20) // for the state
+	line := 0
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+	}
+	if !s.Scan() || s.Text() != header {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("not a movie")
+	}
+	line++
+
+	m := &Movie{}
+	for s.Scan() {
+		line++
+		text := s.Text()
+		if text == "input" {
+			break
+		}
+		key, value := text, ""
+		if i := strings.IndexByte(text, ' '); i >= 0 {
+			key, value = text[:i], text[i+1:]
+		}
+		switch key {
+		case "rom-sha1":
+			b, err := hex.DecodeString(value)
+			if err != nil || len(b) != sha1.Size {
+				return nil, errorf("bad ROM hash %q", value)
+			}
+			copy(m.ROMSHA1[:], b)
+		case "emulator":
+			m.Emulator = value
+		case "model":
+			model, err := mmu.ParseModel(value)
+			if err != nil || model == mmu.ModelAuto {
+				return nil, errorf("bad model %q", value)
+			}
+			m.Model = model
+		case "boot":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, errorf("bad boot %q", value)
+			}
+			m.Boot = b
+		case "hash-interval":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, errorf("bad hash interval %q", value)
+			}
+			m.HashInterval = n
+		case "state":
+			b, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, errorf("bad state: %v", err)
+			}
+			m.State = b
+		default:
+			// Ignore unknown keys, so that newer movies can add some.
+		}
+	}
+	for s.Scan() {
+		line++
+		f, err := parseFrame(s.Text())
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		m.Frames = append(m.Frames, f)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadFile reads a movie from a file, importing it with ReadBK2 if it's a
+// BizHawk .bk2 file.
+func ReadFile(path string) (*Movie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".bk2") {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return ReadBK2(f, info.Size())
+	}
+	return Read(f)
+}
+
+func parseFrame(text string) (Frame, error) {
+	var f Frame
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 || len(fields[0]) != len(buttonLetters) {
+		return f, fmt.Errorf("bad frame %q", text)
+	}
+	for i, b := range buttonLetters {
+		switch fields[0][i] {
+		case b.letter:
+			f.Input |= b.b
+		case '.':
+		default:
+			return f, fmt.Errorf("bad frame %q", text)
+		}
+	}
+	if len(fields) == 2 {
+		h, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			return f, fmt.Errorf("bad hash %q", fields[1])
+		}
+		f.Hash, f.HasHash = h, true
+	}
+	return f, nil
+}
+
+// Recorder records a movie as a game is played.
+type Recorder struct {
+	Movie *Movie
+}
+
+// NewRecorder starts recording a movie of rom. state is the saved state the
+// game starts from, or nil if it starts at power-on. cgb is whether the
+// game runs in Game Boy Color mode, and boot whether it started by running
+// the DMG's boot ROM.
+func NewRecorder(rom, state []byte, cgb, boot bool, emulator string) *Recorder {
+	model := mmu.ModelDMG
+	if cgb {
+		model = mmu.ModelCGB
+	}
+	return &Recorder{Movie: &Movie{
+		ROMSHA1:      sha1.Sum(rom),
+		Emulator:     emulator,
+		Model:        model,
+		Boot:         boot,
+		State:        state,
+		HashInterval: DefaultHashInterval,
+	}}
+}
+
+// EndFrame records the input held during the frame that just ended. hash
+// returns the hash of the machine's state (see savestate.HashState), and is
+// called every HashInterval frames.
+func (r *Recorder) EndFrame(input mmu.Buttons, hash func() uint64) {
+	f := Frame{Input: input}
+	if n := r.Movie.HashInterval; n > 0 && (len(r.Movie.Frames)+1)%n == 0 {
+		f.Hash, f.HasHash = hash(), true
+	}
+	r.Movie.Frames = append(r.Movie.Frames, f)
+}
+
+// Player plays back a movie.
+type Player struct {
+	m        *Movie
+	frame    int
+	desynced bool
+}
+
+// NewPlayer returns a player for a movie of rom. It returns an error if the
+// movie was recorded with a different ROM.
+func NewPlayer(m *Movie, rom []byte) (*Player, error) {
+	if sum := sha1.Sum(rom); !bytes.Equal(sum[:], m.ROMSHA1[:]) {
+		return nil, fmt.Errorf("the movie was recorded with a ROM with SHA-1 %x, but this ROM's is %x", m.ROMSHA1, sum)
+	}
+	return &Player{m: m}, nil
+}
+
+// PlaybackModel returns the model to play the movie back on, given the one
+// asked for: the movie's, if it records one, which model has to be
+// ModelAuto or match.
+func (m *Movie) PlaybackModel(model mmu.Model) (mmu.Model, error) {
+	if m.Model == mmu.ModelAuto {
+		return model, nil
+	}
+	if model != mmu.ModelAuto && model != m.Model {
+		return 0, fmt.Errorf("the movie was recorded with model %s, not %s", m.Model, model)
+	}
+	return m.Model, nil
+}
+
+// DesyncError is returned when the state of the machine during playback
+// differs from the state it was in when the movie was recorded.
+type DesyncError struct {
+	Frame      int
+	Want, Have uint64
+}
+
+func (e *DesyncError) Error() string {
+	return fmt.Sprintf("movie desynced at frame %d: the state's hash is %016x, but was %016x when recorded", e.Frame, e.Have, e.Want)
+}
+
+// Frame returns the number of the current frame, counting from 0.
+func (p *Player) Frame() int {
+	return p.frame
+}
+
+// Input returns the input for the current frame. It returns false when the
+// movie has ended.
+func (p *Player) Input() (mmu.Buttons, bool) {
+	if p.frame >= len(p.m.Frames) {
+		return 0, false
+	}
+	return p.m.Frames[p.frame].Input, true
+}
+
+// EndFrame moves on to the next frame. If a hash was recorded at the end of
+// the current one, it checks it against hash(), returning a *DesyncError if
+// they differ. Once the movie has desynced, it stops checking.
+func (p *Player) EndFrame(hash func() uint64) error {
+	if p.frame >= len(p.m.Frames) {
+		return nil
+	}
+	f := p.m.Frames[p.frame]
+	p.frame++
+	if !f.HasHash || p.desynced {
+		return nil
+	}
+	if h := hash(); h != f.Hash {
+		p.desynced = true
+		return &DesyncError{Frame: p.frame - 1, Want: f.Hash, Have: h}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package movie
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+func TestMovie_Write(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     []byte
+		cgb, boot bool
+	}{
+		{"power-on", nil, false, true},
+		{"from a state", []byte("GBSS\x02 and the rest"), false, false},
+		{"in color", nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRecorder([]byte("ROM"), tt.state, tt.cgb, tt.boot, "gameboy-emu test")
+			for i := 0; i < 130; i++ {
+				r.EndFrame(mmu.Buttons(i), func() uint64 { return uint64(i) << 40 })
+			}
+			var b bytes.Buffer
+			if err := r.Movie.Write(&b); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Read(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, r.Movie) {
+				t.Errorf("Read back %+v, want %+v", got, r.Movie)
+			}
+		})
+	}
+}
+
+func TestRead_errors(t *testing.T) {
+	tests := []struct {
+		name, movie string
+	}{
+		{"not a movie", "hello\n"},
+		{"bad hash", header + "\nrom-sha1 1234\ninput\n"},
+		{"bad button", header + "\ninput\n.......X\n"},
+		{"short frame", header + "\ninput\n....\n"},
+		{"bad model", header + "\nmodel auto\ninput\n"},
+		{"bad boot", header + "\nboot maybe\ninput\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(strings.NewReader(tt.movie)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestMovie_PlaybackModel(t *testing.T) {
+	tests := []struct {
+		name          string
+		recorded, ask mmu.Model
+		want          mmu.Model
+		wantErr       bool
+	}{
+		{"the movie's", mmu.ModelCGB, mmu.ModelAuto, mmu.ModelCGB, false},
+		{"matching", mmu.ModelDMG, mmu.ModelDMG, mmu.ModelDMG, false},
+		{"mismatched", mmu.ModelDMG, mmu.ModelCGB, 0, true},
+		{"not recorded", mmu.ModelAuto, mmu.ModelCGB, mmu.ModelCGB, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&Movie{Model: tt.recorded}).PlaybackModel(tt.ask)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("PlaybackModel(%v) = %v, %v; want %v, error %t", tt.ask, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlayer(t *testing.T) {
+	rom := []byte("ROM")
+	r := NewRecorder(rom, nil, false, true, "gameboy-emu test")
+	for i := 0; i < 150; i++ {
+		r.EndFrame(mmu.ButtonA, func() uint64 { return uint64(i) })
+	}
+
+	if _, err := NewPlayer(r.Movie, []byte("another ROM")); err == nil {
+		t.Error("Expected an error playing with a different ROM")
+	}
+	p, err := NewPlayer(r.Movie, rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desyncs []int
+	for i := 0; ; i++ {
+		input, ok := p.Input()
+		if !ok {
+			break
+		}
+		if input != mmu.ButtonA {
+			t.Errorf("Frame %d: input %v, want A", i, input)
+		}
+		// Desync between the first and second hashes.
+		hash := uint64(i)
+		if i > 100 {
+			hash++
+		}
+		if err := p.EndFrame(func() uint64 { return hash }); err != nil {
+			if _, ok := err.(*DesyncError); !ok {
+				t.Fatalf("EndFrame() = %v, want a DesyncError", err)
+			}
+			desyncs = append(desyncs, i)
+		}
+	}
+	if p.Frame() != 150 {
+		t.Errorf("Played %d frames, want 150", p.Frame())
+	}
+	if !reflect.DeepEqual(desyncs, []int{119}) {
+		t.Errorf("Desynced at frames %v, want only 119", desyncs)
+	}
+}
+
+func TestReadBK2(t *testing.T) {
+	var b bytes.Buffer
+	z := zip.NewWriter(&b)
+	for _, f := range []struct{ name, contents string }{
+		{"Header.txt", "MovieVersion BizHawk v2.0.0\nemuVersion Version 2.3.0\nPlatform GB\nSHA1 5DC9D68B1A7B3F9E2B9E7B5E0B3C0B8E1F2A3B4C\n"},
+		{"Input Log.txt", "[Input]\nLogKey:#Up|Down|Left|Right|Start|Select|B|A|Power|\n|.........|\n|U.L....A.|\n|....S...P|\n[/Input]\n"},
+	} {
+		w, err := z.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.contents))
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadBK2(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Emulator != "BizHawk 2.3.0" || m.ROMSHA1[0] != 0x5D || m.ROMSHA1[19] != 0x4C {
+		t.Errorf("Got emulator %q and ROM hash %x", m.Emulator, m.ROMSHA1)
+	}
+	want := []Frame{{}, {Input: mmu.ButtonUp | mmu.ButtonLeft | mmu.ButtonA}, {Input: mmu.ButtonStart}}
+	if !reflect.DeepEqual(m.Frames, want) {
+		t.Errorf("Frames = %+v, want %+v", m.Frames, want)
+	}
+}
@@ -0,0 +1,261 @@
+// Package oamview decodes the 40 sprites in OAM, to help diagnose sprite
+// bugs such as flicker: their positions, tiles and flags, whether they're
+// on screen, and which scanlines the PPU drew them on in the last frame and
+// which it dropped them from because ten sprites were already on the line.
+// It also draws them as a sprite sheet, in Game Boy Color mode with their
+// tiles' banks and color palettes.
+//
+//	o := oamview.Read(m.Peek, p.LastSpriteSelection(), m)
+//	o.Write(os.Stdout)
+package oamview
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+	"github.com/mpingram/gameboy-emu/vramview"
+)
+
+// Sprites is the number of sprites in OAM.
+const Sprites = 40
+
+// Sprite flags.
+const (
+	FlagBehindBG = 1 << 7
+	FlagFlipY    = 1 << 6
+	FlagFlipX    = 1 << 5
+	FlagOBP1     = 1 << 4
+	// FlagBank and the bits in MaskCGBPalette are only used on the CGB.
+	FlagBank       = 1 << 3
+	MaskCGBPalette = 0b111
+)
+
+// Sprite is a decoded OAM entry.
+type Sprite struct {
+	// Index is the entry's number in OAM, from 0.
+	Index int
+	// Y and X are the entry's coordinates, which are the screen's plus 16
+	// and 8, so that sprites can be partly off the top and left.
+	Y, X, Tile, Flags byte
+	// Height is 8, or 16 if LCDC bit 2 is set, in which case the sprite is
+	// the tile pair Tile&$FE and Tile|1.
+	Height int
+	// Selected and Dropped are the scanlines of the last frame the PPU
+	// drew the sprite on, and those it was on but dropped from because ten
+	// sprites earlier in OAM were already on the line.
+	Selected, Dropped []int
+}
+
+// ScreenX and ScreenY return the position of the sprite's top left on the
+// screen.
+func (s *Sprite) ScreenX() int { return int(s.X) - 8 }
+func (s *Sprite) ScreenY() int { return int(s.Y) - 16 }
+
+// OnScreen reports whether any of the sprite is on the screen.
+func (s *Sprite) OnScreen() bool {
+	x, y := s.ScreenX(), s.ScreenY()
+	return x > -8 && x < ppu.ScreenWidth && y > -s.Height && y < ppu.ScreenHeight
+}
+
+// BehindBG reports whether the sprite is drawn behind background colors
+// 1-3.
+func (s *Sprite) BehindBG() bool { return s.Flags&FlagBehindBG != 0 }
+
+// FlipX and FlipY report whether the sprite is flipped.
+func (s *Sprite) FlipX() bool { return s.Flags&FlagFlipX != 0 }
+func (s *Sprite) FlipY() bool { return s.Flags&FlagFlipY != 0 }
+
+// Palette returns the DMG palette the sprite uses: 0 for OBP0 or 1 for
+// OBP1.
+func (s *Sprite) Palette() int { return int(s.Flags&FlagOBP1) >> 4 }
+
+// Bank and CGBPalette return the VRAM bank of the sprite's tile and its
+// palette on the CGB.
+func (s *Sprite) Bank() int       { return int(s.Flags&FlagBank) >> 3 }
+func (s *Sprite) CGBPalette() int { return int(s.Flags & MaskCGBPalette) }
+
+// OAM is a snapshot of the sprites and what's needed to draw them.
+type OAM struct {
+	Sprites [Sprites]Sprite
+	// OBP0 and OBP1 are the sprite palette registers.
+	OBP0, OBP1 byte
+	// CGB is set in Game Boy Color mode.
+	CGB bool
+	// tiles is VRAM from $8000 to $8FFF, where sprites' tiles are, in
+	// each bank there is, and objPalettes the sprite palette RAM in Game
+	// Boy Color mode.
+	tiles       []byte
+	objPalettes []byte
+}
+
+// tileBank is the size of the part of a bank of VRAM with sprites' tiles.
+const tileBank = 0x1000
+
+// Read takes a snapshot of OAM and the sprites' tiles through read, e.g. an
+// MMU's Peek method, with the scanlines they were drawn on in sel, e.g.
+// from ppu.PPU.LastSpriteSelection. In Game Boy Color mode, the tiles and
+// palettes are read from color instead, which may be nil on the DMG.
+func Read(read func(addr uint16) byte, sel ppu.SpriteSelection, color vramview.ColorMemory) *OAM {
+	o := &OAM{OBP0: read(mmu.AddrOBP0), OBP1: read(mmu.AddrOBP1), tiles: make([]byte, tileBank)}
+	height := 8
+	if read(mmu.AddrLCDC)&0b100 != 0 {
+		height = 16
+	}
+	for i := range o.Sprites {
+		addr := mmu.AddrOamRAM + uint16(i*4)
+		o.Sprites[i] = Sprite{
+			Index:    i,
+			Y:        read(addr),
+			X:        read(addr + 1),
+			Tile:     read(addr + 2),
+			Flags:    read(addr + 3),
+			Height:   height,
+			Selected: sel.Selected[i].List(),
+			Dropped:  sel.Dropped[i].List(),
+		}
+	}
+	if color != nil && color.CGB() {
+		o.CGB = true
+		o.tiles = make([]byte, 2*tileBank)
+		for i := range o.tiles {
+			o.tiles[i] = color.PeekVRAM(byte(i/tileBank), mmu.AddrVRAM+uint16(i%tileBank))
+		}
+		// Eight palettes of four little-endian RGB555 colors.
+		o.objPalettes = make([]byte, 64)
+		for i := range o.objPalettes {
+			o.objPalettes[i] = color.PeekPalette(true, byte(i))
+		}
+		return o
+	}
+	for i := range o.tiles {
+		o.tiles[i] = read(mmu.AddrVRAM + uint16(i))
+	}
+	return o
+}
+
+// Write writes a table of the sprites to w, a line each.
+func (o *OAM) Write(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, " #   Y   X  screen     tile  flags         drawn on    dropped from"); err != nil {
+		return err
+	}
+	for i := range o.Sprites {
+		s := &o.Sprites[i]
+		pos := fmt.Sprintf("(%d,%d)", s.ScreenX(), s.ScreenY())
+		if !s.OnScreen() {
+			pos = "off"
+		}
+		_, err := fmt.Fprintf(w, "%2d  %02X  %02X  %-9s  %02X    %02X %s  %-10s  %s\n",
+			s.Index, s.Y, s.X, pos, s.Tile, s.Flags, flagString(s), lineRanges(s.Selected), lineRanges(s.Dropped))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flagString describes a sprite's flags in a fixed width: B if it's behind
+// the background, X and Y if it's flipped, 0 or 1 for its DMG palette, and
+// its CGB bank and palette.
+func flagString(s *Sprite) string {
+	b := []byte("---0 b0p0")
+	if s.BehindBG() {
+		b[0] = 'B'
+	}
+	if s.FlipX() {
+		b[1] = 'X'
+	}
+	if s.FlipY() {
+		b[2] = 'Y'
+	}
+	b[3] = byte('0' + s.Palette())
+	b[6] = byte('0' + s.Bank())
+	b[8] = byte('0' + s.CGBPalette())
+	return string(b)
+}
+
+// lineRanges formats a sorted list of scanlines as ranges, e.g. "0-7,20",
+// or "-" if it's empty.
+func lineRanges(lines []int) string {
+	if len(lines) == 0 {
+		return "-"
+	}
+	var ranges []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprint(lines[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+// SheetColumns is the width of the sprite sheet, in sprites.
+const SheetColumns = 8
+
+// Sheet draws the sprites in OAM order, SheetColumns to a row, each as it
+// appears on screen: flipped, and in the colors pal gives its DMG palette,
+// or in Game Boy Color mode in its color palette from its tile's bank.
+// Color 0, which is transparent, is left transparent.
+func (o *OAM) Sheet(pal *palette.Palette) *image.RGBA {
+	height := o.Sprites[0].Height
+	img := image.NewRGBA(image.Rect(0, 0, SheetColumns*8, Sprites/SheetColumns*height))
+	for i := range o.Sprites {
+		s := &o.Sprites[i]
+		obp, layer := o.OBP0, ppu.LayerOBJ0
+		if s.Palette() == 1 {
+			obp, layer = o.OBP1, ppu.LayerOBJ1
+		}
+		tile := int(s.Tile)
+		if height == 16 {
+			tile &^= 1
+		}
+		left, top := i%SheetColumns*8, i/SheetColumns*height
+		for y := 0; y < height; y++ {
+			row := y
+			if s.FlipY() {
+				row = height - 1 - y
+			}
+			addr := tile*16 + row*2
+			if o.CGB {
+				addr += s.Bank() * tileBank
+			}
+			lo, hi := o.tiles[addr], o.tiles[addr+1]
+			for x := 0; x < 8; x++ {
+				bit := uint(7 - x)
+				if s.FlipX() {
+					bit = uint(x)
+				}
+				c := (lo>>bit)&1 | (hi>>bit)&1<<1
+				if c == 0 {
+					continue
+				}
+				if o.CGB {
+					img.SetRGBA(left+x, top+y, o.cgbColor(s.CGBPalette(), c))
+					continue
+				}
+				img.SetRGBA(left+x, top+y, pal.Color(layer, ppu.Pixel(obp>>(c*2)&0b11)))
+			}
+		}
+	}
+	return img
+}
+
+// cgbColor returns color c of a sprite color palette in Game Boy Color
+// mode.
+func (o *OAM) cgbColor(palette int, c byte) color.RGBA {
+	i := palette*8 + int(c)*2
+	r, g, b := (ppu.RGB555(o.objPalettes[i+1])<<8&0x7F00 | ppu.RGB555(o.objPalettes[i])).RGB()
+	return color.RGBA{r, g, b, 0xFF}
+}
@@ -0,0 +1,109 @@
+package oamview
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+// testOAM returns OAM with sprite 0 at the top left of the screen, sprite
+// 1 flipped in both directions with OBP1 and off the right of the screen,
+// and the rest off the top. Tile 1 has a single pixel of color 3 in its top
+// left.
+func testOAM(lcdc byte) *OAM {
+	mem := make([]byte, 0x10000)
+	copy(mem[mmu.AddrOamRAM:], []byte{16, 8, 1, 0, 40, 168, 1, FlagFlipX | FlagFlipY | FlagOBP1 | FlagBank | 5})
+	mem[mmu.AddrVRAM+16] = 0x80
+	mem[mmu.AddrVRAM+17] = 0x80
+	mem[mmu.AddrLCDC] = lcdc
+	mem[mmu.AddrOBP0] = 0b11_10_01_00
+	mem[mmu.AddrOBP1] = 0b01_10_11_00
+	return Read(func(addr uint16) byte { return mem[addr] }, ppu.SpriteSelection{}, nil)
+}
+
+// colorMemory is Game Boy Color memory for Read, with tile 1 in bank 1
+// having a single pixel of color 1 in its top left, and sprite palette 5
+// having color 1 blue.
+type colorMemory struct{}
+
+func (colorMemory) CGB() bool { return true }
+func (colorMemory) PeekVRAM(bank byte, addr uint16) byte {
+	if bank == 1 && addr == mmu.AddrVRAM+16 {
+		return 0x80
+	}
+	return 0
+}
+func (colorMemory) PeekPalette(obj bool, i byte) byte {
+	if obj && i == 5*8+1*2+1 {
+		return 0x7C
+	}
+	return 0
+}
+
+func TestOAM_Write(t *testing.T) {
+	o := testOAM(0)
+	o.Sprites[0].Selected = []int{0, 1, 2, 3, 4, 5, 6, 7, 20}
+	o.Sprites[0].Dropped = []int{8}
+	var b bytes.Buffer
+	if err := o.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(b.String(), "\n")
+	for i, want := range []string{
+		" #   Y   X  screen     tile  flags         drawn on    dropped from",
+		" 0  10  08  (0,0)      01    00 ---0 b0p0  0-7,20      8",
+		" 1  28  A8  off        01    7D -XY1 b1p5  -           -",
+		" 2  00  00  off        00    00 ---0 b0p0  -           -",
+	} {
+		if lines[i] != want {
+			t.Errorf("Line %d is %q, want %q", i, lines[i], want)
+		}
+	}
+}
+
+func TestOAM_Sheet(t *testing.T) {
+	pal := &palette.Palette{
+		OBJ0: palette.Shades{3: {0xFF, 0, 0, 0xFF}},
+		OBJ1: palette.Shades{1: {0, 0xFF, 0, 0xFF}},
+	}
+	tests := []struct {
+		name string
+		lcdc byte
+		x, y int
+		want color.RGBA
+	}{
+		{"sprite 0", 0, 0, 0, color.RGBA{0xFF, 0, 0, 0xFF}},
+		{"transparent", 0, 1, 0, color.RGBA{}},
+		{"sprite 1 flipped", 0, 15, 7, color.RGBA{0, 0xFF, 0, 0xFF}},
+		// In 8×16 mode, tile 1 is the bottom of tiles 0 and 1.
+		{"8x16", 0b100, 0, 8, color.RGBA{0xFF, 0, 0, 0xFF}},
+		{"8x16 flipped", 0b100, 15, 7, color.RGBA{0, 0xFF, 0, 0xFF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testOAM(tt.lcdc).Sheet(pal).RGBAAt(tt.x, tt.y); got != tt.want {
+				t.Errorf("Pixel (%d, %d) is %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAM_Sheet_color(t *testing.T) {
+	mem := make([]byte, 0x10000)
+	copy(mem[mmu.AddrOamRAM:], []byte{16, 8, 1, 0, 40, 168, 1, FlagFlipX | FlagFlipY | FlagBank | 5})
+	o := Read(func(addr uint16) byte { return mem[addr] }, ppu.SpriteSelection{}, colorMemory{})
+	img := o.Sheet(palette.Default)
+	// Sprite 0's tile in bank 0 is blank, and sprite 1's in bank 1 is drawn
+	// flipped.
+	if got := img.RGBAAt(0, 0); got != (color.RGBA{}) {
+		t.Errorf("Sprite 0 is %v, want transparent", got)
+	}
+	if got, want := img.RGBAAt(15, 7), (color.RGBA{0, 0, 0xFF, 0xFF}); got != want {
+		t.Errorf("Sprite 1 is %v, want %v", got, want)
+	}
+}
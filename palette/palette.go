@@ -0,0 +1,296 @@
+// Package palette colors the PPU's frames. The DMG's pixels are one of
+// four shades, which a Palette gives colors, optionally different ones for
+// the background and for sprites drawn through OBP0 and OBP1. Frames drawn
+// in Game Boy Color mode have their own colors, which are used instead.
+//
+// Palettes can be loaded from a small text format, one setting per line:
+//
+//	# Lines starting with # are comments.
+//	name  DMG green
+//	bg    9bbc0f 8bac0f 306230 0f380f
+//	obj0  ffffff ff8484 943a3a 000000
+//	obj1  ffffff 7bff31 008400 000000
+//
+// Colors are given from the lightest shade to the darkest, as six hex
+// digits optionally preceded by #. "colors" sets all three layers at once,
+// and layers that aren't set are the same as bg.
+package palette
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+// Shades are the colors of the four shades, from white to black.
+type Shades [4]color.RGBA
+
+// Palette gives colors to each layer's shades.
+type Palette struct {
+	Name string
+	// BG is the background and window's colors, and OBJ0 and OBJ1 those
+	// of sprites drawn through OBP0 and OBP1.
+	BG, OBJ0, OBJ1 Shades
+}
+
+// Uniform returns a palette that colors every layer the same.
+func Uniform(name string, s Shades) *Palette {
+	return &Palette{Name: name, BG: s, OBJ0: s, OBJ1: s}
+}
+
+// Presets are the built-in palettes. The first is the default.
+var Presets = []*Palette{
+	Uniform("gray", mustParseShades("ffffff 969696 4b4b4b 000000")),
+	Uniform("dmg", mustParseShades("9bbc0f 8bac0f 306230 0f380f")),
+	Uniform("pocket", mustParseShades("c4cfa1 8b956d 4d533c 1f1f1f")),
+	Uniform("light", mustParseShades("00b581 009a71 00694a 004f3b")),
+}
+
+// Default is the palette used when none is chosen.
+var Default = Presets[0]
+
+// Preset returns the built-in palette with the given name.
+func Preset(name string) (*Palette, bool) {
+	for _, p := range Presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Layer returns the colors of a layer's shades.
+func (p *Palette) Layer(l ppu.Layer) *Shades {
+	switch l {
+	case ppu.LayerOBJ0:
+		return &p.OBJ0
+	case ppu.LayerOBJ1:
+		return &p.OBJ1
+	}
+	return &p.BG
+}
+
+// Color returns the color of a shade drawn from a layer.
+func (p *Palette) Color(l ppu.Layer, px ppu.Pixel) color.RGBA {
+	return p.Layer(l)[px&0b11]
+}
+
+// PixelColor returns the color of pixel i of a frame, counting from the top
+// left: the color of its shade, or in a Game Boy Color frame, its own.
+func (p *Palette) PixelColor(f *ppu.Frame, i int) color.RGBA {
+	if f.CGB {
+		return rgb555(f.Colors[i])
+	}
+	return p.Color(f.Layers[i], f.Pixels[i])
+}
+
+func rgb555(c ppu.RGB555) color.RGBA {
+	r, g, b := c.RGB()
+	return color.RGBA{r, g, b, 0xFF}
+}
+
+// RGBA returns a frame in color.
+func (p *Palette) RGBA(f *ppu.Frame) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, ppu.ScreenWidth, ppu.ScreenHeight))
+	p.DrawRGBA(img, f)
+	return img
+}
+
+// DrawRGBA colors a frame into img, which must be at least the size of
+// the screen, so that frontends can reuse an image.
+func (p *Palette) DrawRGBA(img *image.RGBA, f *ppu.Frame) {
+	for y := 0; y < ppu.ScreenHeight; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < ppu.ScreenWidth; x++ {
+			i := y*ppu.ScreenWidth + x
+			c := p.PixelColor(f, i)
+			copy(row[x*4:x*4+4], []byte{c.R, c.G, c.B, c.A})
+		}
+	}
+}
+
+// ColorPalette returns the palette's distinct colors, in layer and then
+// shade order, and the index of each layer's shades in it. A uniform
+// palette has only four colors.
+func (p *Palette) ColorPalette() (color.Palette, [3][4]uint8) {
+	var pal color.Palette
+	var index [3][4]uint8
+	for l := ppu.LayerBG; l <= ppu.LayerOBJ1; l++ {
+	shades:
+		for s, c := range p.Layer(l) {
+			for i, seen := range pal {
+				if seen == color.Color(c) {
+					index[l][s] = uint8(i)
+					continue shades
+				}
+			}
+			index[l][s] = uint8(len(pal))
+			pal = append(pal, c)
+		}
+	}
+	return pal, index
+}
+
+// Paletted returns a frame as a paletted image, with the palette's
+// distinct colors (see ColorPalette), e.g. for encoding as a GIF. A Game
+// Boy Color frame has its own distinct colors instead.
+func (p *Palette) Paletted(f *ppu.Frame) *image.Paletted {
+	if f.CGB {
+		return cgbPaletted(f)
+	}
+	pal, index := p.ColorPalette()
+	img := image.NewPaletted(image.Rect(0, 0, ppu.ScreenWidth, ppu.ScreenHeight), pal)
+	for i, px := range f.Pixels {
+		l := f.Layers[i]
+		if l > ppu.LayerOBJ1 {
+			l = ppu.LayerBG
+		}
+		img.Pix[i] = index[l][px&0b11]
+	}
+	return img
+}
+
+// cgbPaletted returns a Game Boy Color frame as a paletted image, with the
+// colors in the order they first appear. A frame can only have more than
+// 256 colors if its palettes were changed while it was drawn; any after the
+// 256th are drawn in the nearest of those.
+func cgbPaletted(f *ppu.Frame) *image.Paletted {
+	var pal color.Palette
+	index := make(map[ppu.RGB555]uint8)
+	img := image.NewPaletted(image.Rect(0, 0, ppu.ScreenWidth, ppu.ScreenHeight), nil)
+	for i, c := range f.Colors {
+		n, ok := index[c]
+		if !ok {
+			if len(pal) < 256 {
+				n = uint8(len(pal))
+				pal = append(pal, rgb555(c))
+			} else {
+				n = uint8(pal.Index(rgb555(c)))
+			}
+			index[c] = n
+		}
+		img.Pix[i] = n
+	}
+	img.Palette = pal
+	return img
+}
+
+// Scale returns an image scaled up by an integer factor, with each pixel
+// becoming a scale×scale square. It returns img itself if scale is 1 or
+// less.
+func Scale(img *image.Paletted, scale int) *image.Paletted {
+	if scale <= 1 {
+		return img
+	}
+	scaled := image.NewPaletted(image.Rect(0, 0, img.Rect.Dx()*scale, img.Rect.Dy()*scale), img.Palette)
+	for y := 0; y < scaled.Rect.Dy(); y++ {
+		src := img.Pix[y/scale*img.Stride:]
+		dst := scaled.Pix[y*scaled.Stride:]
+		for x := 0; x < scaled.Rect.Dx(); x++ {
+			dst[x] = src[x/scale]
+		}
+	}
+	return scaled
+}
+
+// Load returns a palette chosen on the command line: the name of a preset,
+// a list of four colors for ParseShades, or a file to Read.
+func Load(name string) (*Palette, error) {
+	if p, ok := Preset(name); ok {
+		return p, nil
+	}
+	if s, err := ParseShades(name); err == nil {
+		return Uniform("custom", s), nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads a palette in the text format described in the package
+// comment.
+func Read(r io.Reader) (*Palette, error) {
+	p := &Palette{}
+	var gotBG, gotOBJ0, gotOBJ1 bool
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key, value := fields[0], strings.Join(fields[1:], " ")
+		if key == "name" {
+			p.Name = value
+			continue
+		}
+		shades, err := ParseShades(value)
+		if err != nil {
+			return nil, fmt.Errorf("palette line %d: %v", n, err)
+		}
+		switch key {
+		case "colors":
+			p.BG, p.OBJ0, p.OBJ1 = shades, shades, shades
+			gotBG, gotOBJ0, gotOBJ1 = true, true, true
+		case "bg":
+			p.BG, gotBG = shades, true
+		case "obj0":
+			p.OBJ0, gotOBJ0 = shades, true
+		case "obj1":
+			p.OBJ1, gotOBJ1 = shades, true
+		default:
+			return nil, fmt.Errorf("palette line %d: unknown setting %q", n, key)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if !gotBG {
+		return nil, errors.New("palette has no bg or colors")
+	}
+	if !gotOBJ0 {
+		p.OBJ0 = p.BG
+	}
+	if !gotOBJ1 {
+		p.OBJ1 = p.BG
+	}
+	return p, nil
+}
+
+// ParseShades parses a list of four colors, from the lightest shade to the
+// darkest, as six hex digits each, separated by spaces or commas and
+// optionally preceded by #, e.g. "#e0f8d0 #88c070 #346856 #081820".
+func ParseShades(list string) (Shades, error) {
+	var s Shades
+	colors := strings.FieldsFunc(list, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+	if len(colors) != len(s) {
+		return s, fmt.Errorf("want %d colors, got %d in %q", len(s), len(colors), list)
+	}
+	for i, c := range colors {
+		hex := strings.TrimPrefix(c, "#")
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return s, fmt.Errorf("bad color %q", c)
+		}
+		s[i] = color.RGBA{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n), A: 0xFF}
+	}
+	return s, nil
+}
+
+func mustParseShades(list string) Shades {
+	s, err := ParseShades(list)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package palette
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+var (
+	white = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	red   = color.RGBA{0xFF, 0, 0, 0xFF}
+	green = color.RGBA{0, 0xFF, 0, 0xFF}
+	black = color.RGBA{0, 0, 0, 0xFF}
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       *Palette
+	}{
+		{"colors", "name Test\ncolors ffffff ff0000 00ff00 000000\n",
+			Uniform("Test", Shades{white, red, green, black})},
+		{"layers", "# A comment\nbg #ffffff #ffffff #ffffff #ffffff\n\nobj1 000000,000000,000000,000000\n",
+			&Palette{
+				BG:   Shades{white, white, white, white},
+				OBJ0: Shades{white, white, white, white},
+				OBJ1: Shades{black, black, black, black},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *tt.want {
+				t.Errorf("Read() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRead_errors(t *testing.T) {
+	tests := []struct {
+		name, text string
+	}{
+		{"no bg", "obj0 ffffff ff0000 00ff00 000000\n"},
+		{"too few colors", "bg ffffff ff0000 00ff00\n"},
+		{"bad color", "bg ffffff ff0000 00ff00 00000g\n"},
+		{"short color", "bg ffffff ff0000 00ff00 000\n"},
+		{"unknown setting", "bg ffffff ff0000 00ff00 000000\nwindow ffffff ff0000 00ff00 000000\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(strings.NewReader(tt.text)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		want *Palette
+	}{
+		{"dmg", Presets[1]},
+		{"ffffff ff0000 00ff00 000000", Uniform("custom", Shades{white, red, green, black})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *tt.want {
+				t.Errorf("Load(%q) = %+v, want %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// testFrame has a black BG pixel, a red OBJ0 pixel and a green OBJ1 pixel
+// on a white background, and a palette giving each layer its own color.
+func testFrame() (*ppu.Frame, *Palette) {
+	p := &Palette{
+		BG:   Shades{white, black, black, black},
+		OBJ0: Shades{white, red, red, red},
+		OBJ1: Shades{white, green, green, green},
+	}
+	f := &ppu.Frame{}
+	f.Pixels[0] = ppu.LightGray
+	f.Pixels[1], f.Layers[1] = ppu.DarkGray, ppu.LayerOBJ0
+	f.Pixels[2], f.Layers[2] = ppu.Black, ppu.LayerOBJ1
+	return f, p
+}
+
+func TestPalette_RGBA(t *testing.T) {
+	f, p := testFrame()
+	img := p.RGBA(f)
+	for x, want := range []color.RGBA{black, red, green, white} {
+		if got := img.RGBAAt(x, 0); got != want {
+			t.Errorf("Pixel %d is %v, want %v", x, got, want)
+		}
+	}
+	if got := img.RGBAAt(ppu.ScreenWidth-1, ppu.ScreenHeight-1); got != white {
+		t.Errorf("Bottom right pixel is %v, want white", got)
+	}
+}
+
+func TestPalette_Paletted(t *testing.T) {
+	f, p := testFrame()
+	img := p.Paletted(f)
+	if len(img.Palette) != 4 {
+		t.Errorf("Palette has %d colors, want 4: %v", len(img.Palette), img.Palette)
+	}
+	for x, want := range []color.RGBA{black, red, green, white} {
+		if got := img.At(x, 0); got != want {
+			t.Errorf("Pixel %d is %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestPalette_CGB(t *testing.T) {
+	// Game Boy Color frames keep their own colors, whatever the palette.
+	_, p := testFrame()
+	f := &ppu.Frame{CGB: true}
+	for i := range f.Colors {
+		f.Colors[i] = ppu.WhiteRGB555
+	}
+	f.Colors[0], f.Colors[1], f.Colors[2] = 0, 0x001F, 0x03E0
+	want := []color.RGBA{black, red, green, white}
+	rgba := p.RGBA(f)
+	img := p.Paletted(f)
+	for x, want := range want {
+		if got := rgba.RGBAAt(x, 0); got != want {
+			t.Errorf("RGBA: pixel %d is %v, want %v", x, got, want)
+		}
+		if got := img.At(x, 0); got != want {
+			t.Errorf("Paletted: pixel %d is %v, want %v", x, got, want)
+		}
+	}
+	if len(img.Palette) != 4 {
+		t.Errorf("Palette has %d colors, want 4: %v", len(img.Palette), img.Palette)
+	}
+}
@@ -0,0 +1,85 @@
+package ppu
+
+// In Game Boy Color mode, the PPU draws in color from palette RAM rather
+// than the DMG's palette registers, and each background tile has
+// attributes in a second tile map, in VRAM bank 1 behind the first, giving
+// its palette, the bank of its tile data, whether it's flipped, and whether
+// it's drawn in front of sprites. Sprites choose their palette and tile's
+// bank in their flags, and overlapping sprites are ordered by OAM index
+// alone. LCDC bit 0 no longer hides the background and window; clearing it
+// puts sprites in front of them whatever the priority bits say.
+// See https://gbdev.io/pandocs/Tile_Maps.html#bg-map-attributes-cgb-mode-only
+// and https://gbdev.io/pandocs/Palettes.html#lcd-color-palettes-cgb-only.
+
+// ColorMemory is implemented by memory that has the Game Boy Color's
+// second bank of VRAM and color palette RAM. The PPU draws in color if its
+// memory implements ColorMemory and CGB returns true.
+type ColorMemory interface {
+	// CGB reports whether the game runs in Game Boy Color mode.
+	CGB() bool
+	// RbVRAM reads a byte from a bank of VRAM, 0 or 1.
+	RbVRAM(bank byte, addr uint16) byte
+	// RbPalette reads byte i of the background palette RAM, or the sprite
+	// palette RAM if obj is set. Each holds eight palettes of four
+	// little-endian RGB555 colors.
+	RbPalette(obj bool, i byte) byte
+	// HBlank is called as the PPU enters HBlank on a visible line, for
+	// HBlank DMA.
+	HBlank()
+}
+
+// RGB555 is a Game Boy Color color, with five bits each of red in the low
+// bits, green, and blue.
+type RGB555 uint16
+
+// WhiteRGB555 is white.
+const WhiteRGB555 RGB555 = 0x7FFF
+
+// RGB returns the color's components scaled to eight bits. The colors
+// aren't corrected for the CGB's LCD, so they're more saturated than they
+// looked on one.
+func (c RGB555) RGB() (r, g, b uint8) {
+	scale := func(x RGB555) uint8 {
+		x &= 0x1F
+		return uint8(x<<3 | x>>2)
+	}
+	return scale(c), scale(c >> 5), scale(c >> 10)
+}
+
+// RGBA implements color.Color.
+func (c RGB555) RGBA() (r, g, b, a uint32) {
+	r8, g8, b8 := c.RGB()
+	return uint32(r8) * 0x101, uint32(g8) * 0x101, uint32(b8) * 0x101, 0xFFFF
+}
+
+// Background tile attributes. Sprites' flags use the same bits for their
+// palette and bank.
+const (
+	attrPriority = 1 << 7
+	attrFlipY    = 1 << 6
+	attrFlipX    = 1 << 5
+	attrBank     = 1 << 3
+	attrPalette  = 0b111
+)
+
+// cgb reports whether the PPU is drawing in color.
+func (p *PPU) cgb() bool {
+	return p.color != nil
+}
+
+// rbVRAM reads a byte from VRAM bank 1 if attr selects it in Game Boy
+// Color mode, and bank 0 otherwise.
+func (p *PPU) rbVRAM(attr byte, addr uint16) byte {
+	if p.cgb() && attr&attrBank != 0 {
+		return p.color.RbVRAM(1, addr)
+	}
+	return p.mem.Rb(addr)
+}
+
+// cgbColor returns the color of a pixel drawn from the background, or from
+// a sprite if obj is set, in Game Boy Color mode.
+func (p *PPU) cgbColor(px pixelData, obj bool) RGB555 {
+	i := px.CGBPalette*8 + px.Color*2
+	lo, hi := p.color.RbPalette(obj, i), p.color.RbPalette(obj, i+1)
+	return RGB555(hi)<<8&0x7F00 | RGB555(lo)
+}
@@ -0,0 +1,178 @@
+package ppu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// bgColor and objColor are the colors cgbSetup gives color c of background
+// and sprite palette n.
+func bgColor(n, c int) RGB555  { return RGB555(n*4 + c + 1) }
+func objColor(n, c int) RGB555 { return RGB555(0x100 + n*4 + c) }
+
+// cgbSetup returns a PPU in Game Boy Color mode at the start of scanline
+// 0, set up like fifoSetup's, with palettes colored by bgColor and objColor.
+// Tile 3 has its top row color 1. In VRAM bank 1, tile 1 is all color 1.
+func cgbSetup() (*PPU, *mmu.MMU) {
+	rom := make([]byte, 0x8000)
+	rom[0x0143] = 0x80 // the CGB flag
+	m := mmu.New(mmu.MMUOptions{GameRom: bytes.NewReader(rom)})
+	m.Mem[mmu.AddrLCDC] = 0b1001_0011
+	for row := 0; row < 8; row++ {
+		m.Mem[mmu.AddrVRAM+16+row*2] = 0xFF
+		m.Mem[mmu.AddrVRAM+16+row*2+1] = 0xFF
+		m.Mem[mmu.AddrVRAM+32+row*2] = 0x80
+	}
+	m.Mem[mmu.AddrVRAM+48] = 0xFF
+	m.Poke(mmu.AddrVBK, 1)
+	for row := 0; row < 8; row++ {
+		m.Poke(mmu.AddrVRAM+16+uint16(row)*2, 0xFF)
+	}
+	m.Poke(mmu.AddrVBK, 0)
+	for _, pal := range []struct {
+		spec, data uint16
+		color      func(n, c int) RGB555
+	}{{mmu.AddrBCPS, mmu.AddrBCPD, bgColor}, {mmu.AddrOCPS, mmu.AddrOCPD, objColor}} {
+		m.Poke(pal.spec, 0x80) // auto-increment from 0
+		for i := 0; i < 32; i++ {
+			c := pal.color(i/4, i%4)
+			m.Poke(pal.data, byte(c))
+			m.Poke(pal.data, byte(c>>8))
+		}
+	}
+	return New(m.PPUInterface), m
+}
+
+// setAttr sets the attributes of background tile map entry i.
+func setAttr(m *mmu.MMU, i int, attr byte) {
+	m.Poke(mmu.AddrVBK, 1)
+	m.Poke(mmu.AddrTileMap0+uint16(i), attr)
+	m.Poke(mmu.AddrVBK, 0)
+}
+
+func Test_drawScanlineCGB(t *testing.T) {
+	type sample struct {
+		x     int
+		color RGB555
+		layer Layer
+	}
+	bg := func(x, n, c int) sample { return sample{x, bgColor(n, c), LayerBG} }
+	obj := func(x, n, c int) sample { return sample{x, objColor(n, c), LayerOBJ0} }
+	tests := []struct {
+		name    string
+		setup   func(m *mmu.MMU)
+		samples []sample
+	}{
+		{
+			"palette attribute",
+			func(m *mmu.MMU) {
+				m.Mem[mmu.AddrTileMap0] = 1
+				setAttr(m, 0, 3)
+			},
+			[]sample{bg(0, 3, 3), bg(7, 3, 3), bg(8, 0, 0)},
+		},
+		{
+			"bank attribute",
+			func(m *mmu.MMU) {
+				m.Mem[mmu.AddrTileMap0] = 1
+				setAttr(m, 0, attrBank)
+			},
+			[]sample{bg(0, 0, 1)},
+		},
+		{
+			"X flip",
+			func(m *mmu.MMU) {
+				m.Mem[mmu.AddrTileMap0], m.Mem[mmu.AddrTileMap0+1] = 2, 2
+				setAttr(m, 1, attrFlipX)
+			},
+			[]sample{bg(0, 0, 1), bg(7, 0, 0), bg(8, 0, 0), bg(15, 0, 1)},
+		},
+		{
+			"Y flip",
+			func(m *mmu.MMU) {
+				m.Mem[mmu.AddrTileMap0], m.Mem[mmu.AddrTileMap0+1] = 3, 3
+				setAttr(m, 1, attrFlipY)
+			},
+			[]sample{bg(0, 0, 1), bg(8, 0, 0)},
+		},
+		{
+			"sprite palette and bank",
+			func(m *mmu.MMU) { setSprite(m, 0, 16, 8+8, 1, attrBank|5) },
+			[]sample{bg(7, 0, 0), obj(8, 5, 1), obj(15, 5, 1)},
+		},
+		{
+			// On the DMG, sprite 1 would be in front, being further left.
+			"OAM order",
+			func(m *mmu.MMU) {
+				setSprite(m, 0, 16, 8+12, 1, 1)
+				setSprite(m, 1, 16, 8+8, 1, 2)
+			},
+			[]sample{obj(8, 2, 3), obj(12, 1, 3), obj(19, 1, 3)},
+		},
+		{
+			"priority attribute",
+			func(m *mmu.MMU) {
+				m.Mem[mmu.AddrTileMap0+1] = 1
+				setAttr(m, 1, attrPriority)
+				setSprite(m, 0, 16, 8+4, 1, 0)
+			},
+			// The sprite is still in front of color 0.
+			[]sample{obj(4, 0, 3), bg(8, 0, 3)},
+		},
+		{
+			"LCDC bit 0 off",
+			func(m *mmu.MMU) {
+				m.Mem[mmu.AddrLCDC] &^= 1
+				m.Mem[mmu.AddrTileMap0+1] = 1
+				setAttr(m, 1, attrPriority)
+				setSprite(m, 0, 16, 8+4, 1, spriteBehindBG)
+			},
+			// Sprites are in front, but the background is still drawn.
+			[]sample{obj(4, 0, 3), obj(8, 0, 3), bg(12, 0, 3)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, m := cgbSetup()
+			tt.setup(m)
+			drawLine(p)
+			f := &p.buffers[p.back]
+			for _, s := range tt.samples {
+				if f.Colors[s.x] != s.color || f.Layers[s.x] != s.layer {
+					t.Errorf("Pixel %d is $%04X from layer %d, want $%04X from layer %d", s.x, f.Colors[s.x], f.Layers[s.x], s.color, s.layer)
+				}
+			}
+		})
+	}
+}
+
+func TestPPU_CGBFrame(t *testing.T) {
+	p, _ := cgbSetup()
+	var got *Frame
+	p.AddSink(FrameSinkFunc(func(f *Frame) { got = f }))
+	p.RunFor(154 * 456)
+	if got == nil {
+		t.Fatal("No frame was presented")
+	}
+	if !got.CGB || got.Colors[0] != bgColor(0, 0) {
+		t.Errorf("Frame has CGB %v and top left color $%04X, want true and $%04X", got.CGB, got.Colors[0], bgColor(0, 0))
+	}
+	if want := HashColors(got.Colors[:]); p.FrameHash() != want {
+		t.Errorf("FrameHash() = %x, want %x", p.FrameHash(), want)
+	}
+}
+
+func TestPPU_HBlankHDMA(t *testing.T) {
+	p, m := cgbSetup()
+	// 128 blocks, a block each time the PPU enters HBlank on a visible line.
+	m.Poke(mmu.AddrHDMA5, 0xFF)
+	p.RunFor(10 * 456)
+	if got, want := m.Peek(mmu.AddrHDMA5), byte(0x7F-10); got != want {
+		t.Errorf("HDMA5 = $%02X after 10 lines, want $%02X", got, want)
+	}
+	if got := m.TakeStall(); got != 10*32 {
+		t.Errorf("TakeStall() = %d after 10 lines, want 320", got)
+	}
+}
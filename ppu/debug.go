@@ -0,0 +1,73 @@
+package ppu
+
+import "sync/atomic"
+
+// DebugOptions hide layers of the picture, to tell whether a glitch is in
+// the tiles, the tile maps or OAM. They only change what's drawn: hidden
+// layers are drawn as if they were transparent, but the PPU reads memory
+// and takes the same time as it would otherwise, so the emulated state,
+// and with it the game, are unaffected.
+type DebugOptions struct {
+	HideBG, HideWindow, HideSprites bool
+	// HiddenSprites has bit i set to hide the sprite at OAM index i. A
+	// hidden sprite doesn't reveal sprites it's in front of.
+	HiddenSprites uint64
+}
+
+// debugMask is DebugOptions packed into a word, with the hidden sprites in
+// the low bits.
+type debugMask uint64
+
+const (
+	debugHideBG debugMask = 1 << (61 + iota)
+	debugHideWindow
+	debugHideSprites
+
+	debugSpriteMask = 1<<oamSprites - 1
+)
+
+// SetDebugOptions sets the debug options, which take effect from the next
+// scanline. It may be called from any goroutine.
+func (p *PPU) SetDebugOptions(o DebugOptions) {
+	m := debugMask(o.HiddenSprites) & debugSpriteMask
+	if o.HideBG {
+		m |= debugHideBG
+	}
+	if o.HideWindow {
+		m |= debugHideWindow
+	}
+	if o.HideSprites {
+		m |= debugHideSprites
+	}
+	atomic.StoreUint64(&p.debug, uint64(m))
+}
+
+// DebugOptions returns the debug options. It may be called from any
+// goroutine.
+func (p *PPU) DebugOptions() DebugOptions {
+	m := p.loadDebugOptions()
+	return DebugOptions{
+		HideBG:        m&debugHideBG != 0,
+		HideWindow:    m&debugHideWindow != 0,
+		HideSprites:   m&debugHideSprites != 0,
+		HiddenSprites: uint64(m & debugSpriteMask),
+	}
+}
+
+func (p *PPU) loadDebugOptions() debugMask {
+	return debugMask(atomic.LoadUint64(&p.debug))
+}
+
+// hidesBG reports whether background pixels, or window pixels if window
+// is set, are hidden.
+func (m debugMask) hidesBG(window bool) bool {
+	if window {
+		return m&debugHideWindow != 0
+	}
+	return m&debugHideBG != 0
+}
+
+// hidesSprite reports whether the sprite at an OAM index is hidden.
+func (m debugMask) hidesSprite(i byte) bool {
+	return m&debugHideSprites != 0 || m&(1<<i) != 0
+}
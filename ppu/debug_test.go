@@ -0,0 +1,65 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+func TestPPU_DebugOptions(t *testing.T) {
+	// The background has a tile of color 3 at 40-47, behind sprite 2, and
+	// the window starts at 80 with a tile of color 3. Sprites 0 and 1 are
+	// at 8-15 and 12-19, with sprite 0 in front. Sprites use OBP1, which
+	// gives color 3 light gray.
+	type sample struct {
+		x     int
+		layer Layer
+		px    Pixel
+	}
+	bg := func(x int, px Pixel) sample { return sample{x, LayerBG, px} }
+	obj := func(x int) sample { return sample{x, LayerOBJ1, LightGray} }
+	tests := []struct {
+		name    string
+		opt     DebugOptions
+		samples []sample
+	}{
+		{"nothing hidden", DebugOptions{}, []sample{bg(0, White), obj(8), obj(12), obj(16), bg(40, Black), bg(80, Black), bg(88, White)}},
+		{"background", DebugOptions{HideBG: true}, []sample{obj(8), bg(32, White), obj(40), bg(80, Black)}},
+		{"window", DebugOptions{HideWindow: true}, []sample{obj(8), bg(40, Black), bg(80, White)}},
+		{"sprites", DebugOptions{HideSprites: true}, []sample{bg(8, White), bg(16, White), bg(40, Black)}},
+		// A hidden sprite doesn't reveal the sprite behind it.
+		{"sprite 0", DebugOptions{HiddenSprites: 1 << 0}, []sample{bg(8, White), bg(12, White), obj(16), bg(40, Black)}},
+		{"sprite 1", DebugOptions{HiddenSprites: 1 << 1}, []sample{obj(8), obj(12), bg(16, White)}},
+	}
+	dots := -1
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, m := fifoSetup()
+			m.Mem[mmu.AddrLCDC] |= 0b0110_0000 // the window on, from $9C00
+			m.Mem[mmu.AddrWX] = 80 + 7
+			m.Mem[mmu.AddrTileMap0+5] = 1
+			m.Mem[mmu.AddrTileMap1] = 1
+			setSprite(m, 0, 16, 8+8, 1, spriteOBP1)
+			setSprite(m, 1, 16, 8+12, 1, spriteOBP1)
+			setSprite(m, 2, 16, 8+40, 1, spriteOBP1|spriteBehindBG)
+			p.SetDebugOptions(tt.opt)
+			if got := p.DebugOptions(); got != tt.opt {
+				t.Errorf("DebugOptions() = %+v, want %+v", got, tt.opt)
+			}
+
+			n := drawLine(p)
+			// Hiding layers doesn't change the timing.
+			if dots == -1 {
+				dots = n
+			} else if n != dots {
+				t.Errorf("PixelDrawing took %d dots, want %d", n, dots)
+			}
+			f := &p.buffers[p.back]
+			for _, s := range tt.samples {
+				if f.Layers[s.x] != s.layer || f.Pixels[s.x] != s.px {
+					t.Errorf("Pixel %d is %d from layer %d, want %d from layer %d", s.x, f.Pixels[s.x], f.Layers[s.x], s.px, s.layer)
+				}
+			}
+		})
+	}
+}
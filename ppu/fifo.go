@@ -0,0 +1,537 @@
+package ppu
+
+// The PPU draws a scanline a dot at a time during PixelDrawing (mode 3),
+// the way the hardware does: a fetcher reads the background or window tile
+// by tile into a FIFO, and every dot a pixel is shifted out of the FIFO to
+// the screen, mixed with any sprite pixels shifted out of a second FIFO.
+// Mode 3 lasts 172 dots for a plain background, and longer for:
+//
+//   - SCX's fine scroll: the first SCX%8 pixels of the line are thrown away
+//   - the window: the FIFO is cleared, and the fetcher starts again from the
+//     window's first tile, where the window starts on the line
+//   - sprites: the pixels stop for 6 to 11 dots while each sprite on the
+//     line is fetched
+//
+// Registers are read when the hardware reads them, so writes during mode 3
+// take effect from the next pixel or tile: BGP, OBP0 and OBP1 as each pixel
+// is shifted out, SCX, SCY and LCDC as each tile is fetched.
+// See https://gbdev.io/pandocs/pixel_fifo.html.
+
+// Timings in dots.
+const (
+	// oamSearchDots is the length of OAMSearch (mode 2), after which the
+	// PPU starts drawing the scanline.
+	oamSearchDots = 80
+	// spriteFetchDots is the time it takes to fetch a sprite's tile, once
+	// the background fetcher has read the low byte of its tile.
+	spriteFetchDots = 6
+)
+
+const (
+	maxSpritesPerLine = 10
+	oamAddr           = 0xFE00
+	oamSprites        = 40
+)
+
+// pipeline is the state of the pixel pipeline. Its fields are exported,
+// and all of a fixed size, so that encoding/binary can save and restore it
+// (see State).
+type pipeline struct {
+	// X is the next pixel of the scanline to draw.
+	X byte
+	// Discard is the number of pixels to throw away before drawing, for
+	// SCX's fine scroll, or the window being partly off the left edge.
+	Discard byte
+	BG      pixelFifo
+	OBJ     pixelFifo
+	Fetcher fetcher
+
+	// Window is set once the window has started on this scanline.
+	Window bool
+	// WindowY is set once LY has matched WY in the current frame; the
+	// window is only drawn after that.
+	WindowY bool
+	// WindowLine is the line of the window to draw next. It only advances
+	// on scanlines the window was drawn on.
+	WindowLine byte
+
+	// Sprites are the sprites on this scanline, found by the OAM search,
+	// in OAM order. Fetched has bit i set once Sprites[i] has been
+	// fetched.
+	Sprites  [maxSpritesPerLine]sprite
+	NSprites byte
+	Fetched  uint16
+	// SpriteFetch is the sprite being fetched, if any.
+	SpriteFetch spriteFetch
+}
+
+// Steps of the background fetcher. The first three take two dots each;
+// pushing waits until there's room in the FIFO.
+const (
+	fetchTile byte = iota
+	fetchLow
+	fetchHigh
+	fetchPush
+)
+
+// fetcher fetches background or window tiles into the background FIFO.
+type fetcher struct {
+	Step byte
+	// SecondDot is set during the second dot of a step.
+	SecondDot bool
+	// Dummy is set during the first fetch of a scanline, whose tile the
+	// hardware fetches again.
+	Dummy bool
+	// Window is set when fetching the window's tiles rather than the
+	// background's.
+	Window bool
+	// TileX is the tile being fetched, counting from the left of the
+	// screen (or window).
+	TileX byte
+	Tile  byte
+	// Attr is the tile's attributes, in Game Boy Color mode (see cgb.go).
+	Attr   byte
+	Lo, Hi byte
+}
+
+// sprite is a sprite's entry in OAM, and its index there.
+type sprite struct {
+	Y, X, Tile, Flags byte
+	OAM               byte
+}
+
+// Sprite flags.
+const (
+	spriteBehindBG = 1 << 7
+	spriteFlipY    = 1 << 6
+	spriteFlipX    = 1 << 5
+	spriteOBP1     = 1 << 4
+)
+
+// spriteFetch is a sprite fetch in progress.
+type spriteFetch struct {
+	Active bool
+	Index  byte
+	Dots   byte
+	Lo, Hi byte
+}
+
+// pixelData is a pixel in a FIFO, before it's given a shade by its
+// palette.
+type pixelData struct {
+	Color   byte
+	Palette paletteNumber
+	// BehindBG is set for sprite pixels drawn behind background colors
+	// 1-3.
+	BehindBG bool
+	// Sprite is the OAM index of the sprite a sprite pixel came from.
+	Sprite byte
+	// CGBPalette is the pixel's palette in Game Boy Color mode, and
+	// Priority is set for background pixels whose tile is drawn in front
+	// of sprites.
+	CGBPalette byte
+	Priority   bool
+}
+
+type paletteNumber byte
+
+const (
+	obj0 paletteNumber = 0
+	obj1 paletteNumber = 1
+	bg   paletteNumber = 2
+)
+
+// pixelFifo is a queue of up to 16 pixels.
+type pixelFifo struct {
+	Pixels    [16]pixelData
+	Head, Len byte
+}
+
+func (f *pixelFifo) push(px pixelData) {
+	f.Pixels[(f.Head+f.Len)%16] = px
+	f.Len++
+}
+
+func (f *pixelFifo) pop() pixelData {
+	px := f.Pixels[f.Head]
+	f.Head = (f.Head + 1) % 16
+	f.Len--
+	return px
+}
+
+// at returns the i'th pixel from the front of the queue.
+func (f *pixelFifo) at(i byte) *pixelData {
+	return &f.Pixels[(f.Head+i)%16]
+}
+
+func (f *pixelFifo) clear() {
+	f.Head, f.Len = 0, 0
+}
+
+// startScanline starts drawing the current scanline, searching OAM for the
+// sprites on it.
+func (p *PPU) startScanline() {
+	ly := p.getLY()
+	l := &p.pipe
+	if ly == p.getWindowY() {
+		l.WindowY = true
+	}
+	*l = pipeline{
+		Discard:    p.getScrollX() % 8,
+		Fetcher:    fetcher{Dummy: true},
+		WindowY:    l.WindowY,
+		WindowLine: l.WindowLine,
+	}
+
+	p.lineDebug = p.loadDebugOptions()
+
+	lcdc := p.readLCDControl()
+	if !lcdc.SpriteEnable {
+		return
+	}
+	height := spriteHeight(lcdc)
+	for i := uint16(0); i < oamSprites; i++ {
+		addr := oamAddr + i*4
+		// Sprites' Y is the screen's plus 16, so that they can be partly
+		// off the top.
+		y := p.mem.Rb(addr)
+		if int(ly)+16 < int(y) || int(ly)+16 >= int(y)+height {
+			continue
+		}
+		// Sprites after the first ten on the line are only looked at to
+		// record that they were dropped.
+		if l.NSprites == maxSpritesPerLine {
+			p.selection.Dropped[i].add(ly)
+			continue
+		}
+		p.selection.Selected[i].add(ly)
+		l.Sprites[l.NSprites] = sprite{Y: y, X: p.mem.Rb(addr + 1), Tile: p.mem.Rb(addr + 2), Flags: p.mem.Rb(addr + 3), OAM: byte(i)}
+		l.NSprites++
+	}
+}
+
+// endFrame resets the pipeline for the next frame.
+func (p *PPU) endFrame() {
+	p.pipe.WindowY = false
+	p.pipe.WindowLine = 0
+}
+
+func spriteHeight(lcdc LCDControl) int {
+	if lcdc.SpriteSize {
+		return 16
+	}
+	return 8
+}
+
+// drawDot runs the pixel pipeline for a dot, and returns true once the
+// scanline has been drawn.
+func (p *PPU) drawDot() bool {
+	l := &p.pipe
+	lcdc := p.readLCDControl()
+
+	// No pixels are drawn while a sprite is fetched, which waits for the
+	// background fetcher to read the tile's low byte first.
+	if l.SpriteFetch.Active {
+		if l.Fetcher.Step < fetchHigh {
+			p.fetchDot(lcdc)
+		} else {
+			p.fetchSpriteDot(lcdc)
+		}
+		return false
+	}
+
+	p.fetchDot(lcdc)
+	if l.BG.Len == 0 {
+		return false
+	}
+	if l.Discard > 0 {
+		l.BG.pop()
+		l.Discard--
+		return false
+	}
+	if wx := p.getWindowX(); lcdc.WindowEnable && l.WindowY && !l.Window && int(l.X)+7 >= int(wx) {
+		l.Window = true
+		l.BG.clear()
+		l.Fetcher = fetcher{Window: true}
+		if wx < 7 {
+			l.Discard = 7 - wx
+		}
+		p.fetchDot(lcdc)
+		return false
+	}
+	if i, ok := p.nextSprite(lcdc); ok {
+		l.SpriteFetch = spriteFetch{Active: true, Index: i}
+		// The background fetcher has already had this dot.
+		if l.Fetcher.Step >= fetchHigh {
+			p.fetchSpriteDot(lcdc)
+		}
+		return false
+	}
+
+	bgPx := l.BG.pop()
+	var objPx pixelData
+	if l.OBJ.Len > 0 {
+		objPx = l.OBJ.pop()
+	}
+	i := int(p.getLY())*ScreenWidth + int(l.X)
+	f := &p.buffers[p.back]
+	px, layer := p.mix(lcdc, bgPx, objPx)
+	f.Layers[i] = layer
+	if p.cgb() {
+		f.Pixels[i], f.Colors[i] = Pixel(px.Color), p.cgbColor(px, layer != LayerBG)
+	} else {
+		f.Pixels[i] = p.shade(px, layer)
+	}
+	l.X++
+	if l.X < ScreenWidth {
+		return false
+	}
+	if l.Window {
+		l.WindowLine++
+	}
+	return true
+}
+
+// mix returns which of a background and sprite pixel is drawn where they
+// overlap, and the layer it came from.
+func (p *PPU) mix(lcdc LCDControl, bgPx, objPx pixelData) (pixelData, Layer) {
+	// On the DMG, clearing LCDC bit 0 blanks the background and window. On
+	// the CGB, it puts sprites in front of them instead.
+	if !lcdc.WindowDisplayORPriority && !p.cgb() {
+		bgPx.Color = 0
+	}
+	// Layers hidden for debugging are drawn as if transparent (see
+	// debug.go). Since the background FIFO is cleared when the window
+	// starts, its pixels are the window's from then on.
+	if p.lineDebug.hidesBG(p.pipe.Window) {
+		bgPx.Color = 0
+	}
+	if objPx.Color != 0 && p.lineDebug.hidesSprite(objPx.Sprite) {
+		objPx.Color = 0
+	}
+	if objPx.Color == 0 {
+		return bgPx, LayerBG
+	}
+	if bgPx.Color != 0 && (objPx.BehindBG || bgPx.Priority) && lcdc.WindowDisplayORPriority {
+		return bgPx, LayerBG
+	}
+	if objPx.Palette == obj1 {
+		return objPx, LayerOBJ1
+	}
+	return objPx, LayerOBJ0
+}
+
+// shade returns the shade of a pixel drawn from a layer on the DMG, through
+// BGP, OBP0 or OBP1.
+func (p *PPU) shade(px pixelData, layer Layer) Pixel {
+	switch layer {
+	case LayerOBJ0:
+		return shade(p.mem.Rb(0xFF48), px.Color)
+	case LayerOBJ1:
+		return shade(p.mem.Rb(0xFF49), px.Color)
+	}
+	return shade(p.mem.Rb(0xFF47), px.Color)
+}
+
+// shade returns the shade a palette register (BGP, OBP0 or OBP1) gives a
+// color number: bits 1-0 are color 0's shade, bits 3-2 color 1's, and so
+// on.
+func shade(palette, color byte) Pixel {
+	return Pixel(palette >> (color * 2) & 0b11)
+}
+
+// nextSprite returns the index of the next sprite to fetch at the current
+// pixel, if any. Sprites further left are fetched first, and so drawn in
+// front, then sprites earlier in OAM.
+func (p *PPU) nextSprite(lcdc LCDControl) (byte, bool) {
+	l := &p.pipe
+	if !lcdc.SpriteEnable {
+		return 0, false
+	}
+	var next byte
+	found := false
+	for i := byte(0); i < l.NSprites; i++ {
+		s := l.Sprites[i]
+		// Sprites' X is the screen's plus 8, so that they can be partly
+		// off the left.
+		if l.Fetched&(1<<i) != 0 || int(s.X) > int(l.X)+8 {
+			continue
+		}
+		if !found || s.X < l.Sprites[next].X {
+			next, found = i, true
+		}
+	}
+	return next, found
+}
+
+// fetchDot runs the background fetcher for a dot.
+func (p *PPU) fetchDot(lcdc LCDControl) {
+	l := &p.pipe
+	f := &l.Fetcher
+	if f.Step == fetchPush {
+		// The FIFO takes a tile once it has room for one.
+		if l.BG.Len > 8 {
+			return
+		}
+		for i := 7; i >= 0; i-- {
+			bit := uint(i)
+			if f.Attr&attrFlipX != 0 {
+				bit = uint(7 - i)
+			}
+			l.BG.push(pixelData{Color: tileColor(f.Lo, f.Hi, bit), Palette: bg, CGBPalette: f.Attr & attrPalette, Priority: f.Attr&attrPriority != 0})
+		}
+		f.TileX++
+		f.Step = fetchTile
+		return
+	}
+	if !f.SecondDot {
+		f.SecondDot = true
+		return
+	}
+	f.SecondDot = false
+	switch f.Step {
+	case fetchTile:
+		addr := p.tileMapAddr(lcdc)
+		f.Tile = p.mem.Rb(addr)
+		if p.cgb() {
+			f.Attr = p.color.RbVRAM(1, addr)
+		}
+	case fetchLow:
+		f.Lo = p.rbVRAM(f.Attr, p.tileDataAddr(lcdc))
+	case fetchHigh:
+		f.Hi = p.rbVRAM(f.Attr, p.tileDataAddr(lcdc)+1)
+		if f.Dummy {
+			f.Dummy = false
+			f.Step = fetchTile
+			return
+		}
+	}
+	f.Step++
+}
+
+// tileMapAddr returns the address of the tile map entry for the tile the
+// fetcher is fetching.
+//
+// The tile maps are 32x32 tiles, each tile 8x8 pixels:
+//
+//	$9800/$9C00 +--32 tile ptrs-----+
+//	            |0 |1 |2 |...    |31|
+//	         y  |32|33|34|...    |63|
+//	32 tile ptrs|                   |
+//	            |992|993|...   |1023|
+//	$9BFF/$9FFF +-------------------+
+//	                x ----->
+//
+// So the tile at pixel x, y is entry floor(y/8)*32 + floor(x/8). The
+// background wraps around.
+func (p *PPU) tileMapAddr(lcdc LCDControl) uint16 {
+	f := &p.pipe.Fetcher
+	var mapAddr uint16 = 0x9800
+	var x, y byte
+	if f.Window {
+		if lcdc.WindowTileMapSelect {
+			mapAddr = 0x9C00
+		}
+		x, y = f.TileX, p.pipe.WindowLine
+	} else {
+		if lcdc.BGTileMapSelect {
+			mapAddr = 0x9C00
+		}
+		x, y = (p.getScrollX()/8+f.TileX)%32, p.getScrollY()+p.getLY()
+	}
+	return mapAddr + uint16(y/8)*32 + uint16(x)
+}
+
+// tileDataAddr returns the address of the low byte of the row of the tile
+// the fetcher is fetching.
+func (p *PPU) tileDataAddr(lcdc LCDControl) uint16 {
+	f := &p.pipe.Fetcher
+	row := p.pipe.WindowLine % 8
+	if !f.Window {
+		row = (p.getScrollY() + p.getLY()) % 8
+	}
+	if f.Attr&attrFlipY != 0 {
+		row = 7 - row
+	}
+	return bgTileAddr(lcdc, f.Tile) + uint16(row)*2
+}
+
+// bgTileAddr returns the address of a background or window tile. With
+// LCDC bit 4 set, tiles are numbered from $8000; otherwise they're numbered
+// from $9000 with a signed number, so tiles 128-255 are at $8800-$8FFF.
+// See https://gbdev.io/pandocs/Tile_Data.html.
+func bgTileAddr(lcdc LCDControl, tile byte) uint16 {
+	if lcdc.TileAddressingMode {
+		return 0x8000 + uint16(tile)*16
+	}
+	return uint16(0x9000 + int(int8(tile))*16)
+}
+
+// tileColor returns the color number of pixel i of a row of a tile, where
+// pixel 7 is the leftmost. Each row is two bytes: the first has the low bit
+// of each pixel's color, and the second the high bit.
+func tileColor(lo, hi byte, i uint) byte {
+	return (lo>>i)&1 | (hi>>i)&1<<1
+}
+
+// fetchSpriteDot runs the fetch of a sprite for a dot. When the fetch
+// finishes, the sprite's pixels are mixed into the sprite FIFO.
+func (p *PPU) fetchSpriteDot(lcdc LCDControl) {
+	l := &p.pipe
+	sf := &l.SpriteFetch
+	s := l.Sprites[sf.Index]
+	sf.Dots++
+	switch sf.Dots {
+	case 4:
+		sf.Lo = p.rbVRAM(s.Flags, p.spriteRowAddr(lcdc, s))
+	case spriteFetchDots:
+		sf.Hi = p.rbVRAM(s.Flags, p.spriteRowAddr(lcdc, s)+1)
+		// Only the sprite's pixels that are on screen are mixed in.
+		skip := 0
+		if s.X < 8 {
+			skip = 8 - int(s.X)
+		}
+		for i := skip; i < 8; i++ {
+			bit := uint(7 - i)
+			if s.Flags&spriteFlipX != 0 {
+				bit = uint(i)
+			}
+			px := pixelData{Color: tileColor(sf.Lo, sf.Hi, bit), Palette: obj0, BehindBG: s.Flags&spriteBehindBG != 0, Sprite: s.OAM}
+			if p.cgb() {
+				px.CGBPalette = s.Flags & attrPalette
+			} else if s.Flags&spriteOBP1 != 0 {
+				px.Palette = obj1
+			}
+			// Pixels of sprites already in the FIFO take priority, unless
+			// they're transparent, or on the CGB, from a sprite later in
+			// OAM.
+			slot := byte(i - skip)
+			if slot < l.OBJ.Len {
+				old := l.OBJ.at(slot)
+				if old.Color == 0 || p.cgb() && px.Color != 0 && px.Sprite < old.Sprite {
+					*old = px
+				}
+			} else {
+				l.OBJ.push(px)
+			}
+		}
+		l.Fetched |= 1 << sf.Index
+		*sf = spriteFetch{}
+	}
+}
+
+// spriteRowAddr returns the address of the low byte of the row of a
+// sprite's tile on the current scanline. Sprites' tiles are always
+// numbered from $8000, and 8x16 sprites ignore bit 0 of the tile number.
+func (p *PPU) spriteRowAddr(lcdc LCDControl, s sprite) uint16 {
+	height := spriteHeight(lcdc)
+	row := int(p.getLY()) + 16 - int(s.Y)
+	if s.Flags&spriteFlipY != 0 {
+		row = height - 1 - row
+	}
+	tile := s.Tile
+	if height == 16 {
+		tile &^= 1
+	}
+	return 0x8000 + uint16(tile)*16 + uint16(row)*2
+}
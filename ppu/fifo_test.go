@@ -0,0 +1,280 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// fifoSetup returns a PPU at the start of scanline 0, with the LCD, the
+// background and sprites on, tiles numbered from $8000, and the palettes
+// giving each color number its own shade, except OBP1, which gives color 1
+// dark gray and color 3 light gray.
+func fifoSetup() (*PPU, *mmu.MMU) {
+	p, m := testSetup()
+	m.Mem[mmu.AddrLCDC] = 0b1001_0011
+	m.Mem[mmu.AddrBGP] = 0b11_10_01_00
+	m.Mem[mmu.AddrOBP0] = 0b11_10_01_00
+	m.Mem[mmu.AddrOBP1] = 0b01_00_10_00
+	// Tile 1 is all color 3, and tile 2 has a column of color 1 on the
+	// left.
+	for row := 0; row < 8; row++ {
+		m.Mem[mmu.AddrVRAM+16+row*2] = 0xFF
+		m.Mem[mmu.AddrVRAM+16+row*2+1] = 0xFF
+		m.Mem[mmu.AddrVRAM+32+row*2] = 0x80
+	}
+	return p, m
+}
+
+// drawLine runs the PPU to the end of PixelDrawing, and returns how many
+// dots it took.
+func drawLine(p *PPU) int {
+	for p.readLCDStat().Mode != PixelDrawing {
+		p.RunFor(1)
+	}
+	dots := 0
+	for p.readLCDStat().Mode == PixelDrawing {
+		p.RunFor(1)
+		dots++
+	}
+	return dots
+}
+
+// line returns the pixels drawn on scanline 0 so far.
+func line(p *PPU) []Pixel {
+	return p.buffers[p.back].Pixels[:p.pipe.X]
+}
+
+// setSprite sets OAM entry i.
+func setSprite(m *mmu.MMU, i int, y, x, tile, flags byte) {
+	copy(m.Mem[mmu.AddrOamRAM+i*4:], []byte{y, x, tile, flags})
+}
+
+func Test_PixelDrawingLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(m *mmu.MMU)
+		dots  int
+	}{
+		{"plain background", func(m *mmu.MMU) {}, 172},
+		{"SCX fine scroll", func(m *mmu.MMU) { m.Mem[mmu.AddrSCX] = 13 }, 177},
+		{"window", func(m *mmu.MMU) {
+			m.Mem[mmu.AddrLCDC] |= 0b0010_0000
+			m.Mem[mmu.AddrWX] = 7
+		}, 178},
+		{"window off the right edge", func(m *mmu.MMU) {
+			m.Mem[mmu.AddrLCDC] |= 0b0010_0000
+			m.Mem[mmu.AddrWX] = 167
+		}, 172},
+		{"sprite on a tile boundary", func(m *mmu.MMU) { setSprite(m, 0, 16, 8, 1, 0) }, 183},
+		{"sprite mid-tile", func(m *mmu.MMU) { setSprite(m, 0, 16, 13, 1, 0) }, 178},
+		{"sprite on another line", func(m *mmu.MMU) { setSprite(m, 0, 30, 8, 1, 0) }, 172},
+		{"sprites off", func(m *mmu.MMU) {
+			setSprite(m, 0, 16, 8, 1, 0)
+			m.Mem[mmu.AddrLCDC] &^= 0b0000_0010
+		}, 172},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, m := fifoSetup()
+			tt.setup(m)
+			if dots := drawLine(p); dots != tt.dots {
+				t.Errorf("PixelDrawing took %d dots; expected %d", dots, tt.dots)
+			}
+		})
+	}
+}
+
+func Test_drawScanline(t *testing.T) {
+	black := func(from, to int) func(x int) Pixel {
+		return func(x int) Pixel {
+			if x >= from && x < to {
+				return Black
+			}
+			return White
+		}
+	}
+	tests := []struct {
+		name  string
+		setup func(m *mmu.MMU)
+		want  func(x int) Pixel
+	}{
+		{"background", func(m *mmu.MMU) { m.Mem[mmu.AddrTileMap0+2] = 1 }, black(16, 24)},
+		{"SCX", func(m *mmu.MMU) {
+			m.Mem[mmu.AddrTileMap0+2] = 1
+			m.Mem[mmu.AddrSCX] = 3
+		}, black(13, 21)},
+		{"SCX wraps around", func(m *mmu.MMU) {
+			m.Mem[mmu.AddrTileMap0] = 1
+			m.Mem[mmu.AddrSCX] = 252
+		}, black(4, 12)},
+		{"background off", func(m *mmu.MMU) {
+			m.Mem[mmu.AddrTileMap0+2] = 1
+			m.Mem[mmu.AddrLCDC] &^= 0b0000_0001
+		}, black(0, 0)},
+		{"window", func(m *mmu.MMU) {
+			m.Mem[mmu.AddrLCDC] |= 0b0110_0000 // window on, tile map at $9C00
+			m.Mem[mmu.AddrWX] = 7 + 100
+			m.Mem[mmu.AddrTileMap1] = 1
+		}, black(100, 108)},
+		{"window below the line", func(m *mmu.MMU) {
+			m.Mem[mmu.AddrLCDC] |= 0b0110_0000
+			m.Mem[mmu.AddrWX] = 7
+			m.Mem[mmu.AddrWY] = 1
+			m.Mem[mmu.AddrTileMap1] = 1
+		}, black(0, 0)},
+		{"sprite", func(m *mmu.MMU) { setSprite(m, 0, 16, 8+40, 1, 0) }, black(40, 48)},
+		{"sprite partly off the left", func(m *mmu.MMU) { setSprite(m, 0, 16, 3, 1, 0) }, black(0, 3)},
+		{"sprite behind the background", func(m *mmu.MMU) {
+			m.Mem[mmu.AddrTileMap0+5] = 2
+			setSprite(m, 0, 16, 8+40, 1, spriteBehindBG)
+		}, func(x int) Pixel {
+			switch {
+			case x == 40:
+				return LightGray // the background's color 1
+			case x > 40 && x < 48:
+				return Black
+			}
+			return White
+		}},
+		{"sprite flipped, with OBP1", func(m *mmu.MMU) { setSprite(m, 0, 16, 8+40, 2, spriteFlipX|spriteOBP1) }, func(x int) Pixel {
+			if x == 47 {
+				return DarkGray
+			}
+			return White
+		}},
+		{"sprite further left in front", func(m *mmu.MMU) {
+			setSprite(m, 0, 16, 8+44, 1, spriteOBP1)
+			setSprite(m, 1, 16, 8+40, 1, 0)
+		}, func(x int) Pixel {
+			switch {
+			case x >= 40 && x < 48:
+				return Black
+			case x >= 48 && x < 52:
+				return LightGray
+			}
+			return White
+		}},
+		{"sprite earlier in OAM in front", func(m *mmu.MMU) {
+			setSprite(m, 0, 16, 8+40, 2, 0)
+			setSprite(m, 1, 16, 8+40, 1, 0)
+		}, func(x int) Pixel {
+			switch {
+			case x == 40:
+				return LightGray
+			case x > 40 && x < 48:
+				return Black // through sprite 0's transparent pixels
+			}
+			return White
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, m := fifoSetup()
+			tt.setup(m)
+			drawLine(p)
+			if len(line(p)) != ScreenWidth {
+				t.Fatalf("Drew %d pixels; expected %d", len(line(p)), ScreenWidth)
+			}
+			for x, px := range line(p) {
+				if want := tt.want(x); px != want {
+					t.Errorf("Pixel %d is %v; expected %v", x, px, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_drawScanlineLayers(t *testing.T) {
+	p, m := fifoSetup()
+	m.Mem[mmu.AddrTileMap0+5] = 1
+	setSprite(m, 0, 16, 8+8, 1, 0)
+	setSprite(m, 1, 16, 8+20, 1, spriteOBP1)
+	setSprite(m, 2, 16, 8+36, 1, spriteBehindBG)
+	drawLine(p)
+	for x := 0; x < ScreenWidth; x++ {
+		want := LayerBG
+		switch {
+		case x >= 8 && x < 16:
+			want = LayerOBJ0
+		case x >= 20 && x < 28:
+			want = LayerOBJ1
+		case x >= 36 && x < 40:
+			// In front of background color 0, but not the tile at 40.
+			want = LayerOBJ0
+		}
+		if l := p.buffers[p.back].Layers[x]; l != want {
+			t.Errorf("Pixel %d is from layer %d; expected %d", x, l, want)
+		}
+	}
+}
+
+func Test_MidScanlineWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(m *mmu.MMU)
+		// from is the first pixel the write should affect.
+		from int
+	}{
+		// Palettes take effect from the next pixel.
+		{"BGP", func(m *mmu.MMU) { m.Mem[mmu.AddrBGP] = 0 }, 80},
+		// LCDC and SCX take effect from the next tile fetched, which is
+		// two tiles after the next pixel, since a tile is in the FIFO and
+		// the next is being fetched.
+		{"LCDC", func(m *mmu.MMU) { m.Mem[mmu.AddrLCDC] &^= 0b0000_1000 }, 96},
+		{"SCX", func(m *mmu.MMU) { m.Mem[mmu.AddrSCX] = 8 }, 96},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, m := fifoSetup()
+			// The background is from tile map 1, which is black for the
+			// first 13 tiles. Tile map 0 is white.
+			for i := 0; i < 13; i++ {
+				m.Mem[mmu.AddrTileMap1+i] = 1
+			}
+			m.Mem[mmu.AddrLCDC] |= 0b0000_1000
+			for len(line(p)) < 80 {
+				p.RunFor(1)
+			}
+			tt.write(m)
+			drawLine(p)
+			for x := 0; x < 13*8; x++ {
+				want := Black
+				if x >= tt.from {
+					want = White
+				}
+				if px := line(p)[x]; px != want {
+					t.Errorf("Pixel %d is %v; expected %v", x, px, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_StateMidScanline(t *testing.T) {
+	p, m := fifoSetup()
+	setSprite(m, 0, 16, 8+40, 1, 0)
+	m.Mem[mmu.AddrTileMap0+3] = 2
+	m.Mem[mmu.AddrSCX] = 5
+	for len(line(p)) < 30 {
+		p.RunFor(1)
+	}
+	s := p.State()
+
+	restored, restoredMem := testSetup()
+	copy(restoredMem.Mem, m.Mem)
+	restored.SetState(s)
+	drawLine(p)
+	drawLine(restored)
+	if len(line(restored)) != ScreenWidth {
+		t.Fatalf("Drew %d pixels after restoring; expected %d", len(line(restored)), ScreenWidth)
+	}
+	for x, want := range line(p) {
+		if px := line(restored)[x]; px != want {
+			t.Errorf("Pixel %d is %v after restoring; expected %v", x, px, want)
+		}
+	}
+	if p.cycles != restored.cycles {
+		t.Errorf("PixelDrawing ended at cycle %d after restoring; expected %d", restored.cycles, p.cycles)
+	}
+}
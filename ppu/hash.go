@@ -0,0 +1,43 @@
+package ppu
+
+// Frames are hashed with 64-bit FNV-1a, a byte per pixel or two per color,
+// low byte first, computed here rather than with hash/fnv so that hashing
+// each frame doesn't allocate.
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// HashFrame returns a hash of a frame's pixels, to compare frames quickly,
+// e.g. to check that two runs of a game drew the same thing.
+func HashFrame(frame []Pixel) uint64 {
+	h := uint64(fnvOffset)
+	for _, px := range frame {
+		h = (h ^ uint64(byte(px))) * fnvPrime
+	}
+	return h
+}
+
+// HashColors returns a hash of a Game Boy Color frame's colors, as
+// HashFrame does for the DMG's.
+func HashColors(colors []RGB555) uint64 {
+	h := uint64(fnvOffset)
+	for _, c := range colors {
+		h = (h ^ uint64(byte(c))) * fnvPrime
+		h = (h ^ uint64(byte(c>>8))) * fnvPrime
+	}
+	return h
+}
+
+// Frames returns the number of frames the PPU has completed, whether or not
+// they were presented. The count and the hash below aren't
+// part of the PPU's State, so restoring a state doesn't change them.
+func (p *PPU) Frames() int {
+	return p.frames
+}
+
+// FrameHash returns the HashFrame of the last completed frame, or its
+// HashColors in Game Boy Color mode, or 0 if there hasn't been one.
+func (p *PPU) FrameHash() uint64 {
+	return p.frameHash
+}
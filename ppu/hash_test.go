@@ -0,0 +1,38 @@
+package ppu
+
+import (
+	"hash/fnv"
+	"testing"
+)
+
+func TestHashFrame(t *testing.T) {
+	var f Frame
+	for i := range f.Pixels {
+		f.Pixels[i] = Pixel(i % 4)
+		f.Colors[i] = RGB555(i * 37)
+	}
+	// The hashes are FNV-1a of the pixels a byte each, and of the colors
+	// low byte first.
+	h := fnv.New64a()
+	for _, px := range f.Pixels {
+		h.Write([]byte{byte(px)})
+	}
+	if got, want := HashFrame(f.Pixels[:]), h.Sum64(); got != want {
+		t.Errorf("HashFrame() = %016x, want %016x", got, want)
+	}
+	h.Reset()
+	for _, c := range f.Colors {
+		h.Write([]byte{byte(c), byte(c >> 8)})
+	}
+	if got, want := HashColors(f.Colors[:]), h.Sum64(); got != want {
+		t.Errorf("HashColors() = %016x, want %016x", got, want)
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		HashFrame(f.Pixels[:])
+		HashColors(f.Colors[:])
+	})
+	if allocs != 0 {
+		t.Errorf("Hashing a frame made %v allocations; expected none", allocs)
+	}
+}
@@ -0,0 +1,35 @@
+package ppu
+
+// firstLineSkip is the number of dots the first scanline after the LCD is
+// turned on is short by.
+const firstLineSkip = 4
+
+// turnOff turns the LCD off, when LCDC bit 7 is cleared. The PPU stops
+// until it's turned on again, with LY at 0 in HBlank, and the screen goes
+// white.
+func (p *PPU) turnOff() {
+	p.lcdOn = false
+	p.cycles = 0
+	p.setLY(0)
+	p.setMode(HBlank)
+	p.pipe = pipeline{}
+	p.selection = SpriteSelection{}
+	f := &p.buffers[p.back]
+	*f = Frame{Number: p.frames, Blank: true, CGB: p.cgb()}
+	if f.CGB {
+		for i := range f.Colors {
+			f.Colors[i] = WhiteRGB555
+		}
+	}
+	p.present()
+}
+
+// turnOn turns the LCD on, when LCDC bit 7 is set. The first scanline is
+// short, and stays in HBlank rather than OAMSearch until drawing starts.
+// The first frame isn't shown, so the screen stays white until the second.
+// See https://gbdev.io/pandocs/LCDC.html#lcdc7--lcd-enable.
+func (p *PPU) turnOn() {
+	p.lcdOn = true
+	p.cycles = firstLineSkip
+	p.skipFrame = true
+}
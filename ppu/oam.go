@@ -0,0 +1,38 @@
+package ppu
+
+// Lines is a set of scanlines.
+type Lines [(ScreenHeight + 63) / 64]uint64
+
+func (l *Lines) add(ly byte) {
+	l[ly/64] |= 1 << (ly % 64)
+}
+
+// Has reports whether the set has scanline ly.
+func (l Lines) Has(ly int) bool {
+	return ly >= 0 && ly < ScreenHeight && l[ly/64]&(1<<uint(ly%64)) != 0
+}
+
+// List returns the scanlines in the set, in order.
+func (l Lines) List() []int {
+	var lines []int
+	for ly := 0; ly < ScreenHeight; ly++ {
+		if l.Has(ly) {
+			lines = append(lines, ly)
+		}
+	}
+	return lines
+}
+
+// SpriteSelection is which scanlines of a frame each OAM entry was
+// selected to be drawn on, and which it was on but dropped from because
+// ten sprites earlier in OAM had already been selected.
+type SpriteSelection struct {
+	Selected, Dropped [oamSprites]Lines
+}
+
+// LastSpriteSelection returns the sprite selection of the last frame
+// completed, e.g. to diagnose sprites flickering. Like Frames, it's for
+// debugging, and isn't part of the PPU's State.
+func (p *PPU) LastSpriteSelection() SpriteSelection {
+	return p.lastSelection
+}
@@ -0,0 +1,53 @@
+package ppu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func span(from, to int) []int {
+	var lines []int
+	for ly := from; ly < to; ly++ {
+		lines = append(lines, ly)
+	}
+	return lines
+}
+
+func TestPPU_LastSpriteSelection(t *testing.T) {
+	p, m := fifoSetup()
+	// Twelve sprites on lines 0-7, and another on lines 4-11, which is
+	// dropped where it overlaps them.
+	for i := 0; i < 12; i++ {
+		setSprite(m, i, 16, byte(8+i*8), 1, 0)
+	}
+	setSprite(m, 12, 20, 8, 1, 0)
+	p.RunFor(frameDots)
+	sel := p.LastSpriteSelection()
+
+	tests := []struct {
+		sprite            int
+		selected, dropped []int
+	}{
+		{0, span(0, 8), nil},
+		{9, span(0, 8), nil},
+		{10, nil, span(0, 8)},
+		{11, nil, span(0, 8)},
+		{12, span(8, 12), span(4, 8)},
+		{13, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := sel.Selected[tt.sprite].List(); !reflect.DeepEqual(got, tt.selected) {
+			t.Errorf("Sprite %d was selected on lines %v; expected %v", tt.sprite, got, tt.selected)
+		}
+		if got := sel.Dropped[tt.sprite].List(); !reflect.DeepEqual(got, tt.dropped) {
+			t.Errorf("Sprite %d was dropped on lines %v; expected %v", tt.sprite, got, tt.dropped)
+		}
+	}
+
+	// The next frame's selection starts afresh.
+	setSprite(m, 0, 0, 0, 0, 0)
+	p.RunFor(frameDots)
+	if lines := p.LastSpriteSelection().Selected[0].List(); lines != nil {
+		t.Errorf("Sprite 0 was selected on lines %v after moving it off screen", lines)
+	}
+}
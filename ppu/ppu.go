@@ -1,7 +1,5 @@
 package ppu
 
-import "fmt"
-
 type MemoryReadWriter interface {
 	MemoryReader
 	MemoryWriter
@@ -22,21 +20,60 @@ type MemoryWriter interface {
 }
 
 type PPU struct {
-	mem      MemoryReadWriter
-	cycles   int
-	screen   []Pixel
-	VideoOut chan []Pixel
+	mem MemoryReadWriter
+	// color is mem in Game Boy Color mode, and nil otherwise (see cgb.go).
+	color  ColorMemory
+	cycles int
+	pipe   pipeline // see fifo.go
+
+	// buffers are the frame buffers. The PPU draws into buffers[back],
+	// while sinks may still be using the other (see sink.go).
+	buffers    [2]Frame
+	back       int
+	sinks      []sink
+	nextSinkID SinkID
+	muted      bool
+
+	// lcdOn is set while the LCD is on (see lcd.go). skipFrame is set
+	// while drawing the first frame after it's turned on, which isn't
+	// shown.
+	lcdOn     bool
+	skipFrame bool
+
+	// frames is the number of frames completed, and frameHash the hash of
+	// the last one.
+	frames    int
+	frameHash uint64
+	// selection is the sprites selected on each scanline so far this
+	// frame, and lastSelection those of the last frame (see oam.go).
+	selection, lastSelection SpriteSelection
+
+	// debug holds the DebugOptions, read and written with atomic
+	// operations, and lineDebug is the options for the current scanline
+	// (see debug.go).
+	debug     uint64
+	lineDebug debugMask
 }
 
 func New(mem MemoryReadWriter) *PPU {
-	videoOut := make(chan []Pixel, 1) // videoOut channel is buffered by one screen
-	ppu := &PPU{mem, 0, []Pixel{}, videoOut}
-	ppu.setMode(OAMSearch)
+	ppu := &PPU{mem: mem}
+	if c, ok := mem.(ColorMemory); ok && c.CGB() {
+		ppu.color = c
+	}
+	ppu.lcdOn = ppu.readLCDControl().LCDEnable
+	if ppu.lcdOn {
+		ppu.setMode(OAMSearch)
+	} else {
+		ppu.setMode(HBlank)
+	}
 	return ppu
 }
 
-const screenHeight = 144
-const screenWidth = 160
+// The size of the screen, in pixels.
+const (
+	ScreenWidth  = 160
+	ScreenHeight = 144
+)
 
 // Mode represents the 'drawing mode' of the Gameboy,
 // which is stored in bits 0 and 1 of the LCDStat memory register.
@@ -157,127 +194,13 @@ func (p *PPU) readLCDStat() LCDStat {
 	}
 }
 
-// getBackgroundTileRow returns the 8 pixels of a row of a background tile located at
-// coordinate x, y.
-func (p *PPU) getBackgroundTileRow(x, y byte) []pixelData {
-	// check lcdc to see where bg tile map is stored
-	lcdc := p.readLCDControl()
-	var tileMapLocation uint16
-	if lcdc.BGTileMapSelect == false {
-		tileMapLocation = 0x9800
-	} else {
-		tileMapLocation = 0x9C00
-	}
-	// calculate byte offset in bg tile map based on x,y.
-	offset := (uint16(y)/8)*32 + (uint16(x) / 8)
-	// Explanation:
-	// Tile memory is laid out like this:
-	// $9BFF/$9FFF +-------------------+
-	//             |992|993|...   |1023|
-	//             |                   |
-	// 32 tile ptrs|                   |
-	//         |   |32|33|34|...    |63|
-	//         y   |0 |1 |2 |...    |31|
-	// $9800/$9C00 +--32 tile ptrs-----+
-	// 					x ----->
-	// Where each tile represents a 8x8px area. So the formula for
-	// getting the tile byte offset is floor(y/8)*32 + floor(x/8).
-	// Wraparound for x and y is handled by byte overflow.
-
-	// Read the correct byte of the tile map to get the address of the tile data
-	// (Remember that the address of the tile data is an offset, not a full uint16 addresss.)
-	tileAddrOffset := p.mem.Rb(tileMapLocation + offset)
-	var tileAddr uint16
-	// LCDC tileAddressingMode 0 = $8800 1 = $8000
-	if lcdc.TileAddressingMode == false {
-		// convert addrOffset to a signed byte
-		signedAddrOffset := int8(tileAddrOffset)
-		// NOTE this is potentially buggy!
-		// promote the signed int8 to int in order to add it to 0x8800,
-		// then convert the result back to uint16.
-		tileAddr = uint16(0x8800 + int(signedAddrOffset)*0x10)
-	} else {
-		tileAddr = 0x8000 + uint16(tileAddrOffset)*0x10
-	}
-	// The row of the tile that intersects with this y-coordinate. Rows go from 0-7,
-	// where 7 is the bottom row.
-	row := y % 8
-	tileData := p.getTileRowData(tileAddr, row)
-	pixels := make([]pixelData, 0)
-	for _, paletteIndex := range tileData {
-		px := pixelData{paletteIndex, bg}
-		pixels = append(pixels, px)
-	}
-	// panic(fmt.Sprintf("%v", pixels))
-	return pixels
-}
-
-// getTileRowData returns the color numbers, from left to right, of a certain row of a tile located
-// at a 16-byte region in video memory.
-// Tile rows are 0-indexed and run from top to bottom, so the bottom row of a tile is row 7.
-func (p *PPU) getTileRowData(tileAddr uint16, row byte) []byte {
-	if row > 7 {
-		panic(fmt.Sprintf("Got tile row > 7: %v", row))
-	}
-	// Each 2 bytes of the tile is a row of the tile, and they are stored from top
-	// to bottom. The bytes that represents row n of the tile are at (tileAddr + n*2)
-	b1 := p.mem.Rb(tileAddr + uint16(row*2))
-	b2 := p.mem.Rb(tileAddr + uint16(row*2) + 1)
-	tileData := make([]byte, 0)
-	for i := 7; i >= 0; i-- {
-		// WARNING Possibly buggy
-		// https://gbdev.gg8.se/wiki/articles/Video_Display#VRAM_Tile_Data
-		// b1 contains the low bit of each px, from left (bit 7) to right (bit 0)
-		// b2 contains the high bit of each px, as above.
-		mask := byte(1) << i
-		lo := (b1 & mask) >> i
-		hi := (b2 & mask) >> i
-		color := (hi << 1) | lo
-		tileData = append(tileData, color)
-	}
-	return tileData
-}
-
-// palette represents the color palette used to color a tile.
-// Each pixel of a tile has color, numbered from 1-4. The palette is
-// a map from a color number to a RGB color, allowing each tile to be
-// colored with up to four different colors. If a tile is a sprite, color 4
-// is always colored as transparent.
-// In the original Gameboy, there are only 4 colors total to choose from.
-type palette map[byte]Pixel
-
-func (p *PPU) getBGPalette() palette {
-	var bgpAddr uint16 = 0xFF47
-	b := p.mem.Rb(bgpAddr)
-	pal := map[byte]Pixel{
-		3: Pixel(b & 0b1100_0000 >> 6),
-		2: Pixel(b & 0b0011_0000 >> 4),
-		1: Pixel(b & 0b0000_1100 >> 2),
-		0: Pixel(b & 0b0000_0011),
-	}
-	return palette(pal)
-}
-
 type Pixel byte
 
 const (
 	White     Pixel = 0
-	LightGray       = 1
-	DarkGray        = 2
-	Black           = 3
-)
-
-type pixelData struct {
-	colorNumber   byte
-	paletteNumber paletteNumber
-}
-
-type paletteNumber byte
-
-const (
-	bg   paletteNumber = 2
-	obj0 paletteNumber = 0
-	obj1               = 1
+	LightGray Pixel = 1
+	DarkGray  Pixel = 2
+	Black     Pixel = 3
 )
 
 // getYScroll gets the y-coordinate of the top-left of the LCD screen.
@@ -328,7 +251,7 @@ func (p *PPU) getLYCompare() byte {
 // Gets the X coordinate of the Window top left, minus 7.
 // Reads WindowX-7($FF4B) memory register.
 func (p *PPU) getWindowX() byte {
-	var wxAddr uint16 = 0xff4a
+	var wxAddr uint16 = 0xff4b
 	return p.mem.Rb(wxAddr)
 }
 
@@ -342,45 +265,10 @@ func (p *PPU) getWindowY() byte {
 // Screen is a byte array representing the colorized pixels
 // of a gameboy screen. Its format is
 //
-// 	1 pixel
-//  |-----|
+//		1 pixel
+//	 |-----|
+//
 // [R, G, B, R, G, B, R, G, B]
 // Where R,G,B are one byte representing the red, green, blue
 // component of each pixel.
 type Screen []byte
-
-type pixelFifo struct {
-	fifo []pixelData
-}
-
-func (pf *pixelFifo) overlay(sprite []pixelData) {
-	// overlay the sprite's pixels on top of the leftmost 8 pixels in the fifo
-	for i, px := range sprite {
-		// Sprite color #0 is transparent -- don't overlay it.
-		// TODO implement OBJ-to-BG priority (https://gbdev.gg8.se/wiki/articles/Video_Display#FF48_-_OBP0_-_Object_Palette_0_Data_.28R.2FW.29_-_Non_CGB_Mode_Only)
-		if px.colorNumber != 0 {
-			pf.fifo[i] = px
-		}
-	}
-}
-
-func (pf *pixelFifo) dequeue() (pixelData, error) {
-	if len(pf.fifo) > 0 {
-		px := pf.fifo[0]
-		pf.fifo = pf.fifo[1:]
-		return px, nil
-	}
-	return pixelData{}, fmt.Errorf("Pixel fifo is empty")
-}
-
-func (pf *pixelFifo) addTile(tile []pixelData) {
-	pf.fifo = append(pf.fifo, tile...)
-}
-
-func (pf *pixelFifo) clear() {
-	pf.fifo = make([]pixelData, 0)
-}
-
-func (pf *pixelFifo) size() int {
-	return len(pf.fifo)
-}
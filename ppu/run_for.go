@@ -12,30 +12,53 @@ func (p *PPU) RunFor(cycles int) {
 
 // Step executes 1 cycle's worth of work on the PPU.
 func (p *PPU) step() {
+	if on := p.readLCDControl().LCDEnable; on != p.lcdOn {
+		if on {
+			p.turnOn()
+		} else {
+			p.turnOff()
+		}
+	}
+	if !p.lcdOn {
+		return
+	}
 	lcdstat := p.readLCDStat()
 	lastCycle := 455
 	if lcdstat.Mode != VBlank {
 		// In non-VBlank mode, iterate through OAMSearch -> Pixel Drawing -> HBLank modes,
-		// drawing a scanline every 456 clocks.
-		switch p.cycles {
-		case 79:
+		// drawing a scanline every 456 clocks. How long Pixel Drawing takes depends on
+		// what's drawn (see fifo.go).
+		switch {
+		case p.cycles == oamSearchDots-1:
 			p.setMode(PixelDrawing)
-			scanline := p.drawScanline(p.getLY(), p.getScrollX(), p.getScrollY())
-			p.screen = append(p.screen, scanline...)
-		case 159:
-			p.setMode(HBlank)
-		case lastCycle:
+			p.startScanline()
+		case lcdstat.Mode == PixelDrawing:
+			if p.drawDot() {
+				p.setMode(HBlank)
+				if p.color != nil {
+					p.color.HBlank()
+				}
+			}
+		case p.cycles == lastCycle:
 			if p.getLY() < 143 { // 143 is last onscreen scanline
 				p.setMode(OAMSearch)
 				p.setLY(p.getLY() + 1)
 			} else if p.getLY() == 143 {
 				p.setMode(VBlank)
-				// send screen to output channel, but don't block
-				select {
-				case p.VideoOut <- p.screen:
-				default:
+				f := &p.buffers[p.back]
+				f.Number, f.Blank, f.CGB = p.frames, false, p.cgb()
+				p.frames++
+				if f.CGB {
+					p.frameHash = HashColors(f.Colors[:])
+				} else {
+					p.frameHash = HashFrame(f.Pixels[:])
+				}
+				p.lastSelection, p.selection = p.selection, SpriteSelection{}
+				if p.skipFrame {
+					p.skipFrame = false
+				} else {
+					p.present()
 				}
-				p.screen = make([]Pixel, 0)
 				p.setLY(p.getLY() + 1)
 			} else {
 				panic(fmt.Sprintf("LY is %v (>143), but mode is %v (should be VBlank)", p.getLY(), lcdstat.Mode))
@@ -50,6 +73,7 @@ func (p *PPU) step() {
 			} else if p.getLY() == 153 {
 				p.setLY(0)
 				p.setMode(OAMSearch)
+				p.endFrame()
 			} else {
 				panic(fmt.Sprintf("LY is %v, should be less than 153", p.getLY()))
 			}
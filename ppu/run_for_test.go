@@ -8,6 +8,7 @@ import (
 
 func testSetup() (*PPU, *mmu.MMU) {
 	m := mmu.New(mmu.MMUOptions{})
+	m.Mem[mmu.AddrLCDC] = 0b1000_0000 // LCD on
 	p := New(m.PPUInterface)
 	return p, m
 }
@@ -69,3 +70,79 @@ func Test_ModeSwitching(t *testing.T) {
 		})
 	}
 }
+
+// recordFrames returns a pointer to the frames p presents from now on.
+func recordFrames(p *PPU) *[]Frame {
+	var frames []Frame
+	p.AddSink(FrameSinkFunc(func(f *Frame) { frames = append(frames, *f) }))
+	return &frames
+}
+
+func Test_LCDOff(t *testing.T) {
+	p, m := testSetup()
+	frames := recordFrames(p)
+	m.Mem[mmu.AddrLCDC] = 0x91
+	p.RunFor(456*50 + 100)
+	m.Mem[mmu.AddrLCDC] &^= 0b1000_0000
+	p.RunFor(1)
+	if ly, mode := p.getLY(), p.readLCDStat().Mode; ly != 0 || mode != HBlank {
+		t.Errorf("Got LY %d and mode %v after turning the LCD off; expected 0 and HBlank", ly, mode)
+	}
+	if len(*frames) != 1 || !(*frames)[0].Blank {
+		t.Fatalf("Got frames %d after turning the LCD off; expected one blank one", len(*frames))
+	}
+	for i, px := range (*frames)[0].Pixels {
+		if px != White {
+			t.Fatalf("Pixel %d is %v after turning the LCD off; expected white", i, px)
+		}
+	}
+	p.RunFor(456 * 200)
+	if p.cycles != 0 || p.getLY() != 0 {
+		t.Errorf("Got cycles %d and LY %d with the LCD off; expected the PPU to stop", p.cycles, p.getLY())
+	}
+}
+
+func Test_LCDOn(t *testing.T) {
+	tests := []struct {
+		name   string
+		cycles int
+		ly     byte
+		mode   Mode
+	}{
+		{"HBlank rather than OAMSearch at first", 70, 0, HBlank},
+		{"draws the first line", 80, 0, PixelDrawing},
+		{"first line is 4 cycles short", 452, 1, OAMSearch},
+		{"second line is full length", 452 + 455, 1, HBlank},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, m := testSetup()
+			m.Mem[mmu.AddrLCDC] = 0
+			p.RunFor(1)
+			m.Mem[mmu.AddrLCDC] = 0x91
+			p.RunFor(tt.cycles)
+			if ly, mode := p.getLY(), p.readLCDStat().Mode; ly != tt.ly || mode != tt.mode {
+				t.Errorf("Got LY %d and mode %v; expected %d and %v", ly, mode, tt.ly, tt.mode)
+			}
+		})
+	}
+}
+
+func Test_LCDOnSkipsFirstFrame(t *testing.T) {
+	p, m := testSetup()
+	m.Mem[mmu.AddrLCDC] = 0
+	p.RunFor(1)
+	frames := recordFrames(p)
+	m.Mem[mmu.AddrLCDC] = 0x91
+	p.RunFor(456*154 - 4)
+	if len(*frames) != 0 {
+		t.Error("Got the first frame after turning the LCD on; expected it to be skipped")
+	}
+	p.RunFor(456 * 154)
+	if len(*frames) != 1 || (*frames)[0].Number != 1 {
+		t.Errorf("Got %d frames after turning the LCD on; expected the second", len(*frames))
+	}
+	if p.Frames() != 2 {
+		t.Errorf("Frames() = %d; expected 2", p.Frames())
+	}
+}
@@ -0,0 +1,164 @@
+package ppu
+
+import "sync/atomic"
+
+// Frame is a frame drawn by the PPU.
+type Frame struct {
+	// Number is the number of frames the PPU had completed before this
+	// one, so the first is 0.
+	Number int
+	// Blank is set for the white frame presented when the LCD is turned
+	// off, which the PPU didn't draw. It has the same Number as the next
+	// frame the PPU completes.
+	Blank bool
+	// Pixels are the frame's pixels, a row at a time from the top left.
+	Pixels [ScreenWidth * ScreenHeight]Pixel
+	// Layers are the layer each pixel came from, in the same order as
+	// Pixels, so frontends can color layers differently.
+	Layers [ScreenWidth * ScreenHeight]Layer
+	// CGB is set for frames drawn in Game Boy Color mode, whose pixels'
+	// colors are in Colors, in the same order as Pixels. Their Pixels are
+	// each pixel's color number in its palette rather than a shade.
+	CGB    bool
+	Colors [ScreenWidth * ScreenHeight]RGB555
+}
+
+// Layer is the layer a pixel was drawn from: the background or window, or
+// a sprite through OBP0 or OBP1. In Game Boy Color mode, all sprites are
+// LayerOBJ0.
+type Layer byte
+
+const (
+	LayerBG   Layer = 0
+	LayerOBJ0 Layer = 1
+	LayerOBJ1 Layer = 2
+)
+
+// FrameSink is something the PPU presents frames to as it completes
+// them, e.g. a display or a recorder.
+type FrameSink interface {
+	// PresentFrame is called with each frame, on the goroutine running the
+	// PPU. The PPU draws the next frame into another buffer, so the frame
+	// stays valid until PresentFrame is next called; sinks that keep
+	// frames for longer must copy them.
+	PresentFrame(f *Frame)
+}
+
+// FrameSinkFunc is a function called with each frame, as a FrameSink.
+type FrameSinkFunc func(f *Frame)
+
+// PresentFrame calls fn(f).
+func (fn FrameSinkFunc) PresentFrame(f *Frame) {
+	fn(f)
+}
+
+// SinkID identifies a sink added with AddSink.
+type SinkID int
+
+type sink struct {
+	id SinkID
+	s  FrameSink
+}
+
+// AddSink adds a sink that frames are presented to, after any added
+// before it, and returns an ID to remove it with.
+func (p *PPU) AddSink(s FrameSink) SinkID {
+	p.nextSinkID++
+	p.sinks = append(p.sinks, sink{p.nextSinkID, s})
+	return p.nextSinkID
+}
+
+// RemoveSink removes a sink added with AddSink.
+func (p *PPU) RemoveSink(id SinkID) {
+	for i, s := range p.sinks {
+		if s.id == id {
+			p.sinks = append(p.sinks[:i:i], p.sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+// MuteSinks stops or restarts presenting frames to the sinks, e.g. while
+// replaying frames that have been presented already.
+func (p *PPU) MuteSinks(mute bool) {
+	p.muted = mute
+}
+
+// present presents the frame in the back buffer to the sinks, unless
+// they're muted, and swaps the buffers.
+func (p *PPU) present() {
+	if !p.muted {
+		f := &p.buffers[p.back]
+		for _, s := range p.sinks {
+			s.s.PresentFrame(f)
+		}
+	}
+	p.back ^= 1
+}
+
+// ChannelSink is a FrameSink that sends a copy of each frame on a channel,
+// for consumers on other goroutines. The copies come from a pool, which the
+// consumer returns them to with Release.
+type ChannelSink struct {
+	// C is the channel the frames are sent on.
+	C <-chan *Frame
+
+	c       chan *Frame
+	free    chan *Frame
+	block   bool
+	dropped uint64 // read and written with atomic operations
+}
+
+// NewChannelSink returns a ChannelSink whose channel holds up to size
+// frames. If block is set, PresentFrame waits for room on the channel, so
+// no frames are lost but the PPU runs no faster than the consumer.
+// Otherwise frames that don't fit are dropped, which suits displays that
+// only need to keep up.
+func NewChannelSink(size int, block bool) *ChannelSink {
+	c := make(chan *Frame, size)
+	// There's a frame for each place on the channel, and one for the
+	// consumer to hold on to.
+	free := make(chan *Frame, size+1)
+	for i := 0; i < size+1; i++ {
+		free <- new(Frame)
+	}
+	return &ChannelSink{C: c, c: c, free: free, block: block}
+}
+
+// PresentFrame sends a copy of f on the channel. Frames that are dropped
+// aren't copied.
+func (s *ChannelSink) PresentFrame(f *Frame) {
+	var frame *Frame
+	if s.block {
+		frame = <-s.free
+	} else {
+		// PresentFrame is the only sender, so if there's room now, there
+		// still will be once f is copied.
+		if len(s.c) == cap(s.c) {
+			atomic.AddUint64(&s.dropped, 1)
+			return
+		}
+		select {
+		case frame = <-s.free:
+		default:
+			// The consumer is holding on to more than one frame.
+			atomic.AddUint64(&s.dropped, 1)
+			return
+		}
+	}
+	*frame = *f
+	s.c <- frame
+}
+
+// Release returns a frame received from C to the pool, once the consumer
+// is done with it. A consumer that holds on to more than one frame at a
+// time makes PresentFrame drop frames or, if it blocks, wait.
+func (s *ChannelSink) Release(f *Frame) {
+	s.free <- f
+}
+
+// Dropped returns the number of frames dropped because the channel was
+// full. It may be called from any goroutine.
+func (s *ChannelSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
@@ -0,0 +1,124 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// frameSetup returns a PPU with the LCD on, drawing a black tile in the
+// top left corner of a white background.
+func frameSetup() (*PPU, *mmu.MMU) {
+	p, m := fifoSetup()
+	m.Mem[mmu.AddrTileMap0] = 1
+	return p, m
+}
+
+const frameDots = 456 * 154
+
+func TestPPU_AddSink(t *testing.T) {
+	p, m := frameSetup()
+	var first, second []int
+	firstID := p.AddSink(FrameSinkFunc(func(f *Frame) { first = append(first, f.Number) }))
+	p.AddSink(FrameSinkFunc(func(f *Frame) { second = append(second, f.Number) }))
+	p.RunFor(frameDots * 2)
+	p.RemoveSink(firstID)
+	p.RunFor(frameDots)
+	m.Mem[mmu.AddrLCDC] &^= 0b1000_0000
+	p.RunFor(1)
+
+	if len(first) != 2 || first[0] != 0 || first[1] != 1 {
+		t.Errorf("The first sink got frames %v; expected [0 1]", first)
+	}
+	if len(second) != 4 || second[2] != 2 || second[3] != 3 {
+		t.Errorf("The second sink got frames %v; expected [0 1 2 3]", second)
+	}
+}
+
+func TestPPU_MuteSinks(t *testing.T) {
+	p, _ := frameSetup()
+	var frames []*Frame
+	var numbers []int
+	p.AddSink(FrameSinkFunc(func(f *Frame) {
+		frames = append(frames, f)
+		numbers = append(numbers, f.Number)
+	}))
+	p.RunFor(frameDots)
+	p.MuteSinks(true)
+	p.RunFor(frameDots)
+	p.MuteSinks(false)
+	p.RunFor(frameDots)
+
+	if len(numbers) != 2 || numbers[0] != 0 || numbers[1] != 2 {
+		t.Fatalf("The sink got frames %v; expected [0 2]", numbers)
+	}
+	// The buffers are swapped while muted too, so frame 1 was drawn into
+	// the other buffer from the ones presented.
+	if frames[0] != frames[1] {
+		t.Error("Expected frame 2 in the same buffer as frame 0")
+	}
+}
+
+func TestPPU_doubleBuffered(t *testing.T) {
+	p, m := frameSetup()
+	var frames []*Frame
+	p.AddSink(FrameSinkFunc(func(f *Frame) { frames = append(frames, f) }))
+	p.RunFor(frameDots)
+	// The next frame is drawn into the other buffer, so the first is intact
+	// until it's presented.
+	m.Mem[mmu.AddrSCX] = 8
+	p.RunFor(frameDots - 1)
+	if frames[0].Pixels[0] != Black {
+		t.Error("The first frame was drawn over before the next was presented")
+	}
+	p.RunFor(1)
+	if len(frames) != 2 || frames[0] == frames[1] {
+		t.Fatal("Expected the second frame in the other buffer")
+	}
+	if frames[1].Pixels[0] != White {
+		t.Errorf("The second frame's first pixel is %v; expected white", frames[1].Pixels[0])
+	}
+}
+
+func TestChannelSink(t *testing.T) {
+	t.Run("dropping", func(t *testing.T) {
+		p, _ := frameSetup()
+		s := NewChannelSink(1, false)
+		p.AddSink(s)
+		p.RunFor(frameDots * 3)
+		if f := <-s.C; f.Number != 0 {
+			t.Errorf("Received frame %d; expected 0", f.Number)
+		}
+		if s.Dropped() != 2 {
+			t.Errorf("Dropped() = %d; expected 2", s.Dropped())
+		}
+	})
+	t.Run("blocking", func(t *testing.T) {
+		p, _ := frameSetup()
+		s := NewChannelSink(1, true)
+		p.AddSink(s)
+		go p.RunFor(frameDots * 3)
+		for i := 0; i < 3; i++ {
+			f := <-s.C
+			if f.Number != i {
+				t.Errorf("Received frame %d; expected %d", f.Number, i)
+			}
+			s.Release(f)
+		}
+		if s.Dropped() != 0 {
+			t.Errorf("Dropped() = %d; expected 0", s.Dropped())
+		}
+	})
+	t.Run("no allocation", func(t *testing.T) {
+		s := NewChannelSink(1, false)
+		var f Frame
+		allocs := testing.AllocsPerRun(100, func() {
+			s.PresentFrame(&f)
+			s.PresentFrame(&f)
+			s.Release(<-s.C)
+		})
+		if allocs != 0 {
+			t.Errorf("Presenting and releasing a frame made %v allocations; expected none", allocs)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package ppu
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// State is the PPU's progress through the current frame, for saving and
+// restoring, e.g. to rewind. The PPU's registers are in memory, and are
+// saved with it.
+type State struct {
+	// Cycles is the number of cycles into the current scanline.
+	Cycles int
+	// Screen is the frame being drawn, and Layers the layer each of its
+	// pixels came from.
+	Screen []Pixel
+	Layers []Layer
+	// Colors are its pixels' colors in Game Boy Color mode, and nil
+	// otherwise.
+	Colors []RGB555
+	// Pipeline is the state of the pixel pipeline (see fifo.go), encoded.
+	// It's always PipelineSize bytes long.
+	Pipeline []byte
+	// LCDOn is set if the LCD is on, and SkipFrame if the frame being
+	// drawn is the first since it was turned on, which isn't shown.
+	LCDOn, SkipFrame bool
+}
+
+// PipelineSize is the length of a State's Pipeline.
+var PipelineSize = binary.Size(pipeline{})
+
+// State returns the PPU's state.
+func (p *PPU) State() State {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, &p.pipe)
+	var colors []RGB555
+	if p.cgb() {
+		colors = append(colors, p.buffers[p.back].Colors[:]...)
+	}
+	return State{
+		Cycles:    p.cycles,
+		Screen:    append([]Pixel(nil), p.buffers[p.back].Pixels[:]...),
+		Layers:    append([]Layer(nil), p.buffers[p.back].Layers[:]...),
+		Colors:    colors,
+		Pipeline:  b.Bytes(),
+		LCDOn:     p.lcdOn,
+		SkipFrame: p.skipFrame,
+	}
+}
+
+// SetState restores a state returned by State. If the state's Pipeline is
+// the wrong length, the pipeline is reset.
+func (p *PPU) SetState(s State) {
+	p.cycles = s.Cycles
+	copy(p.buffers[p.back].Pixels[:], s.Screen)
+	copy(p.buffers[p.back].Layers[:], s.Layers)
+	copy(p.buffers[p.back].Colors[:], s.Colors)
+	p.lcdOn, p.skipFrame = s.LCDOn, s.SkipFrame
+	p.pipe = pipeline{}
+	if len(s.Pipeline) == PipelineSize {
+		binary.Read(bytes.NewReader(s.Pipeline), binary.LittleEndian, &p.pipe)
+	}
+}
@@ -0,0 +1,146 @@
+// Package profiler measures where a game spends its time, by attributing the
+// cycles taken by each instruction to its address and to the functions on
+// the CPU's call stack.
+//
+// Profiles can be written as a report of the hottest functions and
+// addresses, with a breakdown of the busiest video frame, or in the folded
+// stack format read by flame graph tools such as
+// https://github.com/brendangregg/FlameGraph and https://www.speedscope.app.
+package profiler
+
+import (
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// Profiler profiles a CPU as it executes instructions.
+type Profiler struct {
+	c    *cpu.CPU
+	syms *symbols.Table
+	bank func(addr uint16) int
+
+	root *node
+	// samples are the cycles spent at each address, with each call stack.
+	samples map[sample]int
+	total   int
+
+	// stack and current are the call stack of the last instruction, and
+	// its node, which are usually the same as the next instruction's.
+	stack   []cpu.Frame
+	current *node
+	scratch []cpu.Frame
+
+	// frames are the cycles taken by each complete video frame.
+	frames       []int
+	frameSamples map[sample]int
+	// busiest is the samples of the frame that took the most cycles.
+	busiest      map[sample]int
+	busiestFrame int
+}
+
+// node is a call stack: a call from its parent's stack. The root is the
+// empty stack.
+type node struct {
+	call     call
+	parent   *node
+	children map[call]*node
+}
+
+// call is a Frame without SP, so that the same call made with different
+// amounts of stack counts as the same call.
+type call struct {
+	callerPC, target uint16
+	bank             int
+}
+
+type sample struct {
+	stack *node
+	pc    symbols.Address
+}
+
+// New returns a profiler for c. Cycles are only attributed to functions if c
+// is tracking calls (see cpu.CPU.TrackCalls). syms may be nil. bank returns
+// the bank mapped at an address, and may be nil if there's only one.
+func New(c *cpu.CPU, syms *symbols.Table, bank func(addr uint16) int) *Profiler {
+	root := &node{children: make(map[call]*node)}
+	return &Profiler{
+		c:            c,
+		syms:         syms,
+		bank:         bank,
+		root:         root,
+		current:      root,
+		samples:      make(map[sample]int),
+		frameSamples: make(map[sample]int),
+	}
+}
+
+// Step executes one instruction, as cpu.CPU.Step does, and records the
+// cycles it took.
+func (p *Profiler) Step() (cpu.Instruction, int) {
+	pc := p.address(p.c.PC)
+	stack := p.callStack()
+	instr, cycles := p.c.Step()
+	p.samples[sample{stack, pc}] += cycles
+	p.frameSamples[sample{stack, pc}] += cycles
+	p.total += cycles
+	return instr, cycles
+}
+
+// EndFrame marks the end of a video frame, e.g. at the start of VBlank.
+func (p *Profiler) EndFrame() {
+	cycles := 0
+	for _, n := range p.frameSamples {
+		cycles += n
+	}
+	p.frames = append(p.frames, cycles)
+	if p.busiest == nil || cycles > p.frames[p.busiestFrame] {
+		p.busiest, p.busiestFrame = p.frameSamples, len(p.frames)-1
+		p.frameSamples = make(map[sample]int)
+		return
+	}
+	for s := range p.frameSamples {
+		delete(p.frameSamples, s)
+	}
+}
+
+func (p *Profiler) address(addr uint16) symbols.Address {
+	a := symbols.Address{Addr: addr}
+	if p.bank != nil {
+		a.Bank = p.bank(addr)
+	}
+	return a
+}
+
+// callStack returns the node for the CPU's current call stack.
+func (p *Profiler) callStack() *node {
+	p.scratch = p.c.AppendCallStack(p.scratch[:0])
+	if sameCalls(p.scratch, p.stack) {
+		return p.current
+	}
+	p.stack, p.scratch = p.scratch, p.stack
+	n := p.root
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		f := p.stack[i]
+		c := call{f.CallerPC, f.Target, f.Bank}
+		child, ok := n.children[c]
+		if !ok {
+			child = &node{call: c, parent: n, children: make(map[call]*node)}
+			n.children[c] = child
+		}
+		n = child
+	}
+	p.current = n
+	return n
+}
+
+func sameCalls(a, b []cpu.Frame) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].CallerPC != b[i].CallerPC || a[i].Target != b[i].Target || a[i].Bank != b[i].Bank {
+			return false
+		}
+	}
+	return true
+}
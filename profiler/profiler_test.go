@@ -0,0 +1,94 @@
+package profiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// newTestProfiler returns a profiler for a CPU about to run:
+//
+//	Main:  call Sub
+//	       jr Main
+//	Sub:   nop
+//	.loop: nop
+//	       ret
+func newTestProfiler(t *testing.T, syms string) *Profiler {
+	table, err := symbols.Load(strings.NewReader(syms))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mmu.New(mmu.MMUOptions{})
+	copy(m.Mem[0x0100:], []byte{0xCD, 0x10, 0x01, 0x18, 0xFB})
+	copy(m.Mem[0x0110:], []byte{0x00, 0x00, 0xC9})
+	c := cpu.New(m.CPUInterface)
+	c.PC, c.SP = 0x0100, 0xFFFE
+	c.TrackCalls(m.BankAt)
+	return New(c, table, m.BankAt)
+}
+
+func TestProfiler_WriteFolded(t *testing.T) {
+	tests := []struct {
+		name string
+		syms string
+		want string
+	}{
+		{"symbols", "00:0100 Main\n00:0110 Sub\n00:0111 Sub.loop\n", "Main %d\nMain;Sub %d\n"},
+		{"no symbols", "", "(top level) %d\n(top level);$00:0110 %d\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProfiler(t, tt.syms)
+			// Each loop is call, nop, nop, ret in Sub, then jr.
+			var main, sub int
+			for i := 0; i < 10; i++ {
+				_, cycles := p.Step()
+				if i%5 == 0 || i%5 == 4 {
+					main += cycles
+				} else {
+					sub += cycles
+				}
+			}
+			var b bytes.Buffer
+			if err := p.WriteFolded(&b); err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf(tt.want, main, sub); b.String() != want {
+				t.Errorf("WriteFolded() wrote:\n%s\nwant:\n%s", b.String(), want)
+			}
+		})
+	}
+}
+
+func TestProfiler_WriteReport(t *testing.T) {
+	p := newTestProfiler(t, "00:0100 Main\n00:0110 Sub\n00:0111 Sub.loop\n")
+	for i := 0; i < 5; i++ {
+		p.Step()
+	}
+	p.EndFrame()
+	// A shorter frame: call, then nop in Sub.
+	p.Step()
+	p.Step()
+	p.EndFrame()
+
+	var b bytes.Buffer
+	if err := p.WriteReport(&b, 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"2 frames",
+		"in frame 1\n",
+		"100.0%  Main\n",
+		"$00:0111 <Sub.loop>\n",
+		"Busiest frame (frame 1,",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected report to contain %q:\n%s", want, b.String())
+		}
+	}
+}
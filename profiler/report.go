@@ -0,0 +1,183 @@
+package profiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/symbols"
+)
+
+// topLevel names code that isn't in any known function, when there are no
+// symbols.
+const topLevel = "(top level)"
+
+// WriteFolded writes the profile in the folded stack format: one line per
+// call stack, with the functions from outermost to innermost separated by
+// semicolons, followed by the cycles spent in the innermost one, e.g.
+//
+//	Main;UpdateSprites;MultiplyAB 1234
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	stacks := make(map[string]int)
+	names := make(map[*node][]string)
+	for s, cycles := range p.samples {
+		stacks[strings.Join(p.stackNames(s, names), ";")] += cycles
+	}
+	keys := make([]string, 0, len(stacks))
+	for k := range stacks {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	bw := bufio.NewWriter(w)
+	for _, k := range keys {
+		fmt.Fprintf(bw, "%s %d\n", k, stacks[k])
+	}
+	return bw.Flush()
+}
+
+// WriteReport writes a summary of the profile, showing the n functions and
+// addresses that took the most cycles, and the n functions that took the
+// most cycles in the busiest frame.
+func (p *Profiler) WriteReport(w io.Writer, n int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d cycles", p.total)
+	if len(p.frames) > 0 {
+		sum := 0
+		for _, c := range p.frames {
+			sum += c
+		}
+		fmt.Fprintf(bw, ", %d frames: %d cycles per frame on average, at most %d in frame %d",
+			len(p.frames), sum/len(p.frames), p.frames[p.busiestFrame], p.busiestFrame+1)
+	}
+	fmt.Fprintln(bw)
+
+	self, inclusive := p.functions(p.samples)
+	fmt.Fprintf(bw, "\nFunctions:\n%10s %6s %10s %6s  %s\n", "self", "", "total", "", "function")
+	for _, f := range top(inclusive, n) {
+		fmt.Fprintf(bw, "%10d %5.1f%% %10d %5.1f%%  %s\n", self[f], p.percent(self[f]), inclusive[f], p.percent(inclusive[f]), f)
+	}
+
+	pcs := make(map[symbols.Address]int)
+	for s, cycles := range p.samples {
+		pcs[s.pc] += cycles
+	}
+	byName := make(map[string]int, len(pcs))
+	for a, cycles := range pcs {
+		byName[p.syms.Format(a)] = cycles
+	}
+	fmt.Fprintf(bw, "\nHot spots:\n%10s %6s  %s\n", "cycles", "", "address")
+	for _, a := range top(byName, n) {
+		fmt.Fprintf(bw, "%10d %5.1f%%  %s\n", byName[a], p.percent(byName[a]), a)
+	}
+
+	if p.busiest != nil {
+		_, inclusive := p.functions(p.busiest)
+		busiest := p.frames[p.busiestFrame]
+		fmt.Fprintf(bw, "\nBusiest frame (frame %d, %d cycles):\n%10s %6s  %s\n", p.busiestFrame+1, busiest, "total", "", "function")
+		for _, f := range top(inclusive, n) {
+			fmt.Fprintf(bw, "%10d %5.1f%%  %s\n", inclusive[f], 100*float64(inclusive[f])/float64(busiest), f)
+		}
+	}
+	return bw.Flush()
+}
+
+func (p *Profiler) percent(cycles int) float64 {
+	if p.total == 0 {
+		return 0
+	}
+	return 100 * float64(cycles) / float64(p.total)
+}
+
+// functions returns the cycles spent in each function itself, and in each
+// function including the functions it called.
+func (p *Profiler) functions(samples map[sample]int) (self, inclusive map[string]int) {
+	self = make(map[string]int)
+	inclusive = make(map[string]int)
+	names := make(map[*node][]string)
+	for s, cycles := range samples {
+		stack := p.stackNames(s, names)
+		self[stack[len(stack)-1]] += cycles
+		// Recursive functions only count once.
+		seen := make(map[string]bool, len(stack))
+		for _, f := range stack {
+			if !seen[f] {
+				inclusive[f] += cycles
+				seen[f] = true
+			}
+		}
+	}
+	return self, inclusive
+}
+
+// stackNames returns the names of the functions in a sample's call stack,
+// outermost first. The names of the calls in each node are cached in names.
+func (p *Profiler) stackNames(s sample, names map[*node][]string) []string {
+	calls, ok := names[s.stack]
+	if !ok {
+		for n := s.stack; n != p.root; n = n.parent {
+			calls = append(calls, p.callee(symbols.Address{Bank: n.call.bank, Addr: n.call.target}))
+		}
+		for i, j := 0, len(calls)-1; i < j; i, j = i+1, j-1 {
+			calls[i], calls[j] = calls[j], calls[i]
+		}
+		names[s.stack] = calls
+	}
+
+	// The outermost function is the one the outermost call was made from,
+	// or if there are no calls, the one the instruction is in.
+	base := s.pc
+	if s.stack != p.root {
+		n := s.stack
+		for n.parent != p.root {
+			n = n.parent
+		}
+		base = p.address(n.call.callerPC)
+	}
+	baseName := topLevel
+	if p.syms.Len() > 0 {
+		baseName = p.function(base)
+	}
+	return append([]string{baseName}, calls...)
+}
+
+// function returns the name of the function containing a: the global label
+// it's in, or just its address if it isn't near a label.
+func (p *Profiler) function(a symbols.Address) string {
+	desc := p.syms.Describe(a)
+	if desc == "" {
+		return a.String()
+	}
+	if i := strings.IndexAny(desc, ".+"); i > 0 {
+		desc = desc[:i]
+	}
+	return desc
+}
+
+// callee returns the name of a function that was called: the label at its
+// address, which may be a local label, or else the function it's in.
+func (p *Profiler) callee(a symbols.Address) string {
+	if name, ok := p.syms.Name(a); ok {
+		return name
+	}
+	return p.function(a)
+}
+
+// top returns the n keys of m with the largest values, largest first.
+func top(m map[string]int, n int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
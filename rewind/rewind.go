@@ -0,0 +1,344 @@
+// Package rewind keeps a history of the emulator's state, so that it can go
+// backwards in time.
+//
+// A snapshot of the machine is taken every few frames. Each snapshot is
+// stored as its difference from the one before, XORed and compressed, with
+// a complete keyframe every so often. The oldest snapshots are dropped, a
+// keyframe at a time, to keep the history within a memory limit. To go back
+// to a point between snapshots, the buffer restores the snapshot before it
+// and replays forward from there with the input recorded for each frame,
+// which arrives at the same state because the emulator is deterministic.
+package rewind
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// Machine is an emulator that can be rewound.
+type Machine interface {
+	// SaveState appends the machine's state to dst, and returns the
+	// extended slice.
+	SaveState(dst []byte) []byte
+	// LoadState restores a state saved by SaveState.
+	LoadState(state []byte) error
+	// Step executes one instruction, and returns true if it finished a
+	// video frame, in which case the machine has been given the input for
+	// the next one.
+	Step() bool
+	// Input returns the input the machine has been given for the current
+	// frame.
+	Input() mmu.Buttons
+	// Replay executes one instruction of history that's being replayed.
+	// It's Step without anything that happens outside the machine, such as
+	// presenting frames or profiling, since that happened the first time,
+	// and with next as the input for the next frame if it finishes one.
+	Replay(next mmu.Buttons) bool
+}
+
+// Options configures a Buffer. Zero values are replaced with defaults.
+type Options struct {
+	// Interval is the number of frames between snapshots. Rewinding to a
+	// frame between snapshots replays up to this many frames. The default
+	// is 10 frames, a sixth of a second.
+	Interval int
+	// KeyframeInterval is the number of snapshots from one keyframe to the
+	// next. The default is 30.
+	KeyframeInterval int
+	// MaxBytes is the most memory the snapshots may take up. The default is
+	// 16MiB.
+	MaxBytes int
+}
+
+const (
+	defaultInterval         = 10
+	defaultKeyframeInterval = 30
+	defaultMaxBytes         = 16 << 20
+)
+
+// ErrTooFar is returned when asked to go back further than the oldest
+// snapshot.
+var ErrTooFar = errors.New("not that much history is kept")
+
+// Buffer records a machine's history as it runs, and can return it to any
+// point in that history.
+type Buffer struct {
+	m   Machine
+	opt Options
+
+	snapshots []snapshot // oldest first
+	size      int        // the total size of the snapshots' data
+
+	frame        int
+	instructions uint64
+	// inputs are the input for each frame from inputsFrom on, which is the
+	// oldest snapshot's frame.
+	inputs     []mmu.Buttons
+	inputsFrom int
+	// end is the number of instructions executed at the latest point in
+	// history.
+	end uint64
+
+	// last is the state saved in the newest snapshot, which the next
+	// snapshot is stored as the difference from.
+	last []byte
+	// scratch, delta, buf and zw are reused from one snapshot to the next.
+	scratch []byte
+	delta   []byte
+	buf     bytes.Buffer
+	zw      *flate.Writer
+}
+
+type snapshot struct {
+	frame        int
+	instructions uint64
+	keyframe     bool
+	// data is the compressed state for keyframes, and otherwise the
+	// compressed XOR of the state with the previous snapshot's.
+	data []byte
+}
+
+// New starts recording m's history, starting with its current state.
+func New(m Machine, opt Options) *Buffer {
+	if opt.Interval <= 0 {
+		opt.Interval = defaultInterval
+	}
+	if opt.KeyframeInterval <= 0 {
+		opt.KeyframeInterval = defaultKeyframeInterval
+	}
+	if opt.MaxBytes <= 0 {
+		opt.MaxBytes = defaultMaxBytes
+	}
+	zw, _ := flate.NewWriter(nil, flate.BestSpeed)
+	b := &Buffer{m: m, opt: opt, zw: zw, inputs: []mmu.Buttons{m.Input()}}
+	b.snapshot()
+	return b
+}
+
+// Step executes one instruction, taking a snapshot if it finished a frame
+// that one is due at. It returns true if it finished a frame.
+func (b *Buffer) Step() bool {
+	frameDone := b.m.Step()
+	if frameDone {
+		// Any history after this frame was left behind by rewinding.
+		b.inputs = append(b.inputs[:b.frame+1-b.inputsFrom], b.m.Input())
+	}
+	b.advance(frameDone)
+	b.end = b.instructions
+	return frameDone
+}
+
+// replay executes one instruction of history, with the input recorded for
+// the next frame. There's none recorded if this is the latest frame, but
+// then replaying stops at end, before the frame is finished.
+func (b *Buffer) replay() {
+	next := b.m.Input()
+	if i := b.frame + 1 - b.inputsFrom; i < len(b.inputs) {
+		next = b.inputs[i]
+	}
+	b.advance(b.m.Replay(next))
+}
+
+// advance counts an instruction, and a frame if it finished one, taking a
+// snapshot if one is due.
+func (b *Buffer) advance(frameDone bool) {
+	b.instructions++
+	if frameDone {
+		b.frame++
+		if b.frame%b.opt.Interval == 0 {
+			b.snapshot()
+		}
+	}
+}
+
+// Frame returns the number of frames finished since recording started.
+func (b *Buffer) Frame() int {
+	return b.frame
+}
+
+// Instructions returns the number of instructions executed since recording
+// started.
+func (b *Buffer) Instructions() uint64 {
+	return b.instructions
+}
+
+// Checkpoints returns the points, in instructions executed, at which there
+// are snapshots, oldest first. Seeking to them doesn't need any replaying.
+func (b *Buffer) Checkpoints() []uint64 {
+	points := make([]uint64, len(b.snapshots))
+	for i, s := range b.snapshots {
+		points[i] = s.instructions
+	}
+	return points
+}
+
+// Options returns the buffer's options, with defaults filled in.
+func (b *Buffer) Options() Options {
+	return b.opt
+}
+
+// Size returns the memory taken up by the snapshots, in bytes.
+func (b *Buffer) Size() int {
+	return b.size
+}
+
+// Rewind goes back the given number of frames, to just after the end of
+// the frame that many before the current one. If there isn't enough
+// history, it goes back as far as it can. It returns the number of frames
+// it went back.
+func (b *Buffer) Rewind(frames int) (int, error) {
+	target := b.frame - frames
+	if oldest := b.snapshots[0].frame; target < oldest {
+		target = oldest
+	}
+	i := len(b.snapshots) - 1
+	for b.snapshots[i].frame > target {
+		i--
+	}
+	from := b.frame
+	if err := b.restore(i); err != nil {
+		return 0, err
+	}
+	for b.frame < target {
+		b.replay()
+	}
+	return from - b.frame, nil
+}
+
+// Seek returns the machine to the point at which the given number of
+// instructions had been executed. Seeking forward replays history, if
+// there is any after the current point, and then runs the machine.
+func (b *Buffer) Seek(instructions uint64) error {
+	if instructions < b.snapshots[0].instructions {
+		return ErrTooFar
+	}
+	if instructions < b.instructions {
+		i := len(b.snapshots) - 1
+		for b.snapshots[i].instructions > instructions {
+			i--
+		}
+		if err := b.restore(i); err != nil {
+			return err
+		}
+	}
+	for b.instructions < instructions && b.instructions < b.end {
+		b.replay()
+	}
+	for b.instructions < instructions {
+		b.Step()
+	}
+	return nil
+}
+
+// snapshot saves the machine's state as a new snapshot, dropping the
+// oldest ones if the buffer is full.
+func (b *Buffer) snapshot() {
+	state := b.m.SaveState(b.scratch[:0])
+	s := snapshot{frame: b.frame, instructions: b.instructions}
+	n := len(b.snapshots)
+	if n == 0 || n-b.lastKeyframe() >= b.opt.KeyframeInterval {
+		s.keyframe = true
+		s.data = b.compress(state)
+	} else {
+		if cap(b.delta) < len(state) {
+			b.delta = make([]byte, len(state))
+		}
+		s.data = b.compress(xor(b.delta[:len(state)], state, b.last))
+	}
+	b.snapshots = append(b.snapshots, s)
+	b.size += len(s.data)
+	b.scratch, b.last = b.last, state
+
+	// Drop the oldest keyframe and its deltas until the rest fit, always
+	// keeping the newest keyframe.
+	for b.size > b.opt.MaxBytes {
+		next := 1
+		for next < len(b.snapshots) && !b.snapshots[next].keyframe {
+			next++
+		}
+		if next == len(b.snapshots) {
+			break
+		}
+		for _, s := range b.snapshots[:next] {
+			b.size -= len(s.data)
+		}
+		b.snapshots = b.snapshots[next:]
+		oldest := b.snapshots[0].frame
+		b.inputs = append(b.inputs[:0], b.inputs[oldest-b.inputsFrom:]...)
+		b.inputsFrom = oldest
+	}
+}
+
+// lastKeyframe returns the index of the newest keyframe.
+func (b *Buffer) lastKeyframe() int {
+	i := len(b.snapshots) - 1
+	for i > 0 && !b.snapshots[i].keyframe {
+		i--
+	}
+	return i
+}
+
+// restore loads snapshot i into the machine, and forgets the snapshots
+// after it, since the machine may take a different path from there.
+func (b *Buffer) restore(i int) error {
+	k := i
+	for !b.snapshots[k].keyframe {
+		k--
+	}
+	state, err := decompress(b.snapshots[k].data)
+	if err != nil {
+		return err
+	}
+	for _, s := range b.snapshots[k+1 : i+1] {
+		delta, err := decompress(s.data)
+		if err != nil {
+			return err
+		}
+		state = xor(delta, delta, state)
+	}
+	if err := b.m.LoadState(state); err != nil {
+		return fmt.Errorf("restoring snapshot: %v", err)
+	}
+	for _, s := range b.snapshots[i+1:] {
+		b.size -= len(s.data)
+	}
+	b.snapshots = b.snapshots[:i+1]
+	b.frame, b.instructions = b.snapshots[i].frame, b.snapshots[i].instructions
+	b.last = state
+	return nil
+}
+
+func (b *Buffer) compress(data []byte) []byte {
+	b.buf.Reset()
+	b.zw.Reset(&b.buf)
+	b.zw.Write(data)
+	b.zw.Close()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func decompress(data []byte) ([]byte, error) {
+	state, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing snapshot: %v", err)
+	}
+	return state, nil
+}
+
+// xor sets dst, which must be as long as a, to a XOR b, treating b as if it
+// were padded with zeroes or cut to a's length, and returns dst. States
+// differ in length when e.g. the call stack is deeper.
+func xor(dst, a, b []byte) []byte {
+	for i := range a {
+		var x byte
+		if i < len(b) {
+			x = b[i]
+		}
+		dst[i] = a[i] ^ x
+	}
+	return dst
+}
@@ -0,0 +1,182 @@
+package rewind
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+)
+
+// testMachine counts through its memory, finishing a frame every
+// instructionsPerFrame instructions. The depth of its "call stack" varies,
+// so that states differ in length. Its input for each frame, taken from
+// live when the frame before ends, is mixed into what it counts. presented
+// counts the frames it finished outside of replaying.
+type testMachine struct {
+	mem       [4096]byte
+	pc        int
+	stack     []byte
+	input     mmu.Buttons
+	live      mmu.Buttons
+	presented int
+}
+
+const instructionsPerFrame = 100
+
+func (m *testMachine) SaveState(dst []byte) []byte {
+	dst = append(dst, byte(m.pc), byte(m.pc>>8), byte(m.pc>>16), byte(m.pc>>24), byte(m.input))
+	dst = append(dst, m.mem[:]...)
+	return append(dst, m.stack...)
+}
+
+func (m *testMachine) LoadState(state []byte) error {
+	if len(state) < 5+len(m.mem) {
+		return errors.New("short state")
+	}
+	m.pc = int(state[0]) | int(state[1])<<8 | int(state[2])<<16 | int(state[3])<<24
+	m.input = mmu.Buttons(state[4])
+	copy(m.mem[:], state[5:])
+	m.stack = append(m.stack[:0], state[5+len(m.mem):]...)
+	return nil
+}
+
+func (m *testMachine) Step() bool {
+	frameDone := m.Replay(m.live)
+	if frameDone {
+		m.presented++
+	}
+	return frameDone
+}
+
+func (m *testMachine) Input() mmu.Buttons {
+	return m.input
+}
+
+func (m *testMachine) Replay(next mmu.Buttons) bool {
+	m.mem[m.pc*7%len(m.mem)] += byte(m.pc) ^ byte(m.input)
+	m.pc++
+	m.stack = m.stack[:m.pc%5]
+	for i := range m.stack {
+		m.stack[i] = byte(m.pc)
+	}
+	if m.pc%instructionsPerFrame != 0 {
+		return false
+	}
+	m.input = next
+	return true
+}
+
+func newTestMachine() *testMachine {
+	return &testMachine{stack: make([]byte, 0, 5)}
+}
+
+func TestBuffer_Rewind(t *testing.T) {
+	tests := []struct {
+		name        string
+		run, rewind int // frames
+		want        int // frames rewound
+	}{
+		{"to a snapshot", 25, 5, 5},
+		{"between snapshots", 25, 8, 8},
+		{"within the frame", 25, 0, 0},
+		{"past a keyframe", 95, 60, 60},
+		{"to the start", 25, 25, 25},
+		{"too far", 25, 40, 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMachine()
+			b := New(m, Options{Interval: 4, KeyframeInterval: 3})
+			states := [][]byte{m.SaveState(nil)}
+			for b.Frame() < tt.run {
+				if b.Step() {
+					states = append(states, m.SaveState(nil))
+				}
+			}
+			got, err := b.Rewind(tt.rewind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Rewound %d frames, expected %d", got, tt.want)
+			}
+			if b.Frame() != tt.run-tt.want {
+				t.Errorf("At frame %d, expected %d", b.Frame(), tt.run-tt.want)
+			}
+			if !bytes.Equal(m.SaveState(nil), states[b.Frame()]) {
+				t.Errorf("State after rewinding differs from the state at frame %d", b.Frame())
+			}
+		})
+	}
+}
+
+func TestBuffer_Seek(t *testing.T) {
+	m := newTestMachine()
+	b := New(m, Options{Interval: 2, KeyframeInterval: 4})
+	var states [][]byte
+	for i := 0; i < 2000; i++ {
+		states = append(states, m.SaveState(nil))
+		b.Step()
+	}
+	for _, n := range []uint64{1234, 17, 1999, 0, 1500} {
+		if err := b.Seek(n); err != nil {
+			t.Fatal(err)
+		}
+		if b.Instructions() != n {
+			t.Errorf("Seek(%d) went to %d", n, b.Instructions())
+		}
+		if !bytes.Equal(m.SaveState(nil), states[n]) {
+			t.Errorf("State after Seek(%d) differs", n)
+		}
+	}
+}
+
+func TestBuffer_replaysInput(t *testing.T) {
+	m := newTestMachine()
+	b := New(m, Options{Interval: 4, KeyframeInterval: 3})
+	states := [][]byte{m.SaveState(nil)}
+	for b.Frame() < 30 {
+		m.live = mmu.Buttons(b.Frame() * 37)
+		if b.Step() {
+			states = append(states, m.SaveState(nil))
+		}
+	}
+	m.live = 0xFF
+	m.presented = 0
+	if _, err := b.Rewind(9); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.SaveState(nil), states[21]) {
+		t.Error("State after rewinding differs from the one recorded")
+	}
+	if err := b.Seek(20*instructionsPerFrame + 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Seek(27 * instructionsPerFrame); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.SaveState(nil), states[27]) {
+		t.Error("State after seeking differs from the one recorded")
+	}
+	if m.presented != 0 {
+		t.Errorf("Replaying presented %d frames again", m.presented)
+	}
+}
+
+func TestBuffer_MaxBytes(t *testing.T) {
+	m := newTestMachine()
+	b := New(m, Options{Interval: 1, KeyframeInterval: 10, MaxBytes: 20000})
+	for b.Frame() < 500 {
+		b.Step()
+	}
+	if b.Size() > 20000 {
+		t.Errorf("Snapshots take %d bytes, more than the maximum", b.Size())
+	}
+	if err := b.Seek(0); err != ErrTooFar {
+		t.Errorf("Expected ErrTooFar seeking to the start, got %v", err)
+	}
+	if _, err := b.Rewind(5); err != nil || b.Frame() != 495 {
+		t.Errorf("Expected to rewind to frame 495, got frame %d, error %v", b.Frame(), err)
+	}
+}
@@ -0,0 +1,16 @@
+package savestate
+
+import "hash/fnv"
+
+// HashState returns a hash of a state saved by Append, to compare states
+// quickly, e.g. to check that a movie hasn't desynced.
+func HashState(state []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(state)
+	return h.Sum64()
+}
+
+// Hash returns the HashState of the machine's current state.
+func Hash(m Machine) uint64 {
+	return HashState(Append(nil, m))
+}
@@ -0,0 +1,231 @@
+// Package savestate saves and restores the complete state of the emulator:
+// the CPU, memory and the PPU. The game ROM isn't included, so a state can
+// only be restored with the same game loaded.
+//
+// A state can only be restored in the mode it was saved in: Game Boy Color
+// mode, or as on a DMG.
+//
+// States are laid out with everything of a fixed size first, so that two
+// states of the same game differ only where the machine does, which keeps
+// deltas between them small (see the rewind package).
+package savestate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+// magic starts every state, followed by the format version.
+const (
+	magic   = "GBSS"
+	version = 1
+)
+
+// Machine is the parts of the emulator that a state is saved from.
+type Machine struct {
+	CPU *cpu.CPU
+	MMU *mmu.MMU
+	PPU *ppu.PPU
+}
+
+// CPU flags.
+const (
+	flagHalted = 1 << iota
+	flagStopped
+	flagIME
+	flagSetIME
+)
+
+// PPU flags.
+const (
+	flagLCDOn = 1 << iota
+	flagSkipFrame
+)
+
+// Append appends the machine's state to dst, and returns the extended
+// slice.
+func Append(dst []byte, m Machine) []byte {
+	c := m.CPU.State()
+	mem := m.MMU.State()
+	p := m.PPU.State()
+
+	dst = append(dst, magic...)
+	dst = append(dst, version, boolByte(m.MMU.CGB()))
+	dst = append(dst, c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L)
+	dst = appendUint16(dst, c.SP)
+	dst = appendUint16(dst, c.PC)
+	var flags byte
+	for _, f := range []struct {
+		set  bool
+		flag byte
+	}{{c.Halted, flagHalted}, {c.Stopped, flagStopped}, {c.IME, flagIME}, {c.SetIME, flagSetIME}} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+	dst = append(dst, flags, boolByte(mem.BootROMMapped), byte(mem.Buttons))
+	dst = append(dst, mem.Mem[:]...)
+	dst = append(dst, mem.VRAM1[:]...)
+	for _, b := range mem.WRAMBanks {
+		dst = append(dst, b[:]...)
+	}
+	dst = append(dst, mem.BGPalettes[:]...)
+	dst = append(dst, mem.OBJPalettes[:]...)
+	dst = appendUint16(dst, uint16(mem.Stall))
+	dst = appendUint16(dst, uint16(p.Cycles))
+	dst = append(dst, p.Pipeline...)
+	dst = append(dst, boolByte(p.LCDOn)*flagLCDOn|boolByte(p.SkipFrame)*flagSkipFrame)
+
+	// The rest varies in length.
+	dst = appendUint16(dst, uint16(len(c.Calls)))
+	for _, f := range c.Calls {
+		dst = appendUint16(dst, f.CallerPC)
+		dst = appendUint16(dst, f.Target)
+		dst = appendUint16(dst, uint16(f.Bank))
+		dst = appendUint16(dst, f.SP)
+	}
+	dst = appendUint16(dst, uint16(len(p.Screen)))
+	for i, px := range p.Screen {
+		// Each pixel's layer is packed above its shade.
+		var layer ppu.Layer
+		if i < len(p.Layers) {
+			layer = p.Layers[i]
+		}
+		dst = append(dst, byte(px)|byte(layer)<<2)
+	}
+	dst = appendUint16(dst, uint16(len(p.Colors)))
+	for _, c := range p.Colors {
+		dst = appendUint16(dst, uint16(c))
+	}
+	return dst
+}
+
+// Load restores the machine to a state saved by Append. The machine isn't
+// changed if the state can't be read.
+func Load(m Machine, state []byte) error {
+	r := reader{b: state}
+	if string(r.bytes(len(magic))) != magic {
+		return errors.New("not a saved state")
+	}
+	if v := r.byte(); v != version {
+		return fmt.Errorf("unsupported saved state version %d", v)
+	}
+	if cgb := r.byte() != 0; r.err == nil && cgb != m.MMU.CGB() {
+		return fmt.Errorf("the state was saved in %s, but the game is running in %s", modeName(cgb), modeName(!cgb))
+	}
+	var c cpu.State
+	c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L = r.byte(), r.byte(), r.byte(), r.byte(), r.byte(), r.byte(), r.byte(), r.byte()
+	c.SP, c.PC = r.uint16(), r.uint16()
+	flags := r.byte()
+	c.Halted = flags&flagHalted != 0
+	c.Stopped = flags&flagStopped != 0
+	c.IME = flags&flagIME != 0
+	c.SetIME = flags&flagSetIME != 0
+	mem := &mmu.State{BootROMMapped: r.byte() != 0, Buttons: mmu.Buttons(r.byte())}
+	copy(mem.Mem[:], r.bytes(len(mem.Mem)))
+	copy(mem.VRAM1[:], r.bytes(len(mem.VRAM1)))
+	for i := range mem.WRAMBanks {
+		copy(mem.WRAMBanks[i][:], r.bytes(len(mem.WRAMBanks[i])))
+	}
+	copy(mem.BGPalettes[:], r.bytes(len(mem.BGPalettes)))
+	copy(mem.OBJPalettes[:], r.bytes(len(mem.OBJPalettes)))
+	mem.Stall = int(r.uint16())
+	var p ppu.State
+	p.Cycles = int(r.uint16())
+	p.Pipeline = r.bytes(ppu.PipelineSize)
+	ppuFlags := r.byte()
+	p.LCDOn = ppuFlags&flagLCDOn != 0
+	p.SkipFrame = ppuFlags&flagSkipFrame != 0
+
+	c.Calls = make([]cpu.Frame, r.uint16())
+	for i := range c.Calls {
+		c.Calls[i] = cpu.Frame{
+			CallerPC: r.uint16(),
+			Target:   r.uint16(),
+			Bank:     int(r.uint16()),
+			SP:       r.uint16(),
+		}
+		if r.err != nil {
+			break
+		}
+	}
+	screen := r.bytes(int(r.uint16()))
+	if n := int(r.uint16()); n > 0 {
+		p.Colors = make([]ppu.RGB555, n)
+		for i := range p.Colors {
+			p.Colors[i] = ppu.RGB555(r.uint16())
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	p.Screen = make([]ppu.Pixel, len(screen))
+	p.Layers = make([]ppu.Layer, len(screen))
+	for i, px := range screen {
+		p.Screen[i] = ppu.Pixel(px & 0b11)
+		p.Layers[i] = ppu.Layer(px >> 2)
+	}
+
+	m.CPU.SetState(c)
+	m.MMU.SetState(mem)
+	m.PPU.SetState(p)
+	return nil
+}
+
+// modeName describes the mode a game runs in.
+func modeName(cgb bool) string {
+	if cgb {
+		return "Game Boy Color mode"
+	}
+	return "DMG mode"
+}
+
+func appendUint16(dst []byte, n uint16) []byte {
+	return append(dst, byte(n), byte(n>>8))
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// reader reads a state, recording the first error rather than returning it
+// from every method.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.b) {
+		r.err = errors.New("saved state is truncated")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
@@ -0,0 +1,110 @@
+package savestate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+// newMachine returns a machine about to run:
+//
+//	Main:  call Sub
+//	       jr Main
+//	Sub:   inc a
+//	       ld [$C000], a
+//	       ret
+//
+// If cgb is set, it's in Game Boy Color mode.
+func newMachine(cgb bool) Machine {
+	var opt mmu.MMUOptions
+	if cgb {
+		rom := make([]byte, 0x8000)
+		rom[0x0143] = 0x80 // the CGB flag
+		opt.GameRom = bytes.NewReader(rom)
+	}
+	m := mmu.New(opt)
+	copy(m.Mem[0x0100:], []byte{0xCD, 0x10, 0x01, 0x18, 0xFB})
+	copy(m.Mem[0x0110:], []byte{0x3C, 0xEA, 0x00, 0xC0, 0xC9})
+	c := cpu.New(m.CPUInterface)
+	c.PC, c.SP = 0x0100, 0xFFFE
+	c.TrackCalls(m.BankAt)
+	p := ppu.New(m.PPUInterface)
+	m.Mem[mmu.AddrLCDC] = 0x91 // turns the LCD on
+	return Machine{CPU: c, MMU: m, PPU: p}
+}
+
+func step(m Machine, n int) {
+	for i := 0; i < n; i++ {
+		_, cycles := m.CPU.Step()
+		m.PPU.RunFor(cycles)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps int
+		cgb   bool
+	}{
+		{"outside a call", 5, false},
+		{"inside a call", 7, false},
+		{"in color", 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMachine(tt.cgb)
+			step(m, tt.steps)
+			saved := Append(nil, m)
+			regs, calls := m.CPU.Registers, len(m.CPU.CallStack())
+			step(m, 1000)
+			// Writes to palette RAM and a bank of work RAM in color, and
+			// to the registers and bank 1 otherwise.
+			m.MMU.Poke(mmu.AddrBCPD, 0x12)
+			m.MMU.Poke(mmu.AddrSVBK, 2)
+			m.MMU.Poke(mmu.AddrWorkRAMSwitchableBank, 0x34)
+
+			if err := Load(m, saved); err != nil {
+				t.Fatal(err)
+			}
+			if m.CPU.Registers != regs {
+				t.Errorf("Registers are %+v, expected %+v", m.CPU.Registers, regs)
+			}
+			if got := len(m.CPU.CallStack()); got != calls {
+				t.Errorf("Call depth is %d, expected %d", got, calls)
+			}
+			if got := Append(nil, m); !bytes.Equal(got, saved) {
+				t.Error("Saving again gives a different state")
+			}
+		})
+	}
+}
+
+func TestLoad_errors(t *testing.T) {
+	m := newMachine(false)
+	saved := Append(nil, m)
+	tests := []struct {
+		name  string
+		state []byte
+	}{
+		{"empty", nil},
+		{"not a state", []byte("hello, world")},
+		{"wrong version", append([]byte(magic), version+1)},
+		{"truncated", saved[:len(saved)-1]},
+		{"saved in color", Append(nil, newMachine(true))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step(m, 3)
+			regs := m.CPU.Registers
+			if err := Load(m, tt.state); err == nil {
+				t.Error("Expected an error")
+			}
+			if m.CPU.Registers != regs {
+				t.Error("Expected the machine to be unchanged")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Package screenshot saves frames as PNG images, e.g. to attach to bug
+// reports:
+//
+//	shots := screenshot.NewSink()
+//	p.AddSink(shots)
+//	...
+//	err := shots.WritePNG(w, palette.Default, 1)
+package screenshot
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+// Image returns a frame in a palette, scaled up by an integer factor (see
+// palette.Scale).
+func Image(f *ppu.Frame, pal *palette.Palette, scale int) *image.Paletted {
+	return palette.Scale(pal.Paletted(f), scale)
+}
+
+// EncodePNG writes a frame to w as a PNG (see Image).
+func EncodePNG(w io.Writer, f *ppu.Frame, pal *palette.Palette, scale int) error {
+	return png.Encode(w, Image(f, pal, scale))
+}
+
+// Save writes a frame to a PNG file (see Image).
+func Save(path string, f *ppu.Frame, pal *palette.Palette, scale int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := EncodePNG(file, f, pal, scale); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ErrNoFrame is returned by Sink.WritePNG before any frame has been
+// presented.
+var ErrNoFrame = errors.New("no frame has been drawn yet")
+
+// Sink is a ppu.FrameSink that keeps a copy of the latest frame, so it can
+// be saved at any time from any goroutine.
+type Sink struct {
+	mu    sync.Mutex
+	frame ppu.Frame
+	ok    bool
+}
+
+// NewSink returns a Sink with no frame yet.
+func NewSink() *Sink {
+	return &Sink{}
+}
+
+// PresentFrame keeps a copy of f.
+func (s *Sink) PresentFrame(f *ppu.Frame) {
+	s.mu.Lock()
+	s.frame = *f
+	s.ok = true
+	s.mu.Unlock()
+}
+
+// Latest returns a copy of the latest frame, or false if there hasn't been
+// one.
+func (s *Sink) Latest() (*ppu.Frame, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ok {
+		return nil, false
+	}
+	f := s.frame
+	return &f, true
+}
+
+// WritePNG writes the latest frame to w as a PNG (see Image).
+func (s *Sink) WritePNG(w io.Writer, pal *palette.Palette, scale int) error {
+	f, ok := s.Latest()
+	if !ok {
+		return ErrNoFrame
+	}
+	return EncodePNG(w, f, pal, scale)
+}
@@ -0,0 +1,58 @@
+package screenshot
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+func TestSink_WritePNG(t *testing.T) {
+	s := NewSink()
+	var b bytes.Buffer
+	if err := s.WritePNG(&b, palette.Default, 1); err != ErrNoFrame {
+		t.Errorf("WritePNG() before a frame = %v, want ErrNoFrame", err)
+	}
+
+	f := &ppu.Frame{}
+	f.Pixels[1] = ppu.Black
+	s.PresentFrame(f)
+	// The sink keeps a copy, so later changes to the frame don't matter.
+	f.Pixels[1] = ppu.White
+
+	white := palette.Default.BG[ppu.White]
+	black := palette.Default.BG[ppu.Black]
+	tests := []struct {
+		name  string
+		scale int
+		// want is the color of each pixel in the top row, up to the first
+		// white one after the black pixel.
+		want []color.RGBA
+	}{
+		{"1×", 1, []color.RGBA{white, black, white}},
+		{"3×", 3, []color.RGBA{white, white, white, black, black, black, white}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := s.WritePNG(&b, palette.Default, tt.scale); err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h := img.Bounds().Dx(), img.Bounds().Dy(); w != ppu.ScreenWidth*tt.scale || h != ppu.ScreenHeight*tt.scale {
+				t.Errorf("Image is %d×%d, want %d×%d", w, h, ppu.ScreenWidth*tt.scale, ppu.ScreenHeight*tt.scale)
+			}
+			for x, want := range tt.want {
+				if got := color.RGBAModel.Convert(img.At(x, tt.scale-1)); got != want {
+					t.Errorf("Pixel (%d, %d) is %v, want %v", x, tt.scale-1, got, want)
+				}
+			}
+		})
+	}
+}
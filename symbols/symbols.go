@@ -0,0 +1,230 @@
+// Package symbols loads symbol files (.sym), which map bank-qualified
+// addresses to label names. RGBDS (rgblink -n), wla-dx and no$gmb all emit
+// the same basic format:
+//
+//	; comment
+//	00:0150 Main
+//	00:0158 Main.loop
+//	01:4000 LoadTiles
+//
+// A nil *Table is valid and contains no symbols, so callers don't need to
+// check whether a symbol file was found before using it.
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Address is a bank-qualified address.
+type Address struct {
+	Bank int
+	Addr uint16
+}
+
+func (a Address) String() string {
+	return fmt.Sprintf("$%02X:%04X", a.Bank, a.Addr)
+}
+
+// Symbol is a named address.
+type Symbol struct {
+	Name string
+	Address
+}
+
+// Table is a set of symbols.
+type Table struct {
+	byName map[string]Address
+	// byBank holds each bank's symbols sorted by address. If several
+	// symbols share an address, the one that appeared first in the file
+	// comes first.
+	byBank map[int][]Symbol
+}
+
+// Load reads a symbol file from r.
+func Load(r io.Reader) (*Table, error) {
+	t := &Table{byName: make(map[string]Address), byBank: make(map[int][]Symbol)}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, ';'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		// wla-dx files have section headers like [labels].
+		if text == "" || strings.HasPrefix(text, "[") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("symbols: line %d: expected \"bank:addr name\", got %q", line, text)
+		}
+		a, err := ParseAddress(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("symbols: line %d: %v", line, err)
+		}
+		t.add(Symbol{fields[1], a})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, syms := range t.byBank {
+		sort.SliceStable(syms, func(i, j int) bool { return syms[i].Addr < syms[j].Addr })
+	}
+	return t, nil
+}
+
+// LoadFile reads the symbol file at path.
+func LoadFile(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// ForROM loads the symbol file next to a ROM, i.e. game.sym for game.gb.
+// If there is no such file, it returns a nil Table and no error.
+func ForROM(romPath string) (*Table, error) {
+	path := strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sym"
+	t, err := LoadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (t *Table) add(s Symbol) {
+	if _, ok := t.byName[s.Name]; !ok {
+		t.byName[s.Name] = s.Address
+	}
+	t.byBank[s.Bank] = append(t.byBank[s.Bank], s)
+}
+
+// ParseAddress parses a bank-qualified address like "01:4000". The bank
+// may be omitted, in which case it is 0. Both parts are hexadecimal and may
+// be prefixed with "$" or "0x".
+func ParseAddress(s string) (Address, error) {
+	bank := "0"
+	addr := s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		bank, addr = s[:i], s[i+1:]
+	}
+	b, err := strconv.ParseUint(trimHexPrefix(bank), 16, 16)
+	if err != nil {
+		return Address{}, fmt.Errorf("bad bank in address %q", s)
+	}
+	a, err := strconv.ParseUint(trimHexPrefix(addr), 16, 16)
+	if err != nil {
+		return Address{}, fmt.Errorf("bad address %q", s)
+	}
+	return Address{int(b), uint16(a)}, nil
+}
+
+func trimHexPrefix(s string) string {
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(s, "0x")
+	return strings.TrimPrefix(s, "0X")
+}
+
+// Len returns the number of symbols in the table.
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	n := 0
+	for _, syms := range t.byBank {
+		n += len(syms)
+	}
+	return n
+}
+
+// Lookup returns the address of the symbol called name.
+func (t *Table) Lookup(name string) (Address, bool) {
+	if t == nil {
+		return Address{}, false
+	}
+	a, ok := t.byName[name]
+	return a, ok
+}
+
+// Name returns the name of the symbol at exactly a.
+func (t *Table) Name(a Address) (string, bool) {
+	s, ok := t.Nearest(a)
+	if !ok || s.Addr != a.Addr {
+		return "", false
+	}
+	return s.Name, true
+}
+
+// Nearest returns the closest symbol at or before a in the same bank.
+func (t *Table) Nearest(a Address) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+	syms := t.byBank[a.Bank]
+	// Find the first symbol after a; the one before it is the nearest.
+	i := sort.Search(len(syms), func(i int) bool { return syms[i].Addr > a.Addr })
+	if i == 0 {
+		return Symbol{}, false
+	}
+	// Step back over any other symbols at the same address, so that we
+	// return the first one defined.
+	i--
+	for i > 0 && syms[i-1].Addr == syms[i].Addr {
+		i--
+	}
+	return syms[i], true
+}
+
+// maxOffset is the largest distance from a symbol that Describe will
+// describe an address relative to it. Beyond this, the nearest symbol is
+// probably unrelated.
+const maxOffset = 0x400
+
+// Describe returns a description of a relative to the nearest symbol, like
+// "Main.loop" or "Main.loop+3". It returns "" if there is no nearby symbol.
+func (t *Table) Describe(a Address) string {
+	s, ok := t.Nearest(a)
+	if !ok || a.Addr-s.Addr > maxOffset {
+		return ""
+	}
+	if s.Addr == a.Addr {
+		return s.Name
+	}
+	return fmt.Sprintf("%s+%d", s.Name, a.Addr-s.Addr)
+}
+
+// Format formats a as "$BB:AAAA", followed by its description in angle
+// brackets if there is one, e.g. "$01:4003 <LoadTiles+3>".
+func (t *Table) Format(a Address) string {
+	if desc := t.Describe(a); desc != "" {
+		return fmt.Sprintf("%s <%s>", a, desc)
+	}
+	return a.String()
+}
+
+// Symbols returns all of the symbols in the table, ordered by bank and
+// address.
+func (t *Table) Symbols() []Symbol {
+	if t == nil {
+		return nil
+	}
+	banks := make([]int, 0, len(t.byBank))
+	for bank := range t.byBank {
+		banks = append(banks, bank)
+	}
+	sort.Ints(banks)
+	var all []Symbol
+	for _, bank := range banks {
+		all = append(all, t.byBank[bank]...)
+	}
+	return all
+}
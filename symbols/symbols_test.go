@@ -0,0 +1,143 @@
+package symbols
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSym = `; File generated by rgblink
+00:0150 Main
+00:0150 Main.start
+00:0158 Main.loop
+01:4000 LoadTiles
+00:c000 wCounter ; trailing comment
+`
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantLen int
+		wantErr bool
+	}{
+		{"rgbds", testSym, 5, false},
+		{"wla-dx section header", "[labels]\n00:0100 Start\n", 1, false},
+		{"empty", "", 0, false},
+		{"missing name", "00:0150\n", 0, true},
+		{"bad address", "00:zz50 Main\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := Load(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := table.Len(); got != tt.wantLen {
+				t.Errorf("Len() = %d, want %d", got, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestTable_Lookup(t *testing.T) {
+	table, err := Load(strings.NewReader(testSym))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		want   Address
+		wantOk bool
+	}{
+		{"Main", Address{0, 0x0150}, true},
+		{"Main.loop", Address{0, 0x0158}, true},
+		{"LoadTiles", Address{1, 0x4000}, true},
+		{"NotASymbol", Address{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := table.Lookup(tt.name)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Lookup(%q) = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestTable_Describe(t *testing.T) {
+	table, err := Load(strings.NewReader(testSym))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		addr Address
+		want string
+	}{
+		{"first of several symbols", Address{0, 0x0150}, "Main"},
+		{"offset", Address{0, 0x0153}, "Main+3"},
+		{"local label", Address{0, 0x0158}, "Main.loop"},
+		{"other bank", Address{1, 0x4002}, "LoadTiles+2"},
+		{"wrong bank", Address{2, 0x4002}, ""},
+		{"before first symbol", Address{0, 0x0100}, ""},
+		{"too far from symbol", Address{0, 0x3000}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := table.Describe(tt.addr); got != tt.want {
+				t.Errorf("Describe(%v) = %q, want %q", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTable_Format(t *testing.T) {
+	table, err := Load(strings.NewReader(testSym))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := table.Format(Address{1, 0x4003}), "$01:4003 <LoadTiles+3>"; got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+	if got, want := table.Format(Address{0, 0x0000}), "$00:0000"; got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+}
+
+func TestTable_nil(t *testing.T) {
+	var table *Table
+	if _, ok := table.Lookup("Main"); ok {
+		t.Error("Lookup() on nil Table found a symbol")
+	}
+	if got := table.Describe(Address{0, 0x0150}); got != "" {
+		t.Errorf("Describe() on nil Table = %q, want \"\"", got)
+	}
+	if got := table.Len(); got != 0 {
+		t.Errorf("Len() on nil Table = %d, want 0", got)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Address
+		wantErr bool
+	}{
+		{"01:4000", Address{1, 0x4000}, false},
+		{"$01:$4000", Address{1, 0x4000}, false},
+		{"0x150", Address{0, 0x0150}, false},
+		{"c000", Address{0, 0xC000}, false},
+		{"Main", Address{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseAddress(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAddress(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAddress(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -63,5 +63,7 @@ func renderScreen(t *testing.T) {
 			p.RunFor(456 * 154)
 		}
 	}()
-	frontend.ConnectVideo(p.VideoOut)
+	video := ppu.NewChannelSink(1, false)
+	p.AddSink(video)
+	frontend.ConnectVideo(video)
 }
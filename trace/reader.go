@@ -0,0 +1,101 @@
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Reader streams entries from a trace log. Logs can be many gigabytes long,
+// so only the current line is kept in memory.
+type Reader struct {
+	scanner *bufio.Scanner
+	closers []io.Closer
+	line    int
+	text    string
+}
+
+// NewReader returns a Reader that reads a plain or gzip-compressed trace
+// log from r. Gzip input is detected by its magic number.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	tr := &Reader{}
+	var src io.Reader = br
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		tr.closers = append(tr.closers, gz)
+		src = gz
+	}
+	tr.scanner = bufio.NewScanner(src)
+	tr.scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	return tr, nil
+}
+
+// Open opens the trace log at path. The caller must Close the Reader.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closers = append(r.closers, f)
+	return r, nil
+}
+
+// Next returns the next entry in the log. Blank lines and lines that
+// don't look like trace entries (no "PC:" field) are skipped. At the end
+// of the log Next returns io.EOF.
+func (r *Reader) Next() (Entry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := r.scanner.Text()
+		if !strings.Contains(strings.ToUpper(text), "PC:") {
+			continue
+		}
+		e, err := Parse(text)
+		if err != nil {
+			return Entry{}, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		r.text = text
+		return e, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Entry{}, err
+	}
+	return Entry{}, io.EOF
+}
+
+// Line returns the 1-based line number of the last entry returned by Next.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Text returns the raw text of the last entry returned by Next.
+func (r *Reader) Text() string {
+	return r.text
+}
+
+// Close closes any decompressor and file opened by the Reader.
+func (r *Reader) Close() error {
+	var firstErr error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
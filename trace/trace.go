@@ -0,0 +1,229 @@
+// Package trace reads and writes CPU trace logs in the text format shared by
+// most Gameboy emulators and test harnesses (e.g. Gameboy Doctor):
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// Each line is the CPU state *before* the instruction at PC is executed, and
+// PCMEM holds the four bytes of memory starting at PC.
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/cpu"
+)
+
+// Entry is one line of a CPU trace.
+type Entry struct {
+	A, F, B, C, D, E, H, L byte
+	SP, PC                 uint16
+	PCMem                  [4]byte
+	// HasPCMem is false if the line didn't contain a PCMEM field.
+	HasPCMem bool
+}
+
+// FromCPU captures the current state of c as a trace entry. mem is used
+// to read the four bytes at PC.
+func FromCPU(c *cpu.CPU, mem cpu.MemoryReader) Entry {
+	e := Entry{
+		A: c.A, F: c.F, B: c.B, C: c.C, D: c.D, E: c.E, H: c.H, L: c.L,
+		SP: c.SP, PC: c.PC,
+		HasPCMem: true,
+	}
+	for i := range e.PCMem {
+		e.PCMem[i] = mem.Rb(c.PC + uint16(i))
+	}
+	return e
+}
+
+// String formats the entry in the same format accepted by Parse.
+func (e Entry) String() string {
+	s := fmt.Sprintf("A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X",
+		e.A, e.F, e.B, e.C, e.D, e.E, e.H, e.L, e.SP, e.PC)
+	if e.HasPCMem {
+		s += fmt.Sprintf(" PCMEM:%02X,%02X,%02X,%02X", e.PCMem[0], e.PCMem[1], e.PCMem[2], e.PCMem[3])
+	}
+	return s
+}
+
+// Parse parses a single trace line. Field order doesn't matter, field
+// names are case-insensitive, and unknown fields (e.g. LY, cycle counts)
+// are ignored. Every register field must be present.
+func Parse(line string) (Entry, error) {
+	var e Entry
+	// seen is a bitmask of which of the 10 register fields we've parsed.
+	var seen uint16
+	for _, field := range strings.Fields(line) {
+		sep := strings.IndexByte(field, ':')
+		if sep < 0 {
+			continue
+		}
+		name := strings.ToUpper(field[:sep])
+		val := field[sep+1:]
+		if name == "PCMEM" {
+			bytes := strings.Split(val, ",")
+			if len(bytes) != len(e.PCMem) {
+				return Entry{}, fmt.Errorf("trace: PCMEM should have %d bytes, got %q", len(e.PCMem), val)
+			}
+			for i, b := range bytes {
+				n, err := strconv.ParseUint(b, 16, 8)
+				if err != nil {
+					return Entry{}, fmt.Errorf("trace: bad PCMEM byte %q: %v", b, err)
+				}
+				e.PCMem[i] = byte(n)
+			}
+			e.HasPCMem = true
+			continue
+		}
+		idx := fieldIndex(name)
+		if idx < 0 {
+			continue
+		}
+		bits := 8
+		if idx >= 8 {
+			bits = 16
+		}
+		n, err := strconv.ParseUint(val, 16, bits)
+		if err != nil {
+			return Entry{}, fmt.Errorf("trace: bad %s value %q: %v", name, val, err)
+		}
+		e.set(idx, uint16(n))
+		seen |= 1 << uint(idx)
+	}
+	if seen != 1<<uint(len(fieldNames))-1 {
+		for i, name := range fieldNames {
+			if seen&(1<<uint(i)) == 0 {
+				return Entry{}, fmt.Errorf("trace: missing field %s in %q", name, line)
+			}
+		}
+	}
+	return e, nil
+}
+
+// fieldNames are the register fields of a trace line, in output order.
+var fieldNames = []string{"A", "F", "B", "C", "D", "E", "H", "L", "SP", "PC"}
+
+func fieldIndex(name string) int {
+	for i, n := range fieldNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *Entry) set(idx int, v uint16) {
+	switch idx {
+	case 0:
+		e.A = byte(v)
+	case 1:
+		e.F = byte(v)
+	case 2:
+		e.B = byte(v)
+	case 3:
+		e.C = byte(v)
+	case 4:
+		e.D = byte(v)
+	case 5:
+		e.E = byte(v)
+	case 6:
+		e.H = byte(v)
+	case 7:
+		e.L = byte(v)
+	case 8:
+		e.SP = v
+	case 9:
+		e.PC = v
+	}
+}
+
+func (e Entry) get(idx int) uint16 {
+	switch idx {
+	case 0:
+		return uint16(e.A)
+	case 1:
+		return uint16(e.F)
+	case 2:
+		return uint16(e.B)
+	case 3:
+		return uint16(e.C)
+	case 4:
+		return uint16(e.D)
+	case 5:
+		return uint16(e.E)
+	case 6:
+		return uint16(e.H)
+	case 7:
+		return uint16(e.L)
+	case 8:
+		return e.SP
+	default:
+		return e.PC
+	}
+}
+
+// Diff returns the names of the fields that differ between a and b.
+// If F differs, the individual flags that differ are reported as
+// "flag Z", "flag N", etc. rather than "F". PCMEM is only compared if
+// both entries have it.
+func Diff(a, b Entry) []string {
+	var diffs []string
+	for i, name := range fieldNames {
+		if a.get(i) == b.get(i) {
+			continue
+		}
+		if name != "F" {
+			diffs = append(diffs, name)
+			continue
+		}
+		flags := []struct {
+			name string
+			mask byte
+		}{{"Z", 0x80}, {"N", 0x40}, {"H", 0x20}, {"C", 0x10}}
+		for _, f := range flags {
+			if a.F&f.mask != b.F&f.mask {
+				diffs = append(diffs, "flag "+f.name)
+			}
+		}
+		// The low nibble of F is always zero on real hardware, but a buggy
+		// trace could still differ there.
+		if a.F&0x0F != b.F&0x0F {
+			diffs = append(diffs, "F(low nibble)")
+		}
+	}
+	if a.HasPCMem && b.HasPCMem && a.PCMem != b.PCMem {
+		diffs = append(diffs, "PCMEM")
+	}
+	return diffs
+}
+
+// Instruction decodes the instruction at PC from the entry's PCMEM bytes.
+// It returns false if the entry has no PCMEM field.
+func (e Entry) Instruction() (cpu.Instruction, bool) {
+	if !e.HasPCMem {
+		return cpu.Instruction{}, false
+	}
+	return cpu.Decode(e.PC, pcMemReader{e.PC, e.PCMem}), true
+}
+
+// pcMemReader exposes the four PCMEM bytes of an entry as a cpu.MemoryReader,
+// so that they can be decoded with cpu.Decode. Reads outside of PC..PC+3
+// return 0xFF, like reads from unmapped memory.
+type pcMemReader struct {
+	pc  uint16
+	mem [4]byte
+}
+
+func (r pcMemReader) Rb(addr uint16) byte {
+	offset := addr - r.pc
+	if offset >= uint16(len(r.mem)) {
+		return 0xFF
+	}
+	return r.mem[offset]
+}
+
+func (r pcMemReader) Rw(addr uint16) uint16 {
+	return uint16(r.Rb(addr+1))<<8 | uint16(r.Rb(addr))
+}
@@ -0,0 +1,134 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const doctorLine = "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Entry
+		wantErr bool
+	}{
+		{"gameboy doctor line", doctorLine, Entry{
+			A: 0x01, F: 0xB0, B: 0x00, C: 0x13, D: 0x00, E: 0xD8, H: 0x01, L: 0x4D,
+			SP: 0xFFFE, PC: 0x0100, PCMem: [4]byte{0x00, 0xC3, 0x13, 0x02}, HasPCMem: true,
+		}, false},
+		{"lowercase, reordered, extra fields, no PCMEM",
+			"pc:0150 sp:dfff a:3e f:00 b:01 c:02 d:03 e:04 h:05 l:06 LY:90 cy:1234",
+			Entry{A: 0x3E, B: 0x01, C: 0x02, D: 0x03, E: 0x04, H: 0x05, L: 0x06, SP: 0xDFFF, PC: 0x0150},
+			false},
+		{"missing register", "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 SP:FFFE PC:0100", Entry{}, true},
+		{"bad hex", "A:0G F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100", Entry{}, true},
+		{"short PCMEM", "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3", Entry{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntry_StringRoundTrips(t *testing.T) {
+	e, err := Parse(doctorLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.String() != doctorLine {
+		t.Errorf("Got %q, want %q", e.String(), doctorLine)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	base, _ := Parse(doctorLine)
+	tests := []struct {
+		name   string
+		modify func(e *Entry)
+		want   []string
+	}{
+		{"identical", func(e *Entry) {}, nil},
+		{"register A", func(e *Entry) { e.A = 0x02 }, []string{"A"}},
+		{"flags Z and C", func(e *Entry) { e.F ^= 0x90 }, []string{"flag Z", "flag C"}},
+		{"SP and PCMEM", func(e *Entry) { e.SP--; e.PCMem[3] = 0xFF }, []string{"SP", "PCMEM"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := base
+			tt.modify(&other)
+			got := Diff(base, other)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntry_Instruction(t *testing.T) {
+	e, _ := Parse(doctorLine)
+	// PC:0100 PCMEM:00,C3,13,02 is NOP followed by JP $0213.
+	e.PC, e.PCMem = 0x0101, [4]byte{0xC3, 0x13, 0x02, 0x00}
+	instr, ok := e.Instruction()
+	if !ok {
+		t.Fatal("Expected an instruction")
+	}
+	if got := instr.String(); got != "JP a16: $0213" {
+		t.Errorf("Got %q, want %q", got, "JP a16: $0213")
+	}
+}
+
+func TestReader(t *testing.T) {
+	log := "boot rom output\n" + doctorLine + "\n\n" + strings.Replace(doctorLine, "PC:0100", "PC:0101", 1) + "\n"
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(log))
+	w.Close()
+
+	tests := []struct {
+		name  string
+		input io.Reader
+	}{
+		{"plain", strings.NewReader(log)},
+		{"gzip", &gz},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			var pcs []uint16
+			var lines []int
+			for {
+				e, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				pcs = append(pcs, e.PC)
+				lines = append(lines, r.Line())
+			}
+			if !reflect.DeepEqual(pcs, []uint16{0x0100, 0x0101}) {
+				t.Errorf("Got PCs %04x, want [0100 0101]", pcs)
+			}
+			if !reflect.DeepEqual(lines, []int{2, 4}) {
+				t.Errorf("Got line numbers %v, want [2 4]", lines)
+			}
+		})
+	}
+}
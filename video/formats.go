@@ -0,0 +1,185 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+func (r *Recorder) writeGIF(frames []*image.Paletted) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to write to a GIF")
+	}
+	g := &gif.GIF{Delay: r.delays(len(frames), 100)}
+	for _, img := range frames {
+		g.Image = append(g.Image, palette.Scale(img, r.opt.Scale))
+	}
+	return gif.EncodeAll(r.w, g)
+}
+
+// writeAPNG writes an animated PNG, as described at
+// https://wiki.mozilla.org/APNG_Specification. Each frame is encoded as a
+// PNG, whose image data is moved into the animation's frames; the header
+// and palette are the same for every frame if they share a palette. Game
+// Boy Color frames may not, and then they're all encoded in true color.
+func (r *Recorder) writeAPNG(frames []*image.Paletted) error {
+	if len(frames) == 0 {
+		return errors.New("no frames to write to an APNG")
+	}
+	shared := true
+	for _, img := range frames[1:] {
+		if !samePalette(img.Palette, frames[0].Palette) {
+			shared = false
+			break
+		}
+	}
+	// APNG delays are fractions of a second with 16-bit numerators and
+	// denominators, which are too small for the exact frame rate.
+	delays := r.delays(len(frames), 10000)
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	seq := uint32(0)
+	for i, img := range frames {
+		img = palette.Scale(img, r.opt.Scale)
+		var enc image.Image = img
+		if !shared {
+			rgba := image.NewRGBA(img.Rect)
+			draw.Draw(rgba, rgba.Rect, img, image.Point{}, draw.Src)
+			enc = rgba
+		}
+		var p bytes.Buffer
+		if err := png.Encode(&p, enc); err != nil {
+			return err
+		}
+		chunks, err := pngChunks(p.Bytes())
+		if err != nil {
+			return err
+		}
+		var data []byte
+		for _, c := range chunks {
+			switch {
+			case i == 0 && c.typ == "IHDR":
+				writeChunk(&b, "IHDR", c.data)
+				writeChunk(&b, "acTL", be32(uint32(len(frames)), 0)) // loop forever
+			case i == 0 && c.typ == "PLTE", i == 0 && c.typ == "tRNS":
+				writeChunk(&b, c.typ, c.data)
+			case c.typ == "IDAT":
+				data = append(data, c.data...)
+			}
+		}
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		fctl := be32(seq, uint32(w), uint32(h), 0, 0)
+		fctl = append(fctl, byte(delays[i]>>8), byte(delays[i]), 10000>>8, 10000&0xFF)
+		fctl = append(fctl, 0, 0) // no disposal, no blending
+		writeChunk(&b, "fcTL", fctl)
+		seq++
+		if i == 0 {
+			writeChunk(&b, "IDAT", data)
+		} else {
+			writeChunk(&b, "fdAT", append(be32(seq), data...))
+			seq++
+		}
+	}
+	writeChunk(&b, "IEND", nil)
+	_, err := r.w.Write(b.Bytes())
+	return err
+}
+
+func samePalette(a, b color.Palette) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// pngChunks splits a PNG into its chunks.
+func pngChunks(p []byte) ([]pngChunk, error) {
+	const signature = 8
+	if len(p) < signature {
+		return nil, errors.New("short PNG")
+	}
+	var chunks []pngChunk
+	for p = p[signature:]; len(p) > 0; {
+		if len(p) < 12 {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		n := binary.BigEndian.Uint32(p)
+		if uint64(len(p)) < 12+uint64(n) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, pngChunk{string(p[4:8]), p[8 : 8+n]})
+		p = p[12+n:]
+	}
+	return chunks, nil
+}
+
+func writeChunk(b *bytes.Buffer, typ string, data []byte) {
+	b.Write(be32(uint32(len(data))))
+	start := b.Len()
+	b.WriteString(typ)
+	b.Write(data)
+	b.Write(be32(crc32.ChecksumIEEE(b.Bytes()[start:])))
+}
+
+func be32(ns ...uint32) []byte {
+	b := make([]byte, 0, 4*len(ns))
+	for _, n := range ns {
+		b = append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return b
+}
+
+// writeY4MHeader writes the header of a YUV4MPEG2 stream, in full-range
+// 4:4:4 YCbCr, so each frame is three planes of a byte per pixel.
+func (r *Recorder) writeY4MHeader() error {
+	r.y4m = true
+	w, h := ppu.ScreenWidth*r.opt.Scale, ppu.ScreenHeight*r.opt.Scale
+	_, err := fmt.Fprintf(r.w, "YUV4MPEG2 W%d H%d F%d:%d Ip A1:1 C444 XCOLORRANGE=FULL\n",
+		w, h, clockHz, frameCycles*(r.opt.Skip+1))
+	return err
+}
+
+func (r *Recorder) writeY4MFrame(img *image.Paletted) error {
+	if !r.y4m {
+		if err := r.writeY4MHeader(); err != nil {
+			return err
+		}
+	}
+	img = palette.Scale(img, r.opt.Scale)
+	// The YCbCr of each color in the palette.
+	ycbcr := make([][3]byte, len(img.Palette))
+	for i, c := range img.Palette {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		y, cb, cr := color.RGBToYCbCr(rgba.R, rgba.G, rgba.B)
+		ycbcr[i] = [3]byte{y, cb, cr}
+	}
+	n := len(img.Pix)
+	frame := make([]byte, len("FRAME\n")+3*n)
+	planes := frame[copy(frame, "FRAME\n"):]
+	for i, px := range img.Pix {
+		c := ycbcr[px]
+		planes[i], planes[n+i], planes[2*n+i] = c[0], c[1], c[2]
+	}
+	_, err := r.w.Write(frame)
+	return err
+}
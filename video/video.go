@@ -0,0 +1,201 @@
+// Package video records the PPU's frames as video: an animated GIF or
+// APNG, or uncompressed YUV4MPEG2 that can be piped to an encoder such as
+// ffmpeg. A Recorder is a ppu.FrameSink, which keeps the frame on screen,
+// and records it each time a frame ends:
+//
+//	rec, err := video.New(w, video.Options{Format: video.GIF})
+//	p.AddSink(rec)
+//	...
+//	rec.EndFrame() // at the end of each frame
+//	...
+//	err = rec.Close()
+//
+// Frames should end at the PPU's frame rate even while the LCD is off and
+// nothing is presented, as headless.Machine.RunFrame's do, so that the video
+// keeps to real time, with the screen white while the LCD is off.
+//
+// The DMG draws 4194304/70224 ≈ 59.73 frames a second. GIF delays are in
+// hundredths of a second, so each frame is shown for 1 or 2 of them to
+// keep the average right, but many GIF viewers slow down frames that
+// short; skipping every other frame (Options.Skip) avoids that.
+package video
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+// Format is a video format.
+type Format int
+
+const (
+	GIF Format = iota
+	APNG
+	Y4M
+)
+
+// FormatForPath returns the format to write a file in from its extension:
+// .gif, .png or .apng, or .y4m.
+func FormatForPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gif":
+		return GIF, nil
+	case ".png", ".apng":
+		return APNG, nil
+	case ".y4m":
+		return Y4M, nil
+	}
+	return 0, fmt.Errorf("unknown video format for %s; want .gif, .png, .apng or .y4m", path)
+}
+
+// The DMG's frame rate is clockHz/frameCycles frames a second.
+const (
+	clockHz     = 4194304
+	frameCycles = 70224
+)
+
+// FrameRate is the number of frames the DMG draws a second.
+const FrameRate = float64(clockHz) / frameCycles
+
+// Options configures a Recorder.
+type Options struct {
+	Format Format
+	// Palette colors the frames. If it's nil, palette.Default is used.
+	Palette *palette.Palette
+	// Scale scales the video up by an integer factor. If it's 0, the video
+	// is the size of the screen.
+	Scale int
+	// Skip is the number of frames skipped after each one recorded, e.g.
+	// 1 to record at half the frame rate.
+	Skip int
+	// Last is the number of recorded frames to keep, discarding older
+	// ones, e.g. to save the last few seconds before a test failed. If
+	// it's 0, every frame is kept. Frames are written when the Recorder is
+	// closed, even for Y4M, which is otherwise written as it's recorded.
+	Last int
+}
+
+// Recorder is a ppu.FrameSink that records frames to a video.
+type Recorder struct {
+	w   io.Writer
+	opt Options
+	// screen is a copy of the frame on screen, the last one presented.
+	screen ppu.Frame
+	// ended is the number of frames ended, including skipped ones.
+	ended int
+	// frames are the frames recorded but not yet written, at 1× scale. If
+	// opt.Last is set, it's a ring buffer starting at frames[next].
+	frames []*image.Paletted
+	next   int
+	// y4m is set once the Y4M header has been written.
+	y4m    bool
+	err    error
+	closed bool
+}
+
+// New returns a Recorder writing to w.
+func New(w io.Writer, opt Options) (*Recorder, error) {
+	if opt.Format < GIF || opt.Format > Y4M {
+		return nil, fmt.Errorf("unknown video format %d", opt.Format)
+	}
+	if opt.Palette == nil {
+		opt.Palette = palette.Default
+	}
+	if opt.Scale == 0 {
+		opt.Scale = 1
+	}
+	if opt.Scale < 0 || opt.Skip < 0 || opt.Last < 0 {
+		return nil, errors.New("video scale, skip and last can't be negative")
+	}
+	// The screen is white until a frame is presented.
+	return &Recorder{w: w, opt: opt, screen: ppu.Frame{Blank: true}}, nil
+}
+
+// PresentFrame puts f on screen, to be recorded when the frame ends.
+func (r *Recorder) PresentFrame(f *ppu.Frame) {
+	r.screen = *f
+}
+
+// EndFrame records the frame on screen, unless it's skipped. It's called at
+// the end of each frame, whether or not one was presented during it.
+func (r *Recorder) EndFrame() {
+	n := r.ended
+	r.ended++
+	if r.err != nil || r.closed || n%(r.opt.Skip+1) != 0 {
+		return
+	}
+	img := r.opt.Palette.Paletted(&r.screen)
+	switch {
+	case r.opt.Last > 0 && len(r.frames) == r.opt.Last:
+		r.frames[r.next] = img
+		r.next = (r.next + 1) % len(r.frames)
+	case r.opt.Format == Y4M && r.opt.Last == 0:
+		r.err = r.writeY4MFrame(img)
+	default:
+		r.frames = append(r.frames, img)
+	}
+}
+
+// Frames returns the number of frames in the video, not counting any
+// skipped or discarded because of Options.Last.
+func (r *Recorder) Frames() int {
+	n := (r.ended + r.opt.Skip) / (r.opt.Skip + 1)
+	if r.opt.Last > 0 && n > r.opt.Last {
+		return r.opt.Last
+	}
+	return n
+}
+
+// Close writes the frames that haven't been written yet. It doesn't close
+// the writer.
+func (r *Recorder) Close() error {
+	if r.closed {
+		return r.err
+	}
+	r.closed = true
+	if r.err != nil {
+		return r.err
+	}
+	frames := append(r.frames[r.next:len(r.frames):len(r.frames)], r.frames[:r.next]...)
+	r.frames = nil
+	switch r.opt.Format {
+	case GIF:
+		r.err = r.writeGIF(frames)
+	case APNG:
+		r.err = r.writeAPNG(frames)
+	case Y4M:
+		for _, img := range frames {
+			if r.err = r.writeY4MFrame(img); r.err != nil {
+				break
+			}
+		}
+		if r.err == nil && !r.y4m {
+			// An empty video still has a header.
+			r.err = r.writeY4MHeader()
+		}
+	}
+	return r.err
+}
+
+// delays returns how long each of n frames is shown for in units of
+// 1/perSecond seconds, rounding so that the total stays as close as
+// possible to the real time.
+func (r *Recorder) delays(n, perSecond int) []int {
+	d := make([]int, n)
+	// Each recorded frame is on screen for Skip+1 frames.
+	cycles := int64(frameCycles * (r.opt.Skip + 1))
+	shown := 0
+	for i := range d {
+		end := int((int64(i+1)*cycles*int64(perSecond) + clockHz/2) / clockHz)
+		d[i] = end - shown
+		shown = end
+	}
+	return d
+}
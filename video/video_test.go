@@ -0,0 +1,226 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+// record records n frames, where frame i has its top left pixel black if
+// i is odd, and returns the video.
+func record(t *testing.T, opt Options, n int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	r, err := New(&b, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		f := &ppu.Frame{Number: i}
+		f.Pixels[0] = ppu.Pixel(i%2) * ppu.Black
+		r.PresentFrame(f)
+		r.EndFrame()
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestRecorder_GIF(t *testing.T) {
+	white, black := palette.Default.BG[ppu.White], palette.Default.BG[ppu.Black]
+	tests := []struct {
+		name   string
+		opt    Options
+		frames int
+		// colors are the top left pixel of each frame in the GIF.
+		colors []color.RGBA
+		delays []int
+	}{
+		{"every frame", Options{}, 4, []color.RGBA{white, black, white, black}, []int{2, 1, 2, 2}},
+		{"skip", Options{Skip: 1}, 5, []color.RGBA{white, white, white}, []int{3, 4, 3}},
+		{"last", Options{Last: 3}, 6, []color.RGBA{black, white, black}, []int{2, 1, 2}},
+		{"scale", Options{Scale: 2}, 2, []color.RGBA{white, black}, []int{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opt.Format = GIF
+			g, err := gif.DecodeAll(bytes.NewReader(record(t, tt.opt, tt.frames)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var colors []color.RGBA
+			for _, img := range g.Image {
+				colors = append(colors, color.RGBAModel.Convert(img.At(0, 0)).(color.RGBA))
+				if len(img.Palette) != 4 {
+					t.Errorf("Frame has %d colors, want 4", len(img.Palette))
+				}
+			}
+			if !reflect.DeepEqual(colors, tt.colors) {
+				t.Errorf("Frames' top left pixels are %v, want %v", colors, tt.colors)
+			}
+			if !reflect.DeepEqual(g.Delay, tt.delays) {
+				t.Errorf("Delays are %v, want %v", g.Delay, tt.delays)
+			}
+			scale := tt.opt.Scale
+			if scale == 0 {
+				scale = 1
+			}
+			if g.Config.Width != ppu.ScreenWidth*scale || g.Config.Height != ppu.ScreenHeight*scale {
+				t.Errorf("GIF is %d×%d, want %d×%d", g.Config.Width, g.Config.Height, ppu.ScreenWidth*scale, ppu.ScreenHeight*scale)
+			}
+		})
+	}
+}
+
+func TestRecorder_EndFrame(t *testing.T) {
+	white, black := palette.Default.BG[ppu.White], palette.Default.BG[ppu.Black]
+	var b bytes.Buffer
+	r, err := New(&b, Options{Format: GIF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The screen is white before anything's presented, and a frame stays
+	// on screen until the next, even if it's changed after it's presented.
+	r.EndFrame()
+	f := &ppu.Frame{}
+	f.Pixels[0] = ppu.Black
+	r.PresentFrame(f)
+	f.Pixels[0] = ppu.White
+	r.EndFrame()
+	r.EndFrame()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []color.Color
+	for _, img := range g.Image {
+		got = append(got, img.At(0, 0))
+	}
+	if want := []color.Color{white, black, black}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Top left pixels are %v, want %v", got, want)
+	}
+}
+
+func TestRecorder_APNG(t *testing.T) {
+	b := record(t, Options{Format: APNG, Scale: 2}, 3)
+	// The first frame is the PNG's image, for viewers without APNG support.
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 2*ppu.ScreenWidth {
+		t.Errorf("APNG is %d wide, want %d", img.Bounds().Dx(), 2*ppu.ScreenWidth)
+	}
+	chunks, err := pngChunks(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	var seqs []uint32
+	for _, c := range chunks {
+		types = append(types, c.typ)
+		switch c.typ {
+		case "acTL":
+			if n := binary.BigEndian.Uint32(c.data); n != 3 {
+				t.Errorf("acTL has %d frames, want 3", n)
+			}
+		case "fcTL", "fdAT":
+			seqs = append(seqs, binary.BigEndian.Uint32(c.data))
+		}
+	}
+	wantTypes := []string{"IHDR", "acTL", "PLTE", "fcTL", "IDAT", "fcTL", "fdAT", "fcTL", "fdAT", "IEND"}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("Chunks are %v, want %v", types, wantTypes)
+	}
+	if !reflect.DeepEqual(seqs, []uint32{0, 1, 2, 3, 4}) {
+		t.Errorf("Sequence numbers are %v, want 0-4", seqs)
+	}
+}
+
+func TestRecorder_APNGColor(t *testing.T) {
+	// Game Boy Color frames with different colors don't share a palette,
+	// so they're written in true color.
+	var b bytes.Buffer
+	r, err := New(&b, Options{Format: APNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []ppu.RGB555{0x001F, 0x03E0} {
+		f := &ppu.Frame{CGB: true}
+		for i := range f.Colors {
+			f.Colors[i] = c
+		}
+		r.PresentFrame(f)
+		r.EndFrame()
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := color.RGBAModel.Convert(img.At(0, 0)), (color.RGBA{0xFF, 0, 0, 0xFF}); got != want {
+		t.Errorf("First frame's top left pixel is %v, want %v", got, want)
+	}
+	chunks, err := pngChunks(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if c.typ == "PLTE" {
+			t.Error("APNG has a palette")
+		}
+	}
+}
+
+func TestRecorder_Y4M(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    Options
+		header string
+		frames int
+		// y is the luma of the first frame's top left pixel.
+		y byte
+	}{
+		{"every frame", Options{}, "YUV4MPEG2 W160 H144 F4194304:70224 Ip A1:1 C444 XCOLORRANGE=FULL\n", 3, 0xFF},
+		{"skip and scale", Options{Skip: 2, Scale: 3}, "YUV4MPEG2 W480 H432 F4194304:210672 Ip A1:1 C444 XCOLORRANGE=FULL\n", 1, 0xFF},
+		{"last", Options{Last: 2}, "YUV4MPEG2 W160 H144 F4194304:70224 Ip A1:1 C444 XCOLORRANGE=FULL\n", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opt.Format = Y4M
+			b := string(record(t, tt.opt, 3))
+			if !strings.HasPrefix(b, tt.header) {
+				t.Fatalf("Header is %q, want %q", strings.SplitN(b, "\n", 2)[0], tt.header)
+			}
+			frames := strings.Split(b[len(tt.header):], "FRAME\n")[1:]
+			if len(frames) != tt.frames {
+				t.Fatalf("Got %d frames, want %d", len(frames), tt.frames)
+			}
+			scale := tt.opt.Scale
+			if scale == 0 {
+				scale = 1
+			}
+			if size := 3 * ppu.ScreenWidth * ppu.ScreenHeight * scale * scale; len(frames[0]) != size {
+				t.Errorf("Frame is %d bytes, want %d", len(frames[0]), size)
+			}
+			// The top left pixel is gray, so it has no chroma.
+			if f := frames[0]; f[0] != tt.y || f[len(f)/3] != 0x80 || f[2*len(f)/3] != 0x80 {
+				t.Errorf("Top left pixel is YCbCr %x %x %x, want %x 80 80", f[0], f[len(f)/3], f[2*len(f)/3], tt.y)
+			}
+		})
+	}
+}
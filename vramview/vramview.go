@@ -0,0 +1,296 @@
+// Package vramview renders the contents of video RAM as images, to help
+// diagnose graphics corruption: a sheet of every tile in $8000-$97FF, and
+// the two tile maps at $9800 and $9C00 with the parts shown on screen
+// outlined. In Game Boy Color mode, both banks of VRAM are read, and the
+// maps are drawn with the tile attributes and color palettes.
+//
+//	v := vramview.Read(m.Peek, m)
+//	err := v.WriteFiles("dumps", palette.Default.BG)
+package vramview
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/mpingram/gameboy-emu/mmu"
+	"github.com/mpingram/gameboy-emu/palette"
+	"github.com/mpingram/gameboy-emu/ppu"
+)
+
+const (
+	// BankSize is the size of a bank of VRAM.
+	BankSize = 0x2000
+	// Tiles is the number of tiles in a bank, from $8000 to $97FF.
+	Tiles = 384
+	// SheetColumns is the width of a bank's tile sheet, in tiles.
+	SheetColumns = 16
+	// MapSize is the width and height of a tile map, in pixels.
+	MapSize = 256
+
+	tileBytes = 16
+	mapTiles  = 32
+)
+
+// Outline colors for the parts of the tile maps on screen.
+var (
+	ViewportColor = color.RGBA{0xFF, 0, 0, 0xFF}
+	WindowColor   = color.RGBA{0, 0x60, 0xFF, 0xFF}
+)
+
+// ColorMemory is the Game Boy Color's memory that isn't at an address,
+// e.g. an MMU's.
+type ColorMemory interface {
+	// CGB reports whether the game runs in Game Boy Color mode.
+	CGB() bool
+	// PeekVRAM reads a byte from a bank of VRAM, 0 or 1.
+	PeekVRAM(bank byte, addr uint16) byte
+	// PeekPalette reads byte i of the background palette RAM, or the
+	// sprite palette RAM if obj is set.
+	PeekPalette(obj bool, i byte) byte
+}
+
+// VRAM is a snapshot of video RAM and the registers that say how it's
+// drawn.
+type VRAM struct {
+	// Data is the contents of VRAM from $8000, a bank at a time. The DMG
+	// has one bank.
+	Data []byte
+	// LCDC, SCX, SCY, WX, WY and BGP are the LCD registers.
+	LCDC, SCX, SCY, WX, WY, BGP byte
+	// CGB is set in Game Boy Color mode, in which case Data has both banks,
+	// with the tile maps' attributes in bank 1, and BGPalettes is the
+	// background palette RAM.
+	CGB        bool
+	BGPalettes []byte
+}
+
+// Read takes a snapshot of VRAM and the LCD registers through read, e.g.
+// an MMU's Peek method. In Game Boy Color mode, VRAM and the palettes are
+// read from color instead, which may be nil on the DMG.
+func Read(read func(addr uint16) byte, color ColorMemory) *VRAM {
+	v := &VRAM{Data: make([]byte, BankSize)}
+	if color != nil && color.CGB() {
+		v.CGB = true
+		v.Data = make([]byte, 2*BankSize)
+		for i := range v.Data {
+			v.Data[i] = color.PeekVRAM(byte(i/BankSize), mmu.AddrVRAM+uint16(i%BankSize))
+		}
+		v.BGPalettes = make([]byte, paletteRAMSize)
+		for i := range v.BGPalettes {
+			v.BGPalettes[i] = color.PeekPalette(false, byte(i))
+		}
+	} else {
+		for i := range v.Data {
+			v.Data[i] = read(mmu.AddrVRAM + uint16(i))
+		}
+	}
+	v.LCDC, v.SCX, v.SCY = read(mmu.AddrLCDC), read(mmu.AddrSCX), read(mmu.AddrSCY)
+	v.WX, v.WY, v.BGP = read(mmu.AddrWX), read(mmu.AddrWY), read(mmu.AddrBGP)
+	return v
+}
+
+// tileRow returns the color numbers of a row of a tile, from the left,
+// given the row's two bytes. Bit 7 is the leftmost pixel, with its low bit
+// in lo and high bit in hi.
+func tileRow(lo, hi byte) [8]byte {
+	var row [8]byte
+	for i := range row {
+		bit := uint(7 - i)
+		row[i] = (lo>>bit)&1 | (hi>>bit)&1<<1
+	}
+	return row
+}
+
+// drawTile draws tile data at (x, y), giving each color number the color
+// colors[number].
+func drawTile(img *image.Paletted, tile []byte, x, y int, colors [4]uint8) {
+	for row := 0; row < 8; row++ {
+		for i, c := range tileRow(tile[row*2], tile[row*2+1]) {
+			img.SetColorIndex(x+i, y+row, colors[c])
+		}
+	}
+}
+
+// TileSheet returns every tile in VRAM, SheetColumns tiles wide, with tile
+// 0 at the top left, in the color numbers' shades: the palette registers
+// aren't applied. Banks are side by side.
+func (v *VRAM) TileSheet(s palette.Shades) *image.Paletted {
+	banks := len(v.Data) / BankSize
+	rows := Tiles / SheetColumns
+	img := image.NewPaletted(image.Rect(0, 0, banks*SheetColumns*8, rows*8), shadesPalette(s))
+	for b := 0; b < banks; b++ {
+		for t := 0; t < Tiles; t++ {
+			tile := v.Data[b*BankSize+t*tileBytes:]
+			drawTile(img, tile, (b*SheetColumns+t%SheetColumns)*8, t/SheetColumns*8, [4]uint8{0, 1, 2, 3})
+		}
+	}
+	return img
+}
+
+// Map addresses.
+const (
+	Map0 = mmu.AddrTileMap0
+	Map1 = mmu.AddrTileMap1
+)
+
+// TileMap returns the tile map at addr (Map0 or Map1), drawn as the
+// background would be, with its tile data addressing and BGP, or in Game
+// Boy Color mode its attributes and the color palettes, in which case s
+// isn't used. The part the background shows on screen is outlined in
+// ViewportColor if the background uses the map, wrapping around the edges,
+// and the part the window shows in WindowColor if the window is on and
+// uses it.
+func (v *VRAM) TileMap(addr uint16, s palette.Shades) *image.RGBA {
+	var rgba *image.RGBA
+	if v.CGB {
+		rgba = v.colorTileMap(addr)
+	} else {
+		img := image.NewPaletted(image.Rect(0, 0, MapSize, MapSize), shadesPalette(s))
+		var colors [4]uint8
+		for c := range colors {
+			colors[c] = v.BGP >> (c * 2) & 0b11
+		}
+		for i := 0; i < mapTiles*mapTiles; i++ {
+			tile := v.Data[v.tileAddr(v.Data[int(addr-mmu.AddrVRAM)+i]):]
+			drawTile(img, tile, i%mapTiles*8, i/mapTiles*8, colors)
+		}
+		rgba = image.NewRGBA(img.Rect)
+		draw.Draw(rgba, rgba.Rect, img, image.Point{}, draw.Src)
+	}
+
+	if v.LCDC&lcdcBGMap != 0 == (addr == Map1) {
+		outline(rgba, int(v.SCX), int(v.SCY), 160, 144, ViewportColor)
+	}
+	if v.LCDC&lcdcWindow != 0 && v.LCDC&lcdcWindowMap != 0 == (addr == Map1) && v.WX < 167 && v.WY < 144 {
+		// The window's top left is the map's top left, and it reaches the
+		// bottom right of the screen.
+		x := int(v.WX) - 7
+		if x < 0 {
+			x = 0
+		}
+		outline(rgba, 0, 0, 160-x, 144-int(v.WY), WindowColor)
+	}
+	return rgba
+}
+
+// Background tile attributes, in the tile maps in bank 1 (see the ppu
+// package's cgb.go).
+const (
+	attrFlipY   = 1 << 6
+	attrFlipX   = 1 << 5
+	attrBank    = 1 << 3
+	attrPalette = 0b111
+
+	// paletteRAMSize is the size of the background palette RAM: eight
+	// palettes of four little-endian RGB555 colors.
+	paletteRAMSize = 64
+)
+
+// colorTileMap draws the tile map at addr in Game Boy Color mode, with each
+// tile's attributes from bank 1.
+func (v *VRAM) colorTileMap(addr uint16) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, MapSize, MapSize))
+	for i := 0; i < mapTiles*mapTiles; i++ {
+		offset := int(addr-mmu.AddrVRAM) + i
+		attr := v.Data[BankSize+offset]
+		data := v.tileAddr(v.Data[offset])
+		if attr&attrBank != 0 {
+			data += BankSize
+		}
+		var colors [4]ppu.RGB555
+		for c := range colors {
+			j := int(attr&attrPalette)*8 + c*2
+			colors[c] = ppu.RGB555(v.BGPalettes[j+1])<<8&0x7F00 | ppu.RGB555(v.BGPalettes[j])
+		}
+		left, top := i%mapTiles*8, i/mapTiles*8
+		for row := 0; row < 8; row++ {
+			src := row
+			if attr&attrFlipY != 0 {
+				src = 7 - row
+			}
+			for x, c := range tileRow(v.Data[data+src*2], v.Data[data+src*2+1]) {
+				if attr&attrFlipX != 0 {
+					x = 7 - x
+				}
+				r, g, b := colors[c].RGB()
+				img.SetRGBA(left+x, top+row, color.RGBA{r, g, b, 0xFF})
+			}
+		}
+	}
+	return img
+}
+
+// LCDC bits.
+const (
+	lcdcWindowMap = 1 << 6
+	lcdcWindow    = 1 << 5
+	lcdcTileData  = 1 << 4
+	lcdcBGMap     = 1 << 3
+)
+
+// tileAddr returns the offset into VRAM of a background tile's data: tile
+// numbers count from $8000 if LCDC bit 4 is set, and are signed from $9000
+// otherwise.
+func (v *VRAM) tileAddr(tile byte) int {
+	if v.LCDC&lcdcTileData != 0 {
+		return int(tile) * tileBytes
+	}
+	return 0x1000 + int(int8(tile))*tileBytes
+}
+
+// outline draws the outline of a w×h rectangle with its top left at (x, y)
+// on a tile map, wrapping around its edges.
+func outline(img *image.RGBA, x, y, w, h int, c color.RGBA) {
+	set := func(px, py int) {
+		img.SetRGBA((px%MapSize+MapSize)%MapSize, (py%MapSize+MapSize)%MapSize, c)
+	}
+	for i := 0; i < w; i++ {
+		set(x+i, y)
+		set(x+i, y+h-1)
+	}
+	for i := 0; i < h; i++ {
+		set(x, y+i)
+		set(x+w-1, y+i)
+	}
+}
+
+func shadesPalette(s palette.Shades) color.Palette {
+	return color.Palette{s[0], s[1], s[2], s[3]}
+}
+
+// WriteFiles writes the tile sheet and tile maps to tiles.png,
+// map-9800.png and map-9C00.png in dir, creating it if needed.
+func (v *VRAM) WriteFiles(dir string, s palette.Shades) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"tiles.png", v.TileSheet(s)},
+		{"map-9800.png", v.TileMap(Map0, s)},
+		{"map-9C00.png", v.TileMap(Map1, s)},
+	} {
+		if err := writePNG(filepath.Join(dir, f.name), f.img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,177 @@
+package vramview
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/mpingram/gameboy-emu/palette"
+)
+
+var shades = palette.Shades{
+	{0xFF, 0xFF, 0xFF, 0xFF},
+	{0xAA, 0xAA, 0xAA, 0xFF},
+	{0x55, 0x55, 0x55, 0xFF},
+	{0, 0, 0, 0xFF},
+}
+
+// setTile fills a tile in VRAM (offset from $8000) with a color number.
+func setTile(v *VRAM, offset, c int) {
+	for i := 0; i < tileBytes; i += 2 {
+		v.Data[offset+i] = byte(c&1) * 0xFF
+		v.Data[offset+i+1] = byte(c>>1) * 0xFF
+	}
+}
+
+func TestVRAM_TileSheet(t *testing.T) {
+	v := &VRAM{Data: make([]byte, 2*BankSize), BGP: 0xFF}
+	setTile(v, 1*tileBytes, 3)
+	setTile(v, 383*tileBytes, 2)
+	setTile(v, BankSize+17*tileBytes, 1)
+	img := v.TileSheet(shades)
+	if w, h := img.Rect.Dx(), img.Rect.Dy(); w != 2*16*8 || h != 24*8 {
+		t.Fatalf("Sheet is %d×%d, want 256×192", w, h)
+	}
+	tests := []struct {
+		name string
+		x, y int
+		want color.Color
+	}{
+		{"tile 0", 7, 7, shades[0]},
+		{"tile 1", 8, 0, shades[3]},
+		{"tile 383", 15*8 + 7, 23*8 + 7, shades[2]},
+		{"bank 1 tile 17", 16*8 + 8, 8, shades[1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// BGP isn't applied to the sheet.
+			if got := img.At(tt.x, tt.y); got != tt.want {
+				t.Errorf("Pixel (%d, %d) is %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVRAM_TileMap(t *testing.T) {
+	tests := []struct {
+		name string
+		lcdc byte
+		addr uint16
+		// want is the color of tile 1 in the map, inside the viewport's
+		// outline.
+		want color.RGBA
+	}{
+		// Tile $80 is at $8800 either way; tile 1 is at $8010 or $9010.
+		{"unsigned tile numbers", 0b0001_0000, Map0, shades[3]},
+		{"signed tile numbers", 0b0000_0000, Map0, shades[1]},
+		{"map 1", 0b0001_0000, Map1, shades[2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &VRAM{Data: make([]byte, BankSize), LCDC: tt.lcdc, BGP: 0b11_10_01_00}
+			setTile(v, 0x0010, 3)
+			setTile(v, 0x1010, 1)
+			setTile(v, 0x0800, 2)
+			v.Data[Map0-0x8000+1] = 1
+			v.Data[Map1-0x8000+1] = 0x80
+			img := v.TileMap(tt.addr, shades)
+			if got := img.RGBAAt(9, 1); got != tt.want {
+				t.Errorf("Tile 1 is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// colorMemory is Game Boy Color memory for Read.
+type colorMemory struct {
+	vram     [2][BankSize]byte
+	palettes [paletteRAMSize]byte
+}
+
+func (m *colorMemory) CGB() bool                            { return true }
+func (m *colorMemory) PeekVRAM(bank byte, addr uint16) byte { return m.vram[bank][addr-0x8000] }
+func (m *colorMemory) PeekPalette(obj bool, i byte) byte {
+	if obj {
+		return 0
+	}
+	return m.palettes[i]
+}
+
+func TestRead_color(t *testing.T) {
+	mem := &colorMemory{}
+	mem.vram[0][5], mem.vram[1][5] = 1, 2
+	mem.palettes[63] = 0x7F
+	v := Read(func(addr uint16) byte { return 0xAA }, mem)
+	if !v.CGB || len(v.Data) != 2*BankSize || v.Data[5] != 1 || v.Data[BankSize+5] != 2 {
+		t.Errorf("Read both banks as CGB = %t, %d bytes, $%02X and $%02X; want true, %d bytes, $01 and $02",
+			v.CGB, len(v.Data), v.Data[5], v.Data[BankSize+5], 2*BankSize)
+	}
+	if len(v.BGPalettes) != paletteRAMSize || v.BGPalettes[63] != 0x7F {
+		t.Error("The background palettes weren't read")
+	}
+	if v.LCDC != 0xAA {
+		t.Errorf("LCDC = $%02X, want $AA from read", v.LCDC)
+	}
+}
+
+func TestVRAM_TileMap_color(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	black := color.RGBA{0, 0, 0, 0xFF}
+	tests := []struct {
+		name string
+		attr byte
+		x    int // in tile 1
+		want color.RGBA
+	}{
+		{"palette", 2, 0, red},
+		{"bank 1", attrBank | 2, 0, green},
+		{"flipped", attrFlipX | 2, 7, red},
+		{"flipped, the other side", attrFlipX | 2, 0, black},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &VRAM{Data: make([]byte, 2*BankSize), LCDC: 0b0001_0000, CGB: true, BGPalettes: make([]byte, paletteRAMSize)}
+			// Tile 1's leftmost column is color 3 in bank 0 and color 1 in
+			// bank 1.
+			for i := 0; i < tileBytes; i += 2 {
+				v.Data[0x0010+i], v.Data[0x0010+i+1] = 0x80, 0x80
+				v.Data[BankSize+0x0010+i] = 0x80
+			}
+			v.Data[Map0-0x8000+1] = 1
+			v.Data[BankSize+Map0-0x8000+1] = tt.attr
+			// Palette 2's color 1 is green and color 3 red.
+			v.BGPalettes[2*8+2], v.BGPalettes[2*8+3] = 0xE0, 0x03
+			v.BGPalettes[2*8+6], v.BGPalettes[2*8+7] = 0x1F, 0x00
+			if got := v.TileMap(Map0, shades).RGBAAt(8+tt.x, 1); got != tt.want {
+				t.Errorf("Pixel %d of tile 1 is %v, want %v", tt.x, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVRAM_TileMapOutlines(t *testing.T) {
+	// The background uses map 0, scrolled so the viewport wraps around the
+	// right and bottom, and the window uses map 1, from (27, 100).
+	v := &VRAM{Data: make([]byte, BankSize), LCDC: 0b0110_0000, SCX: 200, SCY: 250, WX: 34, WY: 100}
+	tests := []struct {
+		name string
+		addr uint16
+		x, y int
+		want color.RGBA
+	}{
+		{"viewport top left", Map0, 200, 250, ViewportColor},
+		{"viewport wraps right", Map0, (200 + 159) % 256, 250, ViewportColor},
+		{"viewport wraps bottom", Map0, 200, (250 + 143) % 256, ViewportColor},
+		{"inside the viewport", Map0, 210, 10, shades[0]},
+		{"no window on map 0", Map0, 0, 43, shades[0]},
+		{"window bottom right", Map1, 160 - 27 - 1, 144 - 100 - 1, WindowColor},
+		{"no viewport on map 1", Map1, 200, 250, shades[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := v.TileMap(tt.addr, shades).RGBAAt(tt.x, tt.y); got != tt.want {
+				t.Errorf("Pixel (%d, %d) is %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}